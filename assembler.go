@@ -0,0 +1,1001 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Addresses the assembler places sections at unless told otherwise, as test/Makefile links programs
+const (
+	ASM_TEXT_START uint32 = 0x0000_0000 // Address of the code
+	ASM_DATA_START uint32 = 0x0000_1000 // Address of the initialised data, which the BSS follows
+	ASM_GP_OFFSET  uint32 = 0x800       // Offset of the global pointer into the data, so gp reaches its first 4 KiB
+)
+
+// Indices of the sections every assembled program is made of
+const (
+	ASM_TEXT     = iota // Code
+	ASM_DATA            // Initialised data, including read-only data
+	ASM_BSS             // Zero-initialised data, which takes no space in the image
+	ASM_SECTIONS        // Number of sections
+)
+
+// Reported for symbols that are referenced but never defined
+var ErrUndefinedSymbol = errors.New("undefined symbol")
+
+// A section of an assembled program
+type asmSection struct {
+	name  string
+	addr  uint32
+	data  []byte // Contents, which the BSS does not keep
+	size  uint32 // Size in memory
+	align uint32 // Largest alignment requested within the section
+}
+
+// A symbol of an assembled program, either a label within a section or a constant
+type asmSymbol struct {
+	name    string
+	section *asmSection // Section of a label, nil for a constant
+	offset  uint32      // Offset of a label within its section, or the value of a constant once assembled
+	expr    string      // Expression giving the value of a constant
+	defined bool
+	global  bool
+	kind    elf.SymType
+	size    uint32
+	pending bool // Whether the value of the constant is being evaluated, to catch definitions that refer to themselves
+}
+
+// A definition of a numeric local label, which 1b and 1f refer back and forward to
+type numericLabel struct {
+	statement int
+	section   *asmSection
+	offset    uint32
+}
+
+// A line of source split into its labels, its mnemonic or directive and its operands
+type asmStatement struct {
+	line     int
+	labels   []string
+	mnemonic string
+	args     []string
+}
+
+// Assembles RISC-V source into programs laid out at fixed addresses, standing in for both
+// assembler and linker
+type Assembler struct {
+	textAddr uint32
+	dataAddr uint32
+}
+
+// Constructor to initialize an assembler placing code and data at the given addresses
+func NewAssembler(textAddr uint32, dataAddr uint32) *Assembler {
+	return &Assembler{textAddr: textAddr, dataAddr: dataAddr}
+}
+
+// The state of assembling a single source
+type assembly struct {
+	sections   [ASM_SECTIONS]*asmSection
+	current    *asmSection
+	symbols    map[string]*asmSymbol
+	numeric    map[string][]numericLabel
+	pass       int
+	statement  int               // Index of the statement being assembled
+	addr       uint32            // Address of the statement being assembled
+	sizes      []uint32          // Sizes of the statements chosen in the first pass
+	pcrelHi    map[uint32]uint32 // Addresses %pcrel_hi referred to, keyed by the address of the auipc
+	definition []string          // Order in which symbols were first seen, so output does not depend on map order
+}
+
+// An assembled program, ready to be loaded or written out as an ELF executable
+type Program struct {
+	sections []*asmSection
+	symbols  []*asmSymbol
+	entry    uint32
+}
+
+// Assembles a source file in two passes: the first lays out every statement to find the address of
+// each label, and the second encodes the statements with all addresses known
+func (assembler *Assembler) Assemble(name string, source io.Reader) (*Program, error) {
+	text, err := io.ReadAll(source)
+	if err != nil {
+		return nil, err
+	}
+	statements := parseSource(string(text))
+
+	a := &assembly{
+		symbols: make(map[string]*asmSymbol),
+		numeric: make(map[string][]numericLabel),
+		sizes:   make([]uint32, len(statements)),
+		pcrelHi: make(map[uint32]uint32),
+	}
+	a.sections[ASM_TEXT] = &asmSection{name: ".text", addr: assembler.textAddr, align: BYTES_PER_WORD}
+	a.sections[ASM_DATA] = &asmSection{name: ".data", addr: assembler.dataAddr, align: 1}
+	a.sections[ASM_BSS] = &asmSection{name: ".bss", align: 1}
+
+	for a.pass = 1; a.pass <= 2; a.pass++ {
+		for _, section := range a.sections {
+			section.data, section.size = section.data[:0], 0
+		}
+		a.current = a.sections[ASM_TEXT]
+		for i, statement := range statements {
+			a.statement, a.addr = i, a.pc()
+			if err := a.assembleStatement(statement); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, statement.line, err)
+			}
+			if a.pass == 1 {
+				a.sizes[i] = a.pc() - a.addr
+			}
+		}
+		if a.pass == 1 {
+			if err := a.layout(); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	return a.program()
+}
+
+// Matches the label at the start of a statement
+var labelPattern = regexp.MustCompile(`^\s*([A-Za-z_.$][\w.$]*|\d+)\s*:`)
+
+// Matches a constant defined as name = expression
+var assignmentPattern = regexp.MustCompile(`^\s*([A-Za-z_.$][\w.$]*)\s*=(.*)$`)
+
+// Splits source into statements, dropping comments. Lines may hold several statements separated by semicolons.
+func parseSource(source string) []asmStatement {
+	var statements []asmStatement
+	source = stripBlockComments(source)
+	for i, line := range strings.Split(source, "\n") {
+		for _, text := range splitOutsideStrings(stripLineComment(line), ';') {
+			statement := asmStatement{line: i + 1}
+			for {
+				match := labelPattern.FindStringSubmatch(text)
+				if match == nil {
+					break
+				}
+				statement.labels = append(statement.labels, match[1])
+				text = text[len(match[0]):]
+			}
+			if match := assignmentPattern.FindStringSubmatch(text); match != nil {
+				statement.mnemonic, statement.args = ".set", []string{match[1], strings.TrimSpace(match[2])}
+			} else if fields := strings.Fields(text); len(fields) > 0 {
+				statement.mnemonic = strings.ToLower(fields[0])
+				rest := strings.TrimSpace(text[strings.Index(text, fields[0])+len(fields[0]):])
+				if rest != "" {
+					for _, arg := range splitOutsideStrings(rest, ',') {
+						statement.args = append(statement.args, strings.TrimSpace(arg))
+					}
+				}
+			}
+			if statement.mnemonic != "" || len(statement.labels) > 0 {
+				statements = append(statements, statement)
+			}
+		}
+	}
+	return statements
+}
+
+// Replaces /* */ comments with spaces, keeping the line breaks within them so line numbers stay right
+func stripBlockComments(source string) string {
+	var builder strings.Builder
+	for {
+		start := strings.Index(source, "/*")
+		if start < 0 {
+			builder.WriteString(source)
+			return builder.String()
+		}
+		builder.WriteString(source[:start])
+		end := strings.Index(source[start+2:], "*/")
+		if end < 0 {
+			end = len(source) - start - 2
+		}
+		builder.WriteString(strings.Repeat("\n", strings.Count(source[start:start+2+end], "\n")))
+		source = source[min(start+end+4, len(source)):]
+	}
+}
+
+// Removes a # or // comment from the end of a line, leaving strings alone
+func stripLineComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && quoted:
+			i++
+		case line[i] == '"':
+			quoted = !quoted
+		case !quoted && (line[i] == '#' || strings.HasPrefix(line[i:], "//")):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Splits text at a separator that is neither quoted nor within parentheses
+func splitOutsideStrings(text string, separator byte) []string {
+	var parts []string
+	quoted, depth, start := false, 0, 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == separator && depth == 0:
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+// Returns the address the next byte is assembled at
+func (a *assembly) pc() uint32 {
+	return a.current.addr + a.current.size
+}
+
+// Returns the symbol with the name, creating it if it was not seen before
+func (a *assembly) symbol(name string) *asmSymbol {
+	symbol, ok := a.symbols[name]
+	if !ok {
+		symbol = &asmSymbol{name: name, kind: elf.STT_NOTYPE}
+		a.symbols[name] = symbol
+		a.definition = append(a.definition, name)
+	}
+	return symbol
+}
+
+// Assembles the labels and the directive or instruction of a statement
+func (a *assembly) assembleStatement(statement asmStatement) error {
+	// Labels only need defining once, as their place does not change between passes
+	for _, label := range statement.labels {
+		if a.pass == 2 {
+			break
+		}
+		if label[0] >= '0' && label[0] <= '9' {
+			a.numeric[label] = append(a.numeric[label], numericLabel{statement: a.statement, section: a.current, offset: a.current.size})
+			continue
+		}
+		symbol := a.symbol(label)
+		if symbol.defined {
+			return fmt.Errorf("symbol %q is already defined", label)
+		}
+		symbol.section, symbol.offset, symbol.defined = a.current, a.current.size, true
+	}
+
+	if statement.mnemonic == "" {
+		return nil
+	}
+	if strings.HasPrefix(statement.mnemonic, ".") {
+		return a.directive(statement.mnemonic, statement.args)
+	}
+	if a.current == a.sections[ASM_BSS] {
+		return fmt.Errorf("instructions cannot be placed in %s", a.current.name)
+	}
+	// The first pass only needs to know how much space each instruction takes
+	if a.pass == 1 {
+		return a.emit(make([]byte, a.instructionSize(statement.mnemonic, statement.args)))
+	}
+	if err := a.instruction(statement.mnemonic, statement.args); err != nil {
+		return err
+	}
+	if size := a.pc() - a.addr; size != a.sizes[a.statement] {
+		return fmt.Errorf("%s took %d bytes, but %d were set aside for it", statement.mnemonic, size, a.sizes[a.statement])
+	}
+	return nil
+}
+
+// Places the BSS after the data and defines the symbols a linker script would, once the first
+// pass has found the size of each section
+func (a *assembly) layout() error {
+	text, data, bss := a.sections[ASM_TEXT], a.sections[ASM_DATA], a.sections[ASM_BSS]
+	bss.addr = alignUp(data.addr+data.size, bss.align)
+	if text.size > 0 && data.size+bss.size > 0 && text.addr < data.addr+data.size+bss.size && data.addr < text.addr+text.size {
+		return fmt.Errorf("code at %08x-%08x overlaps the data at %08x", text.addr, text.addr+text.size, data.addr)
+	}
+	linkerSymbols := []struct {
+		name  string
+		value uint32
+	}{
+		{GLOBAL_POINTER_SYMBOL, data.addr + ASM_GP_OFFSET},
+		{"__DATA_BEGIN__", data.addr},
+		{"__SDATA_BEGIN__", data.addr},
+		{"_edata", data.addr + data.size},
+		{"__bss_start", bss.addr},
+		{"__BSS_END__", bss.addr + bss.size},
+		{"_end", bss.addr + bss.size},
+	}
+	for _, linkerSymbol := range linkerSymbols {
+		if symbol := a.symbol(linkerSymbol.name); !symbol.defined {
+			symbol.expr, symbol.defined, symbol.global = fmt.Sprint(linkerSymbol.value), true, true
+		}
+	}
+	return nil
+}
+
+// Rounds an address up to a multiple of a power of two
+func alignUp(addr uint32, alignment uint32) uint32 {
+	return (addr + alignment - 1) &^ (alignment - 1)
+}
+
+// Appends bytes to the current section, of which the BSS may only hold zeros
+func (a *assembly) emit(data []byte) error {
+	if a.current == a.sections[ASM_BSS] {
+		for _, b := range data {
+			if b != 0 {
+				return fmt.Errorf("%s can only hold zeros", a.current.name)
+			}
+		}
+	} else {
+		a.current.data = append(a.current.data, data...)
+	}
+	a.current.size += uint32(len(data))
+	return nil
+}
+
+// Appends a little-endian value of the given size in bytes
+func (a *assembly) emitValue(value uint64, size uint32) error {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(value >> (8 * i))
+	}
+	return a.emit(data)
+}
+
+// Switches to the section a section name belongs to
+func (a *assembly) switchSection(name string) error {
+	switch {
+	case name == ".text" || strings.HasPrefix(name, ".text."):
+		a.current = a.sections[ASM_TEXT]
+	case name == ".bss" || name == ".sbss" || strings.HasPrefix(name, ".bss.") || strings.HasPrefix(name, ".sbss."):
+		a.current = a.sections[ASM_BSS]
+	case strings.HasPrefix(name, "."):
+		// Read-only and small data, and sections such as .tohost, are all kept with the data
+		a.current = a.sections[ASM_DATA]
+	default:
+		return fmt.Errorf("invalid section name %q", name)
+	}
+	return nil
+}
+
+// Assembles a directive
+func (a *assembly) directive(name string, args []string) error {
+	switch name {
+	case ".text", ".data", ".bss":
+		return a.switchSection(name)
+	case ".section":
+		if len(args) == 0 {
+			return fmt.Errorf(".section expects a section name")
+		}
+		return a.switchSection(args[0])
+
+	case ".globl", ".global", ".weak", ".local":
+		for _, arg := range args {
+			if a.pass == 1 {
+				a.symbol(arg).global = name != ".local"
+			}
+		}
+	case ".type":
+		if len(args) != 2 {
+			return fmt.Errorf(".type expects a symbol and a type")
+		}
+		if a.pass == 1 {
+			switch strings.TrimLeft(args[1], "@%") {
+			case "function":
+				a.symbol(args[0]).kind = elf.STT_FUNC
+			case "object":
+				a.symbol(args[0]).kind = elf.STT_OBJECT
+			default:
+				return fmt.Errorf("unknown symbol type %q", args[1])
+			}
+		}
+	case ".size":
+		if len(args) != 2 {
+			return fmt.Errorf(".size expects a symbol and a size")
+		}
+		if a.pass == 2 {
+			size, err := a.eval(args[1])
+			if err != nil {
+				return err
+			}
+			a.symbol(args[0]).size = uint32(size)
+		}
+	case ".equ", ".set", ".equiv":
+		if len(args) != 2 {
+			return fmt.Errorf("%s expects a symbol and a value", name)
+		}
+		if a.pass == 1 {
+			symbol := a.symbol(args[0])
+			if symbol.defined && (name == ".equiv" || symbol.section != nil) {
+				return fmt.Errorf("symbol %q is already defined", args[0])
+			}
+			symbol.expr, symbol.defined = args[1], true
+		}
+
+	case ".align", ".p2align", ".balign":
+		return a.align(name, args)
+	case ".zero", ".skip", ".space":
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("%s expects a size and an optional fill value", name)
+		}
+		// The size is needed in the first pass, so it cannot refer to labels that follow
+		size, err := a.eval(args[0])
+		if err != nil {
+			return err
+		}
+		fill := int64(0)
+		if len(args) == 2 {
+			if fill, err = a.eval(args[1]); err != nil {
+				return err
+			}
+		}
+		if size < 0 {
+			return fmt.Errorf("negative size %d", size)
+		}
+		return a.emit(bytes.Repeat([]byte{byte(fill)}, int(size)))
+
+	case ".byte":
+		return a.data(args, 1)
+	case ".half", ".short", ".2byte":
+		return a.data(args, BYTES_PER_HALF)
+	case ".word", ".long", ".4byte":
+		return a.data(args, BYTES_PER_WORD)
+	case ".dword", ".quad", ".8byte":
+		return a.data(args, BYTES_PER_DOUBLE)
+	case ".ascii", ".asciz", ".string":
+		for _, arg := range args {
+			text, err := strconv.Unquote(arg)
+			if err != nil || !strings.HasPrefix(arg, `"`) {
+				return fmt.Errorf("invalid string %s", arg)
+			}
+			if name != ".ascii" {
+				text += "\x00"
+			}
+			if err := a.emit([]byte(text)); err != nil {
+				return err
+			}
+		}
+
+	case ".option", ".file", ".ident", ".attribute", ".cfi_startproc", ".cfi_endproc":
+		// These only guide a linker or debugger, or control compression, which this assembler never does
+	default:
+		return fmt.Errorf("unknown directive %s", name)
+	}
+	return nil
+}
+
+// Pads the current section to an alignment, with nops in code
+func (a *assembly) align(name string, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("%s expects an alignment and an optional fill value", name)
+	}
+	value, err := a.eval(args[0])
+	if err != nil {
+		return err
+	}
+	// On RISC-V, .align gives a power of two like .p2align
+	alignment := value
+	if name != ".balign" {
+		if value < 0 || value > 16 {
+			return fmt.Errorf("alignment 2^%d is out of range", value)
+		}
+		alignment = 1 << value
+	}
+	if alignment <= 0 || alignment&(alignment-1) != 0 {
+		return fmt.Errorf("alignment %d is not a power of two", alignment)
+	}
+	a.current.align = max(a.current.align, uint32(alignment))
+	padding := alignUp(a.current.size, uint32(alignment)) - a.current.size
+
+	if len(args) == 2 {
+		fill, err := a.eval(args[1])
+		if err != nil {
+			return err
+		}
+		return a.emit(bytes.Repeat([]byte{byte(fill)}, int(padding)))
+	}
+	if a.current == a.sections[ASM_TEXT] && a.current.size%BYTES_PER_WORD == 0 {
+		for ; padding > 0; padding -= BYTES_PER_WORD {
+			if err := a.emitValue(uint64(encodeFields("addi", 0, 0, 0, 0)), BYTES_PER_WORD); err != nil {
+				return err
+			}
+		}
+	}
+	return a.emit(make([]byte, padding))
+}
+
+// Appends the values of a list of expressions, each of the given size in bytes
+func (a *assembly) data(args []string, size uint32) error {
+	for _, arg := range args {
+		// The values are only needed once every label has its address
+		value := int64(0)
+		if a.pass == 2 {
+			var err error
+			if value, err = a.eval(arg); err != nil {
+				return err
+			}
+			if size < BYTES_PER_DOUBLE && (value >= 1<<(8*size) || value < -1<<(8*size-1)) {
+				return fmt.Errorf("value %d does not fit in %d bytes", value, size)
+			}
+		}
+		if err := a.emitValue(uint64(value), size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Evaluates an expression, which must only refer to defined symbols
+func (a *assembly) eval(text string) (int64, error) {
+	parser := &exprParser{a: a, text: text}
+	value, err := parser.parse(0)
+	if err != nil {
+		return 0, err
+	}
+	if parser.skipSpace(); parser.pos < len(parser.text) {
+		return 0, fmt.Errorf("unexpected %q in expression %q", parser.text[parser.pos:], text)
+	}
+	return value, nil
+}
+
+// Returns the value of a symbol
+func (a *assembly) symbolValue(name string) (int64, error) {
+	// Numeric labels are referred to as 1b for the last one defined, or 1f for the next
+	if last := name[len(name)-1]; name[0] >= '0' && name[0] <= '9' && (last == 'b' || last == 'f') {
+		definitions := a.numeric[name[:len(name)-1]]
+		for i := range definitions {
+			if last == 'b' {
+				i = len(definitions) - 1 - i
+			}
+			label := definitions[i]
+			if last == 'b' && label.statement <= a.statement || last == 'f' && label.statement > a.statement {
+				return int64(label.section.addr + label.offset), nil
+			}
+		}
+		return 0, fmt.Errorf("%w %s", ErrUndefinedSymbol, name)
+	}
+
+	symbol, ok := a.symbols[name]
+	if !ok || !symbol.defined {
+		return 0, fmt.Errorf("%w %q", ErrUndefinedSymbol, name)
+	}
+	if symbol.section != nil {
+		// The BSS is only placed once the first pass is over
+		if a.pass == 1 && symbol.section == a.sections[ASM_BSS] {
+			return 0, fmt.Errorf("%w %q", ErrUndefinedSymbol, name)
+		}
+		return int64(symbol.section.addr + symbol.offset), nil
+	}
+	if symbol.pending {
+		return 0, fmt.Errorf("symbol %q is defined in terms of itself", name)
+	}
+	symbol.pending = true
+	defer func() { symbol.pending = false }()
+	return a.eval(symbol.expr)
+}
+
+// Returns the upper 20 bits of a value, rounded so that adding the sign-extended lower 12 bits gives the value
+func hi20(value int64) int64 {
+	return (value + 0x800) >> 12 & 0xFFFFF
+}
+
+// Returns the lower 12 bits of a value, sign-extended
+func lo12(value int64) int64 {
+	return int64(signExtend(uint32(value)&0xFFF, 12))
+}
+
+// Parses expressions of numbers, symbols and the relocation functions %hi, %lo, %pcrel_hi and
+// %pcrel_lo, with the operators and precedence of C
+type exprParser struct {
+	a    *assembly
+	text string
+	pos  int
+}
+
+// Binary operators by precedence, loosest first
+var binaryOperators = [][]string{{"|"}, {"^"}, {"&"}, {"<<", ">>"}, {"+", "-"}, {"*", "/", "%"}}
+
+func (parser *exprParser) skipSpace() {
+	for parser.pos < len(parser.text) && (parser.text[parser.pos] == ' ' || parser.text[parser.pos] == '\t') {
+		parser.pos++
+	}
+}
+
+// Parses the binary operators at a precedence level and above
+func (parser *exprParser) parse(level int) (int64, error) {
+	if level == len(binaryOperators) {
+		return parser.unary()
+	}
+	left, err := parser.parse(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		parser.skipSpace()
+		operator := ""
+		for _, candidate := range binaryOperators[level] {
+			// A % followed by a name starts a relocation function, not a remainder
+			if strings.HasPrefix(parser.text[parser.pos:], candidate) && !(candidate == "%" && parser.relocation()) {
+				operator = candidate
+			}
+		}
+		if operator == "" {
+			return left, nil
+		}
+		parser.pos += len(operator)
+		right, err := parser.parse(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		switch operator {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint64(right)
+		case ">>":
+			left >>= uint64(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "%":
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero in %q", parser.text)
+			}
+			if operator == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+}
+
+// Returns whether a relocation function starts at the current position
+func (parser *exprParser) relocation() bool {
+	rest := parser.text[parser.pos:]
+	return len(rest) > 1 && rest[0] == '%' && (rest[1] >= 'a' && rest[1] <= 'z' || rest[1] == '_')
+}
+
+// Parses unary operators, parentheses, numbers, symbols and relocation functions
+func (parser *exprParser) unary() (int64, error) {
+	parser.skipSpace()
+	if parser.pos == len(parser.text) {
+		return 0, fmt.Errorf("missing operand in expression %q", parser.text)
+	}
+	switch c := parser.text[parser.pos]; {
+	case c == '-' || c == '~' || c == '+' || c == '!':
+		parser.pos++
+		value, err := parser.unary()
+		switch c {
+		case '-':
+			value = -value
+		case '~':
+			value = ^value
+		case '!':
+			if value == 0 {
+				value = 1
+			} else {
+				value = 0
+			}
+		}
+		return value, err
+	case c == '(':
+		parser.pos++
+		value, err := parser.parse(0)
+		if err != nil {
+			return 0, err
+		}
+		if parser.skipSpace(); parser.pos == len(parser.text) || parser.text[parser.pos] != ')' {
+			return 0, fmt.Errorf("missing ) in expression %q", parser.text)
+		}
+		parser.pos++
+		return value, nil
+	case c == '%':
+		return parser.relocationFunction()
+	case c == '\'':
+		end := strings.IndexByte(parser.text[parser.pos+1:], '\'')
+		if end < 0 {
+			return 0, fmt.Errorf("unterminated character in %q", parser.text)
+		}
+		value, _, _, err := strconv.UnquoteChar(parser.text[parser.pos+1:parser.pos+1+end], '\'')
+		if err != nil {
+			return 0, fmt.Errorf("invalid character in %q", parser.text)
+		}
+		parser.pos += end + 2
+		return int64(value), nil
+	}
+
+	start := parser.pos
+	for parser.pos < len(parser.text) && isSymbolChar(parser.text[parser.pos]) {
+		parser.pos++
+	}
+	token := parser.text[start:parser.pos]
+	switch {
+	case token == "":
+		return 0, fmt.Errorf("unexpected %q in expression %q", parser.text[start:], parser.text)
+	case token == ".":
+		return int64(parser.a.addr), nil
+	case token[0] >= '0' && token[0] <= '9':
+		if last := token[len(token)-1]; (last == 'b' || last == 'f') && strings.Trim(token[:len(token)-1], "0123456789") == "" {
+			return parser.a.symbolValue(token)
+		}
+		value, err := strconv.ParseInt(token, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", token)
+		}
+		return value, nil
+	}
+	return parser.a.symbolValue(token)
+}
+
+// Returns whether a character may be part of a symbol or number
+func isSymbolChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '$'
+}
+
+// Parses %hi, %lo, %pcrel_hi or %pcrel_lo and its parenthesised argument
+func (parser *exprParser) relocationFunction() (int64, error) {
+	parser.pos++
+	start := parser.pos
+	for parser.pos < len(parser.text) && isSymbolChar(parser.text[parser.pos]) {
+		parser.pos++
+	}
+	function := parser.text[start:parser.pos]
+	if parser.skipSpace(); parser.pos == len(parser.text) || parser.text[parser.pos] != '(' {
+		return 0, fmt.Errorf("%%%s expects an argument in parentheses", function)
+	}
+	value, err := parser.unary()
+	if err != nil {
+		return 0, err
+	}
+
+	a := parser.a
+	switch function {
+	case "hi":
+		return hi20(value), nil
+	case "lo":
+		return lo12(value), nil
+	case "pcrel_hi":
+		// The matching %pcrel_lo names the auipc, so remember where it pointed
+		a.pcrelHi[a.addr] = uint32(value)
+		return hi20(value - int64(a.addr)), nil
+	case "pcrel_lo":
+		target, ok := a.pcrelHi[uint32(value)]
+		if !ok {
+			return 0, fmt.Errorf("%%pcrel_lo refers to %08x, which is not an auipc with a %%pcrel_hi before it", uint32(value))
+		}
+		return lo12(int64(target) - value), nil
+	}
+	return 0, fmt.Errorf("unknown relocation function %%%s", function)
+}
+
+// Gathers the sections and symbols of the program once both passes are done
+func (a *assembly) program() (*Program, error) {
+	program := &Program{entry: a.sections[ASM_TEXT].addr}
+	for _, section := range a.sections {
+		if section.size > 0 {
+			program.sections = append(program.sections, section)
+		}
+	}
+	for _, name := range a.definition {
+		symbol := a.symbols[name]
+		if !symbol.defined {
+			if symbol.global {
+				// Global symbols may be defined elsewhere, but there is no elsewhere to link against
+				return nil, fmt.Errorf("%w %q", ErrUndefinedSymbol, name)
+			}
+			continue
+		}
+		// Symbols starting with .L are local to the assembler and never reach the symbol table
+		if strings.HasPrefix(name, ".L") {
+			continue
+		}
+		if symbol.section == nil {
+			value, err := a.eval(symbol.expr)
+			if err != nil {
+				return nil, err
+			}
+			symbol.offset = uint32(value)
+		}
+		program.symbols = append(program.symbols, symbol)
+	}
+	if start, ok := a.symbols["_start"]; ok && start.defined {
+		program.entry = start.value()
+	}
+	return program, nil
+}
+
+// Returns the address of a label, or the value of a constant
+func (symbol *asmSymbol) value() uint32 {
+	if symbol.section != nil {
+		return symbol.section.addr + symbol.offset
+	}
+	return symbol.offset
+}
+
+// Returns the entry point of the program
+func (program *Program) Entry() uint32 {
+	return program.entry
+}
+
+// Returns the address of a symbol of the program
+func (program *Program) Symbol(name string) (uint32, bool) {
+	for _, symbol := range program.symbols {
+		if symbol.name == name {
+			return symbol.value(), true
+		}
+	}
+	return 0, false
+}
+
+// Loads the sections of a program into memory and adds its symbols, returning its entry point
+func (cpu *CPU) LoadProgram(program *Program) (uint32, error) {
+	for _, section := range program.sections {
+		data := section.data
+		if uint32(len(data)) < section.size {
+			// The BSS has no contents, but is cleared like the BSS of an ELF executable
+			data = make([]byte, section.size)
+		}
+		if err := cpu.bus.Load(section.addr, data); err != nil {
+			return 0, err
+		}
+	}
+	for _, symbol := range program.symbols {
+		cpu.symbols[symbol.name] = symbol.value()
+	}
+	return program.entry, nil
+}
+
+// Returns the sections of the program that have contents, and its symbols, for listing
+func (program *Program) listingSections() ([]listingSection, []disasmSymbol) {
+	var symbols []disasmSymbol
+	bySection := make(map[*asmSection][]disasmSymbol)
+	for _, symbol := range program.symbols {
+		s := disasmSymbol{name: symbol.name, addr: symbol.value(), global: symbol.global}
+		if symbol.section != nil || symbol.name == GLOBAL_POINTER_SYMBOL {
+			symbols = append(symbols, s)
+			bySection[symbol.section] = append(bySection[symbol.section], s)
+		}
+	}
+	var sections []listingSection
+	for _, section := range program.sections {
+		if len(section.data) > 0 {
+			sections = append(sections, listingSection{
+				name:    section.name,
+				addr:    section.addr,
+				data:    section.data,
+				symbols: newDisassembler(bySection[section]).symbols,
+			})
+		}
+	}
+	return sections, symbols
+}
+
+// Sizes of the structures of a 32-bit ELF file
+const (
+	ELF32_HEADER_SIZE  = 52
+	ELF32_PROG_SIZE    = 32
+	ELF32_SECTION_SIZE = 40
+	ELF32_SYMBOL_SIZE  = 16
+)
+
+// Writes the program as a RISC-V ELF executable, with a segment per section and a symbol table
+func (program *Program) WriteELF(w io.Writer) error {
+	shstrtab := []byte{0}
+	addName := func(table *[]byte, name string) uint32 {
+		offset := uint32(len(*table))
+		*table = append(append(*table, name...), 0)
+		return offset
+	}
+
+	// Contents follow the headers, each section at an offset congruent to its address
+	var contents bytes.Buffer
+	base := uint32(ELF32_HEADER_SIZE + ELF32_PROG_SIZE*len(program.sections))
+	sections := []elf.Section32{{}}
+	var progs []elf.Prog32
+	index := make(map[*asmSection]uint16)
+	for _, section := range program.sections {
+		for (base+uint32(contents.Len()))%section.align != section.addr%section.align {
+			contents.WriteByte(0)
+		}
+		offset := base + uint32(contents.Len())
+		contents.Write(section.data)
+
+		header := elf.Section32{
+			Name: addName(&shstrtab, section.name), Type: uint32(elf.SHT_PROGBITS),
+			Flags: uint32(elf.SHF_ALLOC | elf.SHF_WRITE), Addr: section.addr, Off: offset, Size: section.size, Addralign: section.align,
+		}
+		prog := elf.Prog32{
+			Type: uint32(elf.PT_LOAD), Off: offset, Vaddr: section.addr, Paddr: section.addr,
+			Filesz: uint32(len(section.data)), Memsz: section.size, Flags: uint32(elf.PF_R | elf.PF_W), Align: section.align,
+		}
+		switch section.name {
+		case ".text":
+			header.Flags, prog.Flags = uint32(elf.SHF_ALLOC|elf.SHF_EXECINSTR), uint32(elf.PF_R|elf.PF_X)
+		case ".bss":
+			header.Type = uint32(elf.SHT_NOBITS)
+		}
+		index[section] = uint16(len(sections))
+		sections = append(sections, header)
+		progs = append(progs, prog)
+	}
+
+	// The symbol table lists the local symbols before the global ones
+	strtab := []byte{0}
+	symtab := []elf.Sym32{{}}
+	sorted := append([]*asmSymbol(nil), program.symbols...)
+	sort.SliceStable(sorted, func(i, j int) bool { return !sorted[i].global && sorted[j].global })
+	firstGlobal := uint32(len(sorted) + 1)
+	for i, symbol := range sorted {
+		bind, shndx := elf.STB_LOCAL, uint16(elf.SHN_ABS)
+		if symbol.global {
+			bind = elf.STB_GLOBAL
+			firstGlobal = min(firstGlobal, uint32(i+1))
+		}
+		if symbol.section != nil {
+			shndx = index[symbol.section]
+		}
+		symtab = append(symtab, elf.Sym32{
+			Name: addName(&strtab, symbol.name), Value: symbol.value(), Size: symbol.size,
+			Info: elf.ST_INFO(bind, symbol.kind), Shndx: shndx,
+		})
+	}
+	for contents.Len()%4 != 0 {
+		contents.WriteByte(0)
+	}
+	symtabOffset := base + uint32(contents.Len())
+	binary.Write(&contents, binary.LittleEndian, symtab)
+	strtabOffset := base + uint32(contents.Len())
+	contents.Write(strtab)
+	symtabIndex := uint32(len(sections))
+	sections = append(sections,
+		elf.Section32{Name: addName(&shstrtab, ".symtab"), Type: uint32(elf.SHT_SYMTAB), Off: symtabOffset,
+			Size: uint32(len(symtab) * ELF32_SYMBOL_SIZE), Link: symtabIndex + 1, Info: firstGlobal, Addralign: 4, Entsize: ELF32_SYMBOL_SIZE},
+		elf.Section32{Name: addName(&shstrtab, ".strtab"), Type: uint32(elf.SHT_STRTAB), Off: strtabOffset,
+			Size: uint32(len(strtab)), Addralign: 1},
+	)
+	shstrtabName := addName(&shstrtab, ".shstrtab")
+	sections = append(sections, elf.Section32{Name: shstrtabName, Type: uint32(elf.SHT_STRTAB),
+		Off: base + uint32(contents.Len()), Size: uint32(len(shstrtab)), Addralign: 1})
+	contents.Write(shstrtab)
+	for contents.Len()%4 != 0 {
+		contents.WriteByte(0)
+	}
+
+	header := elf.Header32{
+		Type: uint16(elf.ET_EXEC), Machine: uint16(elf.EM_RISCV), Version: uint32(elf.EV_CURRENT),
+		Entry: program.entry, Phoff: ELF32_HEADER_SIZE, Shoff: base + uint32(contents.Len()),
+		Ehsize: ELF32_HEADER_SIZE, Phentsize: ELF32_PROG_SIZE, Phnum: uint16(len(progs)),
+		Shentsize: ELF32_SECTION_SIZE, Shnum: uint16(len(sections)), Shstrndx: uint16(len(sections) - 1),
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	for _, part := range []any{header, progs, contents.Bytes(), sections} {
+		if err := binary.Write(w, binary.LittleEndian, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,783 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Bits of an atomic memory operation that order it with other accesses
+const (
+	AMO_AQ uint32 = 1 << 26 // Acquire, so later accesses cannot be seen before it
+	AMO_RL uint32 = 1 << 25 // Release, so earlier accesses cannot be seen after it
+)
+
+// The encoding of each instruction by its mnemonic, for assembling
+var encodingByMnemonic = make(map[string]*encoding)
+
+// The address of each CSR by its name, for assembling
+var csrAddresses = make(map[string]uint32)
+
+// Floating-point instructions whose destination is an integer register
+var floatToInteger = map[string]bool{
+	"fcvt.w.s": true, "fcvt.wu.s": true, "fcvt.w.d": true, "fcvt.wu.d": true, "fmv.x.w": true,
+	"feq.s": true, "flt.s": true, "fle.s": true, "feq.d": true, "flt.d": true, "fle.d": true,
+	"fclass.s": true, "fclass.d": true,
+}
+
+// Floating-point instructions whose source is an integer register
+var integerToFloat = map[string]bool{
+	"fcvt.s.w": true, "fcvt.s.wu": true, "fcvt.d.w": true, "fcvt.d.wu": true, "fmv.w.x": true,
+}
+
+func init() {
+	for i := range encodings {
+		encodingByMnemonic[encodings[i].mnemonic] = &encodings[i]
+	}
+	for addr, csr := range newCSRFile() {
+		csrAddresses[csr.name] = addr
+	}
+}
+
+// Places an immediate into the bits of a word the format keeps it in, the reverse of decodeImmediate
+func encodeImmediate(imm int32, format InstructionFormat) uint32 {
+	value := uint32(imm)
+	switch format {
+	case FORMAT_I:
+		return (value & 0xFFF) << 20
+	case FORMAT_I_SHIFT:
+		return (value & 0x1F) << 20
+	case FORMAT_S:
+		return (value>>5&0x7F)<<25 | (value&0x1F)<<7
+	case FORMAT_B:
+		return (value>>12&0x1)<<31 | (value>>5&0x3F)<<25 | (value>>1&0xF)<<8 | (value>>11&0x1)<<7
+	case FORMAT_U:
+		return value & 0xFFFFF000
+	case FORMAT_J:
+		return (value>>20&0x1)<<31 | (value>>1&0x3FF)<<21 | (value>>11&0x1)<<20 | (value>>12&0xFF)<<12
+	default:
+		return 0
+	}
+}
+
+// Encodes an instruction from its operand fields, of which only those its format holds are used
+func encodeFields(mnemonic string, rd uint8, rs1 uint8, rs2 uint8, imm int32) uint32 {
+	e := encodingByMnemonic[mnemonic]
+	word := e.match | encodeImmediate(imm, e.format)
+	switch e.format {
+	case FORMAT_R, FORMAT_R4:
+		word |= uint32(rd)<<REGISTER_RD_SHIFT | uint32(rs1)<<REGISTER_RS1_SHIFT | uint32(rs2)<<REGISTER_RS2_SHIFT
+	case FORMAT_I, FORMAT_I_SHIFT:
+		word |= uint32(rd)<<REGISTER_RD_SHIFT | uint32(rs1)<<REGISTER_RS1_SHIFT
+	case FORMAT_S, FORMAT_B:
+		word |= uint32(rs1)<<REGISTER_RS1_SHIFT | uint32(rs2)<<REGISTER_RS2_SHIFT
+	case FORMAT_U, FORMAT_J:
+		word |= uint32(rd) << REGISTER_RD_SHIFT
+	}
+	return word
+}
+
+// Appends an instruction encoded from its operand fields
+func (a *assembly) encode(mnemonic string, rd uint8, rs1 uint8, rs2 uint8, imm int32) error {
+	return a.emitValue(uint64(encodeFields(mnemonic, rd, rs1, rs2, imm)), BYTES_PER_WORD)
+}
+
+// Checks that an instruction was given the number of operands it takes
+func expectOperands(mnemonic string, args []string, counts ...int) error {
+	for _, count := range counts {
+		if len(args) == count {
+			return nil
+		}
+	}
+	if len(counts) == 1 {
+		return fmt.Errorf("%s expects %d operands, got %d", mnemonic, counts[0], len(args))
+	}
+	return fmt.Errorf("%s expects %d to %d operands, got %d", mnemonic, counts[0], counts[len(counts)-1], len(args))
+}
+
+// Parses the names of integer registers
+func registers(args ...string) ([]uint8, error) {
+	regs := make([]uint8, len(args))
+	for i, arg := range args {
+		reg, err := LookupRegister(arg)
+		if err != nil {
+			return nil, err
+		}
+		regs[i] = reg
+	}
+	return regs, nil
+}
+
+// Parses the name of a floating-point register, either by its number or by its name in the calling convention
+func lookupFloatRegister(name string) (uint8, error) {
+	name = strings.ToLower(name)
+	for reg, abiName := range floatRegisterNames {
+		if name == abiName || name == fmt.Sprintf("f%d", reg) {
+			return uint8(reg), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown floating-point register %q", name)
+}
+
+// Evaluates an immediate, checking that it fits in a signed or unsigned field of the given width
+func (a *assembly) immediate(text string, bits uint, signed bool) (int32, error) {
+	value, err := a.eval(text)
+	if err != nil {
+		return 0, err
+	}
+	low, high := int64(0), int64(1)<<bits-1
+	if signed {
+		low, high = -1<<(bits-1), 1<<(bits-1)-1
+	}
+	if value < low || value > high {
+		return 0, fmt.Errorf("immediate %d is out of range [%d, %d]", value, low, high)
+	}
+	return int32(value), nil
+}
+
+// Evaluates the target of a branch or jump into an offset from the instruction, checking that it reaches
+func (a *assembly) target(text string, bits uint) (int32, error) {
+	value, err := a.eval(text)
+	if err != nil {
+		return 0, err
+	}
+	offset := int64(int32(uint32(value) - a.addr))
+	if offset&1 != 0 {
+		return 0, fmt.Errorf("target %08x is not aligned to 2 bytes", uint32(value))
+	}
+	if offset < -1<<(bits-1) || offset >= 1<<(bits-1) {
+		return 0, fmt.Errorf("target %08x is out of reach of %08x", uint32(value), a.addr)
+	}
+	return int32(offset), nil
+}
+
+// Evaluates the address of a symbol into the upper and lower parts of its offset from the instruction,
+// for an auipc and the instruction that follows it
+func (a *assembly) pcrel(text string) (int32, int32, error) {
+	value, err := a.eval(text)
+	if err != nil {
+		return 0, 0, err
+	}
+	offset := int64(uint32(value) - a.addr)
+	return int32(hi20(offset) << 12), int32(lo12(offset)), nil
+}
+
+// Splits a memory operand of the form offset(base), returning false for operands of another form
+func splitMemoryOperand(text string) (string, uint8, bool) {
+	if !strings.HasSuffix(text, ")") {
+		return "", 0, false
+	}
+	open := strings.LastIndexByte(text, '(')
+	if open < 0 {
+		return "", 0, false
+	}
+	base, err := LookupRegister(strings.TrimSpace(text[open+1 : len(text)-1]))
+	if err != nil {
+		return "", 0, false
+	}
+	offset := strings.TrimSpace(text[:open])
+	if offset == "" {
+		offset = "0"
+	}
+	return offset, base, true
+}
+
+// Evaluates a CSR given by its name or address
+func (a *assembly) csr(text string) (int32, error) {
+	if addr, ok := csrAddresses[strings.ToLower(text)]; ok {
+		return int32(addr), nil
+	}
+	return a.immediate(text, 12, false)
+}
+
+// Returns whether an instruction accesses memory at an address given as a symbol rather than
+// as offset(base), which takes an auipc to reach
+func isSymbolAccess(mnemonic string, args []string) bool {
+	e, ok := encodingByMnemonic[mnemonic]
+	if !ok || len(args) < 2 {
+		return false
+	}
+	switch InstructionType(e.match & MASK_OPCODE) {
+	case I_TYPE_LOAD, I_TYPE_LOAD_FP, S_TYPE, S_TYPE_FP:
+		_, _, ok := splitMemoryOperand(args[1])
+		return !ok
+	}
+	return false
+}
+
+// Returns the number of bytes an instruction takes, before the labels it refers to have addresses
+func (a *assembly) instructionSize(mnemonic string, args []string) uint32 {
+	switch mnemonic {
+	case "la", "lla", "call", "tail":
+		return 2 * BYTES_PER_WORD
+	case "li":
+		// Values that are not known yet take the longest sequence
+		if len(args) == 2 {
+			if value, err := a.eval(args[1]); err != nil || len(loadImmediate(value, false)) > 1 {
+				return 2 * BYTES_PER_WORD
+			}
+		}
+	}
+	if isSymbolAccess(mnemonic, args) {
+		return 2 * BYTES_PER_WORD
+	}
+	return BYTES_PER_WORD
+}
+
+// One of the instructions li expands to
+type immediateStep struct {
+	mnemonic string
+	imm      int32
+}
+
+// Splits a value into the lui and addi that load it, leaving out whichever is not needed unless both are
+func loadImmediate(value int64, both bool) []immediateStep {
+	hi, lo := int32(hi20(value)<<12), int32(lo12(value))
+	switch {
+	case both:
+	case hi == 0:
+		return []immediateStep{{"addi", lo}}
+	case lo == 0:
+		return []immediateStep{{"lui", hi}}
+	}
+	return []immediateStep{{"lui", hi}, {"addi", lo}}
+}
+
+// Assembles an instruction or pseudo-instruction
+func (a *assembly) instruction(mnemonic string, args []string) error {
+	if ok, err := a.pseudoInstruction(mnemonic, args); ok {
+		return err
+	}
+
+	// Atomic memory operations take their ordering as a suffix
+	var ordering uint32
+	e, ok := encodingByMnemonic[mnemonic]
+	if !ok {
+		for suffix, bits := range map[string]uint32{".aq": AMO_AQ, ".rl": AMO_RL, ".aqrl": AMO_AQ | AMO_RL} {
+			if base, found := strings.CutSuffix(mnemonic, suffix); found {
+				if e, ok = encodingByMnemonic[base]; ok && InstructionType(e.match&MASK_OPCODE) == R_TYPE_AMO {
+					ordering = bits
+					break
+				}
+				ok = false
+			}
+		}
+		if !ok {
+			return fmt.Errorf("unknown instruction %q", mnemonic)
+		}
+	}
+
+	word, err := a.operands(e, args)
+	if err != nil {
+		return err
+	}
+	return a.emitValue(uint64(word|ordering), BYTES_PER_WORD)
+}
+
+// Parses the operands of an instruction as its opcode lays them out, returning the encoded word
+func (a *assembly) operands(e *encoding, args []string) (uint32, error) {
+	mnemonic := e.mnemonic
+	switch InstructionType(e.match & MASK_OPCODE) {
+	case U_TYPE_LUI, U_TYPE_AUIPC:
+		if err := expectOperands(mnemonic, args, 2); err != nil {
+			return 0, err
+		}
+		rd, err := LookupRegister(args[0])
+		if err != nil {
+			return 0, err
+		}
+		imm, err := a.immediate(args[1], 20, false)
+		return encodeFields(mnemonic, rd, 0, 0, imm<<12), err
+
+	case J_TYPE:
+		// jal may leave out the return address register, which is then ra
+		if err := expectOperands(mnemonic, args, 1, 2); err != nil {
+			return 0, err
+		}
+		rd := uint8(REG_RA)
+		if len(args) == 2 {
+			var err error
+			if rd, err = LookupRegister(args[0]); err != nil {
+				return 0, err
+			}
+		}
+		offset, err := a.target(args[len(args)-1], 21)
+		return encodeFields(mnemonic, rd, 0, 0, offset), err
+
+	case I_TYPE_JALR:
+		return a.jalr(args)
+
+	case B_TYPE:
+		if err := expectOperands(mnemonic, args, 3); err != nil {
+			return 0, err
+		}
+		regs, err := registers(args[0], args[1])
+		if err != nil {
+			return 0, err
+		}
+		offset, err := a.target(args[2], 13)
+		return encodeFields(mnemonic, 0, regs[0], regs[1], offset), err
+
+	case I_TYPE_LOAD, I_TYPE_LOAD_FP, S_TYPE, S_TYPE_FP:
+		return a.memoryAccess(e, args)
+
+	case I_TYPE_ARITH, R_TYPE:
+		if err := expectOperands(mnemonic, args, 3); err != nil {
+			return 0, err
+		}
+		if e.format == FORMAT_R {
+			regs, err := registers(args...)
+			if err != nil {
+				return 0, err
+			}
+			return encodeFields(mnemonic, regs[0], regs[1], regs[2], 0), nil
+		}
+		regs, err := registers(args[0], args[1])
+		if err != nil {
+			return 0, err
+		}
+		var imm int32
+		if e.format == FORMAT_I_SHIFT {
+			imm, err = a.immediate(args[2], 5, false)
+		} else {
+			imm, err = a.immediate(args[2], 12, true)
+		}
+		return encodeFields(mnemonic, regs[0], regs[1], 0, imm), err
+
+	case I_TYPE_FENCE:
+		return a.fence(e, args)
+
+	case I_TYPE_SYS:
+		if e.mask == MASK_WORD {
+			return e.match, expectOperands(mnemonic, args, 0)
+		}
+		if err := expectOperands(mnemonic, args, 3); err != nil {
+			return 0, err
+		}
+		rd, err := LookupRegister(args[0])
+		if err != nil {
+			return 0, err
+		}
+		csr, err := a.csr(args[1])
+		if err != nil {
+			return 0, err
+		}
+		// The immediate forms keep a 5-bit value where the others name rs1
+		var rs1 uint8
+		if strings.HasSuffix(mnemonic, "i") {
+			var uimm int32
+			uimm, err = a.immediate(args[2], 5, false)
+			rs1 = uint8(uimm)
+		} else {
+			rs1, err = LookupRegister(args[2])
+		}
+		return encodeFields(mnemonic, rd, rs1, 0, csr), err
+
+	case R_TYPE_AMO:
+		return a.atomic(e, args)
+	}
+	return a.float(e, args)
+}
+
+// Parses the operands of jalr, which may leave out the return address register or the offset
+func (a *assembly) jalr(args []string) (uint32, error) {
+	if err := expectOperands("jalr", args, 1, 2, 3); err != nil {
+		return 0, err
+	}
+	rd, rest := uint8(REG_RA), args
+	if len(args) > 1 {
+		var err error
+		if rd, err = LookupRegister(args[0]); err != nil {
+			return 0, err
+		}
+		rest = args[1:]
+	}
+	if len(rest) == 2 {
+		rs1, err := LookupRegister(rest[0])
+		if err != nil {
+			return 0, err
+		}
+		imm, err := a.immediate(rest[1], 12, true)
+		return encodeFields("jalr", rd, rs1, 0, imm), err
+	}
+	offset, rs1, ok := splitMemoryOperand(rest[0])
+	if !ok {
+		offset = "0"
+		var err error
+		if rs1, err = LookupRegister(rest[0]); err != nil {
+			return 0, err
+		}
+	}
+	imm, err := a.immediate(offset, 12, true)
+	return encodeFields("jalr", rd, rs1, 0, imm), err
+}
+
+// Parses the operands of a load or store, whose address is either offset(base) or a symbol, which
+// takes an auipc into rd for loads, or into a temporary register given as a third operand for stores
+func (a *assembly) memoryAccess(e *encoding, args []string) (uint32, error) {
+	opcode := InstructionType(e.match & MASK_OPCODE)
+	store := opcode == S_TYPE || opcode == S_TYPE_FP
+	lookupData := LookupRegister
+	if opcode == I_TYPE_LOAD_FP || opcode == S_TYPE_FP {
+		lookupData = lookupFloatRegister
+	}
+	if err := expectOperands(e.mnemonic, args, 2, 3); err != nil {
+		return 0, err
+	}
+	data, err := lookupData(args[0])
+	if err != nil {
+		return 0, err
+	}
+	encode := func(base uint8, imm int32) uint32 {
+		if store {
+			return encodeFields(e.mnemonic, 0, base, data, imm)
+		}
+		return encodeFields(e.mnemonic, data, base, 0, imm)
+	}
+
+	if offset, base, ok := splitMemoryOperand(args[1]); ok {
+		if len(args) != 2 {
+			return 0, expectOperands(e.mnemonic, args, 2)
+		}
+		imm, err := a.immediate(offset, 12, true)
+		return encode(base, imm), err
+	}
+
+	// Integer loads can reach the symbol through their own destination, everything else needs a temporary
+	temporary := data
+	if store || opcode == I_TYPE_LOAD_FP || len(args) == 3 {
+		if len(args) != 3 {
+			return 0, fmt.Errorf("%s of a symbol expects a temporary register as its third operand", e.mnemonic)
+		}
+		if temporary, err = LookupRegister(args[2]); err != nil {
+			return 0, err
+		}
+	}
+	hi, lo, err := a.pcrel(args[1])
+	if err != nil {
+		return 0, err
+	}
+	if err := a.encode("auipc", temporary, 0, 0, hi); err != nil {
+		return 0, err
+	}
+	return encode(temporary, lo), nil
+}
+
+// Parses the sets of accesses a fence orders, which default to all of them
+func (a *assembly) fence(e *encoding, args []string) (uint32, error) {
+	if e.mnemonic == "fence.i" {
+		return e.match, expectOperands(e.mnemonic, args, 0)
+	}
+	if err := expectOperands(e.mnemonic, args, 0, 2); err != nil {
+		return 0, err
+	}
+	sets := []uint32{0xF, 0xF}
+	for i, arg := range args {
+		found := false
+		for bits, name := range fenceSetNames {
+			if strings.ToLower(arg) == name && name != "0" {
+				sets[i], found = uint32(bits), true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid fence set %q", arg)
+		}
+	}
+	return e.match | sets[0]<<24 | sets[1]<<20, nil
+}
+
+// Parses the operands of an atomic memory operation, whose address is in parentheses
+func (a *assembly) atomic(e *encoding, args []string) (uint32, error) {
+	load := e.mask == MASK_LR
+	count := 3
+	if load {
+		count = 2
+	}
+	if err := expectOperands(e.mnemonic, args, count); err != nil {
+		return 0, err
+	}
+	offset, rs1, ok := splitMemoryOperand(args[count-1])
+	if !ok {
+		return 0, fmt.Errorf("%s expects its address as (register), got %q", e.mnemonic, args[count-1])
+	}
+	if imm, err := a.eval(offset); err != nil || imm != 0 {
+		return 0, fmt.Errorf("%s cannot take an offset from its address", e.mnemonic)
+	}
+	regs, err := registers(args[:count-1]...)
+	if err != nil {
+		return 0, err
+	}
+	if load {
+		return encodeFields(e.mnemonic, regs[0], rs1, 0, 0), nil
+	}
+	return encodeFields(e.mnemonic, regs[0], rs1, regs[1], 0), nil
+}
+
+// Parses the operands of a floating-point computation, which may end with a rounding mode when the
+// encoding leaves it free
+func (a *assembly) float(e *encoding, args []string) (uint32, error) {
+	sources := 2
+	switch {
+	case e.format == FORMAT_R4:
+		sources = 3
+	case e.mask&(REGISTER_MASK<<REGISTER_RS2_SHIFT) != 0:
+		sources = 1
+	}
+	rounded := e.mask&(0x7<<FUNCT3_SHIFT) == 0
+	count := 1 + sources
+	if rounded && len(args) == count+1 {
+		count++
+	}
+	if err := expectOperands(e.mnemonic, args, count); err != nil {
+		return 0, err
+	}
+
+	lookupRd, lookupRs := lookupFloatRegister, lookupFloatRegister
+	if floatToInteger[e.mnemonic] {
+		lookupRd = LookupRegister
+	}
+	if integerToFloat[e.mnemonic] {
+		lookupRs = LookupRegister
+	}
+	var regs [4]uint8
+	for i, arg := range args[:1+sources] {
+		lookup := lookupRs
+		if i == 0 {
+			lookup = lookupRd
+		}
+		reg, err := lookup(arg)
+		if err != nil {
+			return 0, err
+		}
+		regs[i] = reg
+	}
+
+	word := encodeFields(e.mnemonic, regs[0], regs[1], regs[2], 0) | uint32(regs[3])<<REGISTER_RS3_SHIFT
+	if rounded {
+		// Conversions to a double are exact, so they default to rne rather than the dynamic mode
+		rm := uint32(0x7)
+		if strings.HasPrefix(e.mnemonic, "fcvt.d.") {
+			rm = 0x0
+		}
+		if len(args) > 1+sources {
+			mode := strings.ToLower(args[len(args)-1])
+			found := false
+			for bits, name := range roundingModeNames {
+				if mode == name && name != "unknown" || mode == "dyn" && bits == 0x7 {
+					rm, found = uint32(bits), true
+				}
+			}
+			if !found {
+				return 0, fmt.Errorf("invalid rounding mode %q", args[len(args)-1])
+			}
+		}
+		word |= rm << FUNCT3_SHIFT
+	}
+	return word, nil
+}
+
+// Assembles a pseudo-instruction, returning false for mnemonics that are not one
+func (a *assembly) pseudoInstruction(mnemonic string, args []string) (bool, error) {
+	// Pseudo-instructions of the form op rd, rs expanded with a fixed third operand
+	unary := map[string]func(rd, rs uint8) error{
+		"mv":   func(rd, rs uint8) error { return a.encode("addi", rd, rs, 0, 0) },
+		"not":  func(rd, rs uint8) error { return a.encode("xori", rd, rs, 0, -1) },
+		"neg":  func(rd, rs uint8) error { return a.encode("sub", rd, REG_ZERO, rs, 0) },
+		"seqz": func(rd, rs uint8) error { return a.encode("sltiu", rd, rs, 0, 1) },
+		"snez": func(rd, rs uint8) error { return a.encode("sltu", rd, REG_ZERO, rs, 0) },
+		"sltz": func(rd, rs uint8) error { return a.encode("slt", rd, rs, REG_ZERO, 0) },
+		"sgtz": func(rd, rs uint8) error { return a.encode("slt", rd, REG_ZERO, rs, 0) },
+	}
+	// Branches comparing against zero, as the branch and whether the register is its second operand
+	zeroBranches := map[string]struct {
+		mnemonic string
+		swap     bool
+	}{
+		"beqz": {"beq", false}, "bnez": {"bne", false}, "bltz": {"blt", false},
+		"bgez": {"bge", false}, "blez": {"bge", true}, "bgtz": {"blt", true},
+	}
+	// Branches with their operands swapped
+	swappedBranches := map[string]string{"bgt": "blt", "ble": "bge", "bgtu": "bltu", "bleu": "bgeu"}
+	// Counters read by rdcycle and friends
+	counters := map[string]uint32{
+		"rdcycle": CSR_CYCLE, "rdtime": CSR_TIME, "rdinstret": CSR_INSTRET,
+		"rdcycleh": CSR_CYCLEH, "rdtimeh": CSR_TIMEH, "rdinstreth": CSR_INSTRETH,
+	}
+	// Sign injections that move, negate or take the absolute value of a floating-point register
+	signInjections := map[string]string{
+		"fmv.s": "fsgnj.s", "fneg.s": "fsgnjn.s", "fabs.s": "fsgnjx.s",
+		"fmv.d": "fsgnj.d", "fneg.d": "fsgnjn.d", "fabs.d": "fsgnjx.d",
+	}
+
+	if expand, ok := unary[mnemonic]; ok {
+		if err := expectOperands(mnemonic, args, 2); err != nil {
+			return true, err
+		}
+		regs, err := registers(args...)
+		if err != nil {
+			return true, err
+		}
+		return true, expand(regs[0], regs[1])
+	}
+	if branch, ok := zeroBranches[mnemonic]; ok {
+		if err := expectOperands(mnemonic, args, 2); err != nil {
+			return true, err
+		}
+		rs, err := LookupRegister(args[0])
+		if err != nil {
+			return true, err
+		}
+		offset, err := a.target(args[1], 13)
+		if branch.swap {
+			return true, orError(err, a.encode(branch.mnemonic, 0, REG_ZERO, rs, offset))
+		}
+		return true, orError(err, a.encode(branch.mnemonic, 0, rs, REG_ZERO, offset))
+	}
+	if branch, ok := swappedBranches[mnemonic]; ok {
+		if err := expectOperands(mnemonic, args, 3); err != nil {
+			return true, err
+		}
+		return true, a.instruction(branch, []string{args[1], args[0], args[2]})
+	}
+	if csr, ok := counters[mnemonic]; ok {
+		if err := expectOperands(mnemonic, args, 1); err != nil {
+			return true, err
+		}
+		rd, err := LookupRegister(args[0])
+		return true, orError(err, a.encode("csrrs", rd, REG_ZERO, 0, int32(csr)))
+	}
+	if injection, ok := signInjections[mnemonic]; ok {
+		if err := expectOperands(mnemonic, args, 2); err != nil {
+			return true, err
+		}
+		return true, a.instruction(injection, []string{args[0], args[1], args[1]})
+	}
+	for csr, aliases := range floatCSRAliases {
+		if mnemonic == aliases[0] || mnemonic == aliases[1] || mnemonic == aliases[2] && aliases[2] != "" {
+			return true, a.floatCSR(mnemonic, aliases, csr, args)
+		}
+	}
+
+	switch mnemonic {
+	case "nop":
+		return true, orError(expectOperands(mnemonic, args, 0), a.encode("addi", 0, 0, 0, 0))
+	case "unimp":
+		return true, orError(expectOperands(mnemonic, args, 0), a.encode("csrrw", 0, 0, 0, int32(CSR_CYCLE)))
+	case "fence.tso":
+		return true, orError(expectOperands(mnemonic, args, 0), a.emitValue(0x8330_000F, BYTES_PER_WORD))
+	case "ret":
+		return true, orError(expectOperands(mnemonic, args, 0), a.encode("jalr", REG_ZERO, REG_RA, 0, 0))
+	case "j":
+		if err := expectOperands(mnemonic, args, 1); err != nil {
+			return true, err
+		}
+		return true, a.instruction("jal", []string{"zero", args[0]})
+	case "jr":
+		if err := expectOperands(mnemonic, args, 1, 2); err != nil {
+			return true, err
+		}
+		return true, a.instruction("jalr", append([]string{"zero"}, args...))
+
+	case "li":
+		if err := expectOperands(mnemonic, args, 2); err != nil {
+			return true, err
+		}
+		rd, err := LookupRegister(args[0])
+		if err != nil {
+			return true, err
+		}
+		value, err := a.eval(args[1])
+		if err != nil {
+			return true, err
+		}
+		if value < -1<<31 || value >= 1<<32 {
+			return true, fmt.Errorf("immediate %d does not fit in 32 bits", value)
+		}
+		// Values that were not known in the first pass were given room for both instructions
+		steps := loadImmediate(value, a.sizes[a.statement] == 2*BYTES_PER_WORD)
+		base := uint8(REG_ZERO)
+		for _, step := range steps {
+			if err := a.encode(step.mnemonic, rd, base, 0, step.imm); err != nil {
+				return true, err
+			}
+			base = rd
+		}
+		return true, nil
+
+	case "la", "lla", "call", "tail":
+		// Each is an auipc followed by an instruction that adds the lower part of the offset
+		count, rd, link, next := 2, uint8(0), uint8(REG_ZERO), "addi"
+		switch mnemonic {
+		case "call":
+			count, rd, link, next = 1, REG_RA, REG_RA, "jalr"
+		case "tail":
+			count, rd, next = 1, REG_T1, "jalr"
+		}
+		if err := expectOperands(mnemonic, args, count); err != nil {
+			return true, err
+		}
+		if count == 2 {
+			var err error
+			if rd, err = LookupRegister(args[0]); err != nil {
+				return true, err
+			}
+			link = rd
+		}
+		hi, lo, err := a.pcrel(args[count-1])
+		if err != nil {
+			return true, err
+		}
+		return true, orError(a.encode("auipc", rd, 0, 0, hi), a.encode(next, link, rd, 0, lo))
+
+	case "csrr", "csrw", "csrs", "csrc", "csrwi", "csrsi", "csrci":
+		// Reading a CSR discards nothing, while writing, setting and clearing one discard its old value
+		reading := mnemonic == "csrr"
+		if err := expectOperands(mnemonic, args, 2); err != nil {
+			return true, err
+		}
+		operands := []string{"zero", args[0], args[1]}
+		real := "csrr" + strings.TrimPrefix(mnemonic, "csr")
+		if reading {
+			operands, real = []string{args[0], args[1], "zero"}, "csrrs"
+		}
+		return true, a.instruction(real, operands)
+	}
+	return false, nil
+}
+
+// Assembles an alias for reading, writing or writing an immediate to a floating-point CSR, each of which
+// may leave out the register the old value is read into
+func (a *assembly) floatCSR(mnemonic string, aliases [3]string, csr uint32, args []string) error {
+	name := csrNames[csr]
+	switch mnemonic {
+	case aliases[0]:
+		if err := expectOperands(mnemonic, args, 1); err != nil {
+			return err
+		}
+		return a.instruction("csrrs", []string{args[0], name, "zero"})
+	case aliases[1]:
+		if err := expectOperands(mnemonic, args, 1, 2); err != nil {
+			return err
+		}
+		if len(args) == 1 {
+			return a.instruction("csrrw", []string{"zero", name, args[0]})
+		}
+		return a.instruction("csrrw", []string{args[0], name, args[1]})
+	}
+	if err := expectOperands(mnemonic, args, 1, 2); err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		return a.instruction("csrrwi", []string{"zero", name, args[0]})
+	}
+	return a.instruction("csrrwi", []string{args[0], name, args[1]})
+}
+
+// Returns the first of several errors that is not nil
+func orError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Assembles source at the default addresses
+func assemble(t *testing.T, source string) *Program {
+	t.Helper()
+	program, err := NewAssembler(ASM_TEXT_START, ASM_DATA_START).Assemble("test.s", strings.NewReader(source))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return program
+}
+
+// Loads a program and runs it until it reaches an ebreak
+func runProgram(t *testing.T, program *Program) *CPU {
+	t.Helper()
+	cpu := newTestCPU(t, 0, 0x2000)
+	entry, err := cpu.LoadProgram(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cpu.pc = entry
+	for i := 0; i < 1000; i++ {
+		if err := cpu.Step(); errors.Is(err, ErrBreakpoint) {
+			return cpu
+		} else if err != nil {
+			t.Fatalf("Step at %08x: unexpected error: %v", cpu.instructionAddress(), err)
+		}
+	}
+	t.Fatalf("did not reach an ebreak, pc = %08x", cpu.pc)
+	return nil
+}
+
+func TestAssembleInstructions(t *testing.T) {
+	// The expected words are those llvm-mc encodes
+	tests := []struct {
+		source string
+		want   []uint32
+	}{
+		{"lui a0, 0x12345", []uint32{0x12345537}},
+		{"addi a0, a1, -5", []uint32{0xffb58513}},
+		{"srai s1, s2, 5", []uint32{0x40595493}},
+		{"and s10, s11, t6", []uint32{0x01fdfd33}},
+		{"lw a0, 2047(a1)", []uint32{0x7ff5a503}},
+		{"sw ra, 12(sp)", []uint32{0x00112623}},
+		{"jalr t0, a1, -4", []uint32{0xffc582e7}},
+		{"jal 1f; 1: bgtz a0, 1b", []uint32{0x004000ef, 0x00a04063}},
+		{"fence rw, w", []uint32{0x0310000f}},
+		{"fence.tso", []uint32{0x8330000f}},
+		{"csrrwi a0, mtvec, 31", []uint32{0x305fd573}},
+		{"csrrsi a0, 0x7c0, 1", []uint32{0x7c00e573}},
+		{"amoswap.w.aqrl a0, a2, (a1)", []uint32{0x0ec5a52f}},
+		{"lr.w.aq a0, (a1)", []uint32{0x1405a52f}},
+		{"fmsub.s fa0, fa1, fa2, fa3, rne", []uint32{0x68c58547}},
+		{"fcvt.w.s a0, fa0, rtz", []uint32{0xc0051553}},
+		{"fcvt.d.w fa0, a0", []uint32{0xd2050553}},
+		{"fld fs0, 16(a0)", []uint32{0x01053407}},
+
+		// Pseudo-instructions
+		{"li a0, 0x12345678", []uint32{0x12345537, 0x67850513}},
+		{"li a0, -1", []uint32{0xfff00513}},
+		{"li a0, 0x1000", []uint32{0x00001537}},
+		{"li a0, 2048", []uint32{0x00001537, 0x80050513}},
+		{"not a0, a1", []uint32{0xfff5c513}},
+		{"seqz a0, a1", []uint32{0x0015b513}},
+		{"ret", []uint32{0x00008067}},
+		{"csrw mtvec, a0", []uint32{0x30551073}},
+		{"rdinstreth a0", []uint32{0xc8202573}},
+		{"fsflagsi a0, 3", []uint32{0x0011d573}},
+		{"fneg.d fa0, fa1", []uint32{0x22b59553}},
+		{"unimp", []uint32{0xc0001073}},
+	}
+	for _, tt := range tests {
+		program := assemble(t, tt.source)
+		want := make([]byte, 0, len(tt.want)*int(BYTES_PER_WORD))
+		for _, word := range tt.want {
+			want = append(want, byte(word), byte(word>>8), byte(word>>16), byte(word>>24))
+		}
+		if got := program.sections[0].data; !bytes.Equal(got, want) {
+			t.Errorf("Assemble(%q) = % x, want % x", tt.source, got, want)
+		}
+	}
+}
+
+func TestAssembleProgram(t *testing.T) {
+	program := assemble(t, `
+	.equ	COUNT, 5
+	.text
+	.globl	_start
+_start:
+	la	sp, stack_top
+	la	a0, values
+	li	a1, COUNT
+	call	sum
+	lui	t0, %hi(result)
+	sw	a0, %lo(result)(t0)
+	lbu	a1, message+1
+	lw	a2, counter
+	ebreak
+
+/* Returns the sum of the a1 words starting at a0 */
+sum:
+	li	t0, 0
+1:	beqz	a1, 2f
+	lw	t1, 0(a0)
+	add	t0, t0, t1
+	addi	a0, a0, 4
+	addi	a1, a1, -1		# one fewer to go
+	j	1b
+2:	mv	a0, t0; ret
+
+	.data
+values:	.word	1, 2, 3, 4, 0x100
+message: .asciz	"hi"
+	.align	2
+result:	.word	0
+
+	.bss
+counter: .zero	4
+stack:	.skip	64
+stack_top:
+`)
+	cpu := runProgram(t, program)
+
+	if a0, a1, a2 := cpu.registers.Read(REG_A0), cpu.registers.Read(REG_A1), cpu.registers.Read(REG_A2); a0 != 0x10a || a1 != 'i' || a2 != 0 {
+		t.Errorf("a0, a1, a2 = %x, %x, %x, want 10a, 69, 0", a0, a1, a2)
+	}
+	if word, _ := cpu.FetchWord(0x1018); word != 0x10a {
+		t.Errorf("result = %x, want 10a", word)
+	}
+	for name, want := range map[string]uint32{"_start": 0, "values": 0x1000, "result": 0x1018, "counter": 0x101c, "stack_top": 0x1060, "COUNT": 5} {
+		if addr, ok := program.Symbol(name); !ok || addr != want {
+			t.Errorf("Symbol(%s) = %08x, %v, want %08x", name, addr, ok, want)
+		}
+	}
+}
+
+func TestAssembleCRT0(t *testing.T) {
+	source, err := os.Open("test/src/crt0.S")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer source.Close()
+	program, err := NewAssembler(ASM_TEXT_START, ASM_DATA_START).Assemble("crt0.S", source)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The executable written out loads as the program itself does
+	var executable bytes.Buffer
+	if err := program.WriteELF(&executable); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cpu := newTestCPU(t, 0, 0x2000)
+	entry, err := cpu.LoadELF(bytes.NewReader(executable.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if start, ok := cpu.Symbol("_start"); !ok || entry != start {
+		t.Errorf("entry = %08x, want _start at %08x", entry, start)
+	}
+	if word, _ := cpu.FetchWord(0x28); word != 0x00100073 {
+		t.Errorf("word at 00000028 = %08x, want the ebreak ending crt0", word)
+	}
+
+	cpu = runProgram(t, program)
+	if gp := cpu.registers.Read(REG_GP); gp != ASM_DATA_START+ASM_GP_OFFSET {
+		t.Errorf("gp = %08x, want %08x", gp, ASM_DATA_START+ASM_GP_OFFSET)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"frob a0", `test.s:1: unknown instruction "frob"`},
+		{"nop\naddi a0, a0, 2048", "test.s:2: immediate 2048 is out of range [-2048, 2047]"},
+		{"add a0, a1", "add expects 3 operands, got 2"},
+		{"add a0, a1, q0", `unknown register "q0"`},
+		{"j nowhere", `undefined symbol "nowhere"`},
+		{"a: nop\na: nop", `test.s:2: symbol "a" is already defined`},
+		{"beq a0, a1, far\n.skip 8192\nfar:", "target 00002004 is out of reach of 00000000"},
+		{".bss\n.word 1", ".bss can only hold zeros"},
+		{".globl missing", `undefined symbol "missing"`},
+		{".frob", "unknown directive .frob"},
+	}
+	for _, tt := range tests {
+		_, err := NewAssembler(ASM_TEXT_START, 0x10000).Assemble("test.s", strings.NewReader(tt.source))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Assemble(%q) error = %v, want %q", tt.source, err, tt.want)
+		}
+	}
+}
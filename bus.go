@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Represents a device that can be mapped onto the bus, addressed by the offset from its base address
+type Device interface {
+	Read(offset uint32, size uint32) (uint32, error)
+	Write(offset uint32, size uint32, value uint32) error
+}
+
+// Implemented by devices whose contents can be loaded directly, such as RAM and ROM
+type Loader interface {
+	Load(offset uint32, data []byte) error
+}
+
+// Implemented by devices whose contents can be zeroed directly, such as RAM and ROM
+type Clearer interface {
+	Clear(offset uint32, size uint32) error
+}
+
+// Implemented by devices that commit host memory as they are used
+type Resident interface {
+	Resident() uint64
+}
+
+// Represents the interconnect the CPU performs its loads and stores through
+type Bus interface {
+	Map(region Region) error
+	Read(addr uint32, size uint32) (uint32, error)
+	Write(addr uint32, size uint32, value uint32) error
+	ReadDouble(addr uint32) (uint64, error)
+	WriteDouble(addr uint32, value uint64) error
+	Load(addr uint32, data []byte) error
+	Clear(addr uint32, size uint32) error
+	Resident() uint64
+}
+
+// Bitmasks of the access sizes a region accepts, where bit n allows n-byte accesses
+const (
+	ACCESS_BYTE   uint32 = 1 << 1
+	ACCESS_HALF   uint32 = 1 << 2
+	ACCESS_WORD   uint32 = 1 << 4
+	ACCESS_DOUBLE uint32 = 1 << 8
+	ACCESS_ANY    uint32 = ACCESS_BYTE | ACCESS_HALF | ACCESS_WORD | ACCESS_DOUBLE
+)
+
+// Reasons an access on the bus can fail
+var (
+	ErrUnmapped   = errors.New("no device is mapped at the address")
+	ErrMisaligned = errors.New("access is not naturally aligned")
+	ErrAccessSize = errors.New("access size is not supported by the device")
+	ErrReadOnly   = errors.New("device is read-only")
+)
+
+// Describes an access on the bus that failed
+type BusError struct {
+	addr  uint32 // Address of the access
+	size  uint32 // Size of the access in bytes
+	write bool   // Whether the access was a write
+	err   error  // Reason the access failed
+}
+
+// Returns a description of the failed access
+func (err *BusError) Error() string {
+	access := "read"
+	if err.write {
+		access = "write"
+	}
+	return fmt.Sprintf("%d-byte %s at %08x: %v", err.size, access, err.addr, err.err)
+}
+
+// Returns the reason the access failed
+func (err *BusError) Unwrap() error {
+	return err.err
+}
+
+// Represents an address range the bus forwards to a device
+type Region struct {
+	name    string
+	base    uint32 // First address of the region
+	size    uint32 // Number of bytes in the region
+	device  Device
+	access  uint32 // Access sizes the device accepts
+	aligned bool   // Whether accesses must be naturally aligned
+}
+
+// Returns whether the address lies in the region
+func (region *Region) contains(addr uint32) bool {
+	return addr-region.base < region.size
+}
+
+// Represents a bus that routes accesses to devices by address
+type SystemBus struct {
+	regions []Region
+}
+
+// Constructor to initialize an empty bus
+func NewSystemBus() *SystemBus {
+	return &SystemBus{}
+}
+
+// Maps a device onto the bus, taking precedence over the regions it overlaps that were mapped before it
+func (bus *SystemBus) Map(region Region) error {
+	if region.size == 0 || region.base+(region.size-1) < region.base {
+		return fmt.Errorf("cannot map %s: invalid range of %d bytes at %08x", region.name, region.size, region.base)
+	}
+	if region.access == 0 {
+		region.access = ACCESS_ANY
+	}
+	bus.regions = append(bus.regions, region)
+	return nil
+}
+
+// Returns the region an access is routed to, checking the access against the region's restrictions
+func (bus *SystemBus) route(addr uint32, size uint32, write bool) (*Region, error) {
+	for i := len(bus.regions) - 1; i >= 0; i-- {
+		region := &bus.regions[i]
+		if !region.contains(addr) {
+			continue
+		}
+		switch {
+		case size > region.size-(addr-region.base):
+			return nil, &BusError{addr, size, write, ErrUnmapped}
+		case region.access&(1<<size) == 0:
+			return nil, &BusError{addr, size, write, ErrAccessSize}
+		case region.aligned && addr%size != 0:
+			return nil, &BusError{addr, size, write, ErrMisaligned}
+		}
+		return region, nil
+	}
+	return nil, &BusError{addr, size, write, ErrUnmapped}
+}
+
+// Reads a little-endian value of the given size
+func (bus *SystemBus) Read(addr uint32, size uint32) (uint32, error) {
+	region, err := bus.route(addr, size, false)
+	if err != nil {
+		return 0, err
+	}
+	value, err := region.device.Read(addr-region.base, size)
+	if err != nil {
+		return 0, &BusError{addr, size, false, err}
+	}
+	return value, nil
+}
+
+// Writes a little-endian value of the given size
+func (bus *SystemBus) Write(addr uint32, size uint32, value uint32) error {
+	region, err := bus.route(addr, size, true)
+	if err != nil {
+		return err
+	}
+	if err := region.device.Write(addr-region.base, size, value); err != nil {
+		return &BusError{addr, size, true, err}
+	}
+	return nil
+}
+
+// Reads a little-endian doubleword as a single access, which the device sees as two word reads
+func (bus *SystemBus) ReadDouble(addr uint32) (uint64, error) {
+	region, err := bus.route(addr, BYTES_PER_DOUBLE, false)
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	for i := uint32(0); i < BYTES_PER_DOUBLE; i += BYTES_PER_WORD {
+		word, err := region.device.Read(addr-region.base+i, BYTES_PER_WORD)
+		if err != nil {
+			return 0, &BusError{addr, BYTES_PER_DOUBLE, false, err}
+		}
+		value |= uint64(word) << (8 * i)
+	}
+	return value, nil
+}
+
+// Writes a little-endian doubleword as a single access, which the device sees as two word writes. The
+// access is routed as a whole, so one that faults writes nothing.
+func (bus *SystemBus) WriteDouble(addr uint32, value uint64) error {
+	region, err := bus.route(addr, BYTES_PER_DOUBLE, true)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < BYTES_PER_DOUBLE; i += BYTES_PER_WORD {
+		if err := region.device.Write(addr-region.base+i, BYTES_PER_WORD, uint32(value>>(8*i))); err != nil {
+			return &BusError{addr, BYTES_PER_DOUBLE, true, err}
+		}
+	}
+	return nil
+}
+
+// Copies data directly into the device mapped at the address, even if it is read-only
+func (bus *SystemBus) Load(addr uint32, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	for i := len(bus.regions) - 1; i >= 0; i-- {
+		region := &bus.regions[i]
+		if !region.contains(addr) {
+			continue
+		}
+		loader, ok := region.device.(Loader)
+		if !ok || uint64(len(data)) > uint64(region.size-(addr-region.base)) {
+			break
+		}
+		return loader.Load(addr-region.base, data)
+	}
+	return fmt.Errorf("cannot load %d bytes at %08x: no memory covers the range", len(data), addr)
+}
+
+// Zeroes the device mapped at the address directly, even if it is read-only
+func (bus *SystemBus) Clear(addr uint32, size uint32) error {
+	if size == 0 {
+		return nil
+	}
+	for i := len(bus.regions) - 1; i >= 0; i-- {
+		region := &bus.regions[i]
+		if !region.contains(addr) {
+			continue
+		}
+		clearer, ok := region.device.(Clearer)
+		if !ok || size > region.size-(addr-region.base) {
+			break
+		}
+		return clearer.Clear(addr-region.base, size)
+	}
+	return fmt.Errorf("cannot clear %d bytes at %08x: no memory covers the range", size, addr)
+}
+
+// Returns the number of bytes of host memory committed by the mapped devices
+func (bus *SystemBus) Resident() uint64 {
+	var total uint64
+	for _, region := range bus.regions {
+		if device, ok := region.device.(Resident); ok {
+			total += device.Resident()
+		}
+	}
+	return total
+}
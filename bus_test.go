@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// Creates a bus with RAM at zero, ROM above it and a word-only device mapped over the RAM
+func newTestBus(t *testing.T) *SystemBus {
+	t.Helper()
+	bus := NewSystemBus()
+	for _, region := range []Region{
+		{name: "ram", base: 0x0000, size: 0x1000, device: NewRAM(0x1000)},
+		{name: "rom", base: 0x1000, size: 0x100, device: NewROM(0x100)},
+		{name: "mmio", base: 0x0800, size: 0x10, device: NewRAM(0x10), access: ACCESS_WORD, aligned: true},
+	} {
+		if err := bus.Map(region); err != nil {
+			t.Fatalf("Map(%s): unexpected error: %v", region.name, err)
+		}
+	}
+	return bus
+}
+
+func TestBusRouting(t *testing.T) {
+	bus := newTestBus(t)
+	if err := bus.Write(0x0ffe, BYTES_PER_HALF, 0xbeef); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value, _ := bus.Read(0x0fff, 1); value != 0xbe {
+		t.Errorf("Read(0x0fff) = %#x, want the upper byte of the little-endian halfword", value)
+	}
+
+	// The device mapped last shadows the RAM below it
+	bus.Write(0x0800, BYTES_PER_WORD, 0x1234_5678)
+	if value, _ := bus.Read(0x0800, BYTES_PER_WORD); value != 0x1234_5678 {
+		t.Errorf("Read(0x0800) = %#x, want 0x12345678", value)
+	}
+	if value, _ := bus.Read(0x0810, BYTES_PER_WORD); value != 0 {
+		t.Errorf("Read(0x0810) = %#x, want the RAM after the device to be untouched", value)
+	}
+}
+
+func TestBusErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		addr  uint32
+		size  uint32
+		write bool
+		err   error
+	}{
+		{"unmapped address", 0x2000, BYTES_PER_WORD, false, ErrUnmapped},
+		{"access past the end of a region", 0x10fe, BYTES_PER_WORD, false, ErrUnmapped},
+		{"unsupported size", 0x0804, 1, false, ErrAccessSize},
+		{"misaligned access", 0x0802, BYTES_PER_WORD, true, ErrMisaligned},
+		{"write to rom", 0x1000, BYTES_PER_WORD, true, ErrReadOnly},
+	}
+
+	bus := newTestBus(t)
+	for _, test := range tests {
+		var err error
+		if test.write {
+			err = bus.Write(test.addr, test.size, 0)
+		} else {
+			_, err = bus.Read(test.addr, test.size)
+		}
+		var busErr *BusError
+		if !errors.As(err, &busErr) || !errors.Is(err, test.err) {
+			t.Errorf("%s: error = %v, want %v", test.name, err, test.err)
+		} else if busErr.addr != test.addr || busErr.size != test.size || busErr.write != test.write {
+			t.Errorf("%s: reported access = %d-byte at %08x (write %v)", test.name, busErr.size, busErr.addr, busErr.write)
+		}
+	}
+}
+
+func TestBusDouble(t *testing.T) {
+	bus := newTestBus(t)
+	if err := bus.WriteDouble(0x0ff8, 0x1122_3344_5566_7788); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value, _ := bus.ReadDouble(0x0ff8); value != 0x1122_3344_5566_7788 {
+		t.Errorf("ReadDouble(0x0ff8) = %#x, want 0x1122334455667788", value)
+	}
+	if value, _ := bus.Read(0x0ffc, BYTES_PER_WORD); value != 0x1122_3344 {
+		t.Errorf("Read(0x0ffc) = %#x, want the upper word of the little-endian doubleword", value)
+	}
+
+	// Doublewords are routed as one access, so one that runs out of its region writes nothing
+	err := bus.WriteDouble(0x0ffc, 0)
+	if !errors.Is(err, ErrUnmapped) {
+		t.Errorf("WriteDouble(0x0ffc) error = %v, want %v", err, ErrUnmapped)
+	}
+	if value, _ := bus.Read(0x0ffc, BYTES_PER_WORD); value != 0x1122_3344 {
+		t.Errorf("Read(0x0ffc) = %#x, want the RAM untouched", value)
+	}
+	if err := bus.WriteDouble(0x0800, 0); !errors.Is(err, ErrAccessSize) {
+		t.Errorf("WriteDouble(0x0800) error = %v, want %v", err, ErrAccessSize)
+	}
+}
+
+func TestBusLoad(t *testing.T) {
+	bus := newTestBus(t)
+	if err := bus.Load(0x1000, []byte{0x13, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatalf("loading rom: unexpected error: %v", err)
+	}
+	if value, _ := bus.Read(0x1000, BYTES_PER_WORD); value != 0x13 {
+		t.Errorf("Read(0x1000) = %#x, want the loaded word", value)
+	}
+	if err := bus.Load(0x10fe, []byte{1, 2, 3, 4}); err == nil {
+		t.Errorf("loading past the end of the rom should fail")
+	}
+}
+
+func TestBusMisalignedDeviceAccessTraps(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100)
+	_, err := cpu.FetchWord(REG_MTIME + 2)
+	var trap *Trap
+	if !errors.As(err, &trap) || trap.cause != CAUSE_LOAD_ADDRESS_MISALIGNED {
+		t.Errorf("FetchWord(mtime+2) = %v, want a misaligned load", err)
+	}
+}
@@ -42,6 +42,23 @@ func (h *HexUint) UnmarshalText(b []byte) error {
 	return nil
 }
 
+// Used to parse additional RAM regions given as base:size
+type RAMRegion struct {
+	Base HexUint
+	Size HexUint
+}
+
+func (r *RAMRegion) UnmarshalText(b []byte) error {
+	base, size, found := strings.Cut(string(b), ":")
+	if !found {
+		return fmt.Errorf("expected base:size, got %q", b)
+	}
+	if err := r.Base.UnmarshalText([]byte(base)); err != nil {
+		return err
+	}
+	return r.Size.UnmarshalText([]byte(size))
+}
+
 func (args) Epilogue() string {
 	return "For more information visit github.com/Kaweees/RivoGo"
 }
@@ -49,13 +66,58 @@ func (args) Epilogue() string {
 // CLI arguments
 type args struct {
 	// File config
-	FileName string `arg:"required" help:"Image file to virtualize"`
+	FileName []string `arg:"--filename,required,separate" help:"Image to virtualize as path[@address], repeatable to load several images"`
+	// Image format
+	Format string `arg:"--format" help:"Format of the images: auto, elf, ihex, srec, raw, sized or asm"`
 	// Logging config
-	Logging bool `arg:"-l,--logging" help:"Enable logging"`
+	Logging bool `arg:"-l,--logging" help:"Enable logging, showing the registers and memory the program starts and stops with"`
 	// Starting address
 	Start HexUint `arg:"help:Program counter starting address"`
 	// Memory length
 	Length HexUint `arg:"-n,--length" help:"Memory length"`
+	// Additional memory regions
+	RAM []RAMRegion `arg:"--ram,separate" help:"Additional RAM region as base:size, repeatable"`
+	// Instruction set
+	ISA string `arg:"--isa" help:"Instruction set to emulate, e.g. rv32i, rv32imac_zicsr or rv32gc"`
+	// Console config
+	UARTInput  string `arg:"--uart-input" help:"File or pipe the console receives from, - for stdin"`
+	UARTOutput string `arg:"--uart-output" help:"File the console transmits to, - for stdout"`
+	// Host-target interface config
+	ToHost   HexUint `arg:"--tohost" help:"Address of the HTIF tohost word, instead of the tohost symbol"`
+	FromHost HexUint `arg:"--fromhost" help:"Address of the HTIF fromhost word, instead of the fromhost symbol"`
+	// Misaligned access policy
+	Misaligned string `arg:"--misaligned" help:"Handling of misaligned loads and stores: trap, emulate or count"`
+	// Architectural test signature config
+	Signature            string `arg:"--signature" help:"File to write the memory between begin_signature and end_signature to when the program finishes"`
+	SignatureGranularity uint32 `arg:"--signature-granularity" help:"Number of signature bytes per line"`
+	// Commit trace config
+	Trace      string `arg:"--trace" help:"File to write a Spike --log-commits style trace of the retired instructions to, - for stdout"`
+	TraceStart string `arg:"--trace-start" help:"Address or symbol that starts the trace, each time it is reached"`
+	TraceStop  string `arg:"--trace-stop" help:"Address or symbol that stops the trace, each time it is reached"`
+	// Differential testing config
+	Lockstep string `arg:"--lockstep" help:"Spike --log-commits or Sail trace to run in lockstep with, stopping at the first instruction that differs"`
+	// Debugger config
+	GDB   string    `arg:"--gdb" help:"Wait for GDB to attach on host:port or unix:path before running"`
+	Debug *DebugCmd `arg:"subcommand:debug" help:"Drop into an interactive debugger before the first instruction"`
+	// Disassembler config
+	Disasm *DisasmCmd `arg:"subcommand:disasm" help:"List the disassembly of the images as objdump -D does, without running them"`
+	// Assembler config
+	Asm *AsmCmd `arg:"subcommand:asm" help:"Assemble a source file into an ELF executable, without running it"`
+}
+
+// Options of the debug subcommand, which has none of its own
+type DebugCmd struct{}
+
+// Options of the disasm subcommand
+type DisasmCmd struct {
+	Output string `arg:"-o,--output" help:"File to write the listing to, - for stdout"`
+}
+
+// Options of the asm subcommand
+type AsmCmd struct {
+	Output string  `arg:"-o,--output,required" help:"File to write the ELF executable to"`
+	Text   HexUint `arg:"--text" default:"0x0" help:"Address of the code"`
+	Data   HexUint `arg:"--data" default:"0x1000" help:"Address of the data, which the BSS follows"`
 }
 
 // Returns a human-readable version string
@@ -65,20 +127,26 @@ func (args) Version() string {
 
 // Returns a description of the program
 func (args) Description() string {
-	return "A simple assembler for the RISC-V architecture"
+	return "An emulator for the RISC-V architecture, with a debugger, a disassembler and an assembler"
 }
 
 // Returns the parsed CLI arguments
 func GetCliArgs() (cli argsParsed, err error) {
 	rawCli := args{
-		Logging: false,
-		Start:   HexUint(PC_START),
-		Length:  HexUint(MEM_MAX_SIZE),
+		Logging:    false,
+		Start:      HexUint(PC_START),
+		Length:     HexUint(MEM_MAX_SIZE),
+		ISA:        DEFAULT_ISA,
+		Format:     string(IMAGE_AUTO),
+		Misaligned: "emulate",
+		UARTInput:  "-",
+		UARTOutput: "-",
+
+		SignatureGranularity: SIGNATURE_GRANULARITY,
 	}
 
 	arg.MustParse(&rawCli)
 	cli.args = rawCli
-	fmt.Printf("Parsed value: %d (hex: %x)\n", cli.Start, uint32(cli.Start))
 
 	return cli, nil
 }
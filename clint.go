@@ -0,0 +1,92 @@
+package main
+
+// Represents the core-local interruptor, which provides the machine timer and software interrupts
+type CLINT struct {
+	msip     uint32 // Software interrupt pending, only the lowest bit is implemented
+	mtimecmp uint64 // Timer interrupt fires when mtime reaches this value
+	mtime    uint64 // Timer, advanced once per instruction
+}
+
+// Constructor to initialize the CLINT with the timer interrupt disabled
+func NewCLINT() *CLINT {
+	return &CLINT{mtimecmp: ^uint64(0)}
+}
+
+// Advances the timer by one tick
+func (clint *CLINT) tick() {
+	clint.mtime++
+}
+
+// Returns the mip bits of the interrupts the CLINT is raising
+func (clint *CLINT) pending() uint32 {
+	var pending uint32
+	if clint.msip&0x1 != 0 {
+		pending |= MIP_MSIP
+	}
+	if clint.mtime >= clint.mtimecmp {
+		pending |= MIP_MTIP
+	}
+	return pending
+}
+
+// Returns the 64-bit register holding the address and the position of the addressed byte, or nil if there is none
+func (clint *CLINT) register(addr uint32) (value *uint64, shift uint32) {
+	switch {
+	case addr-REG_MTIMECMP < BYTES_PER_DOUBLE:
+		return &clint.mtimecmp, (addr - REG_MTIMECMP) * 8
+	case addr-REG_MTIME < BYTES_PER_DOUBLE:
+		return &clint.mtime, (addr - REG_MTIME) * 8
+	}
+	return nil, 0
+}
+
+// Reads a byte from the CLINT
+func (clint *CLINT) readByte(addr uint32) (uint8, bool) {
+	if addr-REG_MSIP < BYTES_PER_WORD {
+		return uint8(clint.msip >> ((addr - REG_MSIP) * 8)), true
+	}
+	value, shift := clint.register(addr)
+	if value == nil {
+		return 0, false
+	}
+	return uint8(*value >> shift), true
+}
+
+// Writes a byte to the CLINT
+func (clint *CLINT) writeByte(addr uint32, data uint8) bool {
+	if addr-REG_MSIP < BYTES_PER_WORD {
+		if addr == REG_MSIP {
+			clint.msip = uint32(data) & 0x1
+		}
+		return true
+	}
+	value, shift := clint.register(addr)
+	if value == nil {
+		return false
+	}
+	*value = *value&^(0xFF<<shift) | uint64(data)<<shift
+	return true
+}
+
+// Reads a little-endian value of the given size from the CLINT
+func (clint *CLINT) Read(offset uint32, size uint32) (uint32, error) {
+	var value uint32
+	for i := uint32(0); i < size; i++ {
+		data, ok := clint.readByte(REG_CLINT_BASE + offset + i)
+		if !ok {
+			return 0, ErrUnmapped
+		}
+		value |= uint32(data) << (8 * i)
+	}
+	return value, nil
+}
+
+// Writes a little-endian value of the given size to the CLINT
+func (clint *CLINT) Write(offset uint32, size uint32, value uint32) error {
+	for i := uint32(0); i < size; i++ {
+		if !clint.writeByte(REG_CLINT_BASE+offset+i, uint8(value>>(8*i))) {
+			return ErrUnmapped
+		}
+	}
+	return nil
+}
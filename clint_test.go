@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestCLINTRegisters(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100)
+	storeWords(t, cpu, REG_MTIMECMP, 0x89ab_cdef, 0x0123_4567)
+	if cpu.clint.mtimecmp != 0x0123_4567_89ab_cdef {
+		t.Errorf("mtimecmp = %#x, want 0x0123456789abcdef", cpu.clint.mtimecmp)
+	}
+	if value, _ := cpu.FetchHalfWord(REG_MTIMECMP + 6); value != 0x0123 {
+		t.Errorf("upper halfword of mtimecmp = %#x, want 0x0123", value)
+	}
+
+	cpu.clint.mtime = 0x1_0000_0002
+	if low, _ := cpu.FetchWord(REG_MTIME); low != 2 {
+		t.Errorf("lower word of mtime = %d, want 2", low)
+	}
+	if high, _ := cpu.FetchWord(REG_MTIME + 4); high != 1 {
+		t.Errorf("upper word of mtime = %d, want 1", high)
+	}
+
+	storeWords(t, cpu, REG_MSIP, 0xFFFF_FFFF)
+	if value, _ := cpu.FetchWord(REG_MSIP); value != 1 {
+		t.Errorf("msip = %#x, want only the lowest bit to be writable", value)
+	}
+
+	if _, err := cpu.FetchWord(REG_CLINT_BASE + 0x8000); err == nil {
+		t.Errorf("reading an unmapped clint address should fault")
+	}
+}
+
+func TestCLINTTimerInterrupt(t *testing.T) {
+	cpu := newTrapCPU(t, 0x00000013, 0x00000013, 0x00000013, 0x00000013) // nop
+	cpu.clint.mtimecmp = 3
+	cpu.WriteCSR(CSR_MIE, MIP_MTIP)
+
+	// The interrupt is pending but masked until mstatus.MIE is set
+	step(t, cpu, 3)
+	if cpu.pc != 12 {
+		t.Fatalf("pc = %08x, want the masked interrupt to be ignored", cpu.pc)
+	}
+	if mip, _ := cpu.ReadCSR(CSR_MIP); mip != MIP_MTIP {
+		t.Errorf("mip = %#x, want the timer interrupt pending", mip)
+	}
+
+	cpu.csrs[CSR_MSTATUS].value |= MSTATUS_MIE
+	step(t, cpu, 1)
+	checkTrap(t, cpu, MCAUSE_INTERRUPT|CAUSE_MACHINE_TIMER_INTERRUPT, 12, 0)
+	if cpu.csrs[CSR_MSTATUS].value&MSTATUS_MIE != 0 {
+		t.Errorf("mstatus.MIE should be cleared in the handler")
+	}
+}
+
+func TestCLINTSoftwareInterrupt(t *testing.T) {
+	cpu := newTrapCPU(t, 0x00b52023) // sw a1, 0(a0)
+	cpu.registers.Write(REG_A0, REG_MSIP)
+	cpu.registers.Write(REG_A1, 1)
+	cpu.WriteCSR(CSR_MTVEC, testHandler|MTVEC_VECTORED)
+	cpu.WriteCSR(CSR_MIE, MIP_MSIP|MIP_MTIP)
+	cpu.csrs[CSR_MSTATUS].value |= MSTATUS_MIE
+	cpu.clint.mtimecmp = 2
+
+	// Software interrupts take priority over timer interrupts and vector by their cause
+	step(t, cpu, 2)
+	if cpu.pc != testHandler+4*CAUSE_MACHINE_SOFTWARE_INTERRUPT {
+		t.Errorf("pc = %08x, want the software interrupt vector", cpu.pc)
+	}
+	if mcause := cpu.csrs[CSR_MCAUSE].value; mcause != MCAUSE_INTERRUPT|CAUSE_MACHINE_SOFTWARE_INTERRUPT {
+		t.Errorf("mcause = %#x, want a machine software interrupt", mcause)
+	}
+}
+
+func TestCLINTTimeCSR(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100, 0xc0102673) // rdtime a2
+	cpu.clint.mtime = 41
+	step(t, cpu, 1)
+	if cpu.registers.Read(REG_A2) != 42 {
+		t.Errorf("time = %d, want 42", cpu.registers.Read(REG_A2))
+	}
+}
@@ -1,412 +1,891 @@
 package main
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"strings"
 )
 
+// Represents how the processor handles loads and stores that are not naturally aligned
+type MisalignedPolicy uint8
+
+// An enum containing all the misaligned access policies
+const (
+	MISALIGNED_TRAP    MisalignedPolicy = iota // Raise an address-misaligned exception
+	MISALIGNED_EMULATE                         // Perform the access as if it were aligned
+	MISALIGNED_COUNT                           // Perform the access and count it
+)
+
+// Names of the misaligned access policies, as given on the command line
+var misalignedPolicies = map[string]MisalignedPolicy{
+	"trap":    MISALIGNED_TRAP,
+	"emulate": MISALIGNED_EMULATE,
+	"count":   MISALIGNED_COUNT,
+}
+
+// Returns the misaligned access policy with the given name
+func ParseMisalignedPolicy(name string) (MisalignedPolicy, error) {
+	policy, ok := misalignedPolicies[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown misaligned access policy %q: expected trap, emulate or count", name)
+	}
+	return policy, nil
+}
+
 // Represents the emulated RISC-V   processor
 type CPU struct {
-	pc        uint32            // Program counter
-	memSize   uint32            // Size of the memory
-	registers [REG_COUNT]uint32 // Core registers, exposed publicly to make it easier to interface with
-	memory    []uint8           // Memory bus interface
+	pc          uint32            // Program counter
+	instPC      uint32            // Address of the instruction being executed
+	registers   RegisterFile      // Integer registers, with x0 hard-wired to zero
+	fregisters  [REG_COUNT]uint64 // Floating-point registers, holding NaN-boxed singles or doubles
+	fcsr        uint32            // Floating-point rounding mode and accrued exception flags
+	bus         Bus               // Memory bus the loads and stores go through
+	extensions  Extension         // Extensions the processor implements
+	reservation Reservation       // Reservation set held by the last load-reserved
+	csrs        map[uint32]*CSR   // Control and status registers, keyed by address
+	counters    Counters          // Cycle and instructions-retired counters
+	privilege   uint8             // Current privilege level
+	clint       *CLINT            // Core-local interruptor providing the timer and software interrupts
+	htif        *HTIF             // Host-target interface, if the program uses one
+	symbols     map[string]uint32 // Addresses of the symbols of the loaded executables
+	misaligned  MisalignedPolicy  // How loads and stores that are not naturally aligned are handled
+	emulated    uint64            // Number of misaligned accesses emulated under MISALIGNED_COUNT
+	tracer      *Tracer           // Commit trace of the instructions executed, if one is being written
+	commit      *Commit           // Effects of the instruction being executed, recorded while tracing
+	hasHandler  bool              // Whether the program installed a trap handler by writing mtvec
 }
 
 // Constructor to initialize memory for the CPU.
 func NewCPU(memoryStart uint32, memoryLength uint32) (*CPU, error) {
 	cpu := &CPU{}
 	cpu.pc = memoryStart
-	cpu.memSize = memoryLength
-	cpu.memory = make([]uint8, memoryLength)
-	cpu.registers[REG_SP] = memoryLength
+	cpu.registers.Write(REG_SP, memoryLength)
+	cpu.extensions, _ = ParseISA(DEFAULT_ISA)
+	cpu.csrs = newCSRFile()
+	cpu.privilege = PRIV_MACHINE
+	cpu.clint = NewCLINT()
+	cpu.symbols = make(map[string]uint32)
+	cpu.misaligned = MISALIGNED_EMULATE
+
+	// RAM fills the address space from zero, with the devices mapped over it
+	cpu.bus = &reservedBus{NewSystemBus(), &cpu.reservation}
+	if memoryLength > 0 {
+		if err := cpu.MapRAM(0, memoryLength); err != nil {
+			return nil, err
+		}
+	}
+	err := cpu.bus.Map(Region{name: "clint", base: REG_CLINT_BASE, size: REG_CLINT_SIZE, device: cpu.clint, aligned: true})
+	if err != nil {
+		return nil, err
+	}
 	return cpu, nil
 }
 
-// Displays the contents of the registers
-func (cpu *CPU) DisplayRegisters() {
+// Maps a region of RAM onto the bus, whose pages are only committed when first written
+func (cpu *CPU) MapRAM(base uint32, size uint32) error {
+	return cpu.bus.Map(Region{name: "ram", base: base, size: size, device: NewRAM(size)})
+}
+
+// Maps a UART onto the bus at its conventional address
+func (cpu *CPU) AttachUART(uart *UART) error {
+	return cpu.bus.Map(Region{name: "uart", base: REG_UART_BASE, size: REG_UART_SIZE, device: uart, access: ACCESS_BYTE})
+}
+
+// Returns the number of bytes of host memory committed to the guest's memory
+func (cpu *CPU) ResidentSize() uint64 {
+	return cpu.bus.Resident()
+}
+
+// Returns the value of the register with the given ABI or xN name
+func (cpu *CPU) Register(name string) (uint32, error) {
+	reg, err := LookupRegister(name)
+	if err != nil {
+		return 0, err
+	}
+	return cpu.registers.Read(reg), nil
+}
+
+// Sets the value of the register with the given ABI or xN name, discarding writes to x0
+func (cpu *CPU) SetRegister(name string, value uint32) error {
+	reg, err := LookupRegister(name)
+	if err != nil {
+		return err
+	}
+	cpu.registers.Write(reg, value)
+	return nil
+}
+
+// Writes the contents of the registers to w
+func (cpu *CPU) DisplayRegisters(w io.Writer) {
+	writeRegisters(w, &cpu.registers.x, cpu.pc)
+}
+
+// Writes the integer registers eight to a line, followed by the program counter
+func writeRegisters(w io.Writer, x *[REG_COUNT]uint32, pc uint32) {
 	for i := 0; i < REG_COUNT; {
-		fmt.Printf("x%02d: ", i)
+		fmt.Fprintf(w, "x%02d: ", i)
 		for j := 0; j < 8; j++ {
-			fmt.Printf("%08x ", cpu.registers[i])
+			fmt.Fprintf(w, "%08x ", x[i])
 			if j == 3 {
-				fmt.Print(" ")
+				fmt.Fprint(w, " ")
 			}
 			i++
 		}
-		fmt.Println()
+		fmt.Fprintln(w)
 	}
-	fmt.Printf(" pc: %08x\n", cpu.pc)
-}
-
-// Displays the contents of the memory
-func (cpu *CPU) DisplayMemory(addr uint32, count uint32) {
-	// Pading to align the memory address
-	if addr%16 != 0 {
-		fmt.Printf("%08x: ", addr)
-		for i := uint32(0); i < count; i++ {
-			fmt.Printf("%02x ", cpu.memory[addr+i])
+	fmt.Fprintf(w, " pc: %08x\n", pc)
+}
+
+// Writes the contents of the memory to w, showing unmapped bytes as --
+func (cpu *CPU) DisplayMemory(w io.Writer, addr uint32, count uint32) {
+	// Lines start at multiples of 16 bytes, so the first is padded up to the address
+	line := addr &^ 0xF
+	fmt.Fprintf(w, "0x%08x: ", line)
+	for i := line; i < addr; i++ {
+		fmt.Fprint(w, "   ")
+		if i%8 == 7 {
+			fmt.Fprint(w, " ")
 		}
 	}
-	num := 1
-	for i := uint32(addr); i < addr+count; i++ {
-		if i%16 == 0 && i != 0 {
-			fmt.Println()
-		}
-		if i%16 == 0 {
-			fmt.Printf("0x%08x: ", i)
+	for n := uint32(0); n < count; n++ {
+		i := addr + n
+		if i%16 == 0 && n != 0 {
+			fmt.Fprintf(w, "\n0x%08x: ", i)
 		}
-		fmt.Printf("%02x ", cpu.memory[i])
-		if num%8 == 0 && i != 0 {
-			fmt.Print(" ")
-			num = 0
+		cpu.displayByte(w, i)
+		if i%8 == 7 {
+			fmt.Fprint(w, " ")
 		}
-		num++
 	}
-	fmt.Println()
+	fmt.Fprintln(w)
 }
 
-// Loads a binary image into memory
-func (cpu *CPU) LoadImage(image string) error {
-	file, err := os.Open(image)
+// Writes a single byte of memory to w
+func (cpu *CPU) displayByte(w io.Writer, addr uint32) {
+	value, err := cpu.bus.Read(addr, 1)
 	if err != nil {
-		// Log.Fatalf("Error opening file: %v", err)
-		return err
+		fmt.Fprint(w, "-- ")
+		return
 	}
+	fmt.Fprintf(w, "%02x ", value)
+}
 
-	// Read the size of the binary image
-	var binMemSize uint32
-	err = binary.Read(file, binary.LittleEndian, &binMemSize)
+// Loads a value of the given size from the bus, raising an exception if the access fails
+func (cpu *CPU) load(addr uint32, size uint32) (uint32, error) {
+	if err := cpu.checkAlignment(addr, size, CAUSE_LOAD_ADDRESS_MISALIGNED); err != nil {
+		return 0, err
+	}
+	value, err := cpu.bus.Read(addr, size)
 	if err != nil {
-		return fmt.Errorf("error reading binary image size: %v", err)
+		return 0, loadTrap(addr, err)
+	}
+	if cpu.commit != nil {
+		cpu.commit.loads = append(cpu.commit.loads, MemoryAccess{addr, size, uint64(value)})
 	}
+	return value, nil
+}
 
-	// Read the binary image into memory
-	maxReadSize := cpu.memSize - binMemSize
-	err = binary.Read(file, binary.LittleEndian, cpu.memory[:maxReadSize])
-	if err != nil {
-		return fmt.Errorf("error reading binary image: %v", err)
+// Stores a value of the given size to the bus, raising an exception if the access fails
+func (cpu *CPU) store(addr uint32, size uint32, value uint32) error {
+	if err := cpu.checkAlignment(addr, size, CAUSE_STORE_ADDRESS_MISALIGNED); err != nil {
+		return err
+	}
+	if err := cpu.bus.Write(addr, size, value); err != nil {
+		return storeTrap(addr, err)
+	}
+	if cpu.commit != nil {
+		cpu.commit.stores = append(cpu.commit.stores, MemoryAccess{addr, size, uint64(value)})
+	}
+	return nil
+}
+
+// Returns the exception a load raises when the bus rejects it
+func loadTrap(addr uint32, err error) error {
+	if errors.Is(err, ErrMisaligned) {
+		return newTrap(CAUSE_LOAD_ADDRESS_MISALIGNED, addr, "load address misaligned: %v", err)
+	}
+	return newTrap(CAUSE_LOAD_ACCESS_FAULT, addr, "load access fault: %v", err)
+}
+
+// Returns the exception a store raises when the bus rejects it
+func storeTrap(addr uint32, err error) error {
+	if errors.Is(err, ErrMisaligned) {
+		return newTrap(CAUSE_STORE_ADDRESS_MISALIGNED, addr, "store address misaligned: %v", err)
+	}
+	return newTrap(CAUSE_STORE_ACCESS_FAULT, addr, "store access fault: %v", err)
+}
+
+// Applies the misaligned access policy to a load or store, raising the given exception if it traps.
+// Devices that require aligned accesses still reject them, whatever the policy.
+func (cpu *CPU) checkAlignment(addr uint32, size uint32, cause uint32) error {
+	if addr%size == 0 {
+		return nil
+	}
+	switch cpu.misaligned {
+	case MISALIGNED_TRAP:
+		return newTrap(cause, addr, "%d-byte access at %08x is misaligned", size, addr)
+	case MISALIGNED_COUNT:
+		cpu.emulated++
 	}
-	defer file.Close()
 	return nil
 }
 
+// Sets how loads and stores that are not naturally aligned are handled
+func (cpu *CPU) SetMisalignedPolicy(policy MisalignedPolicy) {
+	cpu.misaligned = policy
+}
+
+// Returns the number of misaligned accesses emulated under MISALIGNED_COUNT
+func (cpu *CPU) MisalignedAccesses() uint64 {
+	return cpu.emulated
+}
+
 // Read a byte from memory
 func (cpu *CPU) FetchByte(addr uint32) (byte, error) {
-	// Guard against invalid addresses
-	if addr >= cpu.memSize {
-		return 0, fmt.Errorf("invalid address: %d", addr)
-	}
-	return cpu.memory[addr], nil
+	value, err := cpu.load(addr, 1)
+	return uint8(value), err
 }
 
 // Write a byte to memory
 func (cpu *CPU) StoreByte(addr uint32, byte uint8) error {
-	// Guard against invalid addresses
-	if addr >= cpu.memSize {
-		return fmt.Errorf("invalid address: %d", addr)
-	}
-	cpu.memory[addr] = byte
-	return nil
+	return cpu.store(addr, 1, uint32(byte))
 }
 
 // Read a halfword from memory
 func (cpu *CPU) FetchHalfWord(addr uint32) (uint16, error) {
-	// Guard against invalid addresses
-	if addr >= cpu.memSize {
-		return 0, fmt.Errorf("invalid address: %d", addr)
-	}
-	return binary.LittleEndian.Uint16([]byte(cpu.memory[addr : addr+2])), nil
+	value, err := cpu.load(addr, BYTES_PER_HALF)
+	return uint16(value), err
 }
 
 // Write a halfword to memory
 func (cpu *CPU) StoreHalfWord(addr uint32, halfWord uint16) error {
-	// Guard against invalid addresses
-	if addr >= cpu.memSize {
-		return fmt.Errorf("invalid address: %d", addr)
-	}
-	binary.LittleEndian.PutUint16(cpu.memory[addr:addr+2], halfWord)
-	return nil
+	return cpu.store(addr, BYTES_PER_HALF, uint32(halfWord))
 }
 
 // Read a word from memory
 func (cpu *CPU) FetchWord(addr uint32) (uint32, error) {
-	// Guard against invalid addresses
-	if addr >= cpu.memSize {
-		return 0, fmt.Errorf("invalid address: %d", addr)
-	}
-	return binary.LittleEndian.Uint32([]byte(cpu.memory[addr : addr+4])), nil
+	return cpu.load(addr, BYTES_PER_WORD)
 }
 
 // Writes a word to memory
 func (cpu *CPU) StoreWord(addr uint32, word uint32) error {
-	// Guard against invalid addresses
-	if addr >= cpu.memSize {
-		return fmt.Errorf("invalid address: %d", addr)
+	return cpu.store(addr, BYTES_PER_WORD, word)
+}
+
+// Reads a doubleword from memory as a single access
+func (cpu *CPU) FetchDouble(addr uint32) (uint64, error) {
+	if err := cpu.checkAlignment(addr, BYTES_PER_DOUBLE, CAUSE_LOAD_ADDRESS_MISALIGNED); err != nil {
+		return 0, err
+	}
+	value, err := cpu.bus.ReadDouble(addr)
+	if err != nil {
+		return 0, loadTrap(addr, err)
+	}
+	if cpu.commit != nil {
+		cpu.commit.loads = append(cpu.commit.loads, MemoryAccess{addr, BYTES_PER_DOUBLE, value})
+	}
+	return value, nil
+}
+
+// Writes a doubleword to memory as a single access, which writes nothing if any part of it faults
+func (cpu *CPU) StoreDouble(addr uint32, value uint64) error {
+	if err := cpu.checkAlignment(addr, BYTES_PER_DOUBLE, CAUSE_STORE_ADDRESS_MISALIGNED); err != nil {
+		return err
+	}
+	if err := cpu.bus.WriteDouble(addr, value); err != nil {
+		return storeTrap(addr, err)
+	}
+	if cpu.commit != nil {
+		cpu.commit.stores = append(cpu.commit.stores, MemoryAccess{addr, BYTES_PER_DOUBLE, value})
 	}
-	binary.LittleEndian.PutUint32(cpu.memory[addr:addr+4], word)
 	return nil
 }
 
 // Fetches the instruction at the current program counter
-func (cpu *CPU) Fetch() uint32 {
+func (cpu *CPU) Fetch() (uint32, error) {
 	// Ignore overflow and wrap around
-	instruction, err := cpu.FetchWord(cpu.pc)
+	cpu.instPC = cpu.pc
+	// The lowest bits of the first parcel give the length of the instruction
+	// Instructions only need to be aligned to parcels, so fetches bypass the misaligned access policy
+	parcel, err := cpu.bus.Read(cpu.pc, BYTES_PER_HALF)
+	if err != nil {
+		return 0, newTrap(CAUSE_INSTRUCTION_ACCESS_FAULT, cpu.pc, "instruction access fault: invalid address %08x", cpu.pc)
+	}
+	if isCompressed(parcel) {
+		cpu.pc += BYTES_PER_HALF
+		return parcel, nil
+	}
+	instruction, err := cpu.bus.Read(cpu.pc, BYTES_PER_WORD)
 	if err != nil {
-		Log.Fatalf("Error reading instruction at address %08x: %v", cpu.pc, err)
+		return 0, newTrap(CAUSE_INSTRUCTION_ACCESS_FAULT, cpu.pc, "instruction access fault: invalid address %08x", cpu.pc)
 	}
 	cpu.pc += BYTES_PER_WORD
-	return instruction
+	return instruction, nil
 }
 
 // Decodes and executes the instruction given by its opcode
 func (cpu *CPU) Execute(instruction uint32) error {
-	var opcode InstructionType
-	var funct3 uint8
+	decoded, err := Decode(instruction)
+	if err != nil {
+		return illegalInstruction(instruction, err)
+	}
+	if cpu.extensions&decoded.extension == 0 {
+		return illegalInstruction(instruction, fmt.Errorf("illegal instruction: %08x (%s) belongs to an extension that is not enabled", instruction, decoded.mnemonic))
+	}
+	if decoded.size == BYTES_PER_HALF && cpu.extensions&EXT_C == 0 {
+		return illegalInstruction(instruction, fmt.Errorf("illegal instruction: %04x is compressed but the C extension is not enabled", instruction))
+	}
+
+	err = cpu.dispatch(decoded)
+	cpu.counters.step(err == nil)
+	if cpu.commit != nil {
+		cpu.commit.raw, cpu.commit.retired = instruction, err == nil
+	}
 
-	// Extract the opcode amd funct3 from the instruction
-	opcode = InstructionType(instruction & 0x3F)
-	funct3 = uint8((instruction >> 12) & 0x3)
-	funct7 := uint8((instruction >> 25) & 0x3)
+	// Any other failure to execute the instruction makes it illegal
+	var trap *Trap
+	if err != nil && !errors.As(err, &trap) {
+		return illegalInstruction(instruction, err)
+	}
+	return err
+}
 
-	// Decode the instruction based on the opcode and funct3
-	switch opcode {
+// Dispatches a decoded instruction based on its opcode
+func (cpu *CPU) dispatch(decoded *AssemblyInstruction) error {
+	switch decoded.instructionType {
+	case I_TYPE_LOAD_FP, S_TYPE_FP, R4_TYPE_FMADD, R4_TYPE_FMSUB, R4_TYPE_FNMSUB, R4_TYPE_FNMADD, R_TYPE_FP:
+		if err := cpu.checkFloatEnabled(); err != nil {
+			return err
+		}
+	}
+	switch decoded.instructionType {
 	case R_TYPE:
-		return cpu.ExecuteRType(funct3, funct7, &RTypeInstruction{
-			rd:  uint8((instruction >> 7) & 0x3F),
-			rs1: uint8((instruction >> 15) & 0x1F),
-			rs2: uint8((instruction >> 20) & 0x1F),
-		})
+		return cpu.ExecuteRType(decoded)
+	case R_TYPE_AMO:
+		return cpu.ExecuteAMOType(decoded)
 	case I_TYPE_ARITH:
-		return cpu.ExecuteIArithType(funct3, funct7, &ITypeInstruction{
-			rd:  uint8((instruction >> 7) & 0x3F),
-			rs1: uint8((instruction >> 15) & 0x1F),
-			imm: uint16((instruction >> 20) & 0xFFF),
-		})
+		return cpu.ExecuteIArithType(decoded)
 	case I_TYPE_LOAD:
-		return cpu.ExecuteILoadType(funct3, funct7, &ITypeInstruction{
-			rd:  uint8((instruction >> 7) & 0x3F),
-			rs1: uint8((instruction >> 15) & 0x1F),
-			imm: uint16((instruction >> 20) & 0xFFF),
-		})
+		return cpu.ExecuteILoadType(decoded)
+	case I_TYPE_JALR:
+		return cpu.ExecuteIJumpType(decoded)
+	case I_TYPE_FENCE:
+		return cpu.ExecuteIFenceType(decoded)
 	case I_TYPE_SYS:
-		return cpu.ExecuteISysType(funct3, funct7, &ITypeInstruction{
-			rd:  uint8((instruction >> 7) & 0x3F),
-			rs1: uint8((instruction >> 15) & 0x1F),
-			imm: uint16((instruction >> 20) & 0xFFF),
-		})
+		return cpu.ExecuteISysType(decoded)
 	case S_TYPE:
-		return cpu.ExecuteSType(funct3, &STypeInstruction{
-			imm: uint16(((instruction >> 25 & 0xEF) << 5) & ((instruction >> 7) & 0xF)),
-			rs1: uint8((instruction >> 15) & 0x1F),
-			rs2: uint8((instruction >> 20) & 0x1F),
-		})
+		return cpu.ExecuteSType(decoded)
 	case B_TYPE:
-		return fmt.Errorf("b-type instructions not implemented yet")
-		// return cpu.ExecuteBType(funct3, &BTypeInstruction{
-		// 	imm: uint16(((instruction >> 31 & 0x1) << 12) & ((instruction >> 25 & 0x3F) << 5) & ((instruction >> 8 & 0xF) << 1) & ((instruction >> 7 & 0x1) << 11)),
-		// 	rs1: uint8((instruction >> 15) & 0x1F),
-		// 	rs2: uint8((instruction >> 20) & 0x1F),
-		// })
-	case U_TYPE:
-		return cpu.ExecuteUType(funct3, funct7, &UTypeInstruction{
-			imm: uint16(instruction >> 12),
-			rd:  uint8((instruction >> 7) & 0x3F),
-		})
+		return cpu.ExecuteBType(decoded)
+	case U_TYPE_LUI, U_TYPE_AUIPC:
+		return cpu.ExecuteUType(decoded)
 	case J_TYPE:
-		return cpu.ExecuteJType(funct3, funct7, &JTypeInstruction{
-			imm: uint16(instruction >> 12),
-			rd:  uint8((instruction >> 7) & 0x3F),
-		})
+		return cpu.ExecuteJType(decoded)
+	case I_TYPE_LOAD_FP:
+		return cpu.ExecuteFLoadType(decoded)
+	case S_TYPE_FP:
+		return cpu.ExecuteFStoreType(decoded)
+	case R4_TYPE_FMADD, R4_TYPE_FMSUB, R4_TYPE_FNMSUB, R4_TYPE_FNMADD:
+		return cpu.ExecuteFMAType(decoded)
+	case R_TYPE_FP:
+		return cpu.ExecuteFPType(decoded)
 	default:
-		return fmt.Errorf("unknown instruction type: %v", opcode)
+		return fmt.Errorf("unknown instruction type: %v", decoded.instructionType)
+	}
+}
+
+// Returns the address of the instruction currently being executed
+func (cpu *CPU) instructionAddress() uint32 {
+	return cpu.instPC
+}
+
+// Returns the alignment required of instruction addresses
+func (cpu *CPU) instructionAlignment() uint32 {
+	if cpu.extensions&EXT_C != 0 {
+		return BYTES_PER_HALF
+	}
+	return BYTES_PER_WORD
+}
+
+// Transfers control to the given target address, checking its alignment
+func (cpu *CPU) jump(target uint32) error {
+	if target%cpu.instructionAlignment() != 0 {
+		return newTrap(CAUSE_INSTRUCTION_ADDRESS_MISALIGNED, target, "instruction address misaligned: %08x", target)
 	}
+	cpu.pc = target
+	return nil
 }
 
-// Executes the corresponding R-type instruction based on the funct3 and funct7 fields
-func (cpu *CPU) ExecuteRType(funct3 uint8, funct7 uint8, instruction *RTypeInstruction) error {
-	if funct3 == 0x0 && funct7 == 0x00 {
+// Executes the corresponding R-type instruction based on the mnemonic
+func (cpu *CPU) ExecuteRType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "add":
 		return cpu.ADD(instruction)
-	} else if funct3 == 0x0 && funct7 == 0x20 {
+	case "sub":
 		return cpu.SUB(instruction)
-	} else if funct3 == 0x4 && funct7 == 0x00 {
+	case "xor":
 		return cpu.XOR(instruction)
-	} else if funct3 == 0x6 && funct7 == 0x00 {
+	case "or":
 		return cpu.OR(instruction)
-	} else if funct3 == 0x7 && funct7 == 0x00 {
+	case "and":
 		return cpu.AND(instruction)
-	} else if funct3 == 0x1 && funct7 == 0x00 {
+	case "sll":
 		return cpu.SLL(instruction)
-	} else if funct3 == 0x5 && funct7 == 0x00 {
+	case "srl":
 		return cpu.SRL(instruction)
-	} else if funct3 == 0x5 && funct7 == 0x20 {
+	case "sra":
 		return cpu.SRA(instruction)
-	} else if funct3 == 0x2 && funct7 == 0x00 {
+	case "slt":
 		return cpu.SLT(instruction)
-	} else if funct3 == 0x3 && funct7 == 0x00 {
+	case "sltu":
 		return cpu.SLTU(instruction)
-	} else {
-		return fmt.Errorf("unknown r-type instruction: %v", instruction)
+	case "mul":
+		return cpu.MUL(instruction)
+	case "mulh":
+		return cpu.MULH(instruction)
+	case "mulhsu":
+		return cpu.MULHSU(instruction)
+	case "mulhu":
+		return cpu.MULHU(instruction)
+	case "div":
+		return cpu.DIV(instruction)
+	case "divu":
+		return cpu.DIVU(instruction)
+	case "rem":
+		return cpu.REM(instruction)
+	case "remu":
+		return cpu.REMU(instruction)
+	default:
+		return fmt.Errorf("unknown r-type instruction: %s", instruction.mnemonic)
 	}
 }
 
 // Adds two registers and stores the result in a third register
-func (cpu *CPU) ADD(instruction *RTypeInstruction) error {
-	cpu.registers[instruction.rd] = cpu.registers[instruction.rs1] + cpu.registers[instruction.rs2]
+func (cpu *CPU) ADD(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)+cpu.registers.Read(instruction.rs2))
 	return nil
 }
 
 // Subtracts two registers and stores the result in a third register
-func (cpu *CPU) SUB(instruction *RTypeInstruction) error {
-	cpu.registers[instruction.rd] = cpu.registers[instruction.rs1] - cpu.registers[instruction.rs2]
+func (cpu *CPU) SUB(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)-cpu.registers.Read(instruction.rs2))
 	return nil
 }
 
 // Bitwise XORs two registers and stores the result in a third register
-func (cpu *CPU) XOR(instruction *RTypeInstruction) error {
-	cpu.registers[instruction.rd] = cpu.registers[instruction.rs1] ^ cpu.registers[instruction.rs2]
+func (cpu *CPU) XOR(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)^cpu.registers.Read(instruction.rs2))
 	return nil
 }
 
 // Bitwise ORs two registers and stores the result in a third register
-func (cpu *CPU) OR(instruction *RTypeInstruction) error {
-	cpu.registers[instruction.rd] = cpu.registers[instruction.rs1] | cpu.registers[instruction.rs2]
+func (cpu *CPU) OR(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)|cpu.registers.Read(instruction.rs2))
 	return nil
 }
 
 // Bitwise ANDs two registers and stores the result in a third register
-func (cpu *CPU) AND(instruction *RTypeInstruction) error {
-	cpu.registers[instruction.rd] = cpu.registers[instruction.rs1] & cpu.registers[instruction.rs2]
+func (cpu *CPU) AND(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)&cpu.registers.Read(instruction.rs2))
 	return nil
 }
 
 // Shifts the bits in a register left by a certain amount and stores the result in a third register
-func (cpu *CPU) SLL(instruction *RTypeInstruction) error {
-	cpu.registers[instruction.rd] = cpu.registers[instruction.rs1] << cpu.registers[instruction.rs2]
+func (cpu *CPU) SLL(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)<<(cpu.registers.Read(instruction.rs2)&0x1F))
 	return nil
 }
 
 // Shifts the bits in a register right by a certain amount and stores the result in a third register
-func (cpu *CPU) SRL(instruction *RTypeInstruction) error {
-	cpu.registers[instruction.rd] = cpu.registers[instruction.rs1] >> cpu.registers[instruction.rs2]
+func (cpu *CPU) SRL(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)>>(cpu.registers.Read(instruction.rs2)&0x1F))
 	return nil
 }
 
 // Shifts the bits in a register right by a certain amount, filling the leftmost bits with the sign bit
-func (cpu *CPU) SRA(instruction *RTypeInstruction) error {
-	cpu.registers[instruction.rd] = uint32(int32(cpu.registers[instruction.rs1]) >> cpu.registers[instruction.rs2])
+func (cpu *CPU) SRA(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, uint32(int32(cpu.registers.Read(instruction.rs1))>>(cpu.registers.Read(instruction.rs2)&0x1F)))
 	return nil
 }
 
 // Sets a register to 1 if the first register is less than the second, 0 otherwise
-func (cpu *CPU) SLT(instruction *RTypeInstruction) error {
-	if int32(cpu.registers[instruction.rs1]) < int32(cpu.registers[instruction.rs2]) {
-		cpu.registers[instruction.rd] = 1
+func (cpu *CPU) SLT(instruction *AssemblyInstruction) error {
+	if int32(cpu.registers.Read(instruction.rs1)) < int32(cpu.registers.Read(instruction.rs2)) {
+		cpu.registers.Write(instruction.rd, 1)
 	} else {
-		cpu.registers[instruction.rd] = 0
+		cpu.registers.Write(instruction.rd, 0)
 	}
 	return nil
 }
 
 // Sets a register to 1 if the first register is less than the second, 0 otherwise (unsigned)
-func (cpu *CPU) SLTU(instruction *RTypeInstruction) error {
-	if uint32(cpu.registers[instruction.rs1]) < uint32(cpu.registers[instruction.rs2]) {
-		cpu.registers[instruction.rd] = 1
+func (cpu *CPU) SLTU(instruction *AssemblyInstruction) error {
+	if uint32(cpu.registers.Read(instruction.rs1)) < uint32(cpu.registers.Read(instruction.rs2)) {
+		cpu.registers.Write(instruction.rd, 1)
 	} else {
-		cpu.registers[instruction.rd] = 0
+		cpu.registers.Write(instruction.rd, 0)
 	}
 	return nil
 }
 
-// Executes the corresponding I-type arithmetic instruction based on the funct3 field
-func (cpu *CPU) ExecuteIArithType(funct3 uint8, funct7 uint8, instruction *ITypeInstruction) error {
-	if funct3 == 0x0 {
+// Executes the corresponding I-type arithmetic instruction based on the mnemonic
+func (cpu *CPU) ExecuteIArithType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "addi":
 		return cpu.ADDI(instruction)
-	} else if funct3 == 0x4 {
+	case "xori":
 		return cpu.XORI(instruction)
-	} else if funct3 == 0x6 {
+	case "ori":
 		return cpu.ORI(instruction)
-	} else if funct3 == 0x7 {
+	case "andi":
 		return cpu.ANDI(instruction)
-	} else if funct3 == 0x1 && funct7 == 0x00 {
+	case "slli":
 		return cpu.SLLI(instruction)
-	} else if funct3 == 0x5 && funct7 == 0x00 {
+	case "srli":
 		return cpu.SRLI(instruction)
-	} else if funct3 == 0x5 && funct7 == 0x20 {
+	case "srai":
 		return cpu.SRAI(instruction)
-	} else if funct3 == 0x2 {
+	case "slti":
 		return cpu.SLTI(instruction)
-	} else if funct3 == 0x3 {
+	case "sltiu":
 		return cpu.SLTIU(instruction)
-	} else {
-		return fmt.Errorf("unknown i-type instruction: %v", instruction)
+	default:
+		return fmt.Errorf("unknown i-type instruction: %s", instruction.mnemonic)
 	}
 }
 
-func (cpu *CPU) ADDI(instruction *ITypeInstruction) error {
+// Adds a sign-extended immediate to a register and stores the result in a second register
+func (cpu *CPU) ADDI(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)+uint32(instruction.imm))
 	return nil
 }
 
-func (cpu *CPU) XORI(instruction *ITypeInstruction) error {
+// Bitwise XORs a register with a sign-extended immediate
+func (cpu *CPU) XORI(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)^uint32(instruction.imm))
 	return nil
 }
 
-func (cpu *CPU) ORI(instruction *ITypeInstruction) error {
+// Bitwise ORs a register with a sign-extended immediate
+func (cpu *CPU) ORI(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)|uint32(instruction.imm))
 	return nil
 }
 
-func (cpu *CPU) ANDI(instruction *ITypeInstruction) error {
+// Bitwise ANDs a register with a sign-extended immediate
+func (cpu *CPU) ANDI(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)&uint32(instruction.imm))
 	return nil
 }
 
-func (cpu *CPU) SLLI(instruction *ITypeInstruction) error {
+// Shifts the bits in a register left by the amount given in the lower 5 bits of the immediate
+func (cpu *CPU) SLLI(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)<<(instruction.imm&0x1F))
 	return nil
 }
 
-func (cpu *CPU) SRLI(instruction *ITypeInstruction) error {
+// Shifts the bits in a register right by the amount given in the lower 5 bits of the immediate
+func (cpu *CPU) SRLI(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)>>(instruction.imm&0x1F))
 	return nil
 }
 
-func (cpu *CPU) SRAI(instruction *ITypeInstruction) error {
+// Shifts the bits in a register right by an immediate amount, filling the leftmost bits with the sign bit
+func (cpu *CPU) SRAI(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, uint32(int32(cpu.registers.Read(instruction.rs1))>>(instruction.imm&0x1F)))
 	return nil
 }
 
-func (cpu *CPU) SLTI(instruction *ITypeInstruction) error {
+// Sets a register to 1 if the source register is less than the immediate, 0 otherwise
+func (cpu *CPU) SLTI(instruction *AssemblyInstruction) error {
+	if int32(cpu.registers.Read(instruction.rs1)) < instruction.imm {
+		cpu.registers.Write(instruction.rd, 1)
+	} else {
+		cpu.registers.Write(instruction.rd, 0)
+	}
 	return nil
 }
 
-func (cpu *CPU) SLTIU(instruction *ITypeInstruction) error {
+// Sets a register to 1 if the source register is less than the sign-extended immediate, 0 otherwise (unsigned)
+func (cpu *CPU) SLTIU(instruction *AssemblyInstruction) error {
+	if cpu.registers.Read(instruction.rs1) < uint32(instruction.imm) {
+		cpu.registers.Write(instruction.rd, 1)
+	} else {
+		cpu.registers.Write(instruction.rd, 0)
+	}
 	return nil
 }
 
-// Executes the corresponding I-type load instruction based on the funct3 field
-func (cpu *CPU) ExecuteILoadType(funct3 uint8, funct7 uint8, instruction *ITypeInstruction) error {
+// Executes the corresponding I-type load instruction based on the mnemonic
+func (cpu *CPU) ExecuteILoadType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "lb":
+		return cpu.LB(instruction)
+	case "lh":
+		return cpu.LH(instruction)
+	case "lw":
+		return cpu.LW(instruction)
+	case "lbu":
+		return cpu.LBU(instruction)
+	case "lhu":
+		return cpu.LHU(instruction)
+	default:
+		return fmt.Errorf("unknown load instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Loads a sign-extended byte from memory into a register
+func (cpu *CPU) LB(instruction *AssemblyInstruction) error {
+	value, err := cpu.FetchByte(cpu.registers.Read(instruction.rs1) + uint32(instruction.imm))
+	if err != nil {
+		return err
+	}
+	cpu.registers.Write(instruction.rd, uint32(int32(int8(value))))
 	return nil
 }
 
-// Executes the corresponding I-type system instruction based on the funct3 field
-func (cpu *CPU) ExecuteISysType(funct3 uint8, funct7 uint8, instruction *ITypeInstruction) error {
+// Loads a sign-extended halfword from memory into a register
+func (cpu *CPU) LH(instruction *AssemblyInstruction) error {
+	value, err := cpu.FetchHalfWord(cpu.registers.Read(instruction.rs1) + uint32(instruction.imm))
+	if err != nil {
+		return err
+	}
+	cpu.registers.Write(instruction.rd, uint32(int32(int16(value))))
 	return nil
 }
 
-// Executes the corresponding S-type instruction based on the funct3 field
-func (cpu *CPU) ExecuteSType(funct3 uint8, instruction *STypeInstruction) error {
+// Loads a word from memory into a register
+func (cpu *CPU) LW(instruction *AssemblyInstruction) error {
+	value, err := cpu.FetchWord(cpu.registers.Read(instruction.rs1) + uint32(instruction.imm))
+	if err != nil {
+		return err
+	}
+	cpu.registers.Write(instruction.rd, value)
 	return nil
 }
 
-// Executes the corresponding B-type instruction based on the funct3 field
-func (cpu *CPU) ExecuteBType(funct3 uint8, instruction *BTypeInstruction) error {
+// Loads a zero-extended byte from memory into a register
+func (cpu *CPU) LBU(instruction *AssemblyInstruction) error {
+	value, err := cpu.FetchByte(cpu.registers.Read(instruction.rs1) + uint32(instruction.imm))
+	if err != nil {
+		return err
+	}
+	cpu.registers.Write(instruction.rd, uint32(value))
 	return nil
 }
 
-// Executes the corresponding U-type instruction based on the funct3 field
-func (cpu *CPU) ExecuteUType(funct3 uint8, funct7 uint8, instruction *UTypeInstruction) error {
+// Loads a zero-extended halfword from memory into a register
+func (cpu *CPU) LHU(instruction *AssemblyInstruction) error {
+	value, err := cpu.FetchHalfWord(cpu.registers.Read(instruction.rs1) + uint32(instruction.imm))
+	if err != nil {
+		return err
+	}
+	cpu.registers.Write(instruction.rd, uint32(value))
+	return nil
+}
+
+// Executes the corresponding I-type jump instruction based on the mnemonic
+func (cpu *CPU) ExecuteIJumpType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "jalr":
+		return cpu.JALR(instruction)
+	default:
+		return fmt.Errorf("unknown jump instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Jumps to a register plus an offset and stores the return address in the destination register
+func (cpu *CPU) JALR(instruction *AssemblyInstruction) error {
+	returnAddress := cpu.pc
+	target := (cpu.registers.Read(instruction.rs1) + uint32(instruction.imm)) &^ 0x1
+	if err := cpu.jump(target); err != nil {
+		return err
+	}
+	cpu.registers.Write(instruction.rd, returnAddress)
+	return nil
+}
+
+// Executes the corresponding I-type fence instruction based on the mnemonic
+func (cpu *CPU) ExecuteIFenceType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "fence":
+		return cpu.FENCE(instruction)
+	case "fence.i":
+		return cpu.FENCE_I(instruction)
+	default:
+		return fmt.Errorf("unknown fence instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Orders memory accesses, which is a no-op on a single in-order hart
+func (cpu *CPU) FENCE(instruction *AssemblyInstruction) error {
+	return nil
+}
+
+// Synchronizes the instruction and data streams, which is a no-op as instructions are never cached
+func (cpu *CPU) FENCE_I(instruction *AssemblyInstruction) error {
+	return nil
+}
+
+// Executes the corresponding I-type system instruction based on the mnemonic
+func (cpu *CPU) ExecuteISysType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "ecall":
+		return cpu.ECALL(instruction)
+	case "ebreak":
+		return cpu.EBREAK(instruction)
+	case "mret":
+		return cpu.MRET(instruction)
+	case "wfi":
+		return cpu.WFI(instruction)
+	case "csrrw", "csrrs", "csrrc", "csrrwi", "csrrsi", "csrrci":
+		return cpu.ExecuteCSRType(instruction)
+	default:
+		return fmt.Errorf("unknown system instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Requests a service from the execution environment
+func (cpu *CPU) ECALL(instruction *AssemblyInstruction) error {
+	if cpu.privilege == PRIV_USER {
+		return &Trap{cause: CAUSE_USER_ECALL, err: ErrEnvironmentCall}
+	}
+	return &Trap{cause: CAUSE_MACHINE_ECALL, err: ErrEnvironmentCall}
+}
+
+// Returns control to the debugging environment
+func (cpu *CPU) EBREAK(instruction *AssemblyInstruction) error {
+	return &Trap{cause: CAUSE_BREAKPOINT, value: cpu.instructionAddress(), err: ErrBreakpoint}
+}
+
+// Executes the corresponding S-type instruction based on the mnemonic
+func (cpu *CPU) ExecuteSType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "sb":
+		return cpu.SB(instruction)
+	case "sh":
+		return cpu.SH(instruction)
+	case "sw":
+		return cpu.SW(instruction)
+	default:
+		return fmt.Errorf("unknown s-type instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Stores the lower byte of a register into memory
+func (cpu *CPU) SB(instruction *AssemblyInstruction) error {
+	return cpu.StoreByte(cpu.registers.Read(instruction.rs1)+uint32(instruction.imm), uint8(cpu.registers.Read(instruction.rs2)))
+}
+
+// Stores the lower halfword of a register into memory
+func (cpu *CPU) SH(instruction *AssemblyInstruction) error {
+	return cpu.StoreHalfWord(cpu.registers.Read(instruction.rs1)+uint32(instruction.imm), uint16(cpu.registers.Read(instruction.rs2)))
+}
+
+// Stores a register into memory
+func (cpu *CPU) SW(instruction *AssemblyInstruction) error {
+	return cpu.StoreWord(cpu.registers.Read(instruction.rs1)+uint32(instruction.imm), cpu.registers.Read(instruction.rs2))
+}
+
+// Executes the corresponding B-type instruction based on the mnemonic
+func (cpu *CPU) ExecuteBType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "beq":
+		return cpu.BEQ(instruction)
+	case "bne":
+		return cpu.BNE(instruction)
+	case "blt":
+		return cpu.BLT(instruction)
+	case "bge":
+		return cpu.BGE(instruction)
+	case "bltu":
+		return cpu.BLTU(instruction)
+	case "bgeu":
+		return cpu.BGEU(instruction)
+	default:
+		return fmt.Errorf("unknown b-type instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Takes the branch if the condition holds, otherwise falls through to the next instruction
+func (cpu *CPU) branch(condition bool, instruction *AssemblyInstruction) error {
+	if !condition {
+		return nil
+	}
+	return cpu.jump(cpu.instructionAddress() + uint32(instruction.imm))
+}
+
+// Branches if the two registers are equal
+func (cpu *CPU) BEQ(instruction *AssemblyInstruction) error {
+	return cpu.branch(cpu.registers.Read(instruction.rs1) == cpu.registers.Read(instruction.rs2), instruction)
+}
+
+// Branches if the two registers are not equal
+func (cpu *CPU) BNE(instruction *AssemblyInstruction) error {
+	return cpu.branch(cpu.registers.Read(instruction.rs1) != cpu.registers.Read(instruction.rs2), instruction)
+}
+
+// Branches if the first register is less than the second
+func (cpu *CPU) BLT(instruction *AssemblyInstruction) error {
+	return cpu.branch(int32(cpu.registers.Read(instruction.rs1)) < int32(cpu.registers.Read(instruction.rs2)), instruction)
+}
+
+// Branches if the first register is greater than or equal to the second
+func (cpu *CPU) BGE(instruction *AssemblyInstruction) error {
+	return cpu.branch(int32(cpu.registers.Read(instruction.rs1)) >= int32(cpu.registers.Read(instruction.rs2)), instruction)
+}
+
+// Branches if the first register is less than the second (unsigned)
+func (cpu *CPU) BLTU(instruction *AssemblyInstruction) error {
+	return cpu.branch(cpu.registers.Read(instruction.rs1) < cpu.registers.Read(instruction.rs2), instruction)
+}
+
+// Branches if the first register is greater than or equal to the second (unsigned)
+func (cpu *CPU) BGEU(instruction *AssemblyInstruction) error {
+	return cpu.branch(cpu.registers.Read(instruction.rs1) >= cpu.registers.Read(instruction.rs2), instruction)
+}
+
+// Executes the corresponding U-type instruction based on the mnemonic
+func (cpu *CPU) ExecuteUType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "lui":
+		return cpu.LUI(instruction)
+	case "auipc":
+		return cpu.AUIPC(instruction)
+	default:
+		return fmt.Errorf("unknown u-type instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Loads the upper immediate into a register
+func (cpu *CPU) LUI(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, uint32(instruction.imm))
 	return nil
 }
 
-// Executes the corresponding J-type instruction based on the funct3 field
-func (cpu *CPU) ExecuteJType(funct3 uint8, funct7 uint8, instruction *JTypeInstruction) error {
+// Adds the upper immediate to the address of the instruction and stores the result in a register
+func (cpu *CPU) AUIPC(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.instructionAddress()+uint32(instruction.imm))
 	return nil
 }
 
+// Executes the corresponding J-type instruction based on the mnemonic
+func (cpu *CPU) ExecuteJType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "jal":
+		return cpu.JAL(instruction)
+	default:
+		return fmt.Errorf("unknown j-type instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Jumps to a pc-relative offset and stores the return address in the destination register
+func (cpu *CPU) JAL(instruction *AssemblyInstruction) error {
+	returnAddress := cpu.pc
+	if err := cpu.jump(cpu.instructionAddress() + uint32(instruction.imm)); err != nil {
+		return err
+	}
+	cpu.registers.Write(instruction.rd, returnAddress)
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import "testing"
+
+// Creates a CPU starting at the given pc with the given amount of memory, holding the instructions from the pc onwards
+func newTestCPU(t *testing.T, start uint32, length uint32, instructions ...uint32) *CPU {
+	t.Helper()
+	cpu, err := NewCPU(start, length)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	storeWords(t, cpu, start, instructions...)
+	return cpu
+}
+
+// Stores words from the address onwards, failing the test if any store faults
+func storeWords(t *testing.T, cpu *CPU, addr uint32, words ...uint32) {
+	t.Helper()
+	for i, word := range words {
+		if err := cpu.StoreWord(addr+uint32(i)*BYTES_PER_WORD, word); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   map[uint8]uint32  // Registers once the program reaches its ebreak
+		memory map[uint32]uint32 // Words of memory once the program reaches its ebreak
+	}{
+		{
+			name: "sign-extended immediates",
+			source: `
+	li	a1, 5
+	addi	a0, a1, -6
+	slti	a2, a1, -1
+	sltiu	a3, a1, -1
+	xori	a4, a1, -1
+	lui	a5, 0xfffff`,
+			want: map[uint8]uint32{REG_A0: 0xffff_ffff, REG_A2: 0, REG_A3: 1, REG_A4: 0xffff_fffa, REG_A5: 0xffff_f000},
+		},
+		{
+			name: "shift amounts use the low five bits",
+			source: `
+	li	a1, -16
+	li	a2, 36
+	sll	a0, a1, a2
+	srl	a3, a1, a2
+	sra	a4, a1, a2
+	srai	a5, a1, 2`,
+			want: map[uint8]uint32{REG_A0: 0xffff_ff00, REG_A3: 0x0fff_ffff, REG_A4: 0xffff_ffff, REG_A5: 0xffff_fffc},
+		},
+		{
+			name: "branch offsets",
+			source: `
+	li	a0, 0
+	li	a1, 3
+1:	addi	a0, a0, 2
+	addi	a1, a1, -1
+	bnez	a1, 1b
+	blt	a1, a0, 2f
+	li	a0, -1
+2:	bltu	a0, a1, 3f
+	addi	a0, a0, 1
+3:`,
+			want: map[uint8]uint32{REG_A0: 7, REG_A1: 0},
+		},
+		{
+			name: "auipc and jal are relative to the instruction",
+			source: `
+	nop
+	auipc	a0, 1
+	jal	ra, 1f
+	li	a0, 0
+1:	auipc	a1, 0`,
+			want: map[uint8]uint32{REG_A0: 0x1004, REG_RA: 0xc, REG_A1: 0x10},
+		},
+		{
+			name: "jalr clears the lowest bit of the target",
+			source: `
+	auipc	a1, 0
+	addi	a1, a1, 17
+	jalr	ra, 0(a1)
+	li	a0, -1
+	li	a0, 7`,
+			want: map[uint8]uint32{REG_A0: 7, REG_RA: 0xc, REG_A1: 0x11},
+		},
+		{
+			name: "loads sign- and zero-extend",
+			source: `
+	li	a0, 0x1000
+	li	a1, -2
+	sw	a1, 0(a0)
+	lb	a2, 0(a0)
+	lbu	a3, 0(a0)
+	lh	a4, 0(a0)
+	lhu	a5, 0(a0)
+	lw	a6, 0(a0)`,
+			want: map[uint8]uint32{REG_A2: 0xffff_fffe, REG_A3: 0xfe, REG_A4: 0xffff_fffe, REG_A5: 0xfffe, REG_A6: 0xffff_fffe},
+		},
+		{
+			name: "stores write only their size",
+			source: `
+	li	a0, 0x1000
+	li	a1, 0x12345678
+	sw	zero, 4(a0)
+	sb	a1, 4(a0)
+	sh	a1, 6(a0)`,
+			memory: map[uint32]uint32{0x1004: 0x5678_0078},
+		},
+		{
+			// Clears the BSS a byte at a time as crt0.S does, here between 0x1100 and 0x1108
+			name: "crt0 clearing the bss",
+			source: `
+	li	a0, 0x1100
+	li	a1, 0x1108
+	li	t0, -1
+	sw	t0, 0(a0)
+	sw	t0, 4(a0)
+	sw	t0, 8(a0)
+clear_bss:
+	bgeu	a0, a1, done_bss
+	sb	zero, 0(a0)
+	addi	a0, a0, 1
+	beq	zero, zero, clear_bss
+done_bss:`,
+			want:   map[uint8]uint32{REG_A0: 0x1108},
+			memory: map[uint32]uint32{0x1100: 0, 0x1104: 0, 0x1108: 0xffff_ffff},
+		},
+	}
+	for _, tt := range tests {
+		cpu := runProgram(t, assemble(t, tt.source+"\n\tebreak\n"))
+		for reg, want := range tt.want {
+			if got := cpu.registers.Read(reg); got != want {
+				t.Errorf("%s: %s = %08x, want %08x", tt.name, RegisterName(reg), got, want)
+			}
+		}
+		for addr, want := range tt.memory {
+			if got, _ := cpu.FetchWord(addr); got != want {
+				t.Errorf("%s: word at %08x = %08x, want %08x", tt.name, addr, got, want)
+			}
+		}
+	}
+}
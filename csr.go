@@ -0,0 +1,367 @@
+package main
+
+import "fmt"
+
+// Addresses of the implemented control and status registers
+const (
+	CSR_FFLAGS        uint32 = 0x001 // Floating-point accrued exceptions
+	CSR_FRM           uint32 = 0x002 // Floating-point dynamic rounding mode
+	CSR_FCSR          uint32 = 0x003 // Floating-point control and status register
+	CSR_CYCLE         uint32 = 0xC00 // Cycle counter
+	CSR_TIME          uint32 = 0xC01 // Timer
+	CSR_INSTRET       uint32 = 0xC02 // Instructions-retired counter
+	CSR_HPMCOUNTER3   uint32 = 0xC03 // First of the hardware performance-monitoring counters
+	CSR_CYCLEH        uint32 = 0xC80 // Upper 32 bits of cycle
+	CSR_TIMEH         uint32 = 0xC81 // Upper 32 bits of time
+	CSR_INSTRETH      uint32 = 0xC82 // Upper 32 bits of instret
+	CSR_HPMCOUNTER3H  uint32 = 0xC83 // Upper 32 bits of the first hardware performance-monitoring counter
+	CSR_MVENDORID     uint32 = 0xF11 // Vendor ID
+	CSR_MARCHID       uint32 = 0xF12 // Architecture ID
+	CSR_MIMPID        uint32 = 0xF13 // Implementation ID
+	CSR_MHARTID       uint32 = 0xF14 // Hardware thread ID
+	CSR_MSTATUS       uint32 = 0x300 // Machine status register
+	CSR_MISA          uint32 = 0x301 // ISA and extensions
+	CSR_MIE           uint32 = 0x304 // Machine interrupt-enable register
+	CSR_MTVEC         uint32 = 0x305 // Machine trap-handler base address
+	CSR_MSTATUSH      uint32 = 0x310 // Upper 32 bits of mstatus
+	CSR_MHPMEVENT3    uint32 = 0x323 // First of the machine performance-monitoring event selectors
+	CSR_MSCRATCH      uint32 = 0x340 // Scratch register for machine trap handlers
+	CSR_MEPC          uint32 = 0x341 // Machine exception program counter
+	CSR_MCAUSE        uint32 = 0x342 // Machine trap cause
+	CSR_MTVAL         uint32 = 0x343 // Machine bad address or instruction
+	CSR_MIP           uint32 = 0x344 // Machine interrupt pending
+	CSR_MCYCLE        uint32 = 0xB00 // Machine cycle counter
+	CSR_MINSTRET      uint32 = 0xB02 // Machine instructions-retired counter
+	CSR_MHPMCOUNTER3  uint32 = 0xB03 // First of the machine performance-monitoring counters
+	CSR_MCYCLEH       uint32 = 0xB80 // Upper 32 bits of mcycle
+	CSR_MINSTRETH     uint32 = 0xB82 // Upper 32 bits of minstret
+	CSR_MHPMCOUNTER3H uint32 = 0xB83 // Upper 32 bits of the first machine performance-monitoring counter
+	HPM_COUNTERS      uint32 = 29    // Number of hardware performance-monitoring counters, numbered from 3
+)
+
+// Fields of a CSR address
+const (
+	CSR_ADDRESS_MASK    uint32 = 0xFFF // CSR addresses are 12 bits wide
+	CSR_PRIVILEGE_MASK  uint32 = 0x300 // Lowest privilege level that may access the CSR
+	CSR_PRIVILEGE_SHIFT uint32 = 8     // Position of the privilege level
+	CSR_READ_ONLY_MASK  uint32 = 0xC00 // Both bits set mark a read-only CSR
+)
+
+// Privilege levels of the hart
+const (
+	PRIV_USER       uint8 = 0b00 // User mode
+	PRIV_SUPERVISOR uint8 = 0b01 // Supervisor mode, which only a reference may be in
+	PRIV_MACHINE    uint8 = 0b11 // Machine mode
+)
+
+// Fields of the mstatus register
+const (
+	MSTATUS_MIE       uint32 = 1 << 3  // Machine interrupt enable
+	MSTATUS_MPIE      uint32 = 1 << 7  // Machine interrupt enable before the trap
+	MSTATUS_MPP_SHIFT uint32 = 11      // Position of the privilege before the trap
+	MSTATUS_MPP       uint32 = 3 << 11 // Privilege before the trap
+	MSTATUS_FS        uint32 = 3 << 13 // Floating-point unit state
+	MSTATUS_FS_OFF    uint32 = 0 << 13 // Floating-point instructions are illegal
+	MSTATUS_FS_INIT   uint32 = 1 << 13 // Floating-point state is as it was at reset
+	MSTATUS_FS_DIRTY  uint32 = 3 << 13 // Floating-point state has changed
+	MSTATUS_SD        uint32 = 1 << 31 // Summarizes whether any extension state is dirty
+)
+
+// Interrupt bits shared by the mie and mip registers
+const (
+	MIP_MSIP uint32 = 1 << 3  // Machine software interrupt
+	MIP_MTIP uint32 = 1 << 7  // Machine timer interrupt
+	MIP_MEIP uint32 = 1 << 11 // Machine external interrupt
+)
+
+// Represents a single control and status register
+type CSR struct {
+	name      string
+	value     uint32                       // Stored value of the register
+	readMask  uint32                       // Bits visible to reads, the rest read as zero
+	writeMask uint32                       // Bits writes may change, the rest keep their value
+	extension Extension                    // Extension the register belongs to, if it is not always present
+	read      func(cpu *CPU) uint32        // Computes the value instead of returning the stored one
+	write     func(cpu *CPU, value uint32) // Stores a written value somewhere other than the register
+}
+
+// Represents the cycle and instructions-retired counters of the hart
+type Counters struct {
+	cycle   uint64
+	instret uint64
+	written bool // Set when software writes a counter, suppressing the increment of the writing instruction
+}
+
+// Advances the counters after an instruction, counting it as retired if it completed
+func (counters *Counters) step(retired bool) {
+	if counters.written {
+		counters.written = false
+		return
+	}
+	counters.cycle++
+	if retired {
+		counters.instret++
+	}
+}
+
+// Returns the lower 32 bits of a counter
+func lowWord(value uint64) uint32 {
+	return uint32(value)
+}
+
+// Returns the upper 32 bits of a counter
+func highWord(value uint64) uint32 {
+	return uint32(value >> 32)
+}
+
+// Replaces the lower 32 bits of a counter
+func setLowWord(counter *uint64, value uint32) {
+	*counter = *counter&^0xFFFF_FFFF | uint64(value)
+}
+
+// Replaces the upper 32 bits of a counter
+func setHighWord(counter *uint64, value uint32) {
+	*counter = *counter&0xFFFF_FFFF | uint64(value)<<32
+}
+
+// Builds the CSR file of a hart, keyed by CSR address
+func newCSRFile() map[uint32]*CSR {
+	csrs := map[uint32]*CSR{
+		// Floating-point state, stored in fcsr
+		CSR_FFLAGS: {name: "fflags", readMask: FCSR_FFLAGS_MASK, writeMask: FCSR_FFLAGS_MASK, extension: EXT_F,
+			read: func(cpu *CPU) uint32 { return cpu.fcsr & FCSR_FFLAGS_MASK },
+			write: func(cpu *CPU, value uint32) {
+				cpu.fcsr = cpu.fcsr&^FCSR_FFLAGS_MASK | value
+				cpu.dirtyFloatState()
+			}},
+		CSR_FRM: {name: "frm", readMask: FCSR_FRM_MASK, writeMask: FCSR_FRM_MASK, extension: EXT_F,
+			read: func(cpu *CPU) uint32 { return cpu.fcsr >> FCSR_FRM_SHIFT & FCSR_FRM_MASK },
+			write: func(cpu *CPU, value uint32) {
+				cpu.fcsr = cpu.fcsr&^(FCSR_FRM_MASK<<FCSR_FRM_SHIFT) | value<<FCSR_FRM_SHIFT
+				cpu.dirtyFloatState()
+			}},
+		CSR_FCSR: {name: "fcsr", readMask: FCSR_MASK, writeMask: FCSR_MASK, extension: EXT_F,
+			read: func(cpu *CPU) uint32 { return cpu.fcsr },
+			write: func(cpu *CPU, value uint32) {
+				cpu.fcsr = value
+				cpu.dirtyFloatState()
+			}},
+
+		// Unprivileged counters, read-only shadows of the machine counters and the CLINT timer
+		CSR_CYCLE: {name: "cycle", readMask: 0xFFFF_FFFF,
+			read: func(cpu *CPU) uint32 { return lowWord(cpu.counters.cycle) }},
+		CSR_CYCLEH: {name: "cycleh", readMask: 0xFFFF_FFFF,
+			read: func(cpu *CPU) uint32 { return highWord(cpu.counters.cycle) }},
+		CSR_TIME: {name: "time", readMask: 0xFFFF_FFFF,
+			read: func(cpu *CPU) uint32 { return lowWord(cpu.clint.mtime) }},
+		CSR_TIMEH: {name: "timeh", readMask: 0xFFFF_FFFF,
+			read: func(cpu *CPU) uint32 { return highWord(cpu.clint.mtime) }},
+		CSR_INSTRET: {name: "instret", readMask: 0xFFFF_FFFF,
+			read: func(cpu *CPU) uint32 { return lowWord(cpu.counters.instret) }},
+		CSR_INSTRETH: {name: "instreth", readMask: 0xFFFF_FFFF,
+			read: func(cpu *CPU) uint32 { return highWord(cpu.counters.instret) }},
+
+		// Machine information registers, all zero as allowed for a non-commercial implementation
+		CSR_MVENDORID: {name: "mvendorid"},
+		CSR_MARCHID:   {name: "marchid"},
+		CSR_MIMPID:    {name: "mimpid"},
+		CSR_MHARTID:   {name: "mhartid"},
+
+		// Machine trap setup and handling
+		// The floating-point unit starts out on, so programs need not turn it on before using it
+		CSR_MSTATUS: {name: "mstatus", value: MSTATUS_MPP | MSTATUS_FS_INIT, readMask: 0xFFFF_FFFF,
+			writeMask: MSTATUS_MIE | MSTATUS_MPIE | MSTATUS_FS,
+			read: func(cpu *CPU) uint32 {
+				status := cpu.csrs[CSR_MSTATUS].value
+				if cpu.extensions&EXT_F == 0 {
+					// Without a floating-point unit its state reads as off
+					status &^= MSTATUS_FS
+				}
+				if status&MSTATUS_FS == MSTATUS_FS_DIRTY {
+					status |= MSTATUS_SD
+				}
+				return status
+			}},
+		CSR_MSTATUSH: {name: "mstatush"},
+		CSR_MISA: {name: "misa", readMask: 0xFFFF_FFFF,
+			read: func(cpu *CPU) uint32 { return cpu.extensions.misa() }},
+		CSR_MIE: {name: "mie", readMask: 0xFFFF_FFFF, writeMask: MIP_MSIP | MIP_MTIP | MIP_MEIP},
+		CSR_MTVEC: {name: "mtvec", readMask: 0xFFFF_FFFF, writeMask: 0xFFFF_FFFD,
+			write: func(cpu *CPU, value uint32) {
+				cpu.csrs[CSR_MTVEC].value = value
+				cpu.hasHandler = true
+			}},
+		CSR_MSCRATCH: {name: "mscratch", readMask: 0xFFFF_FFFF, writeMask: 0xFFFF_FFFF},
+		CSR_MEPC:     {name: "mepc", readMask: 0xFFFF_FFFF, writeMask: 0xFFFF_FFFE},
+		CSR_MCAUSE:   {name: "mcause", readMask: 0xFFFF_FFFF, writeMask: 0xFFFF_FFFF},
+		CSR_MTVAL:    {name: "mtval", readMask: 0xFFFF_FFFF, writeMask: 0xFFFF_FFFF},
+		CSR_MIP: {name: "mip", readMask: 0xFFFF_FFFF,
+			read: func(cpu *CPU) uint32 { return cpu.csrs[CSR_MIP].value | cpu.clint.pending() }},
+
+		// Machine counters
+		CSR_MCYCLE: {name: "mcycle", readMask: 0xFFFF_FFFF, writeMask: 0xFFFF_FFFF,
+			read:  func(cpu *CPU) uint32 { return lowWord(cpu.counters.cycle) },
+			write: func(cpu *CPU, value uint32) { setLowWord(&cpu.counters.cycle, value); cpu.counters.written = true }},
+		CSR_MCYCLEH: {name: "mcycleh", readMask: 0xFFFF_FFFF, writeMask: 0xFFFF_FFFF,
+			read:  func(cpu *CPU) uint32 { return highWord(cpu.counters.cycle) },
+			write: func(cpu *CPU, value uint32) { setHighWord(&cpu.counters.cycle, value); cpu.counters.written = true }},
+		CSR_MINSTRET: {name: "minstret", readMask: 0xFFFF_FFFF, writeMask: 0xFFFF_FFFF,
+			read:  func(cpu *CPU) uint32 { return lowWord(cpu.counters.instret) },
+			write: func(cpu *CPU, value uint32) { setLowWord(&cpu.counters.instret, value); cpu.counters.written = true }},
+		CSR_MINSTRETH: {name: "minstreth", readMask: 0xFFFF_FFFF, writeMask: 0xFFFF_FFFF,
+			read:  func(cpu *CPU) uint32 { return highWord(cpu.counters.instret) },
+			write: func(cpu *CPU, value uint32) { setHighWord(&cpu.counters.instret, value); cpu.counters.written = true }},
+	}
+
+	// The performance-monitoring counters and event selectors are implemented as read-only zeros
+	for i := uint32(0); i < HPM_COUNTERS; i++ {
+		csrs[CSR_HPMCOUNTER3+i] = &CSR{name: fmt.Sprintf("hpmcounter%d", i+3)}
+		csrs[CSR_HPMCOUNTER3H+i] = &CSR{name: fmt.Sprintf("hpmcounter%dh", i+3)}
+		csrs[CSR_MHPMCOUNTER3+i] = &CSR{name: fmt.Sprintf("mhpmcounter%d", i+3)}
+		csrs[CSR_MHPMCOUNTER3H+i] = &CSR{name: fmt.Sprintf("mhpmcounter%dh", i+3)}
+		csrs[CSR_MHPMEVENT3+i] = &CSR{name: fmt.Sprintf("mhpmevent%d", i+3)}
+	}
+	return csrs
+}
+
+// Returns the value of misa describing the extensions
+func (extensions Extension) misa() uint32 {
+	const MXL_32 uint32 = 1 << 30
+	misa := MXL_32
+	for letter, extension := range extensionLetters {
+		if letter != 'g' && extensions&extension == extension {
+			misa |= 1 << (letter - 'a')
+		}
+	}
+	return misa
+}
+
+// Returns the CSR at the given address, checking that it exists and may be accessed in the current privilege level
+func (cpu *CPU) lookupCSR(address uint32, write bool) (*CSR, error) {
+	address &= CSR_ADDRESS_MASK
+	csr, ok := cpu.csrs[address]
+	if !ok || csr.extension != 0 && cpu.extensions&csr.extension == 0 {
+		return nil, fmt.Errorf("illegal instruction: csr %03x does not exist", address)
+	}
+	if uint8((address&CSR_PRIVILEGE_MASK)>>CSR_PRIVILEGE_SHIFT) > cpu.privilege {
+		return nil, fmt.Errorf("illegal instruction: csr %s requires a higher privilege level", csr.name)
+	}
+	if write && address&CSR_READ_ONLY_MASK == CSR_READ_ONLY_MASK {
+		return nil, fmt.Errorf("illegal instruction: csr %s is read-only", csr.name)
+	}
+	return csr, nil
+}
+
+// Returns the value of a CSR as software sees it
+func (cpu *CPU) readCSR(csr *CSR) uint32 {
+	value := csr.value
+	if csr.read != nil {
+		value = csr.read(cpu)
+	}
+	return value & csr.readMask
+}
+
+// Writes a CSR, leaving the bits outside its write mask unchanged
+func (cpu *CPU) writeCSR(csr *CSR, value uint32) {
+	if csr.write != nil {
+		csr.write(cpu, value&csr.writeMask)
+		return
+	}
+	csr.value = csr.value&^csr.writeMask | value&csr.writeMask
+}
+
+// Reads the CSR at the given address
+func (cpu *CPU) ReadCSR(address uint32) (uint32, error) {
+	csr, err := cpu.lookupCSR(address, false)
+	if err != nil {
+		return 0, err
+	}
+	return cpu.readCSR(csr), nil
+}
+
+// Writes the CSR at the given address
+func (cpu *CPU) WriteCSR(address uint32, value uint32) error {
+	csr, err := cpu.lookupCSR(address, true)
+	if err != nil {
+		return err
+	}
+	cpu.writeCSR(csr, value)
+	return nil
+}
+
+// Executes the corresponding CSR instruction based on the mnemonic
+func (cpu *CPU) ExecuteCSRType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "csrrw":
+		return cpu.CSRRW(instruction)
+	case "csrrs":
+		return cpu.CSRRS(instruction)
+	case "csrrc":
+		return cpu.CSRRC(instruction)
+	case "csrrwi":
+		return cpu.CSRRWI(instruction)
+	case "csrrsi":
+		return cpu.CSRRSI(instruction)
+	case "csrrci":
+		return cpu.CSRRCI(instruction)
+	default:
+		return fmt.Errorf("unknown csr instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Atomically swaps a CSR with rs1
+func (cpu *CPU) CSRRW(instruction *AssemblyInstruction) error {
+	return cpu.accessCSR(instruction, cpu.registers.Read(instruction.rs1), true, func(value uint32, operand uint32) uint32 { return operand })
+}
+
+// Atomically reads a CSR and sets the bits given in rs1
+func (cpu *CPU) CSRRS(instruction *AssemblyInstruction) error {
+	return cpu.accessCSR(instruction, cpu.registers.Read(instruction.rs1), false, func(value uint32, operand uint32) uint32 { return value | operand })
+}
+
+// Atomically reads a CSR and clears the bits given in rs1
+func (cpu *CPU) CSRRC(instruction *AssemblyInstruction) error {
+	return cpu.accessCSR(instruction, cpu.registers.Read(instruction.rs1), false, func(value uint32, operand uint32) uint32 { return value &^ operand })
+}
+
+// Atomically swaps a CSR with a 5-bit immediate
+func (cpu *CPU) CSRRWI(instruction *AssemblyInstruction) error {
+	return cpu.accessCSR(instruction, uint32(instruction.rs1), true, func(value uint32, operand uint32) uint32 { return operand })
+}
+
+// Atomically reads a CSR and sets the bits given in a 5-bit immediate
+func (cpu *CPU) CSRRSI(instruction *AssemblyInstruction) error {
+	return cpu.accessCSR(instruction, uint32(instruction.rs1), false, func(value uint32, operand uint32) uint32 { return value | operand })
+}
+
+// Atomically reads a CSR and clears the bits given in a 5-bit immediate
+func (cpu *CPU) CSRRCI(instruction *AssemblyInstruction) error {
+	return cpu.accessCSR(instruction, uint32(instruction.rs1), false, func(value uint32, operand uint32) uint32 { return value &^ operand })
+}
+
+// Reads a CSR into rd and writes back the combination of its value with the operand
+func (cpu *CPU) accessCSR(instruction *AssemblyInstruction, operand uint32, swap bool, combine func(value uint32, operand uint32) uint32) error {
+	// A swap into x0 does not read the CSR, and setting or clearing with x0 or a zero immediate does not write it
+	read := !swap || instruction.rd != REG_ZERO
+	write := swap || instruction.rs1 != REG_ZERO
+
+	csr, err := cpu.lookupCSR(uint32(instruction.imm), write)
+	if err != nil {
+		return err
+	}
+	if csr.extension == EXT_F {
+		if err := cpu.checkFloatEnabled(); err != nil {
+			return err
+		}
+	}
+	var value uint32
+	if read {
+		value = cpu.readCSR(csr)
+	}
+	if write {
+		cpu.writeCSR(csr, combine(value, operand))
+		cpu.commitCSR(uint32(instruction.imm))
+	}
+	cpu.registers.Write(instruction.rd, value)
+	return nil
+}
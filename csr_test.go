@@ -0,0 +1,150 @@
+package main
+
+import "testing"
+
+// Executes a sequence of instructions, failing the test on the first error
+func execute(t *testing.T, cpu *CPU, instructions ...uint32) {
+	t.Helper()
+	for _, instruction := range instructions {
+		if err := cpu.Execute(instruction); err != nil {
+			t.Fatalf("Execute(%08x): unexpected error: %v", instruction, err)
+		}
+	}
+}
+
+func TestCSRFloatingPointAliases(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100)
+	cpu.registers.Write(REG_A1, 0xFF)
+	execute(t, cpu,
+		0x00159573, // fsflags a0, a1
+		0x0021d073, // fsrmi 3
+	)
+	if cpu.fcsr != 0x7F {
+		t.Errorf("fcsr = %#x, want 0x7f", cpu.fcsr)
+	}
+	execute(t, cpu, 0x00302573) // frcsr a0
+	if cpu.registers.Read(REG_A0) != 0x7F {
+		t.Errorf("frcsr = %#x, want 0x7f", cpu.registers.Read(REG_A0))
+	}
+	execute(t, cpu, 0x00202573) // frrm a0
+	if cpu.registers.Read(REG_A0) != 0x3 {
+		t.Errorf("frrm = %#x, want 0x3", cpu.registers.Read(REG_A0))
+	}
+
+	cpu.extensions, _ = ParseISA("rv32i_zicsr")
+	if err := cpu.Execute(0x00102573); err == nil { // frflags a0
+		t.Errorf("reading fflags without the F extension should be illegal")
+	}
+}
+
+func TestCSRFloatingPointState(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100)
+	if value, _ := cpu.ReadCSR(CSR_MSTATUS); value&MSTATUS_FS != MSTATUS_FS_INIT {
+		t.Errorf("mstatus.FS = %#x, want the unit to start out on", value&MSTATUS_FS)
+	}
+	execute(t, cpu, 0xf0058553) // fmv.w.x fa0, a1
+	if value, _ := cpu.ReadCSR(CSR_MSTATUS); value&(MSTATUS_SD|MSTATUS_FS) != MSTATUS_SD|MSTATUS_FS_DIRTY {
+		t.Errorf("mstatus = %#x, want the state dirty", value)
+	}
+
+	// Turning the unit off makes its instructions and CSRs illegal
+	cpu.registers.Write(REG_A1, MSTATUS_FS)
+	execute(t, cpu, 0x3005b073) // csrc mstatus, a1
+	// fmv.w.x fa0, a1; frflags a0
+	for _, instruction := range []uint32{0xf0058553, 0x00102573} {
+		if err := cpu.Execute(instruction); err == nil {
+			t.Errorf("Execute(%08x) with the unit off should be illegal", instruction)
+		}
+	}
+}
+
+func TestCSRWriteMasks(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100)
+	cpu.registers.Write(REG_A1, 0xFFFF_FFFF)
+	execute(t, cpu,
+		0x30559073, // csrw mtvec, a1
+		0x34459073, // csrw mip, a1
+		0x3005a073, // csrs mstatus, a1
+	)
+	if value, _ := cpu.ReadCSR(CSR_MTVEC); value != 0xFFFF_FFFD {
+		t.Errorf("mtvec = %#x, want the reserved mode to be unwritable", value)
+	}
+	if value, _ := cpu.ReadCSR(CSR_MIP); value != 0 {
+		t.Errorf("mip = %#x, want the pending bits to be read-only", value)
+	}
+	want := MSTATUS_SD | MSTATUS_FS | MSTATUS_MPP | MSTATUS_MPIE | MSTATUS_MIE
+	if value, _ := cpu.ReadCSR(CSR_MSTATUS); value != want {
+		t.Errorf("mstatus = %#x, want %#x", value, want)
+	}
+}
+
+func TestCSRIllegalAccesses(t *testing.T) {
+	tests := []struct {
+		name        string
+		instruction uint32
+	}{
+		{"write to a read-only counter", 0xc0059073},     // csrw cycle, a1
+		{"read of an unimplemented csr", 0x7c002573},     // csrr a0, 0x7c0
+		{"set of a read-only csr", 0xfff0e073},           // csrrsi zero, 0xfff, 1
+		{"write to an information register", 0xf1459073}, // csrw mhartid, a1
+		{"set of a read-only counter", 0xc000a5f3},       // csrrs a1, cycle, ra
+	}
+
+	for _, test := range tests {
+		cpu := newTestCPU(t, 0, 0x100)
+		if err := cpu.Execute(test.instruction); err == nil {
+			t.Errorf("%s: Execute(%08x) should be illegal", test.name, test.instruction)
+		}
+	}
+
+	// Reading a read-only register is fine as long as nothing is written
+	cpu := newTestCPU(t, 0, 0x100)
+	execute(t, cpu, 0xc00025f3) // csrr a1, cycle
+}
+
+func TestCSRPrivilege(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100)
+	cpu.privilege = PRIV_USER
+	if err := cpu.Execute(0x34002573); err == nil { // csrr a0, mscratch
+		t.Errorf("reading mscratch from user mode should be illegal")
+	}
+	execute(t, cpu, 0xc0202573) // rdinstret a0
+}
+
+func TestCSRCounters(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100)
+	execute(t, cpu,
+		0x00000013, // nop
+		0x00000013, // nop
+		0xb0202573, // csrr a0, minstret
+	)
+	if cpu.registers.Read(REG_A0) != 2 {
+		t.Errorf("minstret = %d, want 2", cpu.registers.Read(REG_A0))
+	}
+
+	// A written counter holds the written value for the next instruction
+	cpu.registers.Write(REG_A1, 100)
+	execute(t, cpu,
+		0xb0259073, // csrw minstret, a1
+		0xb0202673, // csrr a2, minstret
+	)
+	if cpu.registers.Read(REG_A2) != 100 {
+		t.Errorf("minstret after a write = %d, want 100", cpu.registers.Read(REG_A2))
+	}
+	if cpu.counters.instret != 101 {
+		t.Errorf("instret = %d, want 101", cpu.counters.instret)
+	}
+}
+
+func TestCSRMisa(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100)
+	cpu.extensions, _ = ParseISA("rv32imac_zicsr")
+	value, err := cpu.ReadCSR(CSR_MISA)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// MXL = 1 with the A, C, I and M bits set
+	if value != 0x4000_1105 {
+		t.Errorf("misa = %#x, want 0x40001105", value)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DEBUG_PROMPT        = "(rivogo) "
+	DEBUG_POLL_INTERVAL = 0x1000 // Instructions executed between checks for Ctrl-C while continuing
+	DEBUG_DISASM_COUNT  = 8      // Instructions disassembled when no count is given
+	DEBUG_DISASM_BEFORE = 2      // Instructions disassembled before pc when no address is given
+	DEBUG_MEMORY_COUNT  = 64     // Bytes displayed when no count is given
+)
+
+// Describes a command of the debugger
+type debugCommand struct {
+	names []string // Name of the command followed by its abbreviations
+	usage string   // Arguments of the command
+	help  string   // What the command does
+	run   func(debugger *Debugger, args []string) error
+}
+
+// The commands of the debugger, in the order help lists them
+var debugCommands []debugCommand
+
+func init() {
+	debugCommands = []debugCommand{
+		{[]string{"step", "s"}, "[count]", "Execute count instructions, 1 by default", (*Debugger).step},
+		{[]string{"continue", "c"}, "", "Run until a breakpoint, an unhandled trap or the end of the program", (*Debugger).resume},
+		{[]string{"break", "b"}, "[address|symbol]", "Set a breakpoint, or list them without an argument", (*Debugger).setBreakpoint},
+		{[]string{"delete", "d"}, "address|symbol", "Clear a breakpoint", (*Debugger).clearBreakpoint},
+		{[]string{"registers", "regs", "r"}, "", "Display the registers", (*Debugger).registers},
+		{[]string{"set"}, "register|pc value", "Set a register or the program counter", (*Debugger).set},
+		{[]string{"x"}, "address|symbol [count]", "Display count bytes of memory", (*Debugger).examine},
+		{[]string{"patch"}, "address|symbol value [size]", "Write a value of 1, 2 or 4 bytes to memory, 4 by default", (*Debugger).patch},
+		{[]string{"disasm", "dis"}, "[address|symbol] [count]", "Disassemble count instructions, around pc by default", (*Debugger).disassemble},
+		{[]string{"help", "h"}, "", "List the commands", (*Debugger).help},
+	}
+}
+
+// An interactive monitor that lets the user step through a program and inspect and change its state
+type Debugger struct {
+	cpu         *CPU
+	input       *bufio.Scanner
+	output      io.Writer
+	breakpoints map[uint32]bool
+	disasm      *Disassembler
+}
+
+// Constructor to initialize a debugger reading commands from the input and printing to the output
+func NewDebugger(cpu *CPU, input io.Reader, output io.Writer) *Debugger {
+	return &Debugger{
+		cpu:         cpu,
+		input:       bufio.NewScanner(input),
+		output:      output,
+		breakpoints: make(map[uint32]bool),
+		disasm:      NewDisassembler(cpu.symbols),
+	}
+}
+
+// Reads and runs commands until the user quits, the input ends or the program exits.
+// An empty line repeats the previous command.
+func (debugger *Debugger) Run() {
+	fmt.Fprintln(debugger.output, "Type help for a list of commands")
+	debugger.where()
+	var last []string
+	for {
+		fmt.Fprint(debugger.output, DEBUG_PROMPT)
+		if !debugger.input.Scan() {
+			fmt.Fprintln(debugger.output)
+			return
+		}
+		args := strings.Fields(debugger.input.Text())
+		if len(args) == 0 {
+			args = last
+		}
+		if len(args) == 0 {
+			continue
+		}
+		last = args
+		if args[0] == "quit" || args[0] == "q" {
+			return
+		}
+
+		command := lookupDebugCommand(args[0])
+		if command == nil {
+			fmt.Fprintf(debugger.output, "Unknown command %q, type help for a list of commands\n", args[0])
+			continue
+		}
+		if err := command.run(debugger, args[1:]); err != nil {
+			fmt.Fprintf(debugger.output, "Error: %v\n", err)
+		}
+		if _, exited := debugger.cpu.Exited(); exited {
+			return
+		}
+	}
+}
+
+// Returns the command with the given name or abbreviation, or nil if there is none
+func lookupDebugCommand(name string) *debugCommand {
+	for i, command := range debugCommands {
+		for _, commandName := range command.names {
+			if name == commandName {
+				return &debugCommands[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Executes the given number of instructions, one by default
+func (debugger *Debugger) step(args []string) error {
+	count := uint32(1)
+	if len(args) > 0 {
+		var err error
+		if count, err = parseCount(args[0]); err != nil {
+			return err
+		}
+	}
+	debugger.runUntil(int(count), nil)
+	return nil
+}
+
+// Runs the program until a breakpoint, until it stops or until it is interrupted
+func (debugger *Debugger) resume(args []string) error {
+	// Ctrl-C stops the program instead of RivoGo
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	debugger.runUntil(-1, interrupts)
+	return nil
+}
+
+// Executes instructions until count have run, forever when count is negative, stopping early at
+// breakpoints, when the program stops or when it is interrupted. The instruction at pc always runs,
+// so that continuing from a breakpoint moves past it.
+func (debugger *Debugger) runUntil(count int, interrupts <-chan os.Signal) {
+	cpu := debugger.cpu
+	for i := 0; count < 0 || i < count; i++ {
+		if i > 0 && debugger.breakpoints[cpu.pc] {
+			fmt.Fprintf(debugger.output, "Breakpoint at %s\n", debugger.location(cpu.pc))
+			break
+		}
+		if i%DEBUG_POLL_INTERVAL == 0 && len(interrupts) > 0 {
+			<-interrupts
+			fmt.Fprintln(debugger.output, "Interrupted")
+			break
+		}
+
+		err := cpu.Step()
+		if code, exited := cpu.Exited(); exited {
+			fmt.Fprintf(debugger.output, "Program exited with status %d\n", code)
+			return
+		}
+		if err != nil {
+			// Unhandled traps stop at the instruction that raised them
+			cpu.pc = cpu.instructionAddress()
+			fmt.Fprintf(debugger.output, "Unhandled trap at %s: %v\n", debugger.location(cpu.pc), err)
+			break
+		}
+	}
+	debugger.where()
+}
+
+// Sets a breakpoint at the given address, or lists the breakpoints without one
+func (debugger *Debugger) setBreakpoint(args []string) error {
+	if len(args) == 0 {
+		addrs := make([]uint32, 0, len(debugger.breakpoints))
+		for addr := range debugger.breakpoints {
+			addrs = append(addrs, addr)
+		}
+		sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+		for _, addr := range addrs {
+			fmt.Fprintf(debugger.output, "Breakpoint at %s\n", debugger.location(addr))
+		}
+		return nil
+	}
+	addr, err := debugger.address(args[0])
+	if err != nil {
+		return err
+	}
+	debugger.breakpoints[addr] = true
+	fmt.Fprintf(debugger.output, "Breakpoint at %s\n", debugger.location(addr))
+	return nil
+}
+
+// Removes the breakpoint at the given address
+func (debugger *Debugger) clearBreakpoint(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: delete address|symbol")
+	}
+	addr, err := debugger.address(args[0])
+	if err != nil {
+		return err
+	}
+	if !debugger.breakpoints[addr] {
+		return fmt.Errorf("no breakpoint at %08x", addr)
+	}
+	delete(debugger.breakpoints, addr)
+	return nil
+}
+
+// Shows the contents of the registers
+func (debugger *Debugger) registers(args []string) error {
+	debugger.cpu.DisplayRegisters(debugger.output)
+	return nil
+}
+
+// Writes a value to a register or to the program counter
+func (debugger *Debugger) set(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: set register|pc value")
+	}
+	value, err := debugger.value(args[1])
+	if err != nil {
+		return err
+	}
+	if strings.ToLower(args[0]) == "pc" {
+		debugger.cpu.pc = value
+		debugger.where()
+		return nil
+	}
+	return debugger.cpu.SetRegister(args[0], value)
+}
+
+// Shows the contents of memory from the given address
+func (debugger *Debugger) examine(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: x address|symbol [count]")
+	}
+	addr, err := debugger.address(args[0])
+	if err != nil {
+		return err
+	}
+	count := uint32(DEBUG_MEMORY_COUNT)
+	if len(args) > 1 {
+		if count, err = parseCount(args[1]); err != nil {
+			return err
+		}
+	}
+	debugger.cpu.DisplayMemory(debugger.output, addr, count)
+	return nil
+}
+
+// Writes a value of the given size to memory, a word by default
+func (debugger *Debugger) patch(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("usage: patch address|symbol value [size]")
+	}
+	addr, err := debugger.address(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := debugger.value(args[1])
+	if err != nil {
+		return err
+	}
+	size := BYTES_PER_WORD
+	if len(args) > 2 {
+		if size, err = parseCount(args[2]); err != nil {
+			return err
+		}
+	}
+	switch size {
+	case 1, BYTES_PER_HALF, BYTES_PER_WORD:
+	default:
+		return fmt.Errorf("cannot write %d bytes at once", size)
+	}
+	// The debugger writes memory directly, so patching neither traps nor counts as a store
+	return debugger.cpu.bus.Write(addr, size, value)
+}
+
+// Shows the instructions from the given address, or from a little before pc
+func (debugger *Debugger) disassemble(args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("usage: disasm [address|symbol] [count]")
+	}
+	addr := debugger.disassemblyStart()
+	count := uint32(DEBUG_DISASM_COUNT)
+	var err error
+	if len(args) > 0 {
+		if addr, err = debugger.address(args[0]); err != nil {
+			return err
+		}
+	}
+	if len(args) > 1 {
+		if count, err = parseCount(args[1]); err != nil {
+			return err
+		}
+	}
+	for i := uint32(0); i < count; i++ {
+		addr += debugger.printInstruction(addr)
+	}
+	return nil
+}
+
+// Lists the commands and what they do
+func (debugger *Debugger) help(args []string) error {
+	for _, command := range debugCommands {
+		fmt.Fprintf(debugger.output, "  %-40s %s\n", strings.Join(command.names, ", ")+" "+command.usage, command.help)
+	}
+	fmt.Fprintf(debugger.output, "  %-40s %s\n", "quit, q", "Leave the debugger, stopping the program")
+	return nil
+}
+
+// Returns the address a few instructions before pc that disassembling from runs into pc. As
+// instructions differ in length, the furthest address whose instructions line up with pc is chosen.
+func (debugger *Debugger) disassemblyStart() uint32 {
+	pc := debugger.cpu.pc
+	for back := DEBUG_DISASM_BEFORE * BYTES_PER_WORD; back > 0; back -= BYTES_PER_HALF {
+		if back > pc {
+			continue
+		}
+		addr := pc - back
+		for i := 0; i < DEBUG_DISASM_BEFORE && addr < pc; i++ {
+			addr += debugger.instructionSize(addr)
+		}
+		if addr == pc {
+			return pc - back
+		}
+	}
+	return pc
+}
+
+// Prints the instruction at the address and returns its size, marking pc and breakpoints
+func (debugger *Debugger) printInstruction(addr uint32) uint32 {
+	marker := "  "
+	switch {
+	case addr == debugger.cpu.pc:
+		marker = "=>"
+	case debugger.breakpoints[addr]:
+		marker = " *"
+	}
+	size := debugger.instructionSize(addr)
+	word, err := debugger.cpu.bus.Read(addr, size)
+	if err != nil {
+		fmt.Fprintf(debugger.output, "%s %s:  <unmapped>\n", marker, debugger.location(addr))
+		return size
+	}
+	raw := fmt.Sprintf("%08x", word)
+	if size == BYTES_PER_HALF {
+		raw = fmt.Sprintf("%04x", word)
+	}
+	text, _ := debugger.disasm.Disassemble(addr, word)
+	text = strings.ReplaceAll(text, "\t", " ")
+	fmt.Fprintf(debugger.output, "%s %s:  %-8s  %s\n", marker, debugger.location(addr), raw, text)
+	return size
+}
+
+// Returns the size of the instruction at the address, given by the lowest bits of its first parcel
+func (debugger *Debugger) instructionSize(addr uint32) uint32 {
+	parcel, err := debugger.cpu.bus.Read(addr, BYTES_PER_HALF)
+	if err == nil && isCompressed(parcel) {
+		return BYTES_PER_HALF
+	}
+	return BYTES_PER_WORD
+}
+
+// Prints the instruction the program stopped at
+func (debugger *Debugger) where() {
+	debugger.printInstruction(debugger.cpu.pc)
+}
+
+// Describes an address along with the symbol it falls in, if any
+func (debugger *Debugger) location(addr uint32) string {
+	if name, offset, ok := debugger.cpu.SymbolAt(addr); ok {
+		if offset == 0 {
+			return fmt.Sprintf("%08x <%s>", addr, name)
+		}
+		return fmt.Sprintf("%08x <%s+0x%x>", addr, name, offset)
+	}
+	return fmt.Sprintf("%08x", addr)
+}
+
+// Parses an address given as a number or the name of a symbol
+func (debugger *Debugger) address(arg string) (uint32, error) {
+	return debugger.cpu.ParseAddress(arg)
+}
+
+// Parses a value given as an unsigned or negative number, or the name of a symbol
+func (debugger *Debugger) value(arg string) (uint32, error) {
+	if value, err := strconv.ParseInt(arg, 0, 32); err == nil && value < 0 {
+		return uint32(value), nil
+	}
+	return debugger.address(arg)
+}
+
+// Parses a count of instructions or bytes
+func parseCount(arg string) (uint32, error) {
+	count, err := strconv.ParseUint(arg, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid count %q", arg)
+	}
+	return uint32(count), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Creates a CPU with the given instructions at address zero and runs a debugger on the commands
+func runDebugger(t *testing.T, commands string, instructions ...uint32) (*CPU, string) {
+	t.Helper()
+	cpu := newTestCPU(t, 0, 0x2000, instructions...)
+	cpu.symbols["loop"] = 0x8
+	var output bytes.Buffer
+	NewDebugger(cpu, strings.NewReader(commands), &output).Run()
+	return cpu, output.String()
+}
+
+// Checks that the output contains each of the given lines
+func checkOutput(t *testing.T, output string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(output, line) {
+			t.Errorf("output does not contain %q:\n%s", line, output)
+		}
+	}
+}
+
+func TestDebuggerStepAndBreakpoints(t *testing.T) {
+	// addi a0, zero, 1; addi a0, a0, 1; loop: addi a0, a0, 1; jal zero, loop; ebreak
+	program := []uint32{0x00100513, 0x00150513, 0x00150513, 0xffdff06f, 0x00100073}
+	commands := strings.Join([]string{
+		"step",
+		"",
+		"break loop",
+		"continue",
+		"c",
+		"b 0x4",
+		"b",
+		"delete loop",
+		"set a1 -2",
+		"set pc 0x10",
+		"s",
+		"patch 0x100 0xdeadbeef",
+		"patch 0x104 0x12 1",
+		"d loop",
+	}, "\n")
+	cpu, output := runDebugger(t, commands, program...)
+
+	if a0, a1 := cpu.registers.Read(REG_A0), cpu.registers.Read(REG_A1); a0 != 4 || a1 != 0xffff_fffe {
+		t.Errorf("a0, a1 = %d, %08x, want 4, fffffffe", a0, a1)
+	}
+	if cpu.pc != 0x10 {
+		t.Errorf("pc = %08x, want the ebreak at 00000010", cpu.pc)
+	}
+	if word, _ := cpu.FetchWord(0x100); word != 0xdead_beef {
+		t.Errorf("word = %08x, want deadbeef", word)
+	}
+	if word, _ := cpu.FetchWord(0x104); word != 0x12 {
+		t.Errorf("word = %08x, want 00000012", word)
+	}
+	checkOutput(t, output,
+		"=> 00000000:  00100513  li a0,1\n",
+		"=> 00000008 <loop>:  00150513  addi a0,a0,1\n",
+		"Breakpoint at 00000008 <loop>\n",
+		"Breakpoint at 00000004\nBreakpoint at 00000008 <loop>\n",
+		"Unhandled trap at 00000010 <loop+0x8>: breakpoint\n",
+		"Error: no breakpoint at 00000008\n",
+	)
+}
+
+func TestDebuggerDisassemble(t *testing.T) {
+	// c.li a0, 1; c.addi a0, 1; addi a0, a0, 1; c.nop; c.nop; jal zero, 0
+	cpu, output := runDebugger(t, "s 3\ndis\ndis 0 1", 0x05054505, 0x00150513, 0x00010001, 0x0000006f)
+	if cpu.pc != 8 {
+		t.Fatalf("pc = %08x, want 00000008", cpu.pc)
+	}
+	// Disassembly around pc starts where the instructions line up with it
+	checkOutput(t, output,
+		"   00000002:  0505      addi a0,a0,1\n   00000004:  00150513  addi a0,a0,1\n",
+		"=> 00000008 <loop>:  0001      nop\n",
+		"   0000000a <loop+0x2>:  0001      nop\n   0000000c <loop+0x4>:  0000006f  j c <loop+0x4>\n",
+		"(rivogo)    00000000:  4505      li a0,1\n(rivogo) \n",
+	)
+}
+
+func TestDebuggerDisplay(t *testing.T) {
+	// addi a0, zero, 1
+	_, output := runDebugger(t, "s\nr\nx 0 6", 0x00100513)
+	// The registers and memory are shown to the debugger's output rather than to stdout
+	checkOutput(t, output,
+		"x08: 00000000 00000000 00000001 00000000  ",
+		" pc: 00000004\n",
+		"0x00000000: 13 05 10 00 00 00 \n",
+	)
+}
+
+func TestDebuggerProgramExit(t *testing.T) {
+	program := []uint32{0x000012b7, 0x00700393, 0x0072a023, 0x0002a223, 0x0000006f}
+	cpu := newTestCPU(t, 0, 0x2000, program...)
+	if err := cpu.AttachHTIF(NewHTIF(nil, nil), 0x1000, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var output bytes.Buffer
+	NewDebugger(cpu, strings.NewReader("c\nhelp\n"), &output).Run()
+	checkOutput(t, output.String(), "Program exited with status 3\n")
+	if strings.Contains(output.String(), "List the commands") {
+		t.Error("the debugger kept reading commands after the program exited")
+	}
+}
+
+func TestDebuggerErrors(t *testing.T) {
+	_, output := runDebugger(t, "frobnicate\nset\nset x99 1\nb nosuch\ns lots\npatch 0 1 3\nx 0 zero")
+	checkOutput(t, output,
+		"Unknown command \"frobnicate\", type help for a list of commands\n",
+		"Error: usage: set register|pc value\n",
+		"Error: unknown register \"x99\"\n",
+		"Error: \"nosuch\" is neither an address nor a symbol\n",
+		"Error: invalid count \"lots\"\n",
+		"Error: cannot write 3 bytes at once\n",
+		"Error: invalid count \"zero\"\n",
+	)
+}
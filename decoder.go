@@ -0,0 +1,308 @@
+package main
+
+import "fmt"
+
+// Describes how to recognise and decode a single instruction
+type encoding struct {
+	mnemonic  string            // The assembly mnemonic of the instruction
+	mask      uint32            // The bits of the word that identify the instruction
+	match     uint32            // The value of the identifying bits
+	format    InstructionFormat // The layout of the operands within the word
+	extension Extension         // The extension that defines the instruction
+}
+
+// Masks selecting the fields that identify an instruction
+const (
+	MASK_OPCODE        uint32 = 0x0000_007F
+	MASK_FUNCT3        uint32 = 0x0000_707F
+	MASK_FUNCT7        uint32 = 0xFE00_707F
+	MASK_AMO           uint32 = 0xF800_707F
+	MASK_LR            uint32 = 0xF9F0_707F
+	MASK_FMA           uint32 = 0x0600_007F
+	MASK_FP_RM         uint32 = 0xFE00_007F
+	MASK_FP_RS2_RM     uint32 = 0xFFF0_007F
+	MASK_FP_RS2        uint32 = 0xFFF0_707F
+	MASK_WORD          uint32 = 0xFFFF_FFFF
+	FUNCT3_SHIFT       uint32 = 12
+	FUNCT7_SHIFT       uint32 = 25
+	REGISTER_MASK      uint32 = 0x1F
+	REGISTER_RD_SHIFT  uint32 = 7
+	REGISTER_RS1_SHIFT uint32 = 15
+	REGISTER_RS2_SHIFT uint32 = 20
+	REGISTER_RS3_SHIFT uint32 = 27
+)
+
+// Returns the match value for an instruction identified by its opcode
+func opcodeMatch(opcode InstructionType) uint32 {
+	return uint32(opcode)
+}
+
+// Returns the match value for an instruction identified by its opcode and funct3
+func funct3Match(opcode InstructionType, funct3 uint32) uint32 {
+	return uint32(opcode) | funct3<<FUNCT3_SHIFT
+}
+
+// Returns the match value for an instruction identified by its opcode, funct3 and funct7
+func funct7Match(opcode InstructionType, funct3 uint32, funct7 uint32) uint32 {
+	return uint32(opcode) | funct3<<FUNCT3_SHIFT | funct7<<FUNCT7_SHIFT
+}
+
+// The table of every instruction the decoder understands
+var encodings = []encoding{
+	// RV32I upper immediates and jumps
+	{"lui", MASK_OPCODE, opcodeMatch(U_TYPE_LUI), FORMAT_U, EXT_I},
+	{"auipc", MASK_OPCODE, opcodeMatch(U_TYPE_AUIPC), FORMAT_U, EXT_I},
+	{"jal", MASK_OPCODE, opcodeMatch(J_TYPE), FORMAT_J, EXT_I},
+	{"jalr", MASK_FUNCT3, funct3Match(I_TYPE_JALR, 0x0), FORMAT_I, EXT_I},
+
+	// RV32I branches
+	{"beq", MASK_FUNCT3, funct3Match(B_TYPE, 0x0), FORMAT_B, EXT_I},
+	{"bne", MASK_FUNCT3, funct3Match(B_TYPE, 0x1), FORMAT_B, EXT_I},
+	{"blt", MASK_FUNCT3, funct3Match(B_TYPE, 0x4), FORMAT_B, EXT_I},
+	{"bge", MASK_FUNCT3, funct3Match(B_TYPE, 0x5), FORMAT_B, EXT_I},
+	{"bltu", MASK_FUNCT3, funct3Match(B_TYPE, 0x6), FORMAT_B, EXT_I},
+	{"bgeu", MASK_FUNCT3, funct3Match(B_TYPE, 0x7), FORMAT_B, EXT_I},
+
+	// RV32I loads
+	{"lb", MASK_FUNCT3, funct3Match(I_TYPE_LOAD, 0x0), FORMAT_I, EXT_I},
+	{"lh", MASK_FUNCT3, funct3Match(I_TYPE_LOAD, 0x1), FORMAT_I, EXT_I},
+	{"lw", MASK_FUNCT3, funct3Match(I_TYPE_LOAD, 0x2), FORMAT_I, EXT_I},
+	{"lbu", MASK_FUNCT3, funct3Match(I_TYPE_LOAD, 0x4), FORMAT_I, EXT_I},
+	{"lhu", MASK_FUNCT3, funct3Match(I_TYPE_LOAD, 0x5), FORMAT_I, EXT_I},
+
+	// RV32I stores
+	{"sb", MASK_FUNCT3, funct3Match(S_TYPE, 0x0), FORMAT_S, EXT_I},
+	{"sh", MASK_FUNCT3, funct3Match(S_TYPE, 0x1), FORMAT_S, EXT_I},
+	{"sw", MASK_FUNCT3, funct3Match(S_TYPE, 0x2), FORMAT_S, EXT_I},
+
+	// RV32I immediate arithmetic
+	{"addi", MASK_FUNCT3, funct3Match(I_TYPE_ARITH, 0x0), FORMAT_I, EXT_I},
+	{"slti", MASK_FUNCT3, funct3Match(I_TYPE_ARITH, 0x2), FORMAT_I, EXT_I},
+	{"sltiu", MASK_FUNCT3, funct3Match(I_TYPE_ARITH, 0x3), FORMAT_I, EXT_I},
+	{"xori", MASK_FUNCT3, funct3Match(I_TYPE_ARITH, 0x4), FORMAT_I, EXT_I},
+	{"ori", MASK_FUNCT3, funct3Match(I_TYPE_ARITH, 0x6), FORMAT_I, EXT_I},
+	{"andi", MASK_FUNCT3, funct3Match(I_TYPE_ARITH, 0x7), FORMAT_I, EXT_I},
+	{"slli", MASK_FUNCT7, funct7Match(I_TYPE_ARITH, 0x1, 0x00), FORMAT_I_SHIFT, EXT_I},
+	{"srli", MASK_FUNCT7, funct7Match(I_TYPE_ARITH, 0x5, 0x00), FORMAT_I_SHIFT, EXT_I},
+	{"srai", MASK_FUNCT7, funct7Match(I_TYPE_ARITH, 0x5, 0x20), FORMAT_I_SHIFT, EXT_I},
+
+	// RV32I register arithmetic
+	{"add", MASK_FUNCT7, funct7Match(R_TYPE, 0x0, 0x00), FORMAT_R, EXT_I},
+	{"sub", MASK_FUNCT7, funct7Match(R_TYPE, 0x0, 0x20), FORMAT_R, EXT_I},
+	{"sll", MASK_FUNCT7, funct7Match(R_TYPE, 0x1, 0x00), FORMAT_R, EXT_I},
+	{"slt", MASK_FUNCT7, funct7Match(R_TYPE, 0x2, 0x00), FORMAT_R, EXT_I},
+	{"sltu", MASK_FUNCT7, funct7Match(R_TYPE, 0x3, 0x00), FORMAT_R, EXT_I},
+	{"xor", MASK_FUNCT7, funct7Match(R_TYPE, 0x4, 0x00), FORMAT_R, EXT_I},
+	{"srl", MASK_FUNCT7, funct7Match(R_TYPE, 0x5, 0x00), FORMAT_R, EXT_I},
+	{"sra", MASK_FUNCT7, funct7Match(R_TYPE, 0x5, 0x20), FORMAT_R, EXT_I},
+	{"or", MASK_FUNCT7, funct7Match(R_TYPE, 0x6, 0x00), FORMAT_R, EXT_I},
+	{"and", MASK_FUNCT7, funct7Match(R_TYPE, 0x7, 0x00), FORMAT_R, EXT_I},
+
+	// RV32I memory ordering and Zifencei
+	{"fence", MASK_FUNCT3, funct3Match(I_TYPE_FENCE, 0x0), FORMAT_I, EXT_I},
+	{"fence.i", MASK_FUNCT3, funct3Match(I_TYPE_FENCE, 0x1), FORMAT_I, EXT_I},
+
+	// RV32I environment calls and breakpoints
+	{"ecall", MASK_WORD, 0x0000_0073, FORMAT_I, EXT_I},
+	{"ebreak", MASK_WORD, 0x0010_0073, FORMAT_I, EXT_I},
+
+	// Machine-mode trap return and interrupt wait
+	{"mret", MASK_WORD, 0x3020_0073, FORMAT_I, EXT_I},
+	{"wfi", MASK_WORD, 0x1050_0073, FORMAT_I, EXT_I},
+
+	// Zicsr control and status register instructions
+	{"csrrw", MASK_FUNCT3, funct3Match(I_TYPE_SYS, 0x1), FORMAT_I, EXT_ZICSR},
+	{"csrrs", MASK_FUNCT3, funct3Match(I_TYPE_SYS, 0x2), FORMAT_I, EXT_ZICSR},
+	{"csrrc", MASK_FUNCT3, funct3Match(I_TYPE_SYS, 0x3), FORMAT_I, EXT_ZICSR},
+	{"csrrwi", MASK_FUNCT3, funct3Match(I_TYPE_SYS, 0x5), FORMAT_I, EXT_ZICSR},
+	{"csrrsi", MASK_FUNCT3, funct3Match(I_TYPE_SYS, 0x6), FORMAT_I, EXT_ZICSR},
+	{"csrrci", MASK_FUNCT3, funct3Match(I_TYPE_SYS, 0x7), FORMAT_I, EXT_ZICSR},
+
+	// RV32M multiplication and division
+	{"mul", MASK_FUNCT7, funct7Match(R_TYPE, 0x0, 0x01), FORMAT_R, EXT_M},
+	{"mulh", MASK_FUNCT7, funct7Match(R_TYPE, 0x1, 0x01), FORMAT_R, EXT_M},
+	{"mulhsu", MASK_FUNCT7, funct7Match(R_TYPE, 0x2, 0x01), FORMAT_R, EXT_M},
+	{"mulhu", MASK_FUNCT7, funct7Match(R_TYPE, 0x3, 0x01), FORMAT_R, EXT_M},
+	{"div", MASK_FUNCT7, funct7Match(R_TYPE, 0x4, 0x01), FORMAT_R, EXT_M},
+	{"divu", MASK_FUNCT7, funct7Match(R_TYPE, 0x5, 0x01), FORMAT_R, EXT_M},
+	{"rem", MASK_FUNCT7, funct7Match(R_TYPE, 0x6, 0x01), FORMAT_R, EXT_M},
+	{"remu", MASK_FUNCT7, funct7Match(R_TYPE, 0x7, 0x01), FORMAT_R, EXT_M},
+
+	// RV32A atomic memory operations
+	{"lr.w", MASK_LR, amoMatch(0x02), FORMAT_R, EXT_A},
+	{"sc.w", MASK_AMO, amoMatch(0x03), FORMAT_R, EXT_A},
+	{"amoswap.w", MASK_AMO, amoMatch(0x01), FORMAT_R, EXT_A},
+	{"amoadd.w", MASK_AMO, amoMatch(0x00), FORMAT_R, EXT_A},
+	{"amoxor.w", MASK_AMO, amoMatch(0x04), FORMAT_R, EXT_A},
+	{"amoand.w", MASK_AMO, amoMatch(0x0C), FORMAT_R, EXT_A},
+	{"amoor.w", MASK_AMO, amoMatch(0x08), FORMAT_R, EXT_A},
+	{"amomin.w", MASK_AMO, amoMatch(0x10), FORMAT_R, EXT_A},
+	{"amomax.w", MASK_AMO, amoMatch(0x14), FORMAT_R, EXT_A},
+	{"amominu.w", MASK_AMO, amoMatch(0x18), FORMAT_R, EXT_A},
+	{"amomaxu.w", MASK_AMO, amoMatch(0x1C), FORMAT_R, EXT_A},
+
+	// RV32F single-precision floating point
+	{"flw", MASK_FUNCT3, funct3Match(I_TYPE_LOAD_FP, 0x2), FORMAT_I, EXT_F},
+	{"fsw", MASK_FUNCT3, funct3Match(S_TYPE_FP, 0x2), FORMAT_S, EXT_F},
+	{"fmadd.s", MASK_FMA, fmaMatch(R4_TYPE_FMADD, 0x0), FORMAT_R4, EXT_F},
+	{"fmsub.s", MASK_FMA, fmaMatch(R4_TYPE_FMSUB, 0x0), FORMAT_R4, EXT_F},
+	{"fnmsub.s", MASK_FMA, fmaMatch(R4_TYPE_FNMSUB, 0x0), FORMAT_R4, EXT_F},
+	{"fnmadd.s", MASK_FMA, fmaMatch(R4_TYPE_FNMADD, 0x0), FORMAT_R4, EXT_F},
+	{"fadd.s", MASK_FP_RM, fpMatch(0x00, 0x0, 0x0), FORMAT_R, EXT_F},
+	{"fsub.s", MASK_FP_RM, fpMatch(0x04, 0x0, 0x0), FORMAT_R, EXT_F},
+	{"fmul.s", MASK_FP_RM, fpMatch(0x08, 0x0, 0x0), FORMAT_R, EXT_F},
+	{"fdiv.s", MASK_FP_RM, fpMatch(0x0C, 0x0, 0x0), FORMAT_R, EXT_F},
+	{"fsqrt.s", MASK_FP_RS2_RM, fpMatch(0x2C, 0x0, 0x0), FORMAT_R, EXT_F},
+	{"fsgnj.s", MASK_FUNCT7, fpMatch(0x10, 0x0, 0x0), FORMAT_R, EXT_F},
+	{"fsgnjn.s", MASK_FUNCT7, fpMatch(0x10, 0x0, 0x1), FORMAT_R, EXT_F},
+	{"fsgnjx.s", MASK_FUNCT7, fpMatch(0x10, 0x0, 0x2), FORMAT_R, EXT_F},
+	{"fmin.s", MASK_FUNCT7, fpMatch(0x14, 0x0, 0x0), FORMAT_R, EXT_F},
+	{"fmax.s", MASK_FUNCT7, fpMatch(0x14, 0x0, 0x1), FORMAT_R, EXT_F},
+	{"fcvt.w.s", MASK_FP_RS2_RM, fpMatch(0x60, 0x0, 0x0), FORMAT_R, EXT_F},
+	{"fcvt.wu.s", MASK_FP_RS2_RM, fpMatch(0x60, 0x1, 0x0), FORMAT_R, EXT_F},
+	{"feq.s", MASK_FUNCT7, fpMatch(0x50, 0x0, 0x2), FORMAT_R, EXT_F},
+	{"flt.s", MASK_FUNCT7, fpMatch(0x50, 0x0, 0x1), FORMAT_R, EXT_F},
+	{"fle.s", MASK_FUNCT7, fpMatch(0x50, 0x0, 0x0), FORMAT_R, EXT_F},
+	{"fclass.s", MASK_FP_RS2, fpMatch(0x70, 0x0, 0x1), FORMAT_R, EXT_F},
+	{"fcvt.s.w", MASK_FP_RS2_RM, fpMatch(0x68, 0x0, 0x0), FORMAT_R, EXT_F},
+	{"fcvt.s.wu", MASK_FP_RS2_RM, fpMatch(0x68, 0x1, 0x0), FORMAT_R, EXT_F},
+	{"fmv.x.w", MASK_FP_RS2, fpMatch(0x70, 0x0, 0x0), FORMAT_R, EXT_F},
+	{"fmv.w.x", MASK_FP_RS2, fpMatch(0x78, 0x0, 0x0), FORMAT_R, EXT_F},
+
+	// RV32D double-precision floating point
+	{"fld", MASK_FUNCT3, funct3Match(I_TYPE_LOAD_FP, 0x3), FORMAT_I, EXT_D},
+	{"fsd", MASK_FUNCT3, funct3Match(S_TYPE_FP, 0x3), FORMAT_S, EXT_D},
+	{"fmadd.d", MASK_FMA, fmaMatch(R4_TYPE_FMADD, 0x1), FORMAT_R4, EXT_D},
+	{"fmsub.d", MASK_FMA, fmaMatch(R4_TYPE_FMSUB, 0x1), FORMAT_R4, EXT_D},
+	{"fnmsub.d", MASK_FMA, fmaMatch(R4_TYPE_FNMSUB, 0x1), FORMAT_R4, EXT_D},
+	{"fnmadd.d", MASK_FMA, fmaMatch(R4_TYPE_FNMADD, 0x1), FORMAT_R4, EXT_D},
+	{"fadd.d", MASK_FP_RM, fpMatch(0x01, 0x0, 0x0), FORMAT_R, EXT_D},
+	{"fsub.d", MASK_FP_RM, fpMatch(0x05, 0x0, 0x0), FORMAT_R, EXT_D},
+	{"fmul.d", MASK_FP_RM, fpMatch(0x09, 0x0, 0x0), FORMAT_R, EXT_D},
+	{"fdiv.d", MASK_FP_RM, fpMatch(0x0D, 0x0, 0x0), FORMAT_R, EXT_D},
+	{"fsqrt.d", MASK_FP_RS2_RM, fpMatch(0x2D, 0x0, 0x0), FORMAT_R, EXT_D},
+	{"fsgnj.d", MASK_FUNCT7, fpMatch(0x11, 0x0, 0x0), FORMAT_R, EXT_D},
+	{"fsgnjn.d", MASK_FUNCT7, fpMatch(0x11, 0x0, 0x1), FORMAT_R, EXT_D},
+	{"fsgnjx.d", MASK_FUNCT7, fpMatch(0x11, 0x0, 0x2), FORMAT_R, EXT_D},
+	{"fmin.d", MASK_FUNCT7, fpMatch(0x15, 0x0, 0x0), FORMAT_R, EXT_D},
+	{"fmax.d", MASK_FUNCT7, fpMatch(0x15, 0x0, 0x1), FORMAT_R, EXT_D},
+	{"fcvt.w.d", MASK_FP_RS2_RM, fpMatch(0x61, 0x0, 0x0), FORMAT_R, EXT_D},
+	{"fcvt.wu.d", MASK_FP_RS2_RM, fpMatch(0x61, 0x1, 0x0), FORMAT_R, EXT_D},
+	{"feq.d", MASK_FUNCT7, fpMatch(0x51, 0x0, 0x2), FORMAT_R, EXT_D},
+	{"flt.d", MASK_FUNCT7, fpMatch(0x51, 0x0, 0x1), FORMAT_R, EXT_D},
+	{"fle.d", MASK_FUNCT7, fpMatch(0x51, 0x0, 0x0), FORMAT_R, EXT_D},
+	{"fclass.d", MASK_FP_RS2, fpMatch(0x71, 0x0, 0x1), FORMAT_R, EXT_D},
+	{"fcvt.d.w", MASK_FP_RS2_RM, fpMatch(0x69, 0x0, 0x0), FORMAT_R, EXT_D},
+	{"fcvt.d.wu", MASK_FP_RS2_RM, fpMatch(0x69, 0x1, 0x0), FORMAT_R, EXT_D},
+	{"fcvt.s.d", MASK_FP_RS2_RM, fpMatch(0x20, 0x1, 0x0), FORMAT_R, EXT_D},
+	{"fcvt.d.s", MASK_FP_RS2_RM, fpMatch(0x21, 0x0, 0x0), FORMAT_R, EXT_D},
+}
+
+// Returns the match value for an atomic instruction identified by its funct5
+func amoMatch(funct5 uint32) uint32 {
+	return funct3Match(R_TYPE_AMO, 0x2) | funct5<<27
+}
+
+// Returns the match value for a floating-point instruction identified by its funct7, rs2 and funct3
+func fpMatch(funct7 uint32, rs2 uint32, funct3 uint32) uint32 {
+	return funct7Match(R_TYPE_FP, funct3, funct7) | rs2<<REGISTER_RS2_SHIFT
+}
+
+// Returns the match value for a fused multiply-add identified by its opcode and format
+func fmaMatch(opcode InstructionType, format uint32) uint32 {
+	return opcodeMatch(opcode) | format<<FUNCT7_SHIFT
+}
+
+// Encodings grouped by opcode so that decoding only scans the relevant candidates
+var encodingsByOpcode [MASK_OPCODE + 1][]*encoding
+
+func init() {
+	for i := range encodings {
+		opcode := encodings[i].match & MASK_OPCODE
+		encodingsByOpcode[opcode] = append(encodingsByOpcode[opcode], &encodings[i])
+	}
+}
+
+// Decodes an instruction word into its fully-populated assembly instruction, expanding compressed instructions
+func Decode(word uint32) (*AssemblyInstruction, error) {
+	if isCompressed(word) {
+		return decodeCompressed(uint16(word))
+	}
+	for _, e := range encodingsByOpcode[word&MASK_OPCODE] {
+		if word&e.mask == e.match {
+			return decodeOperands(word, e), nil
+		}
+	}
+	return nil, fmt.Errorf("illegal instruction: %08x", word)
+}
+
+// Decodes a 16-bit compressed instruction through its 32-bit equivalent
+func decodeCompressed(parcel uint16) (*AssemblyInstruction, error) {
+	expanded, err := Expand(parcel)
+	if err != nil {
+		return nil, err
+	}
+	instruction, err := Decode(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("illegal compressed instruction: %04x", parcel)
+	}
+	instruction.raw = uint32(parcel)
+	instruction.size = BYTES_PER_HALF
+	return instruction, nil
+}
+
+// Extracts the operand fields of a word according to its encoding
+func decodeOperands(word uint32, e *encoding) *AssemblyInstruction {
+	instruction := &AssemblyInstruction{
+		raw:             word,
+		size:            BYTES_PER_WORD,
+		mnemonic:        e.mnemonic,
+		format:          e.format,
+		extension:       e.extension,
+		instructionType: InstructionType(word & MASK_OPCODE),
+		funct3:          uint8((word >> FUNCT3_SHIFT) & 0x7),
+		funct7:          uint8(word >> FUNCT7_SHIFT),
+	}
+
+	// Only populate the register fields the format actually encodes
+	switch e.format {
+	case FORMAT_R, FORMAT_R4:
+		instruction.rd = uint8((word >> REGISTER_RD_SHIFT) & REGISTER_MASK)
+		instruction.rs1 = uint8((word >> REGISTER_RS1_SHIFT) & REGISTER_MASK)
+		instruction.rs2 = uint8((word >> REGISTER_RS2_SHIFT) & REGISTER_MASK)
+		if e.format == FORMAT_R4 {
+			instruction.rs3 = uint8((word >> REGISTER_RS3_SHIFT) & REGISTER_MASK)
+		}
+	case FORMAT_I, FORMAT_I_SHIFT:
+		instruction.rd = uint8((word >> REGISTER_RD_SHIFT) & REGISTER_MASK)
+		instruction.rs1 = uint8((word >> REGISTER_RS1_SHIFT) & REGISTER_MASK)
+	case FORMAT_S, FORMAT_B:
+		instruction.rs1 = uint8((word >> REGISTER_RS1_SHIFT) & REGISTER_MASK)
+		instruction.rs2 = uint8((word >> REGISTER_RS2_SHIFT) & REGISTER_MASK)
+	case FORMAT_U, FORMAT_J:
+		instruction.rd = uint8((word >> REGISTER_RD_SHIFT) & REGISTER_MASK)
+	}
+
+	instruction.imm = decodeImmediate(word, e.format)
+	return instruction
+}
+
+// Extracts the sign-extended immediate of a word according to its format
+func decodeImmediate(word uint32, format InstructionFormat) int32 {
+	switch format {
+	case FORMAT_I:
+		return signExtend(word>>20, 12)
+	case FORMAT_I_SHIFT:
+		return int32((word >> 20) & 0x1F)
+	case FORMAT_S:
+		return signExtend((word>>25)<<5|(word>>7)&0x1F, 12)
+	case FORMAT_B:
+		return signExtend((word>>31&0x1)<<12|(word>>7&0x1)<<11|(word>>25&0x3F)<<5|(word>>8&0xF)<<1, 13)
+	case FORMAT_U:
+		return int32(word & 0xFFFFF000)
+	case FORMAT_J:
+		return signExtend((word>>31&0x1)<<20|(word>>12&0xFF)<<12|(word>>20&0x1)<<11|(word>>21&0x3FF)<<1, 21)
+	default:
+		return 0
+	}
+}
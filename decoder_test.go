@@ -0,0 +1,169 @@
+package main
+
+import "testing"
+
+func TestDecodeKnownEncodings(t *testing.T) {
+	tests := []struct {
+		word     uint32
+		mnemonic string
+		rd       uint8
+		rs1      uint8
+		rs2      uint8
+		imm      int32
+	}{
+		{0x12345537, "lui", 10, 0, 0, 0x12345000}, // lui a0, 0x12345
+		{0xfffff2b7, "lui", 5, 0, 0, -4096},       // lui t0, 0xfffff
+		{0x00001197, "auipc", 3, 0, 0, 0x1000},    // auipc gp, 0x1
+		{0x001000ef, "jal", 1, 0, 0, 2048},        // jal ra, 2048
+		{0xffdff06f, "jal", 0, 0, 0, -4},          // jal zero, -4
+		{0xfff300e7, "jalr", 1, 6, 0, -1},         // jalr ra, -1(t1)
+		{0x00b50863, "beq", 0, 10, 11, 16},        // beq a0, a1, 16
+		{0xfe941ce3, "bne", 0, 8, 9, -8},          // bne s0, s1, -8
+		{0x7e62cfe3, "blt", 0, 5, 6, 4094},        // blt t0, t1, 4094
+		{0x80d65063, "bge", 0, 12, 13, -4096},     // bge a2, a3, -4096
+		{0x00f76663, "bltu", 0, 14, 15, 12},       // bltu a4, a5, 12
+		{0xfeb57fe3, "bgeu", 0, 10, 11, -2},       // bgeu a0, a1, -2
+		{0x80010503, "lb", 10, 2, 0, -2048},       // lb a0, -2048(sp)
+		{0x7ff41583, "lh", 11, 8, 0, 2047},        // lh a1, 2047(s0)
+		{0x00c12083, "lw", 1, 2, 0, 12},           // lw ra, 12(sp)
+		{0x00054383, "lbu", 7, 10, 0, 0},          // lbu t2, 0(a0)
+		{0xffa5de03, "lhu", 28, 11, 0, -6},        // lhu t3, -6(a1)
+		{0x00050023, "sb", 0, 10, 0, 0},           // sb zero, 0(a0)
+		{0xfef71fa3, "sh", 0, 14, 15, -1},         // sh a5, -1(a4)
+		{0x7e112fa3, "sw", 0, 2, 1, 2047},         // sw ra, 2047(sp)
+		{0xff010113, "addi", 2, 2, 0, -16},        // addi sp, sp, -16
+		{0xfff5a513, "slti", 10, 11, 0, -1},       // slti a0, a1, -1
+		{0x0015b513, "sltiu", 10, 11, 0, 1},       // sltiu a0, a1, 1
+		{0xfff34293, "xori", 5, 6, 0, -1},         // xori t0, t1, -1
+		{0x7fff6e93, "ori", 29, 30, 0, 2047},      // ori t4, t5, 0x7ff
+		{0x0ff9f913, "andi", 18, 19, 0, 255},      // andi s2, s3, 0xff
+		{0x01f51513, "slli", 10, 10, 0, 31},       // slli a0, a0, 31
+		{0x00165593, "srli", 11, 12, 0, 1},        // srli a1, a2, 1
+		{0x41175693, "srai", 13, 14, 0, 17},       // srai a3, a4, 17
+		{0x00c58533, "add", 10, 11, 12, 0},        // add a0, a1, a2
+		{0x416a8a33, "sub", 20, 21, 22, 0},        // sub s4, s5, s6
+		{0x007312b3, "sll", 5, 6, 7, 0},           // sll t0, t1, t2
+		{0x00c5a533, "slt", 10, 11, 12, 0},        // slt a0, a1, a2
+		{0x01adbfb3, "sltu", 31, 27, 26, 0},       // sltu t6, s11, s10
+		{0x00f848b3, "xor", 17, 16, 15, 0},        // xor a7, a6, a5
+		{0x019c5bb3, "srl", 23, 24, 25, 0},        // srl s7, s8, s9
+		{0x401251b3, "sra", 3, 4, 1, 0},           // sra gp, tp, ra
+		{0x00b06533, "or", 10, 0, 11, 0},          // or a0, zero, a1
+		{0x00a57533, "and", 10, 10, 10, 0},        // and a0, a0, a0
+		{0x0ff0000f, "fence", 0, 0, 0, 255},       // fence iorw, iorw
+		{0x0000100f, "fence.i", 0, 0, 0, 0},       // fence.i
+		{0x00000073, "ecall", 0, 0, 0, 0},         // ecall
+		{0x00100073, "ebreak", 0, 0, 0, 1},        // ebreak
+		{0x30200073, "mret", 0, 0, 0, 770},        // mret
+		{0x10500073, "wfi", 0, 0, 0, 261},         // wfi
+		{0x02c58533, "mul", 10, 11, 12, 0},        // mul a0, a1, a2
+		{0x027312b3, "mulh", 5, 6, 7, 0},          // mulh t0, t1, t2
+		{0x02a4a433, "mulhsu", 8, 9, 10, 0},       // mulhsu s0, s1, a0
+		{0x02f736b3, "mulhu", 13, 14, 15, 0},      // mulhu a3, a4, a5
+		{0x0328c833, "div", 16, 17, 18, 0},        // div a6, a7, s2
+		{0x035a59b3, "divu", 19, 20, 21, 0},       // divu s3, s4, s5
+		{0x038beb33, "rem", 22, 23, 24, 0},        // rem s6, s7, s8
+		{0x03eefe33, "remu", 28, 29, 30, 0},       // remu t3, t4, t5
+		{0x1005a52f, "lr.w", 10, 11, 0, 0},        // lr.w a0, (a1)
+		{0x1e63a2af, "sc.w", 5, 7, 6, 0},          // sc.w.aqrl t0, t1, (t2)
+		{0x0cd7262f, "amoswap.w", 12, 14, 13, 0},  // amoswap.w.aq a2, a3, (a4)
+		{0x0091242f, "amoadd.w", 8, 2, 9, 0},      // amoadd.w s0, s1, (sp)
+		{0x20b6252f, "amoxor.w", 10, 12, 11, 0},   // amoxor.w a0, a1, (a2)
+		{0x60b6252f, "amoand.w", 10, 12, 11, 0},   // amoand.w a0, a1, (a2)
+		{0x42b6252f, "amoor.w", 10, 12, 11, 0},    // amoor.w.rl a0, a1, (a2)
+		{0x80b6252f, "amomin.w", 10, 12, 11, 0},   // amomin.w a0, a1, (a2)
+		{0xa0b6252f, "amomax.w", 10, 12, 11, 0},   // amomax.w a0, a1, (a2)
+		{0xc0b6252f, "amominu.w", 10, 12, 11, 0},  // amominu.w a0, a1, (a2)
+		{0xe0b6252f, "amomaxu.w", 10, 12, 11, 0},  // amomaxu.w a0, a1, (a2)
+		{0x00812507, "flw", 10, 2, 0, 8},          // flw fa0, 8(sp)
+		{0xfe853827, "fsd", 0, 10, 8, -16},        // fsd fs0, -16(a0)
+		{0x0020f053, "fadd.s", 0, 1, 2, 0},        // fadd.s ft0, ft1, ft2, dyn
+		{0x12c59553, "fmul.d", 10, 11, 12, 0},     // fmul.d fa0, fa1, fa2, rtz
+		{0x5a0271d3, "fsqrt.d", 3, 4, 0, 0},       // fsqrt.d ft3, ft4
+		{0x68c5f543, "fmadd.s", 10, 11, 12, 0},    // fmadd.s fa0, fa1, fa2, fa3
+		{0x1a20f04b, "fnmsub.d", 0, 1, 2, 0},      // fnmsub.d ft0, ft1, ft2, ft3
+		{0x20c5a553, "fsgnjx.s", 10, 11, 12, 0},   // fsgnjx.s fa0, fa1, fa2
+		{0x2ac59553, "fmax.d", 10, 11, 12, 0},     // fmax.d fa0, fa1, fa2
+		{0xc0051553, "fcvt.w.s", 10, 10, 0, 0},    // fcvt.w.s a0, fa0, rtz
+		{0xd2150553, "fcvt.d.wu", 10, 10, 1, 0},   // fcvt.d.wu fa0, a0
+		{0x4015f553, "fcvt.s.d", 10, 11, 1, 0},    // fcvt.s.d fa0, fa1
+		{0xa2b50553, "fle.d", 10, 10, 11, 0},      // fle.d a0, fa0, fa1
+		{0xe0051553, "fclass.s", 10, 10, 0, 0},    // fclass.s a0, fa0
+		{0xe0050553, "fmv.x.w", 10, 10, 0, 0},     // fmv.x.w a0, fa0
+		{0xf0050553, "fmv.w.x", 10, 10, 0, 0},     // fmv.w.x fa0, a0
+		{0x34059573, "csrrw", 10, 11, 0, 832},     // csrrw a0, mscratch, a1
+		{0x300022f3, "csrrs", 5, 0, 0, 768},       // csrrs t0, mstatus, zero
+		{0x30463073, "csrrc", 0, 12, 0, 772},      // csrrc zero, mie, a2
+		{0x003fd573, "csrrwi", 10, 31, 0, 3},      // csrrwi a0, fcsr, 31
+		{0xfff0e073, "csrrsi", 0, 1, 0, -1},       // csrrsi zero, 0xfff, 1
+		{0xc00275f3, "csrrci", 11, 4, 0, -1024},   // csrrci a1, cycle, 4
+	}
+
+	for _, test := range tests {
+		decoded, err := Decode(test.word)
+		if err != nil {
+			t.Errorf("Decode(%08x): unexpected error: %v", test.word, err)
+			continue
+		}
+		if decoded.mnemonic != test.mnemonic {
+			t.Errorf("Decode(%08x): mnemonic = %q, want %q", test.word, decoded.mnemonic, test.mnemonic)
+		}
+		if decoded.rd != test.rd || decoded.rs1 != test.rs1 || decoded.rs2 != test.rs2 {
+			t.Errorf("Decode(%08x): registers = (%d, %d, %d), want (%d, %d, %d)", test.word,
+				decoded.rd, decoded.rs1, decoded.rs2, test.rd, test.rs1, test.rs2)
+		}
+		if decoded.imm != test.imm {
+			t.Errorf("Decode(%08x): imm = %d, want %d", test.word, decoded.imm, test.imm)
+		}
+		if decoded.raw != test.word {
+			t.Errorf("Decode(%08x): raw = %08x", test.word, decoded.raw)
+		}
+	}
+}
+
+func TestDecodeFunctionFields(t *testing.T) {
+	// sra gp, tp, ra
+	decoded, err := Decode(0x401251b3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decoded.instructionType != R_TYPE || decoded.format != FORMAT_R {
+		t.Errorf("opcode/format = %v/%v, want %v/%v", decoded.instructionType, decoded.format, R_TYPE, FORMAT_R)
+	}
+	if decoded.funct3 != 0x5 || decoded.funct7 != 0x20 {
+		t.Errorf("funct3/funct7 = %#x/%#x, want 0x5/0x20", decoded.funct3, decoded.funct7)
+	}
+}
+
+func TestDecodeIllegalInstructions(t *testing.T) {
+	tests := []uint32{
+		0x00000000, // all zeroes is defined to be illegal
+		0xffffffff, // all ones is defined to be illegal
+		0x00003003, // ld is not part of RV32
+		0x00002063, // branch with reserved funct3
+		0x43175693, // srai with a non-zero shamt[5]
+		0xfec58533, // R-type with an unknown funct7
+		0x00200073, // system instruction with an unknown immediate
+		0x0000007f, // reserved major opcode
+	}
+
+	for _, word := range tests {
+		if decoded, err := Decode(word); err == nil {
+			t.Errorf("Decode(%08x) = %q, want an error", word, decoded.mnemonic)
+		}
+	}
+}
+
+func TestDecodeEncodingTableIsUnambiguous(t *testing.T) {
+	// Every entry's match value must decode back to that entry
+	for _, e := range encodings {
+		decoded, err := Decode(e.match)
+		if err != nil {
+			t.Errorf("%s: unexpected error decoding %08x: %v", e.mnemonic, e.match, err)
+			continue
+		}
+		if decoded.mnemonic != e.mnemonic {
+			t.Errorf("%08x decodes to %q, want %q", e.match, decoded.mnemonic, e.mnemonic)
+		}
+	}
+}
@@ -0,0 +1,733 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Constants that shape listings after the output of objdump -D
+const (
+	GLOBAL_POINTER_SYMBOL  = "__global_pointer$" // Symbol gp is expected to hold, which gp-relative accesses are shown against
+	LISTING_BYTES_PER_LINE = 8                   // Width of the raw bytes column, in bytes
+	LISTING_SKIP_ZEROES    = 8                   // Shortest run of zero bytes elided as "..."
+	LISTING_SKIP_AT_END    = 3                   // Runs of zero bytes ending a symbol that are shorter than this are elided too
+)
+
+// Names of the floating-point registers in the calling convention
+var floatRegisterNames = [REG_COUNT]string{
+	"ft0", "ft1", "ft2", "ft3", "ft4", "ft5", "ft6", "ft7",
+	"fs0", "fs1", "fa0", "fa1", "fa2", "fa3", "fa4", "fa5",
+	"fa6", "fa7", "fs2", "fs3", "fs4", "fs5", "fs6", "fs7",
+	"fs8", "fs9", "fs10", "fs11", "ft8", "ft9", "ft10", "ft11",
+}
+
+// Names of the rounding modes, with the dynamic mode left implicit
+var roundingModeNames = [8]string{"rne", "rtz", "rdn", "rup", "rmm", "unknown", "unknown", ""}
+
+// Names of the sets of accesses a fence orders, indexed by their iorw bits
+var fenceSetNames = [16]string{
+	"0", "w", "r", "rw", "o", "ow", "or", "orw",
+	"i", "iw", "ir", "irw", "io", "iow", "ior", "iorw",
+}
+
+// Aliases for reading, writing and writing an immediate to each floating-point CSR
+var floatCSRAliases = map[uint32][3]string{
+	CSR_FCSR:   {"frcsr", "fscsr", ""},
+	CSR_FRM:    {"frrm", "fsrm", "fsrmi"},
+	CSR_FFLAGS: {"frflags", "fsflags", "fsflagsi"},
+}
+
+// Names of the CSRs, taken from the CSR file so both always agree
+var csrNames = make(map[uint32]string)
+
+func init() {
+	for addr, csr := range newCSRFile() {
+		csrNames[addr] = csr.name
+	}
+}
+
+// A named address that disassembly refers to
+type disasmSymbol struct {
+	name   string
+	addr   uint32
+	global bool
+}
+
+// Renders instructions in the syntax of GNU objdump, naming addresses after the nearest symbol
+type Disassembler struct {
+	symbols []disasmSymbol    // Sorted by address, the preferred name for an address first
+	gp      uint32            // Value of the global pointer
+	hasGP   bool              // Whether the global pointer symbol is known
+	hi      [REG_COUNT]uint32 // Upper address bits a lui or auipc left in each register
+	hasHi   [REG_COUNT]bool   // Whether the register holds upper address bits yet to be used
+	next    uint32            // Address of the instruction following the last one disassembled
+	section *listingSection   // Section addresses are shown against when no symbol precedes them
+}
+
+// Creates a disassembler naming addresses after the given symbols
+func NewDisassembler(symbols map[string]uint32) *Disassembler {
+	list := make([]disasmSymbol, 0, len(symbols))
+	for name, addr := range symbols {
+		list = append(list, disasmSymbol{name: name, addr: addr})
+	}
+	return newDisassembler(list)
+}
+
+// Creates a disassembler from a list of symbols, preferring global symbols over local ones at the same address
+func newDisassembler(symbols []disasmSymbol) *Disassembler {
+	d := &Disassembler{symbols: symbols}
+	sort.Slice(d.symbols, func(i, j int) bool {
+		a, b := d.symbols[i], d.symbols[j]
+		if a.addr != b.addr {
+			return a.addr < b.addr
+		}
+		if a.global != b.global {
+			return a.global
+		}
+		return a.name < b.name
+	})
+	for _, symbol := range d.symbols {
+		if symbol.name == GLOBAL_POINTER_SYMBOL {
+			d.gp, d.hasGP = symbol.addr, true
+		}
+	}
+	return d
+}
+
+// Returns the preferred symbol at or below an address
+func (d *Disassembler) symbolAt(addr uint32) (disasmSymbol, bool) {
+	i := sort.Search(len(d.symbols), func(i int) bool { return d.symbols[i].addr > addr })
+	if i == 0 {
+		return disasmSymbol{}, false
+	}
+	// Step back to the first, and so preferred, symbol sharing the address
+	for i--; i > 0 && d.symbols[i-1].addr == d.symbols[i].addr; i-- {
+	}
+	return d.symbols[i], true
+}
+
+// Renders an address as objdump does, followed by the symbol it lies within
+func (d *Disassembler) address(addr uint32) string {
+	name, base := "", uint32(0)
+	if symbol, ok := d.symbolAt(addr); ok {
+		name, base = symbol.name, symbol.addr
+	} else if d.section != nil && addr >= d.section.addr {
+		name, base = d.section.name, d.section.addr
+	} else {
+		return fmt.Sprintf("%x", addr)
+	}
+	if addr == base {
+		return fmt.Sprintf("%x <%s>", addr, name)
+	}
+	return fmt.Sprintf("%x <%s+0x%x>", addr, name, addr-base)
+}
+
+// Disassembles the instruction in the low bits of a word at an address, returning its text and size.
+// Words that hold no instruction are rendered as data directives.
+func (d *Disassembler) Disassemble(addr uint32, word uint32) (string, uint32) {
+	// Upper address bits only carry over to the instruction that follows the one that set them
+	if addr != d.next {
+		d.hasHi = [REG_COUNT]bool{}
+	}
+	text, size := d.disassemble(addr, word)
+	d.next = addr + size
+	return text, size
+}
+
+// Disassembles a single instruction, without regard to the instructions before it
+func (d *Disassembler) disassemble(addr uint32, word uint32) (string, uint32) {
+	if isCompressed(word) {
+		parcel := word & 0xFFFF
+		if parcel == 0 {
+			return "unimp", BYTES_PER_HALF
+		}
+		instruction, err := Decode(parcel)
+		if err != nil {
+			return fmt.Sprintf(".2byte\t0x%x", parcel), BYTES_PER_HALF
+		}
+		return d.render(addr, instruction), BYTES_PER_HALF
+	}
+	instruction, err := Decode(word)
+	if err != nil {
+		return fmt.Sprintf(".4byte\t0x%x", word), BYTES_PER_WORD
+	}
+	text := d.render(addr, instruction)
+	if text == "" {
+		return fmt.Sprintf(".4byte\t0x%x", word), BYTES_PER_WORD
+	}
+	return text, BYTES_PER_WORD
+}
+
+// Joins a mnemonic and its operands
+func operands(mnemonic string, args ...string) string {
+	if len(args) == 0 {
+		return mnemonic
+	}
+	return mnemonic + "\t" + strings.Join(args, ",")
+}
+
+// Renders an offset from a base register
+func offset(imm int32, base uint8) string {
+	return fmt.Sprintf("%d(%s)", imm, RegisterName(base))
+}
+
+// Returns the address a load, store or addi refers to when the base register is known, consuming
+// the upper bits a lui or auipc left in it
+func (d *Disassembler) knownAddress(base uint8, imm int32) (uint32, bool) {
+	switch {
+	case d.hasHi[base]:
+		d.hasHi[base] = false
+		if base == REG_ZERO {
+			return uint32(imm), true
+		}
+		return d.hi[base] + uint32(imm), true
+	case base == REG_GP && d.hasGP:
+		return d.gp + uint32(imm), true
+	case base == REG_TP || base == REG_ZERO:
+		return uint32(imm), true
+	}
+	return 0, false
+}
+
+// Renders a decoded instruction, returning nothing for encodings objdump does not recognise
+func (d *Disassembler) render(addr uint32, instruction *AssemblyInstruction) string {
+	text := d.renderOperands(addr, instruction)
+	if instruction.size == BYTES_PER_HALF {
+		return text
+	}
+
+	// Addresses built from a register and an offset are shown after the instruction
+	var target uint32
+	known := false
+	switch instruction.instructionType {
+	case I_TYPE_LOAD, I_TYPE_LOAD_FP, S_TYPE, S_TYPE_FP:
+		target, known = d.knownAddress(instruction.rs1, instruction.imm)
+	case I_TYPE_JALR:
+		if text != "ret" {
+			target, known = d.knownAddress(instruction.rs1, instruction.imm)
+		}
+	case I_TYPE_ARITH:
+		if instruction.mnemonic == "addi" && instruction.rs1 != REG_ZERO && instruction.imm != 0 {
+			target, known = d.knownAddress(instruction.rs1, instruction.imm)
+		}
+	}
+	if known && text != "" {
+		text += " # " + d.address(target)
+	}
+	return text
+}
+
+// Renders the mnemonic and operands of an instruction, using the aliases objdump prefers
+func (d *Disassembler) renderOperands(addr uint32, instruction *AssemblyInstruction) string {
+	mnemonic, imm := instruction.mnemonic, instruction.imm
+	rd, rs1, rs2 := RegisterName(instruction.rd), RegisterName(instruction.rs1), RegisterName(instruction.rs2)
+
+	switch instruction.instructionType {
+	case U_TYPE_LUI, U_TYPE_AUIPC:
+		d.hi[instruction.rd], d.hasHi[instruction.rd] = uint32(imm), true
+		if instruction.instructionType == U_TYPE_AUIPC {
+			d.hi[instruction.rd] += addr
+		}
+		return operands(mnemonic, rd, fmt.Sprintf("0x%x", uint32(imm)>>12))
+
+	case J_TYPE:
+		target := d.address(addr + uint32(imm))
+		switch instruction.rd {
+		case REG_ZERO:
+			return operands("j", target)
+		case REG_RA:
+			return operands("jal", target)
+		}
+		return operands("jal", rd, target)
+
+	case I_TYPE_JALR:
+		switch {
+		case instruction.rd == REG_ZERO && instruction.rs1 == REG_RA && imm == 0:
+			return "ret"
+		case instruction.rd == REG_ZERO && imm == 0:
+			return operands("jr", rs1)
+		case instruction.rd == REG_ZERO:
+			return operands("jr", offset(imm, instruction.rs1))
+		case instruction.rd == REG_RA && imm == 0:
+			return operands("jalr", rs1)
+		case instruction.rd == REG_RA:
+			return operands("jalr", offset(imm, instruction.rs1))
+		case imm == 0:
+			return operands("jalr", rd, rs1)
+		}
+		return operands("jalr", rd, offset(imm, instruction.rs1))
+
+	case B_TYPE:
+		target := d.address(addr + uint32(imm))
+		switch {
+		case mnemonic == "beq" && instruction.rs2 == REG_ZERO:
+			return operands("beqz", rs1, target)
+		case mnemonic == "bne" && instruction.rs2 == REG_ZERO:
+			return operands("bnez", rs1, target)
+		case mnemonic == "blt" && instruction.rs2 == REG_ZERO:
+			return operands("bltz", rs1, target)
+		case mnemonic == "blt" && instruction.rs1 == REG_ZERO:
+			return operands("bgtz", rs2, target)
+		case mnemonic == "bge" && instruction.rs1 == REG_ZERO:
+			return operands("blez", rs2, target)
+		case mnemonic == "bge" && instruction.rs2 == REG_ZERO:
+			return operands("bgez", rs1, target)
+		}
+		return operands(mnemonic, rs1, rs2, target)
+
+	case I_TYPE_LOAD:
+		return operands(mnemonic, rd, offset(imm, instruction.rs1))
+	case I_TYPE_LOAD_FP:
+		return operands(mnemonic, floatRegisterNames[instruction.rd], offset(imm, instruction.rs1))
+	case S_TYPE:
+		return operands(mnemonic, rs2, offset(imm, instruction.rs1))
+	case S_TYPE_FP:
+		return operands(mnemonic, floatRegisterNames[instruction.rs2], offset(imm, instruction.rs1))
+
+	case I_TYPE_ARITH:
+		switch {
+		case instruction.format == FORMAT_I_SHIFT:
+			return operands(mnemonic, rd, rs1, fmt.Sprintf("0x%x", imm))
+		case mnemonic == "addi" && instruction.rd == REG_ZERO && instruction.rs1 == REG_ZERO && imm == 0:
+			return "nop"
+		case mnemonic == "addi" && instruction.rs1 == REG_ZERO:
+			return operands("li", rd, fmt.Sprint(imm))
+		case mnemonic == "addi" && imm == 0:
+			return operands("mv", rd, rs1)
+		case mnemonic == "xori" && imm == -1:
+			return operands("not", rd, rs1)
+		case mnemonic == "sltiu" && imm == 1:
+			return operands("seqz", rd, rs1)
+		}
+		return operands(mnemonic, rd, rs1, fmt.Sprint(imm))
+
+	case R_TYPE:
+		switch {
+		case mnemonic == "add" && instruction.rs1 == REG_ZERO && instruction.size == BYTES_PER_HALF:
+			// c.mv expands to an add from zero
+			return operands("mv", rd, rs2)
+		case mnemonic == "sub" && instruction.rs1 == REG_ZERO:
+			return operands("neg", rd, rs2)
+		case mnemonic == "sltu" && instruction.rs1 == REG_ZERO:
+			return operands("snez", rd, rs2)
+		case mnemonic == "slt" && instruction.rs2 == REG_ZERO:
+			return operands("sltz", rd, rs1)
+		case mnemonic == "slt" && instruction.rs1 == REG_ZERO:
+			return operands("sgtz", rd, rs2)
+		}
+		return operands(mnemonic, rd, rs1, rs2)
+
+	case I_TYPE_FENCE:
+		return renderFence(instruction)
+	case I_TYPE_SYS:
+		if instruction.funct3 == 0 {
+			return mnemonic
+		}
+		return renderCSR(instruction)
+	case R_TYPE_AMO:
+		return renderAtomic(instruction)
+	}
+	return renderFloat(instruction)
+}
+
+// Renders a fence, naming the accesses it orders unless it orders all of them
+func renderFence(instruction *AssemblyInstruction) string {
+	if instruction.mnemonic == "fence.i" {
+		return "fence.i"
+	}
+	if instruction.rd != REG_ZERO || instruction.rs1 != REG_ZERO {
+		return ""
+	}
+	fm, pred, succ := uint32(instruction.imm)>>8&0xF, instruction.imm>>4&0xF, instruction.imm&0xF
+	switch {
+	case fm == 0x8 && pred == 0x3 && succ == 0x3:
+		return "fence.tso"
+	case fm != 0:
+		return ""
+	case pred == 0x1 && succ == 0:
+		return "pause"
+	case pred == 0xF && succ == 0xF:
+		return "fence"
+	}
+	return operands("fence", fenceSetNames[pred], fenceSetNames[succ])
+}
+
+// Renders a CSR instruction, using the aliases for reading and writing CSRs on their own
+func renderCSR(instruction *AssemblyInstruction) string {
+	csr := uint32(instruction.imm) & CSR_ADDRESS_MASK
+	name, ok := csrNames[csr]
+	if !ok {
+		name = fmt.Sprintf("0x%x", csr)
+	}
+	rd, source := RegisterName(instruction.rd), RegisterName(instruction.rs1)
+	immediate := strings.HasSuffix(instruction.mnemonic, "i")
+	if immediate {
+		source = fmt.Sprint(instruction.rs1)
+	}
+	aliases, isFloat := floatCSRAliases[csr]
+	// The floating-point aliases name the destination only when it is not zero
+	withRd := func(mnemonic string, operand string) string {
+		if instruction.rd == REG_ZERO {
+			return operands(mnemonic, operand)
+		}
+		return operands(mnemonic, rd, operand)
+	}
+
+	switch instruction.mnemonic {
+	case "csrrw", "csrrwi":
+		switch {
+		case csr == CSR_CYCLE && !immediate && instruction.rd == REG_ZERO && instruction.rs1 == REG_ZERO:
+			return "unimp"
+		case isFloat && !immediate:
+			return withRd(aliases[1], source)
+		case isFloat && aliases[2] != "":
+			return withRd(aliases[2], source)
+		}
+	case "csrrs":
+		switch {
+		case instruction.rs1 == REG_ZERO && isFloat:
+			return operands(aliases[0], rd)
+		case instruction.rs1 == REG_ZERO && (csr >= CSR_CYCLE && csr <= CSR_INSTRET || csr >= CSR_CYCLEH && csr <= CSR_INSTRETH):
+			return operands("rd"+name, rd)
+		case instruction.rs1 == REG_ZERO:
+			return operands("csrr", rd, name)
+		}
+	}
+	// Writes that discard the old value drop the destination, as in csrw and csrsi
+	if instruction.rd == REG_ZERO {
+		return operands("csr"+strings.TrimPrefix(instruction.mnemonic, "csrr"), name, source)
+	}
+	return operands(instruction.mnemonic, rd, name, source)
+}
+
+// Renders an atomic memory operation, suffixed with its ordering bits
+func renderAtomic(instruction *AssemblyInstruction) string {
+	mnemonic := instruction.mnemonic
+	switch instruction.funct7 & 0x3 {
+	case 0x1:
+		mnemonic += ".rl"
+	case 0x2:
+		mnemonic += ".aq"
+	case 0x3:
+		mnemonic += ".aqrl"
+	}
+	rd, rs2, addr := RegisterName(instruction.rd), RegisterName(instruction.rs2), "("+RegisterName(instruction.rs1)+")"
+	if instruction.mnemonic == "lr.w" {
+		return operands(mnemonic, rd, addr)
+	}
+	return operands(mnemonic, rd, rs2, addr)
+}
+
+// Renders a floating-point instruction, naming its rounding mode only when it is not dynamic
+func renderFloat(instruction *AssemblyInstruction) string {
+	mnemonic := instruction.mnemonic
+	rd, rs1 := RegisterName(instruction.rd), RegisterName(instruction.rs1)
+	frd, frs1, frs2 := floatRegisterNames[instruction.rd], floatRegisterNames[instruction.rs1], floatRegisterNames[instruction.rs2]
+	rounded := func(args ...string) string {
+		if rm := roundingModeNames[instruction.funct3]; rm != "" {
+			args = append(args, rm)
+		}
+		return operands(mnemonic, args...)
+	}
+
+	if instruction.format == FORMAT_R4 {
+		return rounded(frd, frs1, frs2, floatRegisterNames[instruction.rs3])
+	}
+	name, _, _ := strings.Cut(mnemonic, ".")
+	switch name {
+	case "fadd", "fsub", "fmul", "fdiv":
+		return rounded(frd, frs1, frs2)
+	case "fsqrt":
+		return rounded(frd, frs1)
+	case "fsgnj", "fsgnjn", "fsgnjx":
+		if instruction.rs1 == instruction.rs2 {
+			alias := map[string]string{"fsgnj": "fmv", "fsgnjn": "fneg", "fsgnjx": "fabs"}[name]
+			return operands(alias+mnemonic[len(name):], frd, frs1)
+		}
+		return operands(mnemonic, frd, frs1, frs2)
+	case "fmin", "fmax":
+		return operands(mnemonic, frd, frs1, frs2)
+	case "feq", "flt", "fle":
+		return operands(mnemonic, rd, frs1, frs2)
+	case "fclass":
+		return operands(mnemonic, rd, frs1)
+	}
+
+	switch mnemonic {
+	case "fmv.x.w":
+		return operands(mnemonic, rd, frs1)
+	case "fmv.w.x":
+		return operands(mnemonic, frd, rs1)
+	case "fcvt.w.s", "fcvt.wu.s", "fcvt.w.d", "fcvt.wu.d":
+		return rounded(rd, frs1)
+	case "fcvt.s.w", "fcvt.s.wu":
+		return rounded(frd, rs1)
+	case "fcvt.s.d":
+		return rounded(frd, frs1)
+	case "fcvt.d.w", "fcvt.d.wu":
+		// Converting to a double is exact, so no rounding mode is shown
+		return operands(mnemonic, frd, rs1)
+	case "fcvt.d.s":
+		return operands(mnemonic, frd, frs1)
+	}
+	return ""
+}
+
+// A section of an image to list, along with the symbols defined within it
+type listingSection struct {
+	name    string
+	addr    uint32
+	data    []byte
+	symbols []disasmSymbol
+}
+
+// A bus that keeps the data an image loads instead of storing it, so the image can be listed
+// without being run. Contiguous data is merged into one section.
+type imageRecorder struct {
+	sections []listingSection
+}
+
+// Accepts the regions of the memory map, which a listing has no use for
+func (recorder *imageRecorder) Map(region Region) error {
+	return nil
+}
+
+// Fails every read, as the recorder stores nothing that could be read back
+func (recorder *imageRecorder) Read(addr uint32, size uint32) (uint32, error) {
+	return 0, &BusError{addr: addr, size: size, err: ErrUnmapped}
+}
+
+// Fails every write, as only loading images is recorded
+func (recorder *imageRecorder) Write(addr uint32, size uint32, value uint32) error {
+	return &BusError{addr: addr, size: size, err: ErrUnmapped}
+}
+
+// Fails every doubleword read, like Read
+func (recorder *imageRecorder) ReadDouble(addr uint32) (uint64, error) {
+	return 0, &BusError{addr: addr, size: BYTES_PER_DOUBLE, err: ErrUnmapped}
+}
+
+// Fails every doubleword write, like Write
+func (recorder *imageRecorder) WriteDouble(addr uint32, value uint64) error {
+	return &BusError{addr: addr, size: BYTES_PER_DOUBLE, write: true, err: ErrUnmapped}
+}
+
+// Records the data an image loads, extending the last section when the data follows on from it
+func (recorder *imageRecorder) Load(addr uint32, data []byte) error {
+	if n := len(recorder.sections); n > 0 {
+		last := &recorder.sections[n-1]
+		if last.addr+uint32(len(last.data)) == addr {
+			last.data = append(last.data, data...)
+			return nil
+		}
+	}
+	recorder.sections = append(recorder.sections, listingSection{addr: addr, data: append([]byte(nil), data...)})
+	return nil
+}
+
+// Ignores zeroing, which only ever follows the data an image loads
+func (recorder *imageRecorder) Clear(addr uint32, size uint32) error {
+	return nil
+}
+
+// Returns zero, as the recorder holds no guest memory
+func (recorder *imageRecorder) Resident() uint64 {
+	return 0
+}
+
+// Writes a listing of every section of an image in the format of objdump -D
+func WriteListing(w io.Writer, image Image) error {
+	file, err := os.Open(image.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	format, err := image.resolveFormat(file)
+	if err != nil {
+		return err
+	}
+
+	var sections []listingSection
+	var symbols []disasmSymbol
+	fileFormat := "binary"
+	switch format {
+	case IMAGE_ELF:
+		fileFormat = "elf32-littleriscv"
+		if sections, symbols, err = elfListingSections(file); err != nil {
+			return err
+		}
+	case IMAGE_ASM:
+		// Assembly source is listed as the executable the asm subcommand would write
+		fileFormat = "elf32-littleriscv"
+		program, err := NewAssembler(ASM_TEXT_START, ASM_DATA_START).Assemble(image.path, file)
+		if err != nil {
+			return err
+		}
+		sections, symbols = program.listingSections()
+	default:
+		// Other formats are loaded as they would be into memory, through a bus that records them
+		cpu, err := NewCPU(0, 0)
+		if err != nil {
+			return err
+		}
+		recorder := &imageRecorder{}
+		cpu.bus = recorder
+		if _, _, err := cpu.LoadImage(image); err != nil {
+			return err
+		}
+		sections = recorder.sections
+		for i := range sections {
+			sections[i].name = ".data"
+			if format == IMAGE_IHEX || format == IMAGE_SREC {
+				fileFormat = string(format)
+				sections[i].name = fmt.Sprintf(".sec%d", i+1)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\n%s:     file format %s\n\n", image.path, fileFormat)
+	d := newDisassembler(symbols)
+	for i := range sections {
+		d.section = &sections[i]
+		d.writeSection(w, &sections[i])
+	}
+	return nil
+}
+
+// Returns the sections of a RISC-V ELF file that hold contents, and the symbols defined in them
+func elfListingSections(reader io.ReaderAt) ([]listingSection, []disasmSymbol, error) {
+	file, err := elf.NewFile(reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading elf: %v", err)
+	}
+	defer file.Close()
+	if file.Machine != elf.EM_RISCV || file.Class != elf.ELFCLASS32 {
+		return nil, nil, fmt.Errorf("elf is not a 32-bit RISC-V file")
+	}
+
+	// A stripped file simply has no symbols
+	list, _ := file.Symbols()
+	var symbols []disasmSymbol
+	bySection := make(map[elf.SectionIndex][]disasmSymbol)
+	for _, symbol := range list {
+		switch elf.ST_TYPE(symbol.Info) {
+		case elf.STT_SECTION, elf.STT_FILE:
+			continue
+		}
+		// Mapping symbols only mark where code and data begin
+		if symbol.Name == "" || strings.HasPrefix(symbol.Name, "$x") || strings.HasPrefix(symbol.Name, "$d") {
+			continue
+		}
+		if symbol.Section == elf.SHN_UNDEF || symbol.Section == elf.SHN_ABS && symbol.Name != GLOBAL_POINTER_SYMBOL {
+			continue
+		}
+		s := disasmSymbol{name: symbol.Name, addr: uint32(symbol.Value), global: elf.ST_BIND(symbol.Info) != elf.STB_LOCAL}
+		symbols = append(symbols, s)
+		bySection[symbol.Section] = append(bySection[symbol.Section], s)
+	}
+
+	var sections []listingSection
+	for i, section := range file.Sections {
+		switch section.Type {
+		case elf.SHT_NULL, elf.SHT_NOBITS, elf.SHT_SYMTAB, elf.SHT_DYNSYM, elf.SHT_STRTAB, elf.SHT_REL, elf.SHT_RELA, elf.SHT_GROUP, elf.SHT_SYMTAB_SHNDX:
+			continue
+		}
+		if section.Size == 0 {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading section %s: %v", section.Name, err)
+		}
+		sections = append(sections, listingSection{
+			name:    section.Name,
+			addr:    uint32(section.Addr),
+			data:    data,
+			symbols: newDisassembler(bySection[elf.SectionIndex(i)]).symbols,
+		})
+	}
+	return sections, symbols, nil
+}
+
+// Writes the disassembly of a section, split at the symbols defined within it
+func (d *Disassembler) writeSection(w io.Writer, section *listingSection) {
+	fmt.Fprintf(w, "\nDisassembly of section %s:\n", section.name)
+	end := section.addr + uint32(len(section.data))
+
+	// Addresses drop the leading zeros the whole section shares, in groups of four digits
+	skip := len(fmt.Sprintf("%08x", end)) - len(strings.TrimLeft(fmt.Sprintf("%08x", end), "0"))
+	if skip > 0 {
+		skip = (skip - 1) &^ 3
+	}
+
+	// The section is split into regions starting at each symbol, or at the section itself
+	starts := []disasmSymbol{}
+	if len(section.symbols) == 0 || section.symbols[0].addr != section.addr {
+		starts = append(starts, disasmSymbol{name: section.name, addr: section.addr})
+	}
+	for _, symbol := range section.symbols {
+		if symbol.addr < section.addr || symbol.addr >= end {
+			continue
+		}
+		if n := len(starts); n == 0 || starts[n-1].addr != symbol.addr {
+			starts = append(starts, symbol)
+		}
+	}
+	for i, start := range starts {
+		stop := end
+		if i+1 < len(starts) {
+			stop = starts[i+1].addr
+		}
+		fmt.Fprintf(w, "\n%08x <%s>:\n", start.addr, start.name)
+		d.writeRegion(w, section, start.addr-section.addr, stop-section.addr, skip)
+	}
+}
+
+// Writes the lines of a region of a section, given as offsets within it, eliding runs of zeros
+func (d *Disassembler) writeRegion(w io.Writer, section *listingSection, offset uint32, stop uint32, skip int) {
+	data := section.data
+	for offset < stop {
+		zeros := offset
+		for zeros < stop && data[zeros] == 0 {
+			zeros++
+		}
+		if zeros-offset >= LISTING_SKIP_ZEROES || zeros == stop && zeros-offset < LISTING_SKIP_AT_END {
+			// Unless the zeros run to the end, skip whole words so as not to run into an instruction
+			if zeros != stop {
+				zeros = offset + (zeros-offset)&^3
+			}
+			fmt.Fprintf(w, "\t...\n")
+			offset = zeros
+			continue
+		}
+
+		// Instructions running off the end of the section are shown as the bytes there are
+		var word uint32
+		available := uint32(len(data)) - offset
+		for i := uint32(0); i < BYTES_PER_WORD && i < available; i++ {
+			word |= uint32(data[offset+i]) << (8 * i)
+		}
+		addr := section.addr + offset
+		text, size := d.Disassemble(addr, word)
+		if size > available {
+			size, text = 1, fmt.Sprintf(".byte\t0x%x", word&0xFF)
+			if available >= BYTES_PER_HALF {
+				size, text = BYTES_PER_HALF, fmt.Sprintf(".2byte\t0x%x", word&0xFFFF)
+			}
+		}
+
+		location := []byte(fmt.Sprintf("%08x", addr)[skip:])
+		for i := 0; i < len(location)-1 && location[i] == '0'; i++ {
+			location[i] = ' '
+		}
+		raw := fmt.Sprintf("%0*x", 2*size, word&(1<<(8*size)-1))
+		// Each chunk of bytes is followed by a space, with the chunks missing from the line left blank
+		padding := int(LISTING_BYTES_PER_LINE-size) / int(size) * (2*int(size) + 1)
+		fmt.Fprintf(w, "%s:\t%s %s\t%s\n", location, raw, strings.Repeat(" ", padding), text)
+		offset += size
+	}
+}
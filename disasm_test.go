@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		word uint32
+		want string
+	}{
+		// Integer aliases
+		{0x00000013, "nop"},
+		{0x00100513, "li\ta0,1"},
+		{0x00058513, "mv\ta0,a1"},
+		{0xfff54513, "not\ta0,a0"},
+		{0x40b00533, "neg\ta0,a1"},
+		{0x0015b513, "seqz\ta0,a1"},
+		{0x00b03533, "snez\ta0,a1"},
+		{0x00351513, "slli\ta0,a0,0x3"},
+		{0x12345537, "lui\ta0,0x12345"},
+
+		// Jumps and branches name their targets
+		{0x00008067, "ret"},
+		{0x000500e7, "jalr\ta0"},
+		{0x0080006f, "j\t108 <main+0x8>"},
+		{0xff5ff0ef, "jal\tf4 <start+0x4>"},
+		{0x00050463, "beqz\ta0,108 <main+0x8>"},
+		{0x00a05463, "blez\ta0,108 <main+0x8>"},
+		{0x00b56463, "bltu\ta0,a1,108 <main+0x8>"},
+
+		// Loads and stores, with absolute addresses shown
+		{0x00812503, "lw\ta0,8(sp)"},
+		{0x00a12423, "sw\ta0,8(sp)"},
+		{0x01002503, "lw\ta0,16(zero) # 10"},
+
+		// System instructions and CSR aliases
+		{0x00000073, "ecall"},
+		{0xc0001073, "unimp"},
+		{0x30002573, "csrr\ta0,mstatus"},
+		{0x30529073, "csrw\tmtvec,t0"},
+		{0x34059573, "csrrw\ta0,mscratch,a1"},
+		{0x30046073, "csrsi\tmstatus,8"},
+		{0x7c002573, "csrr\ta0,0x7c0"},
+		{0xc0002573, "rdcycle\ta0"},
+		{0x00202573, "frrm\ta0"},
+		{0x00159073, "fsflags\ta1"},
+		{0x0020d073, "fsrmi\t1"},
+		{0x0ff0000f, "fence"},
+		{0x0330000f, "fence\trw,rw"},
+		{0x8330000f, "fence.tso"},
+		{0x0000100f, "fence.i"},
+
+		// Atomics and floating point
+		{0x06b6252f, "amoadd.w.aqrl\ta0,a1,(a2)"},
+		{0x1005a52f, "lr.w\ta0,(a1)"},
+		{0x00c5f553, "fadd.s\tfa0,fa1,fa2"},
+		{0x00c59553, "fadd.s\tfa0,fa1,fa2,rtz"},
+		{0xc0051553, "fcvt.w.s\ta0,fa0,rtz"},
+		{0x20b58553, "fmv.s\tfa0,fa1"},
+		{0x6ac5f543, "fmadd.d\tfa0,fa1,fa2,fa3"},
+		{0x00813507, "fld\tfa0,8(sp)"},
+		{0x00a13427, "fsd\tfa0,8(sp)"},
+
+		// Compressed instructions read as their expansions, and words without instructions as data
+		{0x4505, "li\ta0,1"},
+		{0x852e, "mv\ta0,a1"},
+		{0x8082, "ret"},
+		{0x9002, "ebreak"},
+		{0x0000, "unimp"},
+		{0xffffffff, ".4byte\t0xffffffff"},
+	}
+	symbols := map[string]uint32{"start": 0xf0, "main": 0x100}
+	for _, tt := range tests {
+		// Each instruction gets a disassembler of its own, so none sees the registers another set
+		text, size := NewDisassembler(symbols).Disassemble(0x100, tt.word)
+		if text != tt.want {
+			t.Errorf("Disassemble(%08x) = %q, want %q", tt.word, text, tt.want)
+		}
+		want := uint32(BYTES_PER_WORD)
+		if isCompressed(tt.word) {
+			want = BYTES_PER_HALF
+		}
+		if size != want {
+			t.Errorf("Disassemble(%08x) size = %d, want %d", tt.word, size, want)
+		}
+	}
+}
+
+func TestDisassembleAddressComments(t *testing.T) {
+	d := NewDisassembler(map[string]uint32{"data": 0x1234_5000, GLOBAL_POINTER_SYMBOL: 0x10000})
+	// lui a0, 0x12345; addi a0, a0, 0x678; addi a0, a0, 8; lw a1, -4(gp); auipc a1, 0x1; jr 16(a1)
+	program := []uint32{0x12345537, 0x67850513, 0x00850513, 0xffc1a583, 0x00001597, 0x01058067}
+	want := []string{
+		"lui\ta0,0x12345",
+		"addi\ta0,a0,1656 # 12345678 <data+0x678>",
+		"addi\ta0,a0,8",
+		"lw\ta1,-4(gp) # fffc",
+		"auipc\ta1,0x1",
+		"jr\t16(a1) # 1020",
+	}
+	for i, word := range program {
+		if text, _ := d.Disassemble(uint32(i)*BYTES_PER_WORD, word); text != want[i] {
+			t.Errorf("Disassemble(%08x) = %q, want %q", word, text, want[i])
+		}
+	}
+
+	// Upper bits are forgotten when the next instruction is not the one that follows
+	d.Disassemble(0x100, 0x12345537)
+	if text, _ := d.Disassemble(0x200, 0x67850513); text != "addi\ta0,a0,1656" {
+		t.Errorf("Disassemble(67850513) = %q, want %q", text, "addi\ta0,a0,1656")
+	}
+}
+
+func TestWriteListing(t *testing.T) {
+	// li a0, 1; c.li a0, 1; c.nop; eight zero bytes; ret
+	data := []byte{0x13, 0x05, 0x10, 0x00, 0x05, 0x45, 0x01, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0x67, 0x80, 0x00, 0x00}
+	path := filepath.Join(t.TempDir(), "program.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	image, err := ParseImage(path+"@0x80000000", "raw")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var output bytes.Buffer
+	if err := WriteListing(&output, image); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "\n" + path + ":     file format binary\n\n" +
+		"\nDisassembly of section .data:\n" +
+		"\n80000000 <.data>:\n" +
+		"80000000:\t00100513          \tli\ta0,1\n" +
+		"80000004:\t4505                \tli\ta0,1\n" +
+		"80000006:\t0001                \tnop\n" +
+		"\t...\n" +
+		"80000010:\t00008067          \tret\n"
+	if output.String() != want {
+		t.Errorf("listing = %q, want %q", output.String(), want)
+	}
+}
+
+func TestWriteListingELF(t *testing.T) {
+	image, err := ParseImage(filepath.Join(riscvTestDir, "rv32ui-p-simple"), "auto")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var output bytes.Buffer
+	if err := WriteListing(&output, image); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, line := range []string{
+		"rv32ui-p-simple:     file format elf32-littleriscv\n",
+		"\nDisassembly of section .text:\n\n80000000 <_start>:\n",
+		"80000000:\t0040006f          \tj\t80000004 <_start+0x4>\n",
+		"\tcsrr\ta0,mhartid\n",
+	} {
+		if !strings.Contains(output.String(), line) {
+			t.Errorf("listing does not contain %q:\n%s", line, output.String())
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Loads the PT_LOAD segments of a RISC-V ELF executable at their physical addresses, returning its entry point
+func (cpu *CPU) LoadELF(reader io.ReaderAt) (uint32, error) {
+	file, err := elf.NewFile(reader)
+	if err != nil {
+		return 0, fmt.Errorf("error reading elf: %v", err)
+	}
+	defer file.Close()
+	if file.Machine != elf.EM_RISCV {
+		return 0, fmt.Errorf("elf is built for %v, not RISC-V", file.Machine)
+	}
+	if file.Type != elf.ET_EXEC {
+		return 0, fmt.Errorf("elf is of type %v, not an executable", file.Type)
+	}
+
+	for _, segment := range file.Progs {
+		if segment.Type != elf.PT_LOAD || segment.Memsz == 0 {
+			continue
+		}
+		if segment.Filesz > segment.Memsz {
+			return 0, fmt.Errorf("segment at %08x holds more data than fits in its memory size", segment.Paddr)
+		}
+		if segment.Paddr+segment.Memsz > math.MaxUint32+1 {
+			return 0, fmt.Errorf("segment at %x does not fit in the 32-bit address space", segment.Paddr)
+		}
+
+		data := make([]byte, segment.Filesz)
+		if _, err := io.ReadFull(segment.Open(), data); err != nil {
+			return 0, fmt.Errorf("error reading segment at %08x: %v", segment.Paddr, err)
+		}
+		if err := cpu.bus.Load(uint32(segment.Paddr), data); err != nil {
+			return 0, err
+		}
+		// The part of the segment past its file data is the BSS, which is zeroed without committing memory for it
+		if err := cpu.bus.Clear(uint32(segment.Paddr+segment.Filesz), uint32(segment.Memsz-segment.Filesz)); err != nil {
+			return 0, err
+		}
+	}
+
+	if file.Entry > math.MaxUint32 {
+		return 0, fmt.Errorf("entry point %x does not fit in the 32-bit address space", file.Entry)
+	}
+	// Symbols of later images are added to those already loaded
+	for name, addr := range elfSymbols(file) {
+		cpu.symbols[name] = addr
+	}
+	return uint32(file.Entry), nil
+}
+
+// Returns the addresses of the named code and data symbols of an ELF file
+func elfSymbols(file *elf.File) map[string]uint32 {
+	symbols := make(map[string]uint32)
+	// A stripped executable simply has no symbols
+	list, _ := file.Symbols()
+	for _, symbol := range list {
+		switch elf.ST_TYPE(symbol.Info) {
+		case elf.STT_SECTION, elf.STT_FILE:
+			continue
+		}
+		if symbol.Name != "" {
+			symbols[symbol.Name] = uint32(symbol.Value)
+		}
+	}
+	return symbols
+}
+
+// Returns the address of a symbol of the loaded executable
+func (cpu *CPU) Symbol(name string) (uint32, bool) {
+	addr, ok := cpu.symbols[name]
+	return addr, ok
+}
+
+// Parses an address given as a number or the name of a symbol
+func (cpu *CPU) ParseAddress(arg string) (uint32, error) {
+	if addr, ok := cpu.Symbol(arg); ok {
+		return addr, nil
+	}
+	addr, err := strconv.ParseUint(arg, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is neither an address nor a symbol", arg)
+	}
+	return uint32(addr), nil
+}
+
+// Returns the symbol closest below an address and the offset of the address within it
+func (cpu *CPU) SymbolAt(addr uint32) (string, uint32, bool) {
+	name, found := "", false
+	for symbol, symbolAddr := range cpu.symbols {
+		if symbolAddr > addr {
+			continue
+		}
+		// Ties go to the first name in alphabetical order, so the result does not depend on map order
+		if best := cpu.symbols[name]; !found || symbolAddr > best || symbolAddr == best && symbol < name {
+			name, found = symbol, true
+		}
+	}
+	return name, addr - cpu.symbols[name], found
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"runtime"
+	"testing"
+)
+
+// Describes a loadable segment of a test executable
+type testSegment struct {
+	addr  uint32
+	data  []byte
+	memsz uint32
+}
+
+// Builds a 32-bit little-endian ELF executable with the given segments and absolute symbols
+func buildELF(t *testing.T, machine elf.Machine, entry uint32, segments []testSegment, symbols map[string]uint32) []byte {
+	t.Helper()
+	const headerSize, progSize, sectionSize, symbolSize = 52, 32, 40, 16
+
+	// Symbol and section name tables, each starting with the empty name
+	strtab := []byte{0}
+	symtab := []elf.Sym32{{}}
+	for name, value := range symbols {
+		symtab = append(symtab, elf.Sym32{
+			Name:  uint32(len(strtab)),
+			Value: value,
+			Info:  elf.ST_INFO(elf.STB_GLOBAL, elf.STT_OBJECT),
+			Shndx: uint16(elf.SHN_ABS),
+		})
+		strtab = append(append(strtab, name...), 0)
+	}
+	shstrtab := []byte("\x00.symtab\x00.strtab\x00.shstrtab\x00")
+
+	// Lay out the segment data, then the tables, then the section headers
+	offset := uint32(headerSize + progSize*len(segments))
+	progs := make([]elf.Prog32, len(segments))
+	var contents bytes.Buffer
+	for i, segment := range segments {
+		progs[i] = elf.Prog32{
+			Type: uint32(elf.PT_LOAD), Off: offset + uint32(contents.Len()),
+			Vaddr: segment.addr, Paddr: segment.addr,
+			Filesz: uint32(len(segment.data)), Memsz: segment.memsz,
+			Flags: uint32(elf.PF_R | elf.PF_W | elf.PF_X),
+		}
+		contents.Write(segment.data)
+	}
+	symtabOffset := offset + uint32(contents.Len())
+	binary.Write(&contents, binary.LittleEndian, symtab)
+	strtabOffset := offset + uint32(contents.Len())
+	contents.Write(strtab)
+	shstrtabOffset := offset + uint32(contents.Len())
+	contents.Write(shstrtab)
+	for contents.Len()%4 != 0 {
+		contents.WriteByte(0)
+	}
+	sections := []elf.Section32{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_SYMTAB), Off: symtabOffset, Size: uint32(len(symtab) * symbolSize), Link: 2, Info: 1, Entsize: symbolSize},
+		{Name: 9, Type: uint32(elf.SHT_STRTAB), Off: strtabOffset, Size: uint32(len(strtab))},
+		{Name: 17, Type: uint32(elf.SHT_STRTAB), Off: shstrtabOffset, Size: uint32(len(shstrtab))},
+	}
+
+	header := elf.Header32{
+		Type: uint16(elf.ET_EXEC), Machine: uint16(machine), Version: uint32(elf.EV_CURRENT),
+		Entry: entry, Phoff: headerSize, Shoff: offset + uint32(contents.Len()),
+		Ehsize: headerSize, Phentsize: progSize, Phnum: uint16(len(progs)),
+		Shentsize: sectionSize, Shnum: uint16(len(sections)), Shstrndx: 3,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var file bytes.Buffer
+	binary.Write(&file, binary.LittleEndian, header)
+	binary.Write(&file, binary.LittleEndian, progs)
+	file.Write(contents.Bytes())
+	binary.Write(&file, binary.LittleEndian, sections)
+	return file.Bytes()
+}
+
+func TestLoadELF(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x2000)
+	// Dirty the BSS to check that loading clears it
+	storeWords(t, cpu, 0x1008, 0xFFFF_FFFF)
+
+	image := buildELF(t, elf.EM_RISCV, 0x104, []testSegment{
+		{addr: 0x100, data: []byte{0x13, 0, 0, 0, 0x73, 0, 0x10, 0}, memsz: 8},
+		{addr: 0x1000, data: []byte{1, 2, 3, 4}, memsz: 12},
+	}, map[string]uint32{"_start": 0x104, "tohost": 0x1000})
+	entry, err := cpu.LoadELF(bytes.NewReader(image))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if entry != 0x104 {
+		t.Errorf("entry = %08x, want 00000104", entry)
+	}
+	if word, _ := cpu.FetchWord(0x104); word != 0x00100073 {
+		t.Errorf("word at 00000104 = %08x, want 00100073", word)
+	}
+	if word, _ := cpu.FetchWord(0x1000); word != 0x04030201 {
+		t.Errorf("word at 00001000 = %08x, want 04030201", word)
+	}
+	if word, _ := cpu.FetchWord(0x1008); word != 0 {
+		t.Errorf("bss word at 00001008 = %08x, want 0", word)
+	}
+	if addr, ok := cpu.Symbol("tohost"); !ok || addr != 0x1000 {
+		t.Errorf("Symbol(tohost) = %08x, %v, want 00001000", addr, ok)
+	}
+}
+
+func TestLoadELFLargeBSS(t *testing.T) {
+	cpu := newTestCPU(t, 0, MEM_MAX_SIZE)
+	// Dirty a page in the middle of the BSS, which loading releases
+	storeWords(t, cpu, 0x0800_0000, 0xFFFF_FFFF)
+
+	image := buildELF(t, elf.EM_RISCV, 0x1000, []testSegment{
+		{addr: 0x1000, data: []byte{0x73, 0, 0x10, 0}, memsz: 0x1000_0000},
+	}, nil)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := cpu.LoadELF(bytes.NewReader(image)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("loading allocated %d bytes, want the BSS left out", allocated)
+	}
+	if word, _ := cpu.FetchWord(0x0800_0000); word != 0 {
+		t.Errorf("bss word at 08000000 = %08x, want 0", word)
+	}
+	// Only the page holding the file data is committed, not the 256 MiB of BSS
+	if cpu.ResidentSize() != PAGE_SIZE {
+		t.Errorf("ResidentSize() = %d, want %d", cpu.ResidentSize(), PAGE_SIZE)
+	}
+}
+
+func TestLoadELFRejectsInvalidExecutables(t *testing.T) {
+	tests := []struct {
+		name  string
+		image []byte
+	}{
+		{"other machine", buildELF(t, elf.EM_ARM, 0, []testSegment{{addr: 0, data: []byte{0}, memsz: 1}}, nil)},
+		{"segment outside memory", buildELF(t, elf.EM_RISCV, 0, []testSegment{{addr: 0x8000_0000, data: []byte{0}, memsz: 1}}, nil)},
+		{"file data larger than the segment", buildELF(t, elf.EM_RISCV, 0, []testSegment{{addr: 0, data: []byte{0, 0}, memsz: 1}}, nil)},
+		{"not an elf", []byte("not an elf file")},
+	}
+
+	for _, test := range tests {
+		cpu := newTestCPU(t, 0, 0x100)
+		if _, err := cpu.LoadELF(bytes.NewReader(test.image)); err == nil {
+			t.Errorf("%s: LoadELF should fail", test.name)
+		}
+	}
+}
@@ -0,0 +1,699 @@
+package main
+
+import (
+	"math"
+	"math/big"
+)
+
+// Describes the layout of an IEEE 754 binary floating-point format
+type floatFormat struct {
+	suffix    string // The instruction suffix naming the format
+	width     uint   // Total width of an encoded value in bits
+	precision uint   // Significand precision in bits, including the implicit bit
+	bias      int    // Exponent bias
+}
+
+// The floating-point formats of the F and D extensions
+var (
+	FLOAT32 = floatFormat{"s", 32, 24, 127}
+	FLOAT64 = floatFormat{"d", 64, 53, 1023}
+)
+
+// Rounding modes encoded in the rm field of an instruction and the frm field of fcsr
+const (
+	RM_RNE uint8 = 0b000 // Round to nearest, ties to even
+	RM_RTZ uint8 = 0b001 // Round towards zero
+	RM_RDN uint8 = 0b010 // Round down (towards negative infinity)
+	RM_RUP uint8 = 0b011 // Round up (towards positive infinity)
+	RM_RMM uint8 = 0b100 // Round to nearest, ties to max magnitude
+	RM_DYN uint8 = 0b111 // Use the dynamic rounding mode held in frm
+)
+
+// Accrued exception flags held in the fflags field of fcsr
+const (
+	FFLAG_NX uint8 = 1 << iota // Inexact
+	FFLAG_UF                   // Underflow
+	FFLAG_OF                   // Overflow
+	FFLAG_DZ                   // Divide by zero
+	FFLAG_NV                   // Invalid operation
+)
+
+// Bits of the result of the classify instructions
+const (
+	FCLASS_NEG_INF       uint32 = 1 << iota // Negative infinity
+	FCLASS_NEG_NORMAL                       // Negative normal number
+	FCLASS_NEG_SUBNORMAL                    // Negative subnormal number
+	FCLASS_NEG_ZERO                         // Negative zero
+	FCLASS_POS_ZERO                         // Positive zero
+	FCLASS_POS_SUBNORMAL                    // Positive subnormal number
+	FCLASS_POS_NORMAL                       // Positive normal number
+	FCLASS_POS_INF                          // Positive infinity
+	FCLASS_SNAN                             // Signaling NaN
+	FCLASS_QNAN                             // Quiet NaN
+)
+
+// Returns the largest unbiased exponent of a normal number
+func (f floatFormat) emax() int {
+	return f.bias
+}
+
+// Returns the smallest unbiased exponent of a normal number
+func (f floatFormat) emin() int {
+	return 1 - f.bias
+}
+
+// Returns the mask of the sign bit
+func (f floatFormat) signBit() uint64 {
+	return 1 << (f.width - 1)
+}
+
+// Returns the mask of the stored fraction bits
+func (f floatFormat) fractionMask() uint64 {
+	return 1<<(f.precision-1) - 1
+}
+
+// Returns the mask of the exponent field, shifted into place
+func (f floatFormat) exponentMask() uint64 {
+	return (f.signBit() - 1) &^ f.fractionMask()
+}
+
+// Returns the mask of the most significant fraction bit, which distinguishes quiet from signaling NaNs
+func (f floatFormat) quietBit() uint64 {
+	return 1 << (f.precision - 2)
+}
+
+// Returns the canonical quiet NaN produced by invalid operations
+func (f floatFormat) canonicalNaN() uint64 {
+	return f.exponentMask() | f.quietBit()
+}
+
+// Returns positive infinity
+func (f floatFormat) infinity() uint64 {
+	return f.exponentMask()
+}
+
+// Returns the largest finite magnitude
+func (f floatFormat) maxFinite() uint64 {
+	return f.exponentMask() - 1
+}
+
+// Returns whether the value is negative, including negative zero and NaNs with the sign bit set
+func (f floatFormat) isNegative(value uint64) bool {
+	return value&f.signBit() != 0
+}
+
+// Returns whether the value is a NaN
+func (f floatFormat) isNaN(value uint64) bool {
+	return value&f.exponentMask() == f.exponentMask() && value&f.fractionMask() != 0
+}
+
+// Returns whether the value is a signaling NaN
+func (f floatFormat) isSignalingNaN(value uint64) bool {
+	return f.isNaN(value) && value&f.quietBit() == 0
+}
+
+// Returns whether the value is an infinity of either sign
+func (f floatFormat) isInf(value uint64) bool {
+	return value&^f.signBit() == f.infinity()
+}
+
+// Returns whether the value is a zero of either sign
+func (f floatFormat) isZero(value uint64) bool {
+	return value&^f.signBit() == 0
+}
+
+// Returns the value with its sign flipped
+func (f floatFormat) negate(value uint64) uint64 {
+	return value ^ f.signBit()
+}
+
+// Returns the signed zero or infinity for the given sign
+func (f floatFormat) signed(negative bool, magnitude uint64) uint64 {
+	if negative {
+		return magnitude | f.signBit()
+	}
+	return magnitude
+}
+
+// Classifies a value into exactly one of the FCLASS categories
+func (f floatFormat) classify(value uint64) uint32 {
+	negative := f.isNegative(value)
+	exponent := value & f.exponentMask()
+	switch {
+	case f.isSignalingNaN(value):
+		return FCLASS_SNAN
+	case f.isNaN(value):
+		return FCLASS_QNAN
+	case f.isInf(value) && negative:
+		return FCLASS_NEG_INF
+	case f.isInf(value):
+		return FCLASS_POS_INF
+	case f.isZero(value) && negative:
+		return FCLASS_NEG_ZERO
+	case f.isZero(value):
+		return FCLASS_POS_ZERO
+	case exponent == 0 && negative:
+		return FCLASS_NEG_SUBNORMAL
+	case exponent == 0:
+		return FCLASS_POS_SUBNORMAL
+	case negative:
+		return FCLASS_NEG_NORMAL
+	default:
+		return FCLASS_POS_NORMAL
+	}
+}
+
+// Returns the exact magnitude of a finite value as a rational number
+func (f floatFormat) magnitude(value uint64) *big.Rat {
+	exponent := int((value & f.exponentMask()) >> (f.precision - 1))
+	significand := value & f.fractionMask()
+	if exponent == 0 {
+		// Subnormals share the exponent of the smallest normal number but lack the implicit bit
+		exponent = 1
+	} else {
+		significand |= 1 << (f.precision - 1)
+	}
+
+	// The value is significand * 2^(exponent - bias - (precision - 1))
+	shift := exponent - f.bias - int(f.precision-1)
+	numerator := new(big.Int).SetUint64(significand)
+	denominator := big.NewInt(1)
+	if shift >= 0 {
+		numerator.Lsh(numerator, uint(shift))
+	} else {
+		denominator.Lsh(denominator, uint(-shift))
+	}
+	return new(big.Rat).SetFrac(numerator, denominator)
+}
+
+// Returns the exact signed value of a finite value as a rational number
+func (f floatFormat) rational(value uint64) *big.Rat {
+	r := f.magnitude(value)
+	if f.isNegative(value) {
+		r.Neg(r)
+	}
+	return r
+}
+
+// Returns floor(log2(numerator / denominator)) for positive integers
+func floorLog2(numerator *big.Int, denominator *big.Int) int {
+	e := numerator.BitLen() - denominator.BitLen()
+	n := new(big.Int).Set(numerator)
+	d := new(big.Int).Set(denominator)
+	if e >= 0 {
+		d.Lsh(d, uint(e))
+	} else {
+		n.Lsh(n, uint(-e))
+	}
+	if n.Cmp(d) < 0 {
+		e--
+	}
+	return e
+}
+
+// Computes floor(numerator / denominator * 2^shift), reporting how the discarded fraction compares to one half
+func scaleFloor(numerator *big.Int, denominator *big.Int, shift int) (quotient *big.Int, half int, inexact bool) {
+	n := new(big.Int).Set(numerator)
+	d := new(big.Int).Set(denominator)
+	if shift >= 0 {
+		n.Lsh(n, uint(shift))
+	} else {
+		d.Lsh(d, uint(-shift))
+	}
+	quotient, remainder := new(big.Int).QuoRem(n, d, new(big.Int))
+	half = remainder.Lsh(remainder, 1).Cmp(d)
+	return quotient, half, remainder.Sign() != 0
+}
+
+// Rounds a truncated magnitude to an integer according to the rounding mode
+func roundMagnitude(quotient *big.Int, half int, inexact bool, negative bool, mode uint8) *big.Int {
+	var increment bool
+	switch mode {
+	case RM_RNE:
+		increment = half > 0 || (half == 0 && quotient.Bit(0) == 1)
+	case RM_RTZ:
+		increment = false
+	case RM_RDN:
+		increment = inexact && negative
+	case RM_RUP:
+		increment = inexact && !negative
+	case RM_RMM:
+		increment = half >= 0
+	}
+	if increment {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	return quotient
+}
+
+// Rounds an exact magnitude to the format, returning the encoded value and the raised exception flags
+func (f floatFormat) round(negative bool, value *big.Rat, mode uint8) (uint64, uint8) {
+	sign := f.signed(negative, 0)
+	if value.Sign() == 0 {
+		return sign, 0
+	}
+
+	numerator := new(big.Int).Abs(value.Num())
+	denominator := value.Denom()
+	precision := int(f.precision)
+
+	// Round as if the exponent range were unbounded
+	exponent := floorLog2(numerator, denominator)
+	significand, half, inexact := scaleFloor(numerator, denominator, precision-1-exponent)
+	significand = roundMagnitude(significand, half, inexact, negative, mode)
+	if significand.BitLen() > precision {
+		// Rounding carried into the next binade
+		exponent++
+	}
+
+	if exponent > f.emax() {
+		return f.overflow(negative, mode), FFLAG_OF | FFLAG_NX
+	}
+
+	if exponent < f.emin() {
+		// Tiny after rounding, so round again to the fixed precision of the subnormal range
+		significand, half, inexact = scaleFloor(numerator, denominator, precision-1-f.emin())
+		significand = roundMagnitude(significand, half, inexact, negative, mode)
+		if inexact {
+			return sign | significand.Uint64(), FFLAG_UF | FFLAG_NX
+		}
+		return sign | significand.Uint64(), 0
+	}
+
+	var flags uint8
+	if inexact {
+		flags = FFLAG_NX
+	}
+	biased := uint64(exponent+f.bias) << (f.precision - 1)
+	return sign | biased | significand.Uint64()&f.fractionMask(), flags
+}
+
+// Returns the result of an overflowing operation, which is infinity or the largest finite value depending on the rounding mode
+func (f floatFormat) overflow(negative bool, mode uint8) uint64 {
+	switch {
+	case mode == RM_RTZ, mode == RM_RDN && !negative, mode == RM_RUP && negative:
+		return f.signed(negative, f.maxFinite())
+	default:
+		return f.signed(negative, f.infinity())
+	}
+}
+
+// Returns the canonical NaN and flags for an operation with NaN operands, raising invalid for signaling NaNs
+func (f floatFormat) propagateNaN(operands ...uint64) (uint64, uint8) {
+	var flags uint8
+	for _, operand := range operands {
+		if f.isSignalingNaN(operand) {
+			flags |= FFLAG_NV
+		}
+	}
+	return f.canonicalNaN(), flags
+}
+
+// Returns whether any of the operands is a NaN
+func (f floatFormat) anyNaN(operands ...uint64) bool {
+	for _, operand := range operands {
+		if f.isNaN(operand) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the correctly rounded sum of two values
+func (f floatFormat) add(a uint64, b uint64, mode uint8) (uint64, uint8) {
+	if f.anyNaN(a, b) {
+		return f.propagateNaN(a, b)
+	}
+	if f.isInf(a) && f.isInf(b) && f.isNegative(a) != f.isNegative(b) {
+		return f.canonicalNaN(), FFLAG_NV
+	}
+	if f.isInf(a) {
+		return a, 0
+	}
+	if f.isInf(b) {
+		return b, 0
+	}
+	if result, flags, ok := f.nativeAdd(a, b, mode); ok {
+		return result, flags
+	}
+	return f.roundSum(f.rational(a), f.isNegative(a), f.rational(b), f.isNegative(b), mode)
+}
+
+// Rounds the exact sum of two finite values, applying the IEEE 754 rules for the sign of a zero sum
+func (f floatFormat) roundSum(a *big.Rat, aNegative bool, b *big.Rat, bNegative bool, mode uint8) (uint64, uint8) {
+	sum := new(big.Rat).Add(a, b)
+	if sum.Sign() == 0 {
+		if aNegative == bNegative {
+			return f.signed(aNegative, 0), 0
+		}
+		return f.signed(mode == RM_RDN, 0), 0
+	}
+	negative := sum.Sign() < 0
+	return f.round(negative, sum.Abs(sum), mode)
+}
+
+// Returns the correctly rounded difference of two values
+func (f floatFormat) sub(a uint64, b uint64, mode uint8) (uint64, uint8) {
+	if f.isNaN(b) {
+		return f.propagateNaN(a, b)
+	}
+	return f.add(a, f.negate(b), mode)
+}
+
+// Returns the correctly rounded product of two values
+func (f floatFormat) mul(a uint64, b uint64, mode uint8) (uint64, uint8) {
+	if f.anyNaN(a, b) {
+		return f.propagateNaN(a, b)
+	}
+	negative := f.isNegative(a) != f.isNegative(b)
+	if (f.isInf(a) && f.isZero(b)) || (f.isZero(a) && f.isInf(b)) {
+		return f.canonicalNaN(), FFLAG_NV
+	}
+	if f.isInf(a) || f.isInf(b) {
+		return f.signed(negative, f.infinity()), 0
+	}
+	if result, flags, ok := f.nativeMul(a, b, mode); ok {
+		return result, flags
+	}
+	product := new(big.Rat).Mul(f.magnitude(a), f.magnitude(b))
+	return f.round(negative, product, mode)
+}
+
+// Returns the correctly rounded quotient of two values
+func (f floatFormat) div(a uint64, b uint64, mode uint8) (uint64, uint8) {
+	if f.anyNaN(a, b) {
+		return f.propagateNaN(a, b)
+	}
+	negative := f.isNegative(a) != f.isNegative(b)
+	switch {
+	case f.isInf(a) && f.isInf(b), f.isZero(a) && f.isZero(b):
+		return f.canonicalNaN(), FFLAG_NV
+	case f.isInf(a):
+		return f.signed(negative, f.infinity()), 0
+	case f.isInf(b):
+		return f.signed(negative, 0), 0
+	case f.isZero(b):
+		return f.signed(negative, f.infinity()), FFLAG_DZ
+	}
+	if result, flags, ok := f.nativeDiv(a, b, mode); ok {
+		return result, flags
+	}
+	quotient := new(big.Rat).Quo(f.magnitude(a), f.magnitude(b))
+	return f.round(negative, quotient, mode)
+}
+
+// Returns the correctly rounded square root of a value
+func (f floatFormat) sqrt(a uint64, mode uint8) (uint64, uint8) {
+	switch {
+	case f.isNaN(a):
+		return f.propagateNaN(a)
+	case f.isZero(a):
+		return a, 0
+	case f.isNegative(a):
+		return f.canonicalNaN(), FFLAG_NV
+	case f.isInf(a):
+		return a, 0
+	}
+	if result, flags, ok := f.nativeSqrt(a, mode); ok {
+		return result, flags
+	}
+	value := f.magnitude(a)
+	numerator := value.Num()
+	denominator := value.Denom()
+
+	// Scale the radicand by 4^k so that its integer square root carries a few more bits than the format
+	k := (2*(int(f.precision)+3)-(numerator.BitLen()-denominator.BitLen()))/2 + 1
+	radicand, _, inexact := scaleFloor(numerator, denominator, 2*k)
+	root := new(big.Int).Sqrt(radicand)
+	if new(big.Int).Mul(root, root).Cmp(radicand) != 0 {
+		inexact = true
+	}
+
+	// An inexact root gets a sticky bit below its last bit, which rounds the same way as the true root
+	if inexact {
+		root.Lsh(root, 1).Add(root, big.NewInt(1))
+		k++
+	}
+
+	// The square root of the original value is root / 2^k
+	scale := big.NewInt(1)
+	if k >= 0 {
+		scale.Lsh(scale, uint(k))
+	} else {
+		root.Lsh(root, uint(-k))
+	}
+	return f.round(false, new(big.Rat).SetFrac(root, scale), mode)
+}
+
+// Returns the correctly rounded fused multiply-add a*b+c, negating the product and addend as requested
+func (f floatFormat) fusedMultiplyAdd(a uint64, b uint64, c uint64, negateProduct bool, negateAddend bool, mode uint8) (uint64, uint8) {
+	invalidProduct := (f.isInf(a) && f.isZero(b)) || (f.isZero(a) && f.isInf(b))
+	if f.anyNaN(a, b, c) {
+		result, flags := f.propagateNaN(a, b, c)
+		if invalidProduct {
+			flags |= FFLAG_NV
+		}
+		return result, flags
+	}
+	if invalidProduct {
+		return f.canonicalNaN(), FFLAG_NV
+	}
+
+	productNegative := f.isNegative(a) != f.isNegative(b) != negateProduct
+	if negateAddend {
+		c = f.negate(c)
+	}
+	if f.isInf(a) || f.isInf(b) {
+		if f.isInf(c) && f.isNegative(c) != productNegative {
+			return f.canonicalNaN(), FFLAG_NV
+		}
+		return f.signed(productNegative, f.infinity()), 0
+	}
+	if f.isInf(c) {
+		return c, 0
+	}
+
+	product := new(big.Rat).Mul(f.magnitude(a), f.magnitude(b))
+	if productNegative {
+		product.Neg(product)
+	}
+	return f.roundSum(product, productNegative, f.rational(c), f.isNegative(c), mode)
+}
+
+// Returns the ordering key of a non-NaN value, under which -0 and +0 compare equal
+func (f floatFormat) orderKey(value uint64) int64 {
+	magnitude := int64(value &^ f.signBit())
+	if f.isNegative(value) {
+		return -magnitude
+	}
+	return magnitude
+}
+
+// Returns the minimum or maximum of two values, treating -0 as less than +0 and preferring numbers over NaNs
+func (f floatFormat) minMax(a uint64, b uint64, maximum bool) (uint64, uint8) {
+	var flags uint8
+	if f.isSignalingNaN(a) || f.isSignalingNaN(b) {
+		flags = FFLAG_NV
+	}
+	switch {
+	case f.isNaN(a) && f.isNaN(b):
+		return f.canonicalNaN(), flags
+	case f.isNaN(a):
+		return b, flags
+	case f.isNaN(b):
+		return a, flags
+	}
+
+	aLess := f.orderKey(a) < f.orderKey(b) || (f.orderKey(a) == f.orderKey(b) && f.isNegative(a))
+	if aLess != maximum {
+		return a, flags
+	}
+	return b, flags
+}
+
+// Returns whether two values are equal, raising invalid only for signaling NaNs
+func (f floatFormat) equal(a uint64, b uint64) (bool, uint8) {
+	if f.anyNaN(a, b) {
+		_, flags := f.propagateNaN(a, b)
+		return false, flags
+	}
+	return f.orderKey(a) == f.orderKey(b), 0
+}
+
+// Returns whether a is less than b, or less than or equal when orEqual is set, raising invalid for any NaN
+func (f floatFormat) less(a uint64, b uint64, orEqual bool) (bool, uint8) {
+	if f.anyNaN(a, b) {
+		return false, FFLAG_NV
+	}
+	if orEqual {
+		return f.orderKey(a) <= f.orderKey(b), 0
+	}
+	return f.orderKey(a) < f.orderKey(b), 0
+}
+
+// Converts a value to a 32-bit integer, saturating out-of-range values and NaNs
+func (f floatFormat) toInt(a uint64, signed bool, mode uint8) (uint32, uint8) {
+	// The results for NaN and out-of-range values
+	var maximum, minimum uint64 = 1<<32 - 1, 0
+	if signed {
+		maximum, minimum = 1<<31-1, 1<<31
+	}
+
+	negative := f.isNegative(a)
+	switch {
+	case f.isNaN(a):
+		return uint32(maximum), FFLAG_NV
+	case f.isInf(a) && negative:
+		return uint32(minimum), FFLAG_NV
+	case f.isInf(a):
+		return uint32(maximum), FFLAG_NV
+	}
+
+	value := f.magnitude(a)
+	integer, half, inexact := scaleFloor(value.Num(), value.Denom(), 0)
+	integer = roundMagnitude(integer, half, inexact, negative, mode)
+
+	// Check the rounded magnitude against the range of the destination
+	limit := maximum
+	if negative {
+		limit = minimum
+		if !signed {
+			limit = 0
+		}
+	}
+	if !integer.IsUint64() || integer.Uint64() > limit {
+		if negative {
+			return uint32(minimum), FFLAG_NV
+		}
+		return uint32(maximum), FFLAG_NV
+	}
+
+	var flags uint8
+	if inexact {
+		flags = FFLAG_NX
+	}
+	result := uint32(integer.Uint64())
+	if negative {
+		result = -result
+	}
+	return result, flags
+}
+
+// Converts a 32-bit integer to the format
+func (f floatFormat) fromInt(value uint32, signed bool, mode uint8) (uint64, uint8) {
+	integer := new(big.Int).SetUint64(uint64(value))
+	negative := signed && int32(value) < 0
+	if negative {
+		integer.SetInt64(-int64(int32(value)))
+	}
+	return f.round(negative, new(big.Rat).SetInt(integer), mode)
+}
+
+// Converts a value from another format into this one
+func (f floatFormat) convert(a uint64, from floatFormat, mode uint8) (uint64, uint8) {
+	negative := from.isNegative(a)
+	switch {
+	case from.isSignalingNaN(a):
+		return f.canonicalNaN(), FFLAG_NV
+	case from.isNaN(a):
+		return f.canonicalNaN(), 0
+	case from.isInf(a):
+		return f.signed(negative, f.infinity()), 0
+	}
+	return f.round(negative, from.magnitude(a), mode)
+}
+
+// The hardware arithmetic of the host rounds to nearest-even and is used as a fast path when it is.
+// Results are accepted only when comfortably inside the normal range, where error-free transformations
+// computed with fused multiply-add report exactly whether the operation was inexact.
+
+// Returns a value of the format as a host double, which is always exact
+func (f floatFormat) toNative(value uint64) float64 {
+	if f == FLOAT32 {
+		return float64(math.Float32frombits(uint32(value)))
+	}
+	return math.Float64frombits(value)
+}
+
+// Rounds a host double to the format, returning the encoded value and the value it represents
+func (f floatFormat) fromNative(value float64) (uint64, float64) {
+	if f == FLOAT32 {
+		rounded := float32(value)
+		return uint64(math.Float32bits(rounded)), float64(rounded)
+	}
+	return math.Float64bits(value), value
+}
+
+// Returns whether a result is far enough from underflow and overflow for the fast path to be exact about flags
+func (f floatFormat) nativeSafe(value float64) bool {
+	magnitude := math.Abs(value)
+	if f == FLOAT32 {
+		return magnitude >= 0x1p-126 && magnitude <= math.MaxFloat32
+	}
+	return magnitude >= 0x1p-969 && magnitude <= math.MaxFloat64
+}
+
+// Returns the inexact flag if set
+func inexactFlag(inexact bool) uint8 {
+	if inexact {
+		return FFLAG_NX
+	}
+	return 0
+}
+
+// Computes a round-to-nearest-even sum natively, reporting whether the fast path applied
+func (f floatFormat) nativeAdd(a uint64, b uint64, mode uint8) (uint64, uint8, bool) {
+	x, y := f.toNative(a), f.toNative(b)
+	sum := x + y
+	if mode != RM_RNE || !f.nativeSafe(sum) {
+		return 0, 0, false
+	}
+	// The rounding error of the host sum, computed exactly by the two-sum algorithm
+	virtual := sum - x
+	roundingError := (x - (sum - virtual)) + (y - virtual)
+	result, rounded := f.fromNative(sum)
+	if !f.nativeSafe(rounded) {
+		return 0, 0, false
+	}
+	return result, inexactFlag(roundingError != 0 || rounded != sum), true
+}
+
+// Computes a round-to-nearest-even product natively, reporting whether the fast path applied
+func (f floatFormat) nativeMul(a uint64, b uint64, mode uint8) (uint64, uint8, bool) {
+	x, y := f.toNative(a), f.toNative(b)
+	product := x * y
+	if mode != RM_RNE || !f.nativeSafe(product) {
+		return 0, 0, false
+	}
+	roundingError := math.FMA(x, y, -product)
+	result, rounded := f.fromNative(product)
+	if !f.nativeSafe(rounded) {
+		return 0, 0, false
+	}
+	return result, inexactFlag(roundingError != 0 || rounded != product), true
+}
+
+// Computes a round-to-nearest-even quotient natively, reporting whether the fast path applied
+func (f floatFormat) nativeDiv(a uint64, b uint64, mode uint8) (uint64, uint8, bool) {
+	x, y := f.toNative(a), f.toNative(b)
+	quotient := x / y
+	if mode != RM_RNE || !f.nativeSafe(x) || !f.nativeSafe(quotient) {
+		return 0, 0, false
+	}
+	result, rounded := f.fromNative(quotient)
+	if !f.nativeSafe(rounded) {
+		return 0, 0, false
+	}
+	// The quotient is exact when multiplying it back leaves no remainder
+	return result, inexactFlag(math.FMA(-rounded, y, x) != 0), true
+}
+
+// Computes a round-to-nearest-even square root natively, reporting whether the fast path applied
+func (f floatFormat) nativeSqrt(a uint64, mode uint8) (uint64, uint8, bool) {
+	x := f.toNative(a)
+	if mode != RM_RNE || !f.nativeSafe(x) {
+		return 0, 0, false
+	}
+	result, rounded := f.fromNative(math.Sqrt(x))
+	return result, inexactFlag(math.FMA(-rounded, rounded, x) != 0), true
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// Returns the encoding of a single-precision value
+func single(value float32) uint64 {
+	return uint64(math.Float32bits(value))
+}
+
+// Returns the encoding of a double-precision value
+func double(value float64) uint64 {
+	return math.Float64bits(value)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []struct {
+		name   string
+		f      floatFormat
+		op     func(f floatFormat, a uint64, b uint64, mode uint8) (uint64, uint8)
+		a, b   uint64
+		mode   uint8
+		result uint64
+		flags  uint8
+	}{
+		{"exact sum", FLOAT32, floatFormat.add, single(1.5), single(2.25), RM_RNE, single(3.75), 0},
+		{"inexact sum", FLOAT64, floatFormat.add, double(0.1), double(0.2), RM_RNE, 0x3fd3333333333334, FFLAG_NX},
+		{"round towards zero", FLOAT32, floatFormat.div, single(1), single(3), RM_RTZ, 0x3eaaaaaa, FFLAG_NX},
+		{"round up", FLOAT32, floatFormat.div, single(1), single(3), RM_RUP, 0x3eaaaaab, FFLAG_NX},
+		{"exact cancellation rounding down", FLOAT64, floatFormat.sub, double(1), double(1), RM_RDN, double(math.Copysign(0, -1)), 0},
+		{"overflow to infinity", FLOAT32, floatFormat.mul, single(math.MaxFloat32), single(2), RM_RNE, single(float32(math.Inf(1))), FFLAG_OF | FFLAG_NX},
+		{"overflow towards zero", FLOAT32, floatFormat.mul, single(math.MaxFloat32), single(2), RM_RTZ, single(math.MaxFloat32), FFLAG_OF | FFLAG_NX},
+		{"underflow", FLOAT64, floatFormat.div, double(0x1p-1070), double(3), RM_RNE, 0x5, FFLAG_UF | FFLAG_NX},
+		{"divide by zero", FLOAT64, floatFormat.div, double(-1), double(0), RM_RNE, double(math.Inf(-1)), FFLAG_DZ},
+		{"invalid", FLOAT64, floatFormat.sub, double(math.Inf(1)), double(math.Inf(1)), RM_RNE, FLOAT64.canonicalNaN(), FFLAG_NV},
+		{"signaling nan", FLOAT32, floatFormat.add, 0x7f800001, single(1), RM_RNE, FLOAT32.canonicalNaN(), FFLAG_NV},
+	}
+
+	for _, test := range tests {
+		result, flags := test.op(test.f, test.a, test.b, test.mode)
+		if result != test.result || flags != test.flags {
+			t.Errorf("%s: got %#x flags %05b, want %#x flags %05b", test.name, result, flags, test.result, test.flags)
+		}
+	}
+}
+
+func TestFloatMinMax(t *testing.T) {
+	negativeZero := single(float32(math.Copysign(0, -1)))
+	if result, _ := FLOAT32.minMax(single(0), negativeZero, false); result != negativeZero {
+		t.Errorf("fmin(0, -0) = %#x, want -0", result)
+	}
+	if result, _ := FLOAT32.minMax(negativeZero, single(0), true); result != single(0) {
+		t.Errorf("fmax(-0, 0) = %#x, want 0", result)
+	}
+	if result, flags := FLOAT32.minMax(FLOAT32.canonicalNaN(), single(2), false); result != single(2) || flags != 0 {
+		t.Errorf("fmin(qNaN, 2) = %#x flags %05b, want 2 with no flags", result, flags)
+	}
+	if result, flags := FLOAT32.minMax(0x7f800001, 0x7f800001, true); result != FLOAT32.canonicalNaN() || flags != FFLAG_NV {
+		t.Errorf("fmax(sNaN, sNaN) = %#x flags %05b, want the canonical NaN and NV", result, flags)
+	}
+}
+
+func TestFloatToInt(t *testing.T) {
+	tests := []struct {
+		a      uint64
+		signed bool
+		mode   uint8
+		result uint32
+		flags  uint8
+	}{
+		{double(-2.5), true, RM_RNE, 0xfffffffe, FFLAG_NX},
+		{double(-2.5), true, RM_RMM, 0xfffffffd, FFLAG_NX},
+		{double(3e9), true, RM_RNE, 0x7fffffff, FFLAG_NV},
+		{double(3e9), false, RM_RNE, 3000000000, 0},
+		{double(-1), false, RM_RNE, 0, FFLAG_NV},
+		{double(-0.5), false, RM_RTZ, 0, FFLAG_NX},
+		{FLOAT64.canonicalNaN(), true, RM_RNE, 0x7fffffff, FFLAG_NV},
+	}
+
+	for _, test := range tests {
+		result, flags := FLOAT64.toInt(test.a, test.signed, test.mode)
+		if result != test.result || flags != test.flags {
+			t.Errorf("toInt(%#x, %v, %d) = %#x flags %05b, want %#x flags %05b", test.a, test.signed, test.mode,
+				result, flags, test.result, test.flags)
+		}
+	}
+}
+
+func TestFloatNaNBoxing(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100)
+	cpu.writeFloat(1, FLOAT32, single(1.5))
+	if cpu.fregisters[1] != NAN_BOX_UPPER|single(1.5) {
+		t.Errorf("boxed value = %#x, want the upper half set to ones", cpu.fregisters[1])
+	}
+	if value := cpu.readFloat(1, FLOAT32); value != single(1.5) {
+		t.Errorf("readFloat of a boxed value = %#x, want %#x", value, single(1.5))
+	}
+	cpu.fregisters[2] = double(1.5)
+	if value := cpu.readFloat(2, FLOAT32); value != FLOAT32.canonicalNaN() {
+		t.Errorf("readFloat of an unboxed value = %#x, want the canonical NaN", value)
+	}
+}
+
+func TestFloatDoubleAccess(t *testing.T) {
+	// fsd fa0, 0xf8(zero); fld fa1, 0xf8(zero); fsd fa0, 0xfc(zero), which runs past the end of memory
+	cpu := newTestCPU(t, 0, 0x100, 0x0ea03c27, 0x0f803587, 0x0ea03e27)
+	cpu.fregisters[10] = 0x1122_3344_5566_7788
+
+	// Each is a single 8-byte access
+	record, err := cpu.StepCommit()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (MemoryAccess{0xf8, BYTES_PER_DOUBLE, 0x1122_3344_5566_7788}); len(record.stores) != 1 || record.stores[0] != want {
+		t.Errorf("stores = %v, want [%v]", record.stores, want)
+	}
+	if record, err = cpu.StepCommit(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (MemoryAccess{0xf8, BYTES_PER_DOUBLE, 0x1122_3344_5566_7788}); len(record.loads) != 1 || record.loads[0] != want {
+		t.Errorf("loads = %v, want [%v]", record.loads, want)
+	}
+	if cpu.fregisters[11] != 0x1122_3344_5566_7788 {
+		t.Errorf("fa1 = %#x, want 0x1122334455667788", cpu.fregisters[11])
+	}
+
+	// A store that faults in its upper half writes nothing
+	err = cpu.Step()
+	var trap *Trap
+	if !errors.As(err, &trap) || trap.cause != CAUSE_STORE_ACCESS_FAULT {
+		t.Fatalf("Step() error = %v, want a store access fault", err)
+	}
+	if word, _ := cpu.bus.Read(0xfc, BYTES_PER_WORD); word != 0x1122_3344 {
+		t.Errorf("word = %08x, want the 11223344 stored before", word)
+	}
+}
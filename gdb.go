@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Signals reported to GDB when the program stops
+const (
+	GDB_SIGINT  = 2  // Interrupted by the user
+	GDB_SIGILL  = 4  // Unhandled illegal instruction
+	GDB_SIGTRAP = 5  // Breakpoint or single step
+	GDB_SIGSEGV = 11 // Unhandled access fault or misaligned access
+)
+
+// Numbers of the registers in the g, p and P packets, following GDB's RISC-V numbering
+const (
+	GDB_REG_PC     = 32 // Follows x0 to x31
+	GDB_REG_F0     = 33 // f0 to f31, when the F extension is enabled
+	GDB_REG_FFLAGS = 66 // Floating-point CSRs, numbered 65 plus their address
+	GDB_REG_FRM    = 67
+	GDB_REG_FCSR   = 68
+)
+
+const (
+	GDB_INTERRUPT     byte = 0x03 // Sent by GDB outside of packets to stop the running program
+	GDB_ESCAPE        byte = '}'  // Escapes special characters in binary data
+	GDB_PACKET_SIZE        = 0x1000
+	GDB_POLL_INTERVAL      = 0x1000 // Instructions executed between checks for an interrupt
+)
+
+// Kinds of breakpoint set by the Z packets, kept as a mask per address
+const (
+	GDB_SOFTWARE_BREAKPOINT uint8 = 1 << iota
+	GDB_HARDWARE_BREAKPOINT
+)
+
+// Serves the GDB remote serial protocol over a connection, letting GDB control the CPU
+type GDBServer struct {
+	cpu         *CPU
+	conn        io.ReadWriter
+	mutex       sync.Mutex       // Serializes writes of replies and acknowledgements
+	noAck       atomic.Bool      // Whether GDB asked to stop acknowledging packets
+	packets     chan string      // Packets received from GDB, closed when the connection is
+	done        chan struct{}    // Closed once Serve returns, after which packets are no longer read
+	interrupts  chan struct{}    // Interrupts received from GDB while the program runs
+	breakpoints map[uint32]uint8 // Kinds of breakpoint set at each address
+	stop        string           // Reply describing why the program last stopped
+}
+
+// Listens for GDB on a TCP address given as host:port, or on a Unix socket given as unix:path
+func ListenGDB(address string) (net.Listener, error) {
+	if path, found := strings.CutPrefix(address, "unix:"); found {
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", address)
+}
+
+// Constructor to initialize a server controlling the CPU through the connection
+func NewGDBServer(cpu *CPU, conn io.ReadWriter) *GDBServer {
+	server := &GDBServer{
+		cpu:         cpu,
+		conn:        conn,
+		packets:     make(chan string),
+		done:        make(chan struct{}),
+		interrupts:  make(chan struct{}, 1),
+		breakpoints: make(map[uint32]uint8),
+		stop:        fmt.Sprintf("S%02x", GDB_SIGTRAP),
+	}
+	go server.receive()
+	return server
+}
+
+// Answers packets until GDB detaches, kills the program or disconnects.
+// Returns whether the program should keep running without the debugger.
+func (server *GDBServer) Serve() (bool, error) {
+	defer close(server.done)
+	for packet := range server.packets {
+		switch packet[0] {
+		case 'D':
+			return true, server.reply("OK")
+		case 'k':
+			return false, nil
+		}
+		if err := server.reply(server.handle(packet)); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Reads packets and interrupts from the connection, acknowledging packets unless asked not to
+func (server *GDBServer) receive() {
+	defer close(server.packets)
+	reader := bufio.NewReader(server.conn)
+	for {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		switch c {
+		case GDB_INTERRUPT:
+			select {
+			case server.interrupts <- struct{}{}:
+			default:
+			}
+			continue
+		case '$':
+		default:
+			// Acknowledgements of the replies are not needed over a reliable connection
+			continue
+		}
+
+		data, err := reader.ReadString('#')
+		if err != nil {
+			return
+		}
+		data = data[:len(data)-1]
+		sum := make([]byte, 2)
+		if _, err := io.ReadFull(reader, sum); err != nil {
+			return
+		}
+		if !server.noAck.Load() {
+			ack := "+"
+			if expected, err := strconv.ParseUint(string(sum), 16, 8); err != nil || uint8(expected) != checksum([]byte(data)) {
+				ack = "-"
+			}
+			if server.write(ack) != nil {
+				return
+			}
+			if ack == "-" {
+				continue
+			}
+		}
+		if data == "" {
+			continue
+		}
+		// Packets that arrive once Serve has returned have no one to answer them
+		select {
+		case <-server.done:
+			return
+		default:
+		}
+		select {
+		case server.packets <- data:
+		case <-server.done:
+			return
+		}
+	}
+}
+
+// Sends a reply framed as a packet
+func (server *GDBServer) reply(data string) error {
+	return server.write(fmt.Sprintf("$%s#%02x", data, checksum([]byte(data))))
+}
+
+// Writes data to the connection, serialized with the other writes
+func (server *GDBServer) write(data string) error {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	_, err := io.WriteString(server.conn, data)
+	return err
+}
+
+// Returns the reply to a packet, which is empty for packets that are not supported
+func (server *GDBServer) handle(packet string) string {
+	command, args := packet[0], packet[1:]
+	switch command {
+	case '?':
+		return server.stop
+	case 'g':
+		var registers strings.Builder
+		for reg := 0; reg <= GDB_REG_PC; reg++ {
+			value, _ := server.readRegister(reg)
+			registers.WriteString(value)
+		}
+		return registers.String()
+	case 'G':
+		for reg := 0; reg <= GDB_REG_PC && len(args) >= 8; reg++ {
+			if !server.writeRegister(reg, args[:8]) {
+				return "E01"
+			}
+			args = args[8:]
+		}
+		return "OK"
+	case 'p':
+		reg, err := strconv.ParseUint(args, 16, 32)
+		if err != nil {
+			return "E01"
+		}
+		if value, ok := server.readRegister(int(reg)); ok {
+			return value
+		}
+		return "E01"
+	case 'P':
+		number, value, _ := strings.Cut(args, "=")
+		reg, err := strconv.ParseUint(number, 16, 32)
+		if err != nil || !server.writeRegister(int(reg), value) {
+			return "E01"
+		}
+		return "OK"
+	case 'm':
+		addr, length, err := parseRange(args)
+		if err != nil || length > GDB_PACKET_SIZE/2 {
+			return "E01"
+		}
+		data := make([]byte, length)
+		for i := range data {
+			value, err := server.cpu.bus.Read(addr+uint32(i), 1)
+			if err != nil {
+				return "E02"
+			}
+			data[i] = uint8(value)
+		}
+		return hex.EncodeToString(data)
+	case 'M':
+		span, encoded, _ := strings.Cut(args, ":")
+		addr, length, err := parseRange(span)
+		data, decodeErr := hex.DecodeString(encoded)
+		if err != nil || decodeErr != nil || uint32(len(data)) != length {
+			return "E01"
+		}
+		for i, value := range data {
+			if server.cpu.bus.Write(addr+uint32(i), 1, uint32(value)) != nil {
+				return "E02"
+			}
+		}
+		return "OK"
+	case 'c', 's':
+		if args != "" {
+			addr, err := strconv.ParseUint(args, 16, 32)
+			if err != nil {
+				return "E01"
+			}
+			server.cpu.pc = uint32(addr)
+		}
+		server.stop = server.resume(command == 's')
+		return server.stop
+	case 'Z', 'z':
+		return server.breakpoint(command == 'Z', args)
+	case 'H', 'T':
+		// There is a single thread
+		return "OK"
+	case 'q', 'Q':
+		return server.query(packet)
+	}
+	return ""
+}
+
+// Answers the general query packets
+func (server *GDBServer) query(packet string) string {
+	name, args, _ := strings.Cut(packet, ":")
+	switch name {
+	case "qSupported":
+		return fmt.Sprintf("PacketSize=%x;qXfer:features:read+;swbreak+;hwbreak+;QStartNoAckMode+", GDB_PACKET_SIZE)
+	case "QStartNoAckMode":
+		server.noAck.Store(true)
+		return "OK"
+	case "qAttached":
+		return "1"
+	case "qC":
+		return "QC1"
+	case "qfThreadInfo":
+		return "m1"
+	case "qsThreadInfo":
+		return "l"
+	case "qXfer":
+		// qXfer:features:read:target.xml:offset,length
+		object, annex, _ := strings.Cut(args, ":read:")
+		file, span, _ := strings.Cut(annex, ":")
+		offset, length, err := parseRange(span)
+		if object != "features" || file != "target.xml" || err != nil {
+			return "E00"
+		}
+		description := server.targetDescription()
+		if offset >= uint32(len(description)) {
+			return "l"
+		}
+		chunk := description[offset:]
+		if uint32(len(chunk)) > length {
+			return "m" + escape(chunk[:length])
+		}
+		return "l" + escape(chunk)
+	}
+	return ""
+}
+
+// Sets or clears a software (Z0) or hardware (Z1) breakpoint. Watchpoints are not supported.
+func (server *GDBServer) breakpoint(set bool, args string) string {
+	fields := strings.Split(args, ",")
+	if len(fields) < 2 {
+		return "E01"
+	}
+	var kind uint8
+	switch fields[0] {
+	case "0":
+		kind = GDB_SOFTWARE_BREAKPOINT
+	case "1":
+		kind = GDB_HARDWARE_BREAKPOINT
+	default:
+		return ""
+	}
+	addr, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return "E01"
+	}
+	kinds := server.breakpoints[uint32(addr)] &^ kind
+	if set {
+		kinds |= kind
+	}
+	if kinds == 0 {
+		delete(server.breakpoints, uint32(addr))
+	} else {
+		server.breakpoints[uint32(addr)] = kinds
+	}
+	return "OK"
+}
+
+// Runs the program until it hits a breakpoint, stops or is interrupted, or for a single
+// instruction, and returns the stop reply. The instruction at the current address always runs,
+// so continuing from a breakpoint moves past it.
+func (server *GDBServer) resume(step bool) string {
+	cpu := server.cpu
+	for i := 0; ; i++ {
+		if i > 0 {
+			if kind := server.breakpoints[cpu.pc]; kind != 0 {
+				if kind&GDB_SOFTWARE_BREAKPOINT != 0 {
+					return fmt.Sprintf("T%02xswbreak:;", GDB_SIGTRAP)
+				}
+				return fmt.Sprintf("T%02xhwbreak:;", GDB_SIGTRAP)
+			}
+		}
+		if i%GDB_POLL_INTERVAL == 0 {
+			select {
+			case <-server.interrupts:
+				return fmt.Sprintf("S%02x", GDB_SIGINT)
+			default:
+			}
+		}
+
+		err := cpu.Step()
+		if code, exited := cpu.Exited(); exited {
+			return fmt.Sprintf("W%02x", uint8(code))
+		}
+		if err != nil {
+			// Unhandled traps stop at the instruction that raised them
+			cpu.pc = cpu.instructionAddress()
+			return fmt.Sprintf("S%02x", trapSignal(err))
+		}
+		if step {
+			return fmt.Sprintf("S%02x", GDB_SIGTRAP)
+		}
+	}
+}
+
+// Returns the signal GDB is told about for a trap the program did not handle
+func trapSignal(err error) int {
+	var trap *Trap
+	if !errors.As(err, &trap) {
+		return GDB_SIGILL
+	}
+	switch trap.cause {
+	case CAUSE_BREAKPOINT:
+		return GDB_SIGTRAP
+	case CAUSE_ILLEGAL_INSTRUCTION:
+		return GDB_SIGILL
+	}
+	return GDB_SIGSEGV
+}
+
+// Returns the size in bytes of the floating-point registers, or zero without the F extension
+func (server *GDBServer) floatSize() int {
+	switch {
+	case server.cpu.extensions&EXT_D != 0:
+		return 8
+	case server.cpu.extensions&EXT_F != 0:
+		return 4
+	}
+	return 0
+}
+
+// Returns a register as little-endian hex, and whether it exists
+func (server *GDBServer) readRegister(reg int) (string, bool) {
+	cpu := server.cpu
+	var value uint64
+	size := 4
+	switch {
+	case reg < GDB_REG_PC:
+		value = uint64(cpu.registers.Read(uint8(reg)))
+	case reg == GDB_REG_PC:
+		value = uint64(cpu.pc)
+	case server.floatSize() == 0:
+		return "", false
+	case reg >= GDB_REG_F0 && reg < GDB_REG_F0+REG_COUNT:
+		value, size = cpu.fregisters[reg-GDB_REG_F0], server.floatSize()
+	case reg >= GDB_REG_FFLAGS && reg <= GDB_REG_FCSR:
+		csr, _ := cpu.ReadCSR(uint32(reg - GDB_REG_FFLAGS + int(CSR_FFLAGS)))
+		value = uint64(csr)
+	default:
+		return "", false
+	}
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, value)
+	return hex.EncodeToString(data[:size]), true
+}
+
+// Sets a register from little-endian hex, returning whether it exists
+func (server *GDBServer) writeRegister(reg int, encoded string) bool {
+	cpu := server.cpu
+	data, err := hex.DecodeString(encoded)
+	if err != nil || len(data) > 8 {
+		return false
+	}
+	value := binary.LittleEndian.Uint64(append(data, make([]byte, 8-len(data))...))
+	switch {
+	case reg < GDB_REG_PC:
+		cpu.registers.Write(uint8(reg), uint32(value))
+	case reg == GDB_REG_PC:
+		cpu.pc = uint32(value)
+	case server.floatSize() == 0:
+		return false
+	case reg >= GDB_REG_F0 && reg < GDB_REG_F0+REG_COUNT:
+		if server.floatSize() == 4 {
+			// Singles are NaN-boxed in the registers
+			value |= 0xFFFF_FFFF_0000_0000
+		}
+		cpu.fregisters[reg-GDB_REG_F0] = value
+	case reg >= GDB_REG_FFLAGS && reg <= GDB_REG_FCSR:
+		return cpu.WriteCSR(uint32(reg-GDB_REG_FFLAGS+int(CSR_FFLAGS)), uint32(value)) == nil
+	default:
+		return false
+	}
+	return true
+}
+
+// Returns the target description of the CPU, listing its registers
+func (server *GDBServer) targetDescription() string {
+	var xml strings.Builder
+	xml.WriteString(`<?xml version="1.0"?>` + "\n")
+	xml.WriteString(`<!DOCTYPE target SYSTEM "gdb-target.dtd">` + "\n")
+	xml.WriteString("<target version=\"1.0\">\n<architecture>riscv:rv32</architecture>\n")
+	xml.WriteString("<feature name=\"org.gnu.gdb.riscv.cpu\">\n")
+	for reg := uint8(0); reg < REG_COUNT; reg++ {
+		kind := "int"
+		switch reg {
+		case REG_RA:
+			kind = "code_ptr"
+		case REG_SP, REG_GP, REG_TP, REG_S0:
+			kind = "data_ptr"
+		}
+		fmt.Fprintf(&xml, "<reg name=\"%s\" bitsize=\"32\" type=\"%s\" regnum=\"%d\"/>\n", RegisterName(reg), kind, reg)
+	}
+	fmt.Fprintf(&xml, "<reg name=\"pc\" bitsize=\"32\" type=\"code_ptr\" regnum=\"%d\"/>\n</feature>\n", GDB_REG_PC)
+
+	if size := server.floatSize(); size != 0 {
+		kind := "ieee_single"
+		if size == 8 {
+			kind = "ieee_double"
+		}
+		xml.WriteString("<feature name=\"org.gnu.gdb.riscv.fpu\">\n")
+		for reg := 0; reg < REG_COUNT; reg++ {
+			fmt.Fprintf(&xml, "<reg name=\"f%d\" bitsize=\"%d\" type=\"%s\" regnum=\"%d\"/>\n", reg, size*8, kind, GDB_REG_F0+reg)
+		}
+		for reg, name := range []string{"fflags", "frm", "fcsr"} {
+			fmt.Fprintf(&xml, "<reg name=\"%s\" bitsize=\"32\" type=\"int\" regnum=\"%d\"/>\n", name, GDB_REG_FFLAGS+reg)
+		}
+		xml.WriteString("</feature>\n")
+	}
+	xml.WriteString("</target>\n")
+	return xml.String()
+}
+
+// Parses an address and length given in hex as addr,length
+func parseRange(span string) (uint32, uint32, error) {
+	addr, length, found := strings.Cut(span, ",")
+	if !found {
+		return 0, 0, fmt.Errorf("expected addr,length, got %q", span)
+	}
+	start, err := strconv.ParseUint(addr, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := strconv.ParseUint(length, 16, 32)
+	return uint32(start), uint32(size), err
+}
+
+// Escapes the characters with a meaning in packets from binary data
+func escape(data string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '#', '$', GDB_ESCAPE, '*':
+			escaped.WriteByte(GDB_ESCAPE)
+			escaped.WriteByte(c ^ 0x20)
+		default:
+			escaped.WriteByte(c)
+		}
+	}
+	return escaped.String()
+}
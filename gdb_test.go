@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Drives a GDB server through one end of a pipe, as GDB would
+type gdbClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	done   chan bool // Receives whether Serve asked to keep running
+}
+
+// Creates a CPU with the given instructions at address zero and connects a client to a server controlling it
+func newGDBClient(t *testing.T, instructions ...uint32) (*CPU, *gdbClient) {
+	t.Helper()
+	cpu := newTestCPU(t, 0, 0x2000, instructions...)
+	server, conn := net.Pipe()
+	client := &gdbClient{t: t, conn: conn, reader: bufio.NewReader(conn), done: make(chan bool, 1)}
+	go func() {
+		resume, _ := NewGDBServer(cpu, server).Serve()
+		server.Close()
+		client.done <- resume
+	}()
+	t.Cleanup(func() { conn.Close() })
+	return cpu, client
+}
+
+// Sends a packet and returns the reply
+func (client *gdbClient) send(packet string) string {
+	client.t.Helper()
+	fmt.Fprintf(client.conn, "$%s#%02x", packet, checksum([]byte(packet)))
+	return client.receive()
+}
+
+// Returns the next reply, skipping acknowledgements
+func (client *gdbClient) receive() string {
+	client.t.Helper()
+	for {
+		c, err := client.reader.ReadByte()
+		if err != nil {
+			client.t.Fatalf("unexpected error: %v", err)
+		}
+		if c == '$' {
+			break
+		}
+	}
+	reply, err := client.reader.ReadString('#')
+	if err != nil {
+		client.t.Fatalf("unexpected error: %v", err)
+	}
+	sum := make([]byte, 2)
+	if _, err := io.ReadFull(client.reader, sum); err != nil {
+		client.t.Fatalf("unexpected error: %v", err)
+	}
+	reply = reply[:len(reply)-1]
+	if want := fmt.Sprintf("%02x", checksum([]byte(reply))); string(sum) != want {
+		client.t.Errorf("checksum of %q = %s, want %s", reply, sum, want)
+	}
+	return reply
+}
+
+// Sends a packet and checks its reply
+func (client *gdbClient) expect(packet string, want string) {
+	client.t.Helper()
+	if reply := client.send(packet); reply != want {
+		client.t.Errorf("%s: reply = %q, want %q", packet, reply, want)
+	}
+}
+
+func TestGDBRegistersAndMemory(t *testing.T) {
+	cpu, client := newGDBClient(t)
+	client.expect("QStartNoAckMode", "OK")
+	cpu.registers.Write(REG_RA, 0x1234_5678)
+	cpu.pc = 0x100
+
+	registers := client.send("g")
+	if len(registers) != 33*8 || registers[8:16] != "78563412" || registers[32*8:] != "00010000" {
+		t.Errorf("g: reply = %q, want ra = 12345678 and pc = 00000100", registers)
+	}
+	client.expect("p20", "00010000")
+	client.expect("P2=efbeadde", "OK")
+	client.expect("P0=ffffffff", "OK")
+	if sp, zero := cpu.registers.Read(REG_SP), cpu.registers.Read(REG_ZERO); sp != 0xdead_beef || zero != 0 {
+		t.Errorf("sp, zero = %08x, %08x, want deadbeef, 00000000", sp, zero)
+	}
+	client.expect("p21", "0000000000000000")
+	client.expect("P43=01000000", "OK")
+	if frm, _ := cpu.ReadCSR(CSR_FRM); frm != 1 {
+		t.Errorf("frm = %d, want 1", frm)
+	}
+	client.expect("p64", "E01")
+
+	client.expect("M200,4:01020304", "OK")
+	if word, _ := cpu.FetchWord(0x200); word != 0x0403_0201 {
+		t.Errorf("word = %08x, want 04030201", word)
+	}
+	client.expect("m1ff,6", "000102030400")
+	client.expect("mfffffff0,4", "E02")
+}
+
+func TestGDBTargetDescription(t *testing.T) {
+	_, client := newGDBClient(t)
+	client.expect("QStartNoAckMode", "OK")
+	if reply := client.send("qSupported:swbreak+;hwbreak+"); !strings.Contains(reply, "qXfer:features:read+") {
+		t.Errorf("qSupported: reply = %q, want target descriptions supported", reply)
+	}
+
+	// Read the description in small pieces, as GDB does with its packet size
+	var description strings.Builder
+	for offset := 0; ; offset += 0x80 {
+		reply := client.send(fmt.Sprintf("qXfer:features:read:target.xml:%x,80", offset))
+		description.WriteString(reply[1:])
+		if reply[0] == 'l' {
+			break
+		}
+	}
+	for _, want := range []string{"org.gnu.gdb.riscv.cpu", `<reg name="ra" bitsize="32" type="code_ptr" regnum="1"/>`, `<reg name="pc"`, `<reg name="f31" bitsize="64" type="ieee_double" regnum="64"/>`, `regnum="68"`} {
+		if !strings.Contains(description.String(), want) {
+			t.Errorf("target description does not contain %s:\n%s", want, description.String())
+		}
+	}
+}
+
+func TestGDBExecution(t *testing.T) {
+	// addi a0, zero, 1; addi a0, a0, 1; addi a0, a0, 1; jal zero, -4; ebreak
+	cpu, client := newGDBClient(t, 0x00100513, 0x00150513, 0x00150513, 0xffdff06f, 0x00100073)
+	client.expect("QStartNoAckMode", "OK")
+	client.expect("?", "S05")
+	client.expect("s", "S05")
+	if a0 := cpu.registers.Read(REG_A0); cpu.pc != 4 || a0 != 1 {
+		t.Errorf("pc, a0 = %08x, %d, want 00000004, 1", cpu.pc, a0)
+	}
+
+	// The program loops back onto the breakpoint, which continuing moves past
+	client.expect("Z0,8,4", "OK")
+	client.expect("c", "T05swbreak:;")
+	client.expect("c", "T05swbreak:;")
+	if a0 := cpu.registers.Read(REG_A0); cpu.pc != 8 || a0 != 3 {
+		t.Errorf("pc, a0 = %08x, %d, want 00000008, 3", cpu.pc, a0)
+	}
+	client.expect("z0,8,4", "OK")
+	client.expect("Z1,c,4", "OK")
+	client.expect("c", "T05hwbreak:;")
+	client.expect("z1,c,4", "OK")
+	client.expect("Z2,200,4", "")
+
+	// Interrupt the endless loop, then run the ebreak, which stops where it is
+	fmt.Fprintf(client.conn, "$c#63")
+	client.conn.Write([]byte{GDB_INTERRUPT})
+	if reply := client.receive(); reply != "S02" {
+		t.Errorf("c: reply = %q, want S02 once interrupted", reply)
+	}
+	client.expect("?", "S02")
+	client.expect("c10", "S05")
+	if cpu.pc != 0x10 {
+		t.Errorf("pc = %08x, want the ebreak at 00000010", cpu.pc)
+	}
+
+	client.expect("D", "OK")
+	if resume := <-client.done; !resume {
+		t.Error("Serve() = false, want the program to keep running after detaching")
+	}
+}
+
+func TestGDBReceiveStopsAfterServe(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100)
+	end, conn := net.Pipe()
+	defer conn.Close()
+	server := NewGDBServer(cpu, end)
+	client := &gdbClient{t: t, conn: conn, reader: bufio.NewReader(conn), done: make(chan bool, 1)}
+	go func() {
+		resume, _ := server.Serve()
+		client.done <- resume
+	}()
+	client.expect("QStartNoAckMode", "OK")
+	client.expect("D", "OK")
+	<-client.done
+
+	// A packet sent after detaching does not leave the receiver waiting for Serve to take it
+	fmt.Fprintf(conn, "$g#67")
+	if packet, ok := <-server.packets; ok {
+		t.Errorf("received %q after Serve returned, want the receiver stopped", packet)
+	}
+}
+
+func TestGDBProgramExit(t *testing.T) {
+	cpu, client := newGDBClient(t, 0x000012b7, 0x00700393, 0x0072a023, 0x0002a223, 0x0000006f)
+	if err := cpu.AttachHTIF(NewHTIF(nil, nil), 0x1000, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client.expect("QStartNoAckMode", "OK")
+	client.expect("c", "W03")
+	client.conn.Write([]byte("$k#6b"))
+	if resume := <-client.done; resume {
+		t.Error("Serve() = true, want the program stopped after it was killed")
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// Devices and commands of the host-target interface, encoded in the upper bytes of tohost
+const (
+	HTIF_DEVICE_SHIFT    = 56
+	HTIF_COMMAND_SHIFT   = 48
+	HTIF_PAYLOAD_MASK    = 1<<HTIF_COMMAND_SHIFT - 1
+	HTIF_DEVICE_SYSCALL  = 0 // Proxies system calls, or exits when the payload is odd
+	HTIF_DEVICE_CONSOLE  = 1 // Reads and writes characters
+	HTIF_CONSOLE_GETCHAR = 0
+	HTIF_CONSOLE_PUTCHAR = 1
+)
+
+// Numbers of the proxied system calls, following the RISC-V Linux ABI
+const (
+	SYS_READ       = 63
+	SYS_WRITE      = 64
+	SYS_EXIT       = 93
+	SYS_EXIT_GROUP = 94
+	ERR_NOSYS      = -38 // Result of system calls that are not implemented
+	ERR_BADF       = -9  // Result of system calls on file descriptors that are not open
+)
+
+// Represents the host-target interface, through which programs print and report their exit status
+// by writing commands to the tohost word and reading the replies from the fromhost word
+type HTIF struct {
+	bus      Bus       // Memory holding the arguments of proxied system calls
+	input    io.Reader // Source of the console and of reads from stdin
+	output   io.Writer // Destination of the console and of writes to stdout and stderr
+	tohost   uint64
+	fromhost uint64
+	exited   bool
+	code     int // Exit status of the program, once it has exited
+}
+
+// Constructor to initialize the interface with the console attached to the given input and output
+func NewHTIF(input io.Reader, output io.Writer) *HTIF {
+	return &HTIF{input: input, output: output}
+}
+
+// Returns the exit status of the program, and whether it has exited
+func (htif *HTIF) Exited() (int, bool) {
+	return htif.code, htif.exited
+}
+
+// Performs the command written to tohost, then clears it to acknowledge it
+func (htif *HTIF) command(value uint64) {
+	device := value >> HTIF_DEVICE_SHIFT
+	command := value >> HTIF_COMMAND_SHIFT & 0xFF
+	payload := value & HTIF_PAYLOAD_MASK
+
+	switch {
+	case device == HTIF_DEVICE_SYSCALL && payload&1 != 0:
+		htif.exit(int(payload >> 1))
+	case device == HTIF_DEVICE_SYSCALL:
+		htif.syscall(uint32(payload))
+		htif.fromhost = 1
+	case device == HTIF_DEVICE_CONSOLE && command == HTIF_CONSOLE_PUTCHAR:
+		if htif.output != nil {
+			htif.output.Write([]byte{byte(payload)})
+		}
+		htif.fromhost = value &^ HTIF_PAYLOAD_MASK
+	case device == HTIF_DEVICE_CONSOLE && command == HTIF_CONSOLE_GETCHAR:
+		// Reaching the end of the input reads as -1
+		data := uint64(HTIF_PAYLOAD_MASK)
+		buffer := make([]byte, 1)
+		if htif.input != nil {
+			if n, _ := io.ReadFull(htif.input, buffer); n == 1 {
+				data = uint64(buffer[0])
+			}
+		}
+		htif.fromhost = value&^HTIF_PAYLOAD_MASK | data
+	}
+	htif.tohost = 0
+}
+
+// Stops the program with the given exit status
+func (htif *HTIF) exit(code int) {
+	htif.exited, htif.code = true, code
+}
+
+// Proxies the system call described by the eight doublewords at the address, storing the result in the first
+func (htif *HTIF) syscall(addr uint32) {
+	var args [8]uint64
+	for i := range args {
+		low, err := htif.bus.Read(addr+uint32(i)*BYTES_PER_DOUBLE, BYTES_PER_WORD)
+		high, err2 := htif.bus.Read(addr+uint32(i)*BYTES_PER_DOUBLE+BYTES_PER_WORD, BYTES_PER_WORD)
+		if err != nil || err2 != nil {
+			return
+		}
+		args[i] = uint64(high)<<32 | uint64(low)
+	}
+
+	var result int64
+	switch args[0] {
+	case SYS_WRITE:
+		result = htif.transfer(args[1] == 1 || args[1] == 2, uint32(args[2]), uint32(args[3]), true)
+	case SYS_READ:
+		result = htif.transfer(args[1] == 0, uint32(args[2]), uint32(args[3]), false)
+	case SYS_EXIT, SYS_EXIT_GROUP:
+		htif.exit(int(args[1]))
+		return
+	default:
+		result = ERR_NOSYS
+	}
+	htif.bus.Write(addr, BYTES_PER_WORD, uint32(result))
+	htif.bus.Write(addr+BYTES_PER_WORD, BYTES_PER_WORD, uint32(uint64(result)>>32))
+}
+
+// Copies a buffer of guest memory to the output or from the input, returning the number of bytes copied
+func (htif *HTIF) transfer(open bool, addr uint32, length uint32, write bool) int64 {
+	if !open {
+		return ERR_BADF
+	}
+	buffer := make([]byte, length)
+	if write {
+		for i := range buffer {
+			value, err := htif.bus.Read(addr+uint32(i), 1)
+			if err != nil {
+				return int64(i)
+			}
+			buffer[i] = byte(value)
+		}
+		if htif.output == nil {
+			return int64(length)
+		}
+		n, _ := htif.output.Write(buffer)
+		return int64(n)
+	}
+	if htif.input == nil {
+		return 0
+	}
+	n, _ := htif.input.Read(buffer)
+	for i := 0; i < n; i++ {
+		if htif.bus.Write(addr+uint32(i), 1, uint32(buffer[i])) != nil {
+			return int64(i)
+		}
+	}
+	return int64(n)
+}
+
+// Represents the tohost or fromhost word of the interface as a device
+type htifPort struct {
+	htif   *HTIF
+	tohost bool
+}
+
+// Returns the word of the interface the port exposes
+func (port *htifPort) value() *uint64 {
+	if port.tohost {
+		return &port.htif.tohost
+	}
+	return &port.htif.fromhost
+}
+
+// Reads a little-endian value of the given size from the word
+func (port *htifPort) Read(offset uint32, size uint32) (uint32, error) {
+	return uint32(*port.value()>>(8*offset)) & uint32(uint64(1)<<(8*size)-1), nil
+}
+
+// Writes a little-endian value of the given size to the word, performing a command once the upper half
+// of tohost is written, as 32-bit programs write the lower half first
+func (port *htifPort) Write(offset uint32, size uint32, value uint32) error {
+	mask := uint64(1)<<(8*size) - 1
+	word := port.value()
+	*word = *word&^(mask<<(8*offset)) | (uint64(value)&mask)<<(8*offset)
+	if port.tohost && offset+size > BYTES_PER_WORD && *word != 0 {
+		port.htif.command(*word)
+	}
+	return nil
+}
+
+// Maps the tohost and fromhost words of the interface onto the bus of the CPU, leaving out fromhost if its address is 0
+func (cpu *CPU) AttachHTIF(htif *HTIF, tohost uint32, fromhost uint32) error {
+	if tohost%BYTES_PER_DOUBLE != 0 || fromhost%BYTES_PER_DOUBLE != 0 {
+		return fmt.Errorf("tohost %08x and fromhost %08x must be doubleword-aligned", tohost, fromhost)
+	}
+	htif.bus = cpu.bus
+	cpu.htif = htif
+	err := cpu.bus.Map(Region{name: "tohost", base: tohost, size: BYTES_PER_DOUBLE, device: &htifPort{htif, true}, aligned: true})
+	if err != nil || fromhost == 0 {
+		return err
+	}
+	return cpu.bus.Map(Region{name: "fromhost", base: fromhost, size: BYTES_PER_DOUBLE, device: &htifPort{htif, false}, aligned: true})
+}
+
+// Returns the exit status the program reported through the host-target interface, and whether it has exited
+func (cpu *CPU) Exited() (int, bool) {
+	if cpu.htif == nil {
+		return 0, false
+	}
+	return cpu.htif.Exited()
+}
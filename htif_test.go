@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Creates a CPU with the host-target interface at 00001000 and 00001040, printing to the output
+func newHTIFCPU(t *testing.T, output *bytes.Buffer) *CPU {
+	t.Helper()
+	cpu := newTestCPU(t, 0, 0x2000)
+	if err := cpu.AttachHTIF(NewHTIF(nil, output), 0x1000, 0x1040); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return cpu
+}
+
+// Writes a doubleword to memory as two words, lower half first
+func storeDouble(t *testing.T, cpu *CPU, addr uint32, value uint64) {
+	t.Helper()
+	if err := cpu.StoreWord(addr, uint32(value)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cpu.StoreWord(addr+BYTES_PER_WORD, uint32(value>>32)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestHTIFConsoleAndExit(t *testing.T) {
+	var output bytes.Buffer
+	cpu := newHTIFCPU(t, &output)
+	// Print 'A' through the console device, then exit with status 3 and spin
+	program := []uint32{0x000012b7, 0x01010337, 0x04100393, 0x0072a023, 0x0062a223, 0x00700393, 0x0072a023, 0x0002a223, 0x0000006f}
+	storeWords(t, cpu, 0, program...)
+
+	for i := 0; i < len(program); i++ {
+		step(t, cpu, 1)
+		if i == 4 {
+			if output.String() != "A" {
+				t.Errorf("output = %q, want A", output.String())
+			}
+			if low, _ := cpu.FetchWord(0x1044); low != 0x0101_0000 {
+				t.Errorf("fromhost = %08x_????????, want the putchar command acknowledged", low)
+			}
+		}
+	}
+	if code, exited := cpu.Exited(); !exited || code != 3 {
+		t.Errorf("Exited() = %d, %v, want 3", code, exited)
+	}
+	if tohost, _ := cpu.FetchWord(0x1000); tohost != 0 {
+		t.Errorf("tohost = %08x, want it cleared once the command is performed", tohost)
+	}
+}
+
+func TestHTIFSyscalls(t *testing.T) {
+	var output bytes.Buffer
+	cpu := newHTIFCPU(t, &output)
+	for i, b := range []byte("hello") {
+		if err := cpu.StoreByte(0x1900+uint32(i), b); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// write(1, "hello", 5), then write(3, ...) to a file that is not open
+	for _, test := range []struct {
+		fd     uint64
+		result uint64
+	}{{1, 5}, {3, 1<<64 + ERR_BADF}} {
+		for i, arg := range []uint64{SYS_WRITE, test.fd, 0x1900, 5} {
+			storeDouble(t, cpu, 0x1800+uint32(i)*BYTES_PER_DOUBLE, arg)
+		}
+		storeDouble(t, cpu, 0x1000, 0x1800)
+		low, _ := cpu.FetchWord(0x1800)
+		high, _ := cpu.FetchWord(0x1804)
+		if result := uint64(high)<<32 | uint64(low); result != test.result {
+			t.Errorf("write(%d) = %#x, want %#x", test.fd, result, test.result)
+		}
+		if fromhost, _ := cpu.FetchWord(0x1040); fromhost != 1 {
+			t.Errorf("fromhost = %d, want the system call acknowledged", fromhost)
+		}
+		storeDouble(t, cpu, 0x1040, 0)
+	}
+	if output.String() != "hello" {
+		t.Errorf("output = %q, want hello", output.String())
+	}
+
+	storeDouble(t, cpu, 0x1800, SYS_EXIT)
+	storeDouble(t, cpu, 0x1808, 42)
+	storeDouble(t, cpu, 0x1000, 0x1800)
+	if code, exited := cpu.Exited(); !exited || code != 42 {
+		t.Errorf("Exited() = %d, %v, want 42", code, exited)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Represents the file format of an image
+type ImageFormat string
+
+// An enum containing all the supported image formats
+const (
+	IMAGE_AUTO  ImageFormat = "auto"  // Detect the format from the contents of the file
+	IMAGE_ELF   ImageFormat = "elf"   // ELF executable
+	IMAGE_IHEX  ImageFormat = "ihex"  // Intel HEX
+	IMAGE_SREC  ImageFormat = "srec"  // Motorola S-record
+	IMAGE_RAW   ImageFormat = "raw"   // Plain binary loaded at a given address
+	IMAGE_SIZED ImageFormat = "sized" // Binary prefixed with its 4-byte size, loaded at address 0
+	IMAGE_ASM   ImageFormat = "asm"   // Assembly source, assembled with the built-in assembler
+)
+
+// Describes an image to load into memory
+type Image struct {
+	path    string
+	format  ImageFormat
+	addr    uint32 // Address raw images are loaded at
+	hasAddr bool   // Whether an address was given
+}
+
+// Parses an image given as path[@address] in the given format
+func ParseImage(spec string, format string) (Image, error) {
+	image := Image{path: spec, format: ImageFormat(strings.ToLower(format))}
+	switch image.format {
+	case IMAGE_AUTO, IMAGE_ELF, IMAGE_IHEX, IMAGE_SREC, IMAGE_RAW, IMAGE_SIZED, IMAGE_ASM:
+	default:
+		return Image{}, fmt.Errorf("unknown image format %q", format)
+	}
+
+	// Paths may contain an @ themselves, so only a trailing number is taken as the address
+	if at := strings.LastIndexByte(spec, '@'); at >= 0 {
+		addr, err := strconv.ParseUint(spec[at+1:], 0, 32)
+		if err == nil {
+			image.path, image.addr, image.hasAddr = spec[:at], uint32(addr), true
+		}
+	}
+	return image, nil
+}
+
+// Returns the format of an image from the first bytes of its contents
+func detectImageFormat(header []byte, hasAddr bool) ImageFormat {
+	text := bytes.TrimLeft(header, " \t\r\n")
+	switch {
+	case bytes.HasPrefix(header, []byte(elf.ELFMAG)):
+		return IMAGE_ELF
+	case len(text) > 0 && text[0] == ':':
+		return IMAGE_IHEX
+	case len(text) > 1 && text[0] == 'S' && text[1] >= '0' && text[1] <= '9':
+		return IMAGE_SREC
+	case hasAddr:
+		return IMAGE_RAW
+	default:
+		return IMAGE_SIZED
+	}
+}
+
+// Loads the images in order, starting at the entry point of the first image that has one
+func (cpu *CPU) LoadImages(images []Image) error {
+	started := false
+	for _, image := range images {
+		entry, hasEntry, err := cpu.LoadImage(image)
+		if err != nil {
+			return fmt.Errorf("error loading image %s: %v", image.path, err)
+		}
+		if hasEntry && !started {
+			cpu.pc, started = entry, true
+		}
+	}
+	return nil
+}
+
+// Loads an image into memory, returning its entry point if the format records one
+func (cpu *CPU) LoadImage(image Image) (uint32, bool, error) {
+	file, err := os.Open(image.path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+	format, err := image.resolveFormat(file)
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch format {
+	case IMAGE_ELF:
+		entry, err := cpu.LoadELF(file)
+		return entry, err == nil, err
+	case IMAGE_IHEX:
+		return cpu.LoadIntelHex(file)
+	case IMAGE_SREC:
+		return cpu.LoadSRecord(file)
+	case IMAGE_ASM:
+		program, err := NewAssembler(ASM_TEXT_START, ASM_DATA_START).Assemble(image.path, file)
+		if err != nil {
+			return 0, false, err
+		}
+		entry, err := cpu.LoadProgram(program)
+		return entry, err == nil, err
+	case IMAGE_RAW:
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return 0, false, err
+		}
+		return 0, false, cpu.bus.Load(image.addr, data)
+	default:
+		return 0, false, cpu.loadSizedImage(file)
+	}
+}
+
+// Returns the format of the image, detecting it from the start of the file unless it was given
+func (image Image) resolveFormat(file io.ReadSeeker) (ImageFormat, error) {
+	format := image.format
+	// Assembly source is told apart by its extension, as it may start with anything
+	if ext := filepath.Ext(image.path); format == IMAGE_AUTO && (ext == ".s" || ext == ".S") {
+		format = IMAGE_ASM
+	}
+	if format == IMAGE_AUTO {
+		header := make([]byte, 16)
+		n, _ := io.ReadFull(file, header)
+		format = detectImageFormat(header[:n], image.hasAddr)
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+	}
+	if image.hasAddr && format != IMAGE_RAW {
+		return "", fmt.Errorf("a load address only applies to raw images, not %s", format)
+	}
+	return format, nil
+}
+
+// Loads a binary image prefixed with its size at address 0
+func (cpu *CPU) loadSizedImage(file io.Reader) error {
+	// Read the size of the binary image
+	var binMemSize uint32
+	err := binary.Read(file, binary.LittleEndian, &binMemSize)
+	if err != nil {
+		return fmt.Errorf("error reading binary image size: %v", err)
+	}
+
+	// Read the binary image into memory
+	data := make([]byte, binMemSize)
+	_, err = io.ReadFull(file, data)
+	if err != nil {
+		return fmt.Errorf("error reading binary image: %v", err)
+	}
+	return cpu.bus.Load(0, data)
+}
+
+// Record types of Intel HEX files
+const (
+	IHEX_DATA                   = 0x00 // Data at an offset from the current base address
+	IHEX_END_OF_FILE            = 0x01 // Last record of the file
+	IHEX_EXTENDED_SEGMENT       = 0x02 // Sets the base address to a segment times 16
+	IHEX_START_SEGMENT_ADDRESS  = 0x03 // Entry point as a CS:IP pair
+	IHEX_EXTENDED_LINEAR        = 0x04 // Sets the upper 16 bits of the base address
+	IHEX_START_LINEAR_ADDRESS   = 0x05 // Entry point as a 32-bit address
+	IHEX_RECORD_HEADER_SIZE     = 4    // Byte count, address and record type
+	IHEX_RECORD_CHECKSUM_LENGTH = 1
+)
+
+// Loads an Intel HEX file, returning the start address if it has one
+func (cpu *CPU) LoadIntelHex(reader io.Reader) (uint32, bool, error) {
+	var base, entry uint32
+	var hasEntry bool
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if text[0] != ':' {
+			return 0, false, fmt.Errorf("line %d: record does not start with ':'", line)
+		}
+		record, err := decodeRecord(text[1:], line)
+		if err != nil {
+			return 0, false, err
+		}
+		// The byte count must match the data, and all the bytes must sum to zero
+		if len(record) < IHEX_RECORD_HEADER_SIZE+IHEX_RECORD_CHECKSUM_LENGTH ||
+			int(record[0]) != len(record)-IHEX_RECORD_HEADER_SIZE-IHEX_RECORD_CHECKSUM_LENGTH {
+			return 0, false, fmt.Errorf("line %d: byte count does not match the record length", line)
+		}
+		if checksum(record) != 0 {
+			return 0, false, fmt.Errorf("line %d: checksum mismatch", line)
+		}
+
+		offset := uint32(binary.BigEndian.Uint16(record[1:3]))
+		data := record[IHEX_RECORD_HEADER_SIZE : len(record)-IHEX_RECORD_CHECKSUM_LENGTH]
+		switch record[3] {
+		case IHEX_DATA:
+			if err := cpu.bus.Load(base+offset, data); err != nil {
+				return 0, false, fmt.Errorf("line %d: %v", line, err)
+			}
+		case IHEX_END_OF_FILE:
+			return entry, hasEntry, nil
+		case IHEX_EXTENDED_SEGMENT, IHEX_EXTENDED_LINEAR:
+			if len(data) != 2 {
+				return 0, false, fmt.Errorf("line %d: base address records hold 2 bytes", line)
+			}
+			base = uint32(binary.BigEndian.Uint16(data)) << 4
+			if record[3] == IHEX_EXTENDED_LINEAR {
+				base = uint32(binary.BigEndian.Uint16(data)) << 16
+			}
+		case IHEX_START_SEGMENT_ADDRESS, IHEX_START_LINEAR_ADDRESS:
+			if len(data) != 4 {
+				return 0, false, fmt.Errorf("line %d: start address records hold 4 bytes", line)
+			}
+			entry, hasEntry = binary.BigEndian.Uint32(data), true
+			if record[3] == IHEX_START_SEGMENT_ADDRESS {
+				entry = uint32(binary.BigEndian.Uint16(data[0:2]))<<4 + uint32(binary.BigEndian.Uint16(data[2:4]))
+			}
+		default:
+			return 0, false, fmt.Errorf("line %d: unknown record type %02x", line, record[3])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, false, err
+	}
+	return 0, false, fmt.Errorf("missing end-of-file record")
+}
+
+// Loads a Motorola S-record file, returning the start address if it has one
+func (cpu *CPU) LoadSRecord(reader io.Reader) (uint32, bool, error) {
+	var entry uint32
+	var hasEntry bool
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if len(text) < 2 || text[0] != 'S' {
+			return 0, false, fmt.Errorf("line %d: record does not start with 'S'", line)
+		}
+		record, err := decodeRecord(text[2:], line)
+		if err != nil {
+			return 0, false, err
+		}
+		// The count covers the address, data and checksum, whose ones' complement makes the sum 0xFF
+		if len(record) < 1 || int(record[0]) != len(record)-1 {
+			return 0, false, fmt.Errorf("line %d: byte count does not match the record length", line)
+		}
+		if checksum(record) != 0xFF {
+			return 0, false, fmt.Errorf("line %d: checksum mismatch", line)
+		}
+
+		// Record types S1-S3 hold data and S7-S9 the start address, with 2, 3 or 4 address bytes
+		var addressLength int
+		switch text[1] {
+		case '0', '5', '6':
+			continue
+		case '1', '9':
+			addressLength = 2
+		case '2', '8':
+			addressLength = 3
+		case '3', '7':
+			addressLength = 4
+		default:
+			return 0, false, fmt.Errorf("line %d: unknown record type S%c", line, text[1])
+		}
+		if len(record) < 1+addressLength+1 {
+			return 0, false, fmt.Errorf("line %d: record is too short for its address", line)
+		}
+		var addr uint32
+		for _, b := range record[1 : 1+addressLength] {
+			addr = addr<<8 | uint32(b)
+		}
+		if text[1] >= '7' {
+			entry, hasEntry = addr, true
+			continue
+		}
+		if err := cpu.bus.Load(addr, record[1+addressLength:len(record)-1]); err != nil {
+			return 0, false, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, false, err
+	}
+	return entry, hasEntry, nil
+}
+
+// Decodes the hexadecimal bytes of a record
+func decodeRecord(text string, line int) ([]byte, error) {
+	record, err := hex.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", line, err)
+	}
+	return record, nil
+}
+
+// Returns the sum of the bytes of a record, modulo 256
+func checksum(record []byte) uint8 {
+	var sum uint8
+	for _, b := range record {
+		sum += b
+	}
+	return sum
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// An Intel HEX file placing two instructions at 00010010, starting at 00010014
+const testIntelHex = `:020000040001F9
+:08001000130000007300100052
+:0400000500010014E2
+:00000001FF
+`
+
+// An S-record file placing a word at 00000200 and a halfword at 00000300, starting at 00000204
+const testSRecord = `S0060000686472BB
+S3090000020001020304EA
+S10503000506EC
+S70500000204F4
+`
+
+// Writes the contents of an image to a temporary file, returning its path
+func writeImage(t *testing.T, name string, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadIntelHex(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x20000)
+	entry, hasEntry, err := cpu.LoadIntelHex(strings.NewReader(testIntelHex))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !hasEntry || entry != 0x10014 {
+		t.Errorf("entry = %08x, %v, want 00010014", entry, hasEntry)
+	}
+	if word, _ := cpu.FetchWord(0x10014); word != 0x00100073 {
+		t.Errorf("word at 00010014 = %08x, want 00100073", word)
+	}
+}
+
+func TestLoadSRecord(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x1000)
+	entry, hasEntry, err := cpu.LoadSRecord(strings.NewReader(testSRecord))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !hasEntry || entry != 0x204 {
+		t.Errorf("entry = %08x, %v, want 00000204", entry, hasEntry)
+	}
+	if word, _ := cpu.FetchWord(0x200); word != 0x04030201 {
+		t.Errorf("word at 00000200 = %08x, want 04030201", word)
+	}
+	if half, _ := cpu.FetchHalfWord(0x300); half != 0x0605 {
+		t.Errorf("halfword at 00000300 = %04x, want 0605", half)
+	}
+}
+
+func TestLoadRecordErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		srec   bool
+		record string
+	}{
+		{"intel hex checksum", false, ":0400000500010014E3\n:00000001FF\n"},
+		{"intel hex byte count", false, ":0500000500010014E2\n:00000001FF\n"},
+		{"intel hex missing end of file", false, ":0400000500010014E2\n"},
+		{"intel hex unknown record", false, ":00000007F9\n"},
+		{"s-record checksum", true, "S10503000506ED\n"},
+		{"s-record bad hex", true, "S1050300050GEC\n"},
+		{"s-record outside memory", true, "S3090001000001020304EB\n"},
+	}
+
+	for _, test := range tests {
+		cpu := newTestCPU(t, 0, 0x1000)
+		var err error
+		if test.srec {
+			_, _, err = cpu.LoadSRecord(strings.NewReader(test.record))
+		} else {
+			_, _, err = cpu.LoadIntelHex(strings.NewReader(test.record))
+		}
+		if err == nil {
+			t.Errorf("%s: loading should fail", test.name)
+		}
+	}
+}
+
+func TestParseImage(t *testing.T) {
+	tests := []struct {
+		spec    string
+		path    string
+		addr    uint32
+		hasAddr bool
+	}{
+		{"app.bin", "app.bin", 0, false},
+		{"app.bin@0x8000", "app.bin", 0x8000, true},
+		{"boot@rom.bin@4096", "boot@rom.bin", 4096, true},
+		{"user@host.bin", "user@host.bin", 0, false},
+	}
+
+	for _, test := range tests {
+		image, err := ParseImage(test.spec, "auto")
+		if err != nil {
+			t.Errorf("ParseImage(%q): unexpected error: %v", test.spec, err)
+		} else if image.path != test.path || image.addr != test.addr || image.hasAddr != test.hasAddr {
+			t.Errorf("ParseImage(%q) = %q@%x (%v), want %q@%x (%v)", test.spec,
+				image.path, image.addr, image.hasAddr, test.path, test.addr, test.hasAddr)
+		}
+	}
+	if _, err := ParseImage("app.bin", "coff"); err == nil {
+		t.Errorf("ParseImage with an unknown format should fail")
+	}
+}
+
+func TestDetectImageFormat(t *testing.T) {
+	tests := []struct {
+		header  string
+		hasAddr bool
+		format  ImageFormat
+	}{
+		{"\x7fELF\x01\x01\x01", false, IMAGE_ELF},
+		{":020000040001F9", false, IMAGE_IHEX},
+		{"S0060000686472BB", false, IMAGE_SREC},
+		{"\x08\x00\x00\x00\x13\x00\x00\x00", false, IMAGE_SIZED},
+		{"\x13\x00\x00\x00", true, IMAGE_RAW},
+	}
+
+	for _, test := range tests {
+		if format := detectImageFormat([]byte(test.header), test.hasAddr); format != test.format {
+			t.Errorf("detectImageFormat(%q) = %s, want %s", test.header, format, test.format)
+		}
+	}
+}
+
+func TestLoadImages(t *testing.T) {
+	cpu := newTestCPU(t, 0x40, 0x20000)
+	// A raw bootloader without an entry point, then two images that each record one
+	boot, _ := ParseImage(writeImage(t, "boot.bin", "\x13\x00\x00\x00")+"@0x80", "auto")
+	app, _ := ParseImage(writeImage(t, "app.srec", testSRecord), "auto")
+	other, _ := ParseImage(writeImage(t, "other.hex", testIntelHex), "auto")
+	if err := cpu.LoadImages([]Image{boot, app, other}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cpu.pc != 0x204 {
+		t.Errorf("pc = %08x, want the entry point of the first image that has one", cpu.pc)
+	}
+	if word, _ := cpu.FetchWord(0x80); word != 0x13 {
+		t.Errorf("word at 00000080 = %08x, want the raw image", word)
+	}
+	if word, _ := cpu.FetchWord(0x10010); word != 0x13 {
+		t.Errorf("word at 00010010 = %08x, want the intel hex image", word)
+	}
+
+	// Only raw images are placed at an address
+	hex, _ := ParseImage(writeImage(t, "app.hex", testIntelHex)+"@0x100", "auto")
+	if err := cpu.LoadImages([]Image{hex}); err == nil {
+		t.Errorf("loading an intel hex image at an address should fail")
+	}
+}
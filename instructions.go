@@ -1,67 +1,74 @@
 package main
 
+import "errors"
+
 // Represents an instruction type in RISC-V
 type InstructionType uint8
 
 // An enum containing all the possible formats of an instruction
 const (
 	R_TYPE       InstructionType = 0b0110011 // Register (R-format) instructions
+	R_TYPE_AMO   InstructionType = 0b0101111 // Atomic memory operation (R-format) instructions
 	I_TYPE_ARITH InstructionType = 0b0010011 // Arithmetic Immediate (I-format) instructions
 	I_TYPE_LOAD  InstructionType = 0b0000011 // Load Immediate (I-format) instructions
+	I_TYPE_JALR  InstructionType = 0b1100111 // Jump and link register (I-format) instructions
+	I_TYPE_FENCE InstructionType = 0b0001111 // Memory ordering (I-format) instructions
 	I_TYPE_SYS   InstructionType = 0b1110011 // System Immediate (I-format) instructions
 	S_TYPE       InstructionType = 0b0100011 // Store (S-format) instructions
 	B_TYPE       InstructionType = 0b1100011 // Branch (B-format) instructions
-	U_TYPE       InstructionType = 0b0110111 // Upper immediate (U-format) instructions
+	U_TYPE_LUI   InstructionType = 0b0110111 // Load upper immediate (U-format) instructions
+	U_TYPE_AUIPC InstructionType = 0b0010111 // Add upper immediate to pc (U-format) instructions
 	J_TYPE       InstructionType = 0b1101111 // Jump (J-format) instructions
-)
 
-// Represents a MIPS assembly instruction
-type AssemblyInstruction struct {
-	instructionType InstructionType   // The type of instruction
-	rType           *RTypeInstruction // The R-type instruction
-	iType           *ITypeInstruction // The I-type instruction
-	sType           *STypeInstruction // The S-type instruction
-	bType           *BTypeInstruction // The B-type instruction
-	uType           *UTypeInstruction // The U-type instruction
-	jType           *JTypeInstruction // The J-type instruction
-}
-
-// Represents a R-type instruction
-type RTypeInstruction struct {
-	rd  uint8 // The destination register
-	rs1 uint8 // The first source register
-	rs2 uint8 // The second source register
-}
+	I_TYPE_LOAD_FP InstructionType = 0b0000111 // Floating-point load (I-format) instructions
+	S_TYPE_FP      InstructionType = 0b0100111 // Floating-point store (S-format) instructions
+	R_TYPE_FP      InstructionType = 0b1010011 // Floating-point computational (R-format) instructions
+	R4_TYPE_FMADD  InstructionType = 0b1000011 // Fused multiply-add (R4-format) instructions
+	R4_TYPE_FMSUB  InstructionType = 0b1000111 // Fused multiply-subtract (R4-format) instructions
+	R4_TYPE_FNMSUB InstructionType = 0b1001011 // Negated fused multiply-subtract (R4-format) instructions
+	R4_TYPE_FNMADD InstructionType = 0b1001111 // Negated fused multiply-add (R4-format) instructions
+)
 
-// Represents a I-type instruction
-type ITypeInstruction struct {
-	rd  uint8  // The destination register
-	rs1 uint8  // The first source register
-	imm uint16 // The immediate value
-}
+// Errors wrapped by the traps of instructions that request control be handed to the environment
+var (
+	ErrEnvironmentCall = errors.New("environment call")
+	ErrBreakpoint      = errors.New("breakpoint")
+)
 
-// Represents a S-type instruction
-type STypeInstruction struct {
-	imm uint16 // The immediate value
-	rs1 uint8  // The first source register
-	rs2 uint8  // The second source register
-}
+// Represents the layout of the operands within an instruction
+type InstructionFormat uint8
 
-// Represents a B-type instruction
-type BTypeInstruction struct {
-	imm uint16 // The immediate value
-	rs1 uint8  // The first source register
-	rs2 uint8  // The second source register
-}
+// An enum containing all the possible operand layouts of an instruction
+const (
+	FORMAT_R       InstructionFormat = iota // Three register operands
+	FORMAT_R4                               // Four register operands (fused multiply-add)
+	FORMAT_I                                // Register and 12-bit immediate operands
+	FORMAT_I_SHIFT                          // Register and 5-bit shift amount operands
+	FORMAT_S                                // Store offset operands
+	FORMAT_B                                // Branch offset operands
+	FORMAT_U                                // 20-bit upper immediate operand
+	FORMAT_J                                // Jump offset operand
+)
 
-// Represents a U-type instruction
-type UTypeInstruction struct {
-	imm uint16 // The immediate value
-	rd  uint8  // The destination register
+// Represents a fully decoded RISC-V assembly instruction
+type AssemblyInstruction struct {
+	raw             uint32            // The raw encoded instruction
+	size            uint32            // The length of the encoded instruction in bytes
+	mnemonic        string            // The assembly mnemonic of the instruction
+	format          InstructionFormat // The layout of the operands
+	extension       Extension         // The extension that defines the instruction
+	instructionType InstructionType   // The major opcode of the instruction
+	funct3          uint8             // The 3-bit function field
+	funct7          uint8             // The 7-bit function field
+	rd              uint8             // The destination register
+	rs1             uint8             // The first source register
+	rs2             uint8             // The second source register
+	rs3             uint8             // The third source register
+	imm             int32             // The sign-extended immediate value
 }
 
-// Represents a J-type instruction
-type JTypeInstruction struct {
-	imm uint16 // The immediate value
-	rd  uint8  // The destination register
+// Sign-extends the lower bits of a value to a 32-bit signed integer
+func signExtend(value uint32, bits uint) int32 {
+	shift := 32 - bits
+	return int32(value<<shift) >> shift
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Represents a set of RISC-V extensions as a bitmask
+type Extension uint32
+
+// An enum containing all the supported extensions
+const (
+	EXT_I     Extension = 1 << iota // Base integer instruction set
+	EXT_M                           // Integer multiplication and division
+	EXT_A                           // Atomic instructions
+	EXT_F                           // Single-precision floating point
+	EXT_D                           // Double-precision floating point
+	EXT_C                           // Compressed instructions
+	EXT_ZICSR                       // Control and status register instructions
+)
+
+// The extensions enabled when none are specified
+const DEFAULT_ISA = "rv32gc"
+
+// Maps each single-letter extension in an ISA string to its bit
+var extensionLetters = map[byte]Extension{
+	'i': EXT_I,
+	'm': EXT_M,
+	'a': EXT_A,
+	'f': EXT_F | EXT_ZICSR,
+	'd': EXT_D,
+	'c': EXT_C,
+	'g': EXT_I | EXT_M | EXT_A | EXT_F | EXT_D | EXT_ZICSR,
+}
+
+// Maps each multi-letter extension, given after an underscore, to its bits
+var extensionNames = map[string]Extension{
+	"zicsr":    EXT_ZICSR,
+	"zifencei": 0, // Instruction-fetch fences are always available
+}
+
+// Parses an ISA string such as "rv32imac_zicsr" into the set of extensions it names
+func ParseISA(isa string) (Extension, error) {
+	isa = strings.ToLower(isa)
+	if !strings.HasPrefix(isa, "rv32") {
+		return 0, fmt.Errorf("unsupported isa %q: only rv32 is supported", isa)
+	}
+
+	names := strings.Split(strings.TrimPrefix(isa, "rv32"), "_")
+	letters := names[0]
+	if !strings.HasPrefix(letters, "i") && !strings.HasPrefix(letters, "g") {
+		return 0, fmt.Errorf("unsupported isa %q: the base integer instruction set must come first", isa)
+	}
+
+	var extensions Extension
+	for i := 0; i < len(letters); i++ {
+		extension, ok := extensionLetters[letters[i]]
+		if !ok {
+			return 0, fmt.Errorf("unsupported isa %q: unknown extension %q", isa, letters[i])
+		}
+		extensions |= extension
+	}
+	for _, name := range names[1:] {
+		extension, ok := extensionNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unsupported isa %q: unknown extension %q", isa, name)
+		}
+		extensions |= extension
+	}
+	if extensions&EXT_D != 0 && extensions&EXT_F == 0 {
+		return 0, fmt.Errorf("unsupported isa %q: the D extension depends on the F extension", isa)
+	}
+	return extensions, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestParseISA(t *testing.T) {
+	tests := []struct {
+		isa        string
+		extensions Extension
+		valid      bool
+	}{
+		{"rv32i", EXT_I, true},
+		{"rv32im", EXT_I | EXT_M, true},
+		{"rv32ima", EXT_I | EXT_M | EXT_A, true},
+		{"RV32IM", EXT_I | EXT_M, true},
+		{"rv32imafdc", EXT_I | EXT_M | EXT_A | EXT_F | EXT_D | EXT_C | EXT_ZICSR, true},
+		{"rv32gc", EXT_I | EXT_M | EXT_A | EXT_F | EXT_D | EXT_C | EXT_ZICSR, true},
+		{"rv32imac_zicsr_zifencei", EXT_I | EXT_M | EXT_A | EXT_C | EXT_ZICSR, true},
+		{"rv32i_zfoo", 0, false},
+		{"rv32id", 0, false},
+		{"rv64i", 0, false},
+		{"rv32m", 0, false},
+		{"rv32iq", 0, false},
+	}
+
+	for _, test := range tests {
+		extensions, err := ParseISA(test.isa)
+		if (err == nil) != test.valid {
+			t.Errorf("ParseISA(%q): error = %v, want valid = %v", test.isa, err, test.valid)
+		} else if extensions != test.extensions {
+			t.Errorf("ParseISA(%q) = %b, want %b", test.isa, extensions, test.extensions)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Errors returned when the reference runs out of instructions to compare against
+var (
+	ErrReferenceEnded     = errors.New("reference ended")
+	ErrReferenceUnreached = errors.New("reference never reached pc")
+)
+
+// Lines of the reference logs
+var (
+	// core   0: 3 0x80000000 (0x00000297) x5  0x80000000 mem 0x80001000 0x00000001
+	spikeCommitPattern = regexp.MustCompile(`^core\s+(\d+): (\d) 0x([0-9a-fA-F]+) \(0x([0-9a-fA-F]+)\)(.*)$`)
+	// [12] [M]: 0x80000000 (0x00000297) auipc t0, 0x0
+	sailInstructionPattern = regexp.MustCompile(`^\[\d+\] \[([MSU])\]: 0x([0-9a-fA-F]+) \(0x([0-9a-fA-F]+)\)`)
+	// x5 <- 0x80000000, f1 <- 0x3f800000 or CSR mstatus <- 0x00001800
+	sailWritePattern = regexp.MustCompile(`^(x|f|CSR )(\w+) <- 0x([0-9a-fA-F]+)`)
+	// mem[W,0x80001000] <- 0x00000001, or mem[0x80001000] <- 0x1 in older versions
+	sailMemoryPattern = regexp.MustCompile(`^mem\[(?:([RWX]),)?0x([0-9a-fA-F]+)\] (<-|->) 0x([0-9a-fA-F]+)`)
+)
+
+// Privilege levels as Sail abbreviates them
+var sailPrivileges = map[string]uint8{"U": PRIV_USER, "S": PRIV_SUPERVISOR, "M": PRIV_MACHINE}
+
+// Reads the retired instructions from a Spike --log-commits log or a Sail trace, skipping the lines of neither
+type ReferenceReader struct {
+	name    string
+	scanner *bufio.Scanner
+	line    int
+	pending *CommitRecord // Sail instruction whose effects are still being read
+}
+
+// Constructor to initialize a reader of the named reference log
+func NewReferenceReader(name string, input io.Reader) *ReferenceReader {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(nil, 1<<20)
+	return &ReferenceReader{name: name, scanner: scanner}
+}
+
+// Returns the next instruction the reference retired, or io.EOF after the last
+func (reader *ReferenceReader) Next() (*CommitRecord, error) {
+	for reader.scanner.Scan() {
+		reader.line++
+		line := strings.TrimSpace(reader.scanner.Text())
+		if match := spikeCommitPattern.FindStringSubmatch(line); match != nil {
+			record, err := parseSpikeCommit(match)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", reader.name, reader.line, err)
+			}
+			return record, nil
+		}
+
+		// Sail logs the effects of an instruction on the lines after it, so it is complete once the next one starts
+		if match := sailInstructionPattern.FindStringSubmatch(line); match != nil {
+			pc, _ := strconv.ParseUint(match[2], 16, 32)
+			raw, _ := strconv.ParseUint(match[3], 16, 32)
+			record := &CommitRecord{privilege: sailPrivileges[match[1]], pc: uint32(pc), raw: uint32(raw), size: BYTES_PER_WORD}
+			if isCompressed(record.raw) {
+				record.size = BYTES_PER_HALF
+			}
+			previous := reader.pending
+			reader.pending = record
+			if previous != nil {
+				return previous, nil
+			}
+		} else if reader.pending != nil {
+			if err := reader.pending.parseSailEffect(line); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", reader.name, reader.line, err)
+			}
+			// An instruction that traps does not retire
+			if strings.HasPrefix(line, "trapping from") {
+				reader.pending = nil
+			}
+		}
+	}
+	if err := reader.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if record := reader.pending; record != nil {
+		reader.pending = nil
+		return record, nil
+	}
+	return nil, io.EOF
+}
+
+// Parses a line of Spike's commit log, ignoring the disassembly our own traces follow it with
+func parseSpikeCommit(match []string) (*CommitRecord, error) {
+	hart, _ := strconv.ParseUint(match[1], 10, 32)
+	privilege, _ := strconv.ParseUint(match[2], 10, 8)
+	pc, err := strconv.ParseUint(match[3], 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("pc 0x%s does not fit in %d bits", match[3], XLEN)
+	}
+	raw, err := strconv.ParseUint(match[4], 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("instruction 0x%s is too long", match[4])
+	}
+	record := &CommitRecord{hart: uint32(hart), privilege: uint8(privilege), pc: uint32(pc), raw: uint32(raw), size: uint32(len(match[4]) / 2)}
+
+	effects, _, _ := strings.Cut(match[5], " #")
+	fields := strings.Fields(effects)
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if i+1 == len(fields) {
+			return nil, fmt.Errorf("%q is missing its value", field)
+		}
+		value, width, err := parseReferenceValue(fields[i+1])
+		if err != nil {
+			return nil, err
+		}
+		i++
+		if field == "mem" {
+			// Stores give the value after the address, which loads do not
+			access := MemoryAccess{addr: uint32(value)}
+			if i+1 < len(fields) && strings.HasPrefix(fields[i+1], "0x") {
+				stored, width, err := parseReferenceValue(fields[i+1])
+				if err != nil {
+					return nil, err
+				}
+				i++
+				access.size, access.value = width/8, stored
+				record.stores = append(record.stores, access)
+			} else {
+				record.loads = append(record.loads, access)
+			}
+			continue
+		}
+		write, err := parseReferenceRegister(field)
+		if err != nil {
+			return nil, err
+		}
+		write.value, write.width = value, width
+		record.writes = append(record.writes, write)
+	}
+	return record, nil
+}
+
+// Parses the name of a register Spike logs a write to: x5, f1 or c768_mstatus
+func parseReferenceRegister(name string) (CommitWrite, error) {
+	if len(name) > 1 {
+		kind, number := name[0], name[1:]
+		if kind == COMMIT_CSR {
+			number, _, _ = strings.Cut(number, "_")
+		}
+		reg, err := strconv.ParseUint(number, 10, 12)
+		if err == nil && (kind == COMMIT_CSR || (kind == COMMIT_X || kind == COMMIT_F) && reg < REG_COUNT) {
+			return CommitWrite{kind: kind, reg: uint32(reg)}, nil
+		}
+	}
+	return CommitWrite{}, fmt.Errorf("unknown register %q", name)
+}
+
+// Parses a hexadecimal value of the reference, returning it along with its width in bits
+func parseReferenceValue(text string) (uint64, uint32, error) {
+	digits, ok := strings.CutPrefix(text, "0x")
+	value, err := strconv.ParseUint(digits, 16, 64)
+	if !ok || err != nil {
+		return 0, 0, fmt.Errorf("invalid value %q", text)
+	}
+	return value, uint32(len(digits)) * 4, nil
+}
+
+// Adds a line of Sail's trace describing an effect of the instruction to the record, ignoring the other lines
+func (record *CommitRecord) parseSailEffect(line string) error {
+	if match := sailWritePattern.FindStringSubmatch(line); match != nil {
+		value, width, err := parseReferenceValue("0x" + match[3])
+		if err != nil {
+			return err
+		}
+		var write CommitWrite
+		if match[1] == "CSR " {
+			address, ok := csrAddresses[match[2]]
+			if !ok {
+				// CSRs are not compared, so one this CPU does not implement can be left out
+				return nil
+			}
+			write = CommitWrite{kind: COMMIT_CSR, reg: address}
+		} else if write, err = parseReferenceRegister(match[1] + match[2]); err != nil {
+			return err
+		}
+		write.value, write.width = value, width
+		record.writes = append(record.writes, write)
+	} else if match := sailMemoryPattern.FindStringSubmatch(line); match != nil && match[1] != "X" {
+		addr, _, err := parseReferenceValue("0x" + match[2])
+		if err != nil {
+			return err
+		}
+		value, width, err := parseReferenceValue("0x" + match[4])
+		if err != nil {
+			return err
+		}
+		// The value is padded to the size of the access
+		access := MemoryAccess{addr: uint32(addr), size: (width + 7) / 8, value: value}
+		if match[3] == "<-" {
+			record.stores = append(record.stores, access)
+		} else {
+			record.loads = append(record.loads, access)
+		}
+	}
+	return nil
+}
+
+// Steps a CPU in lockstep with a reference log, checking each instruction it retires against the reference's
+type Lockstep struct {
+	cpu       *CPU
+	reference *ReferenceReader
+	disasm    *Disassembler
+	shadow    [REG_COUNT]uint32 // Integer registers as the reference left them
+	retired   uint64            // Instructions that matched the reference
+}
+
+// The first instruction whose pc, register writes or stores differ from the reference's
+type Divergence struct {
+	reason    string
+	retired   uint64 // Instructions that matched before it
+	text      string // Disassembly of the instruction
+	got       *CommitRecord
+	want      *CommitRecord
+	registers [REG_COUNT]uint32 // Integer registers after each executed it
+	reference [REG_COUNT]uint32
+}
+
+// Constructor to initialize a lockstep check of the CPU against the reference, from the state it is in
+func NewLockstep(cpu *CPU, reference *ReferenceReader) *Lockstep {
+	return &Lockstep{cpu: cpu, reference: reference, disasm: NewDisassembler(cpu.symbols), shadow: cpu.registers.x}
+}
+
+// Executes the next instruction like Step, returning a *Divergence if it differs from the reference's
+func (lockstep *Lockstep) Step() error {
+	cpu := lockstep.cpu
+	tracing := cpu.tracer != nil && cpu.tracer.tracing(cpu.pc)
+	record, err := cpu.StepCommit()
+	if record == nil {
+		// The reference does not log instructions that trap either
+		return err
+	}
+	if tracing {
+		cpu.tracer.write(record)
+	}
+
+	want, refErr := lockstep.next(record.pc)
+	if refErr == io.EOF {
+		return fmt.Errorf("%w after %d instructions, before the program stopped", ErrReferenceEnded, lockstep.retired)
+	} else if refErr != nil {
+		return refErr
+	}
+	for _, write := range want.writes {
+		if write.kind == COMMIT_X && write.reg < REG_COUNT && write.reg != REG_ZERO {
+			lockstep.shadow[write.reg] = uint32(write.value)
+		}
+	}
+	if reason := compareCommits(record, want); reason != "" {
+		text, _ := lockstep.disasm.Disassemble(record.pc, record.raw)
+		return &Divergence{reason, lockstep.retired, strings.ReplaceAll(text, "\t", " "), record, want, cpu.registers.x, lockstep.shadow}
+	}
+	lockstep.retired++
+	return err
+}
+
+// Returns the next instruction of the reference, first skipping any it runs before reaching pc, as Spike does
+// its boot ROM
+func (lockstep *Lockstep) next(pc uint32) (*CommitRecord, error) {
+	for {
+		want, err := lockstep.reference.Next()
+		if err == io.EOF && lockstep.retired == 0 {
+			// Nothing was compared, so the program cannot be said to match
+			return nil, fmt.Errorf("%w %08x", ErrReferenceUnreached, pc)
+		}
+		if err != nil || lockstep.retired > 0 || want.pc == pc {
+			return want, err
+		}
+	}
+}
+
+// Describes the first difference between the effects of an instruction and those of the reference, or returns ""
+func compareCommits(got, want *CommitRecord) string {
+	if got.pc != want.pc {
+		return fmt.Sprintf("pc %08x, reference %08x", got.pc, want.pc)
+	}
+
+	// CSRs are left out, as the reference logs those the hardware updates on its own
+	gotWrites, wantWrites := registerWrites(got), registerWrites(want)
+	for i := 0; i < len(gotWrites) || i < len(wantWrites); i++ {
+		switch {
+		case i == len(wantWrites) || i < len(gotWrites) && gotWrites[i].key() < wantWrites[i].key():
+			return fmt.Sprintf("%s written, not by the reference", gotWrites[i].name())
+		case i == len(gotWrites) || gotWrites[i].key() > wantWrites[i].key():
+			return fmt.Sprintf("%s not written, unlike by the reference", wantWrites[i].name())
+		}
+		g, w := gotWrites[i], wantWrites[i]
+		// Floating-point registers may be logged at either width, so only the narrower is compared
+		if mask := valueMask(min(g.width, w.width)); g.value&mask != w.value&mask {
+			return fmt.Sprintf("%s = 0x%0*x, reference 0x%0*x", g.name(), g.width/4, g.value, w.width/4, w.value)
+		}
+	}
+
+	if len(got.stores) != len(want.stores) {
+		return fmt.Sprintf("%d stores, reference %d", len(got.stores), len(want.stores))
+	}
+	for i, g := range got.stores {
+		if w := want.stores[i]; g != w {
+			return fmt.Sprintf("store of 0x%0*x to %08x, reference 0x%0*x to %08x", g.size*2, g.value, g.addr, w.size*2, w.value, w.addr)
+		}
+	}
+	return ""
+}
+
+// Returns a mask of the given number of low bits
+func valueMask(width uint32) uint64 {
+	if width >= 64 {
+		return ^uint64(0)
+	}
+	return uint64(1)<<width - 1
+}
+
+// Returns the integer and floating-point register writes of a record, in the order Spike logs them
+func registerWrites(record *CommitRecord) []CommitWrite {
+	var writes []CommitWrite
+	for _, write := range record.writes {
+		if write.kind == COMMIT_F || write.kind == COMMIT_X && write.reg != REG_ZERO {
+			writes = append(writes, write)
+		}
+	}
+	sort.SliceStable(writes, func(i, j int) bool {
+		return writes[i].key() < writes[j].key()
+	})
+	return writes
+}
+
+// Returns the name of the register written, as the disassembler gives it
+func (write CommitWrite) name() string {
+	switch write.kind {
+	case COMMIT_X:
+		return RegisterName(uint8(write.reg))
+	case COMMIT_F:
+		return floatRegisterNames[write.reg]
+	}
+	return csrNames[write.reg]
+}
+
+// Returns the number of instructions that matched the reference so far
+func (lockstep *Lockstep) Retired() uint64 {
+	return lockstep.retired
+}
+
+// Checks that the reference stops where the program did, returning an error if it has instructions left
+func (lockstep *Lockstep) Finish() error {
+	record, err := lockstep.reference.Next()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return fmt.Errorf("program stopped after %d instructions, but the reference continues at pc %08x", lockstep.retired, record.pc)
+}
+
+func (divergence *Divergence) Error() string {
+	return fmt.Sprintf("diverged from the reference after %d instructions at %08x: %s", divergence.retired, divergence.got.pc, divergence.reason)
+}
+
+// Writes the instruction that diverged as each executed it, followed by the registers each left behind
+func (divergence *Divergence) Report(w io.Writer) {
+	fmt.Fprintf(w, "Diverged from the reference after %d instructions: %s\n", divergence.retired, divergence.reason)
+	fmt.Fprintf(w, "%08x: %s\n", divergence.got.pc, divergence.text)
+	fmt.Fprintf(w, "  rivogo:    %s\n", divergence.got)
+	fmt.Fprintf(w, "  reference: %s\n", divergence.want)
+
+	// Both show the pc of the instruction, as the reference does not log where it continues
+	fmt.Fprintln(w, "RivoGo registers:")
+	writeRegisters(w, &divergence.registers, divergence.got.pc)
+	fmt.Fprintln(w, "Reference registers:")
+	writeRegisters(w, &divergence.reference, divergence.want.pc)
+	var differ []string
+	for reg := range divergence.registers {
+		if divergence.registers[reg] != divergence.reference[reg] {
+			differ = append(differ, RegisterName(uint8(reg)))
+		}
+	}
+	if len(differ) > 0 {
+		fmt.Fprintf(w, "Registers that differ: %s\n", strings.Join(differ, ", "))
+	}
+}
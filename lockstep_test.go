@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Program run against the references, which stores the numbers from 3 down to 1
+const lockstepSource = `
+	li	a0, 0x400
+	li	a1, 3
+1:	sw	a1, 0(a0)
+	addi	a1, a1, -1
+	bnez	a1, 1b
+	ebreak
+`
+
+// Reads every record of a reference log
+func readReference(t *testing.T, log string) []*CommitRecord {
+	t.Helper()
+	reader := NewReferenceReader("ref.log", strings.NewReader(log))
+	var records []*CommitRecord
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return records
+		} else if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		records = append(records, record)
+	}
+}
+
+// Runs the lockstep program against a reference log until it stops
+func runLockstep(t *testing.T, log string) (*Lockstep, error) {
+	t.Helper()
+	cpu := newTestCPU(t, 0, 0x2000)
+	if _, err := cpu.LoadProgram(assemble(t, lockstepSource)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lockstep := NewLockstep(cpu, NewReferenceReader("ref.log", strings.NewReader(log)))
+	for i := 0; i < 100; i++ {
+		if err := lockstep.Step(); err != nil {
+			return lockstep, err
+		}
+	}
+	t.Fatalf("did not stop, pc = %08x", cpu.pc)
+	return nil, nil
+}
+
+func TestReferenceReader(t *testing.T) {
+	// Spike logs the disassembly and exceptions on lines of their own when also given -l
+	spike := readReference(t, `
+core   0: 0x00001000 (0x00000297) auipc   t0, 0x0
+core   0: 3 0x00001000 (0x00000297) x5  0x00001000
+core   0: exception trap_illegal_instruction, epc 0x00001004
+core   0: 3 0x00000010 (0x340596f3) x13 0x00000000 c832_mscratch 0xfffffffe # csrrw a3,mscratch,a1
+core   0: 1 0x00000018 (0x0ca5272f) x14 0xfffe0000 mem 0x00000400 mem 0x00000400 0x00000400
+core   0: 3 0x0000001c (0x4501) f10 0xffffffff3f800000 mem 0x00000402 0xfffe
+`)
+	sail := readReference(t, `
+Running file test.elf.
+[0] [M]: 0x00001000 (0x00000297) auipc t0, 0x0
+x5 <- 0x00001000
+[1] [M]: 0x00001004 (0x00000000) illegal 0x0000
+trapping from M to M to handle illegal-instruction
+[2] [M]: 0x00000010 (0x340596F3) csrrw a3, mscratch, a1
+x13 <- 0x00000000
+CSR mscratch <- 0xFFFFFFFE
+[3] [S]: 0x00000018 (0x0CA5272F) amoswap.w.aq a4, a0, (a0)
+mem[R,0x00000400] -> 0xFFFE0000
+x14 <- 0xFFFE0000
+mem[W,0x00000400] <- 0x00000400
+[4] [M]: 0x0000001C (0x4501) c.li a0, 0
+mem[X,0x0000001C] -> 0x4501
+f10 <- 0xFFFFFFFF3F800000
+mem[0x00000402] <- 0xFFFE
+`)
+
+	for _, records := range [][]*CommitRecord{spike, sail} {
+		if len(records) != 4 {
+			t.Fatalf("len(records) = %d, want 4", len(records))
+		}
+		if got, want := records[0].String(), "core   0: 3 0x00001000 (0x00000297) x5  0x00001000"; got != want {
+			t.Errorf("records[0] = %q, want %q", got, want)
+		}
+		if got, want := records[1].String(), "core   0: 3 0x00000010 (0x340596f3) x13 0x00000000 c832_mscratch 0xfffffffe"; got != want {
+			t.Errorf("records[1] = %q, want %q", got, want)
+		}
+		if got, want := records[2].String(), "core   0: 1 0x00000018 (0x0ca5272f) x14 0xfffe0000 mem 0x00000400 mem 0x00000400 0x00000400"; got != want {
+			t.Errorf("records[2] = %q, want %q", got, want)
+		}
+		if got, want := records[3].String(), "core   0: 3 0x0000001c (0x4501) f10 0xffffffff3f800000 mem 0x00000402 0xfffe"; got != want {
+			t.Errorf("records[3] = %q, want %q", got, want)
+		}
+	}
+
+	// Stores of doubles keep all 64 bits of the value
+	double := "core   0: 3 0x00000020 (0x00b53427) mem 0x00000408 0x3ff0000000000000"
+	record, err := NewReferenceReader("ref.log", strings.NewReader(double)).Next()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (MemoryAccess{0x408, BYTES_PER_DOUBLE, 0x3ff0000000000000}); len(record.stores) != 1 || record.stores[0] != want {
+		t.Errorf("stores = %v, want [%v]", record.stores, want)
+	}
+
+	_, err = NewReferenceReader("ref.log", strings.NewReader("\ncore   0: 3 0x00000000 (0x00000013) q1 0x1\n")).Next()
+	if err == nil || err.Error() != `ref.log:2: unknown register "q1"` {
+		t.Errorf("Next() error = %v, want unknown register", err)
+	}
+}
+
+func TestLockstep(t *testing.T) {
+	// A trace of the program is a reference it matches
+	trace := traceProgram(t, lockstepSource, func(cpu *CPU, tracer *Tracer) {})
+	lockstep, err := runLockstep(t, trace)
+	if !errors.Is(err, ErrBreakpoint) {
+		t.Fatalf("Step() error = %v, want %v", err, ErrBreakpoint)
+	}
+	if retired := lockstep.Retired(); retired != 11 {
+		t.Errorf("Retired() = %d, want 11", retired)
+	}
+	if err := lockstep.Finish(); err != nil {
+		t.Errorf("Finish() error = %v, want nil", err)
+	}
+
+	// One that starts with instructions of a boot ROM is followed from the first pc, until it ends too early
+	lines := strings.SplitAfter(trace, "\n")
+	reference := "core   0: 3 0x00001000 (0x00000297) x5  0x00001000\n" + strings.Join(lines[:4], "")
+	if _, err := runLockstep(t, reference); !errors.Is(err, ErrReferenceEnded) {
+		t.Errorf("Step() error = %v, want %v", err, ErrReferenceEnded)
+	}
+
+	// One that never reaches the program matches nothing
+	_, err = runLockstep(t, "core   0: 3 0x00001000 (0x00000297) x5  0x00001000\n")
+	if !errors.Is(err, ErrReferenceUnreached) || err.Error() != "reference never reached pc 00000000" {
+		t.Errorf("Step() error = %v, want %v", err, ErrReferenceUnreached)
+	}
+
+	// And one that goes on past the ebreak does not end where the program does
+	lockstep, _ = runLockstep(t, trace+"core   0: 3 0x00000018 (0x00000013)\n")
+	want := "program stopped after 11 instructions, but the reference continues at pc 00000018"
+	if err := lockstep.Finish(); err == nil || err.Error() != want {
+		t.Errorf("Finish() error = %v, want %q", err, want)
+	}
+}
+
+func TestLockstepDivergence(t *testing.T) {
+	trace := traceProgram(t, lockstepSource, func(cpu *CPU, tracer *Tracer) {})
+	tests := []struct {
+		old, new string
+		want     string
+	}{
+		{"x11 0x00000001", "x11 0x00000007", "diverged from the reference after 6 instructions at 0000000c: a1 = 0x00000001, reference 0x00000007"},
+		{"mem 0x00000400 0x00000002", "mem 0x00000404 0x00000002", "diverged from the reference after 5 instructions at 00000008: store of 0x00000002 to 00000400, reference 0x00000002 to 00000404"},
+		{"mem 0x00000400 0x00000002", "mem 0x00000400 0x0002", "diverged from the reference after 5 instructions at 00000008: store of 0x00000002 to 00000400, reference 0x0002 to 00000400"},
+		{"(0xfe059ce3) # bnez a1,8\ncore   0: 3 0x00000008", "(0xfe059ce3) # bnez a1,8\ncore   0: 3 0x00000014", "diverged from the reference after 5 instructions at 00000008: pc 00000008, reference 00000014"},
+		{"(0x00300593) x11 0x00000003", "(0x00300593)", "diverged from the reference after 1 instructions at 00000004: a1 written, not by the reference"},
+		{"(0x00b52023)", "(0x00b52023) x12 0x00000000", "diverged from the reference after 2 instructions at 00000008: a2 not written, unlike by the reference"},
+	}
+	for _, tt := range tests {
+		if !strings.Contains(trace, tt.old) {
+			t.Fatalf("trace does not contain %q", tt.old)
+		}
+		_, err := runLockstep(t, strings.Replace(trace, tt.old, tt.new, 1))
+		var divergence *Divergence
+		if !errors.As(err, &divergence) || err.Error() != tt.want {
+			t.Errorf("Step() error = %v, want %q", err, tt.want)
+		}
+	}
+
+	// The report shows the registers each left behind
+	_, err := runLockstep(t, strings.Replace(trace, "x11 0x00000001", "x11 0x00000007", 1))
+	var divergence *Divergence
+	if !errors.As(err, &divergence) {
+		t.Fatalf("Step() error = %v, want a divergence", err)
+	}
+	var report bytes.Buffer
+	divergence.Report(&report)
+	for _, want := range []string{
+		"0000000c: addi a1,a1,-1\n",
+		"  reference: core   0: 3 0x0000000c (0xfff58593) x11 0x00000007\n",
+		"x08: 00000000 00000000 00000400 00000001  ",
+		"x08: 00000000 00000000 00000400 00000007  ",
+		"Registers that differ: a1\n",
+	} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report =\n%s\nwant it to contain %q", report.String(), want)
+		}
+	}
+}
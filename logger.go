@@ -12,15 +12,16 @@ import (
 // Log is the central logger instance that can be used throughout the application.
 var Log *logrus.Logger
 
-func initalizeLogger() {
+// Initializes the logger, writing to the given console as well as to the log file
+func initalizeLogger(console io.Writer) {
 	// Read the value of the APP_ENV environment variable
 	env := os.Getenv("APP_ENV")
 
 	// Create a new logger instance
 	log := logrus.New()
 
-	// configure logrus to output to a file called server.log along with stdout
-	log.SetOutput(io.MultiWriter(os.Stdout, &lumberjack.Logger{
+	// configure logrus to output to a file called server.log along with the console
+	log.SetOutput(io.MultiWriter(console, &lumberjack.Logger{
 		Filename:   "logfile.log",
 		MaxSize:    10, // megabytes
 		MaxBackups: 3,
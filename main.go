@@ -1,35 +1,359 @@
 package main
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
 func main() {
+	os.Exit(run())
+}
+
+// Runs the emulator on the command line arguments, returning the exit status of the process
+func run() int {
 	var err error
 	var cli argsParsed
 
 	// Parse the arguments
 	cli, _ = GetCliArgs()
 
-	// Initialize the logger
-	initalizeLogger()
+	// Initialize the logger, on stderr when stdout carries a trace or a listing so the two do not mix
+	console := io.Writer(os.Stdout)
+	if cli.Trace == "-" || cli.Disasm != nil && (cli.Disasm.Output == "" || cli.Disasm.Output == "-") {
+		console = os.Stderr
+	}
+	initalizeLogger(console)
 
 	var cpu *CPU
 
 	// Initialize the CPU
 	cpu, err = NewCPU(uint32(cli.Start), uint32(cli.Length))
 	if err != nil {
-		return
+		Log.Errorf("Error initializing CPU: %v", err)
+		return 1
+	}
+	for _, region := range cli.RAM {
+		err = cpu.MapRAM(uint32(region.Base), uint32(region.Size))
+		if err != nil {
+			Log.Errorf("Error mapping RAM: %v", err)
+			return 1
+		}
+	}
+
+	cpu.extensions, err = ParseISA(cli.ISA)
+	if err != nil {
+		Log.Errorf("Error selecting instruction set: %v", err)
+		return 1
+	}
+
+	policy, err := ParseMisalignedPolicy(cli.Misaligned)
+	if err != nil {
+		Log.Errorf("Error selecting misaligned access policy: %v", err)
+		return 1
+	}
+	cpu.SetMisalignedPolicy(policy)
+
+	// Load the images into memory, starting at the entry point of the first that has one
+	images := make([]Image, len(cli.FileName))
+	for i, spec := range cli.FileName {
+		images[i], err = ParseImage(spec, cli.Format)
+		if err != nil {
+			Log.Errorf("Error parsing image %s: %v", spec, err)
+			return 1
+		}
+	}
+	// Listing the images replaces running them
+	if cli.Disasm != nil {
+		if !listImages(images, cli.Disasm.Output) {
+			return 1
+		}
+		return 0
+	}
+	// As does assembling them
+	if cli.Asm != nil {
+		if !assembleImages(images, cli.Asm) {
+			return 1
+		}
+		return 0
+	}
+	err = cpu.LoadImages(images)
+	if err != nil {
+		Log.Errorf("Error loading images: %v", err)
+		return 1
+	}
+
+	// Attach the console, after loading so the devices do not get in the way of the images
+	input, output := io.Reader(os.Stdin), io.Writer(os.Stdout)
+	if cli.Debug != nil && cli.UARTInput == "-" {
+		// The debugger reads its commands from stdin
+		input = nil
+	}
+	if cli.UARTInput != "-" {
+		file, err := os.Open(cli.UARTInput)
+		if err != nil {
+			Log.Errorf("Error opening UART input: %v", err)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	}
+	if cli.UARTOutput != "-" {
+		file, err := os.Create(cli.UARTOutput)
+		if err != nil {
+			Log.Errorf("Error opening UART output: %v", err)
+			return 1
+		}
+		defer file.Close()
+		output = file
+	}
+	// Programs using the host-target interface take the console input, and report their exit status through it
+	tohost, fromhost := uint32(cli.ToHost), uint32(cli.FromHost)
+	if tohost == 0 {
+		tohost, _ = cpu.Symbol("tohost")
+		fromhost, _ = cpu.Symbol("fromhost")
+	}
+	if tohost != 0 {
+		err = cpu.AttachHTIF(NewHTIF(input, output), tohost, fromhost)
+		if err != nil {
+			Log.Errorf("Error attaching HTIF: %v", err)
+			return 1
+		}
+		input = nil
+	}
+	err = cpu.AttachUART(NewUART(input, output))
+	if err != nil {
+		Log.Errorf("Error attaching UART: %v", err)
+		return 1
+	}
+
+	// Trace the instructions, once the images have given the symbols the triggers may name
+	finishTrace, ok := startTrace(cpu, cli)
+	if !ok {
+		return 1
 	}
+	defer finishTrace()
 
-	// Load the image into memory
-	cpu.LoadImage(cli.FileName)
-	cpu.DisplayRegisters()
-	cpu.DisplayMemory(cpu.pc, 200)
-	var running = true
-	for running {
-		// Fetch the instruction
-		instruction := cpu.Fetch()
-		// Execute the instruction
-		err = cpu.Execute(instruction)
+	// Logging shows the state the program starts from
+	if cli.Logging {
+		cpu.DisplayRegisters(console)
+		cpu.DisplayMemory(console, cpu.pc, 200)
+	}
+	// GDB controls the program until it detaches, or until it kills the program
+	if cli.GDB != "" {
+		resume, err := debugWithGDB(cpu, cli.GDB)
+		if err != nil {
+			Log.Errorf("Error debugging with GDB: %v", err)
+			return 1
+		}
+		if _, exited := cpu.Exited(); !resume && !exited {
+			return 0
+		}
+	}
+	// The debugger controls the program until the user quits, unless the program exits first
+	if cli.Debug != nil {
+		NewDebugger(cpu, os.Stdin, os.Stdout).Run()
+		if _, exited := cpu.Exited(); !exited {
+			return 0
+		}
+	}
+	// Check each instruction against a reference, from the state the program runs from
+	step := cpu.Step
+	var lockstep *Lockstep
+	if cli.Lockstep != "" {
+		file, err := os.Open(cli.Lockstep)
 		if err != nil {
-			return
+			Log.Errorf("Error opening lockstep reference: %v", err)
+			return 1
 		}
+		defer file.Close()
+		lockstep = NewLockstep(cpu, NewReferenceReader(cli.Lockstep, file))
+		step = lockstep.Step
+	}
+	for {
+		if code, exited := cpu.Exited(); exited {
+			// The program reported its exit status through the host-target interface
+			if code != 0 {
+				Log.Errorf("Program exited with status %d", code)
+			}
+			if !finishRun(cpu, cli, lockstep, console) && code == 0 {
+				code = 1
+			}
+			return code
+		}
+		// Fetch and execute the next instruction
+		err = step()
+		var divergence *Divergence
+		if errors.Is(err, ErrBreakpoint) {
+			// The program handed control back to the environment
+			if !finishRun(cpu, cli, lockstep, console) {
+				return 1
+			}
+			return 0
+		} else if errors.As(err, &divergence) {
+			divergence.Report(console)
+			return 1
+		} else if errors.Is(err, ErrReferenceUnreached) || errors.Is(err, ErrReferenceEnded) {
+			// A reference that does not cover the whole program does not show that it matches
+			Log.Errorf("Error checking against the reference: %v", err)
+			return 1
+		} else if err != nil {
+			Log.Errorf("Unhandled trap at address %08x: %v", cpu.instructionAddress(), err)
+			return 1
+		}
+	}
+}
+
+// Reports on a program that stopped, however it stopped, returning whether the reference and the signature agree
+func finishRun(cpu *CPU, cli argsParsed, lockstep *Lockstep, console io.Writer) bool {
+	if cli.Logging {
+		cpu.DisplayRegisters(console)
 	}
+	if lockstep != nil {
+		if err := lockstep.Finish(); err != nil {
+			Log.Errorf("Error checking against the reference: %v", err)
+			return false
+		}
+		Log.Infof("Matched the reference for %d instructions", lockstep.Retired())
+	}
+	if !dumpSignature(cpu, cli) {
+		return false
+	}
+	Log.Infof("Resident guest memory: %d KiB", cpu.ResidentSize()/1024)
+	if cpu.misaligned == MISALIGNED_COUNT {
+		Log.Infof("Misaligned accesses emulated: %d", cpu.MisalignedAccesses())
+	}
+	return true
+}
+
+// Writes the signature of an architectural test if one was requested, returning whether that succeeded
+func dumpSignature(cpu *CPU, cli argsParsed) bool {
+	if cli.Signature == "" {
+		return true
+	}
+	file, err := os.Create(cli.Signature)
+	if err == nil {
+		err = cpu.WriteSignature(file, cli.SignatureGranularity)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		Log.Errorf("Error writing signature: %v", err)
+		return false
+	}
+	return true
+}
+
+// Attaches a tracer if a trace was requested, returning a function that finishes writing it and whether that succeeded
+func startTrace(cpu *CPU, cli argsParsed) (func(), bool) {
+	if cli.Trace == "" {
+		return func() {}, true
+	}
+	var start, stop uint32
+	var err error
+	if cli.TraceStart != "" {
+		if start, err = cpu.ParseAddress(cli.TraceStart); err != nil {
+			Log.Errorf("Error parsing trace start: %v", err)
+			return nil, false
+		}
+	}
+	if cli.TraceStop != "" {
+		if stop, err = cpu.ParseAddress(cli.TraceStop); err != nil {
+			Log.Errorf("Error parsing trace stop: %v", err)
+			return nil, false
+		}
+	}
+
+	file := os.Stdout
+	if cli.Trace != "-" {
+		if file, err = os.Create(cli.Trace); err != nil {
+			Log.Errorf("Error opening trace output: %v", err)
+			return nil, false
+		}
+	}
+	tracer := NewTracer(file, cpu.symbols)
+	tracer.SetTriggers(start, cli.TraceStart != "", stop, cli.TraceStop != "")
+	cpu.AttachTracer(tracer)
+	return func() {
+		if err := tracer.Flush(); err != nil {
+			Log.Errorf("Error writing trace: %v", err)
+		}
+		if file != os.Stdout {
+			file.Close()
+		}
+	}, true
+}
+
+// Writes the listings of the images to a file, or to stdout for -, returning whether that succeeded
+func listImages(images []Image, path string) bool {
+	output := io.Writer(os.Stdout)
+	if path != "" && path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			Log.Errorf("Error opening listing output: %v", err)
+			return false
+		}
+		defer file.Close()
+		output = file
+	}
+	for _, image := range images {
+		if err := WriteListing(output, image); err != nil {
+			Log.Errorf("Error listing image %s: %v", image.path, err)
+			return false
+		}
+	}
+	return true
+}
+
+// Assembles the source of the single image into an ELF executable, returning whether that succeeded
+func assembleImages(images []Image, options *AsmCmd) bool {
+	if len(images) != 1 {
+		Log.Errorf("Error assembling: expected a single source file, got %d", len(images))
+		return false
+	}
+	source, err := os.Open(images[0].path)
+	if err != nil {
+		Log.Errorf("Error opening source: %v", err)
+		return false
+	}
+	defer source.Close()
+	program, err := NewAssembler(uint32(options.Text), uint32(options.Data)).Assemble(images[0].path, source)
+	if err != nil {
+		Log.Errorf("Error assembling: %v", err)
+		return false
+	}
+
+	file, err := os.Create(options.Output)
+	if err != nil {
+		Log.Errorf("Error opening executable output: %v", err)
+		return false
+	}
+	if err = program.WriteELF(file); err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		file.Close()
+		Log.Errorf("Error writing executable: %v", err)
+		return false
+	}
+	return true
+}
+
+// Waits for GDB to attach and serves it, returning whether the program should keep running without it
+func debugWithGDB(cpu *CPU, address string) (bool, error) {
+	listener, err := ListenGDB(address)
+	if err != nil {
+		return false, fmt.Errorf("listening: %w", err)
+	}
+	defer listener.Close()
+	Log.Infof("Waiting for GDB to attach on %s", address)
+	conn, err := listener.Accept()
+	if err != nil {
+		return false, fmt.Errorf("accepting: %w", err)
+	}
+	defer conn.Close()
+
+	return NewGDBServer(cpu, conn).Serve()
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sends the log to a buffer for the duration of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var output bytes.Buffer
+	previous := Log
+	Log = logrus.New()
+	Log.SetOutput(&output)
+	t.Cleanup(func() { Log = previous })
+	return &output
+}
+
+func TestFinishRun(t *testing.T) {
+	output := captureLog(t)
+	cpu := newTestCPU(t, 0, 0x100)
+	cpu.SetMisalignedPolicy(MISALIGNED_COUNT)
+	if !finishRun(cpu, argsParsed{}, nil, &bytes.Buffer{}) {
+		t.Errorf("finishRun() = false, want true")
+	}
+	for _, want := range []string{"Resident guest memory: 0 KiB", "Misaligned accesses emulated: 0"} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("log =\n%s\nwant it to contain %q", output.String(), want)
+		}
+	}
+}
+
+// Program that exits with status 3 through the tohost word at 0x1000
+var exitProgram = []uint32{0x000012b7, 0x00700393, 0x0072a023, 0x0002a223, 0x0000006f}
+
+// Runs the exit program until it exits, stepping it with the given function of its CPU
+func runExitProgram(t *testing.T, configure func(cpu *CPU) func() error) *CPU {
+	t.Helper()
+	cpu := newTestCPU(t, 0, 0x2000, exitProgram...)
+	if err := cpu.AttachHTIF(NewHTIF(nil, nil), 0x1000, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	step := configure(cpu)
+	for i := 0; i < len(exitProgram); i++ {
+		if err := step(); err != nil {
+			t.Fatalf("Step at %08x: unexpected error: %v", cpu.instructionAddress(), err)
+		}
+		if _, exited := cpu.Exited(); exited {
+			return cpu
+		}
+	}
+	t.Fatalf("did not exit, pc = %08x", cpu.pc)
+	return nil
+}
+
+func TestFinishRunChecksReference(t *testing.T) {
+	output := captureLog(t)
+	var trace bytes.Buffer
+	runExitProgram(t, func(cpu *CPU) func() error {
+		tracer := NewTracer(&trace, cpu.symbols)
+		cpu.AttachTracer(tracer)
+		return func() error {
+			defer tracer.Flush()
+			return cpu.Step()
+		}
+	})
+
+	// The reference goes on past the store that exits the program
+	for _, tt := range []struct {
+		reference string
+		want      bool
+	}{
+		{trace.String(), true},
+		{trace.String() + "core   0: 3 0x00000010 (0x0000006f)\n", false},
+	} {
+		var lockstep *Lockstep
+		cpu := runExitProgram(t, func(cpu *CPU) func() error {
+			lockstep = NewLockstep(cpu, NewReferenceReader("ref.log", strings.NewReader(tt.reference)))
+			return lockstep.Step
+		})
+		if got := finishRun(cpu, argsParsed{}, lockstep, &bytes.Buffer{}); got != tt.want {
+			t.Errorf("finishRun() = %v, want %v\n%s", got, tt.want, output.String())
+		}
+	}
+	if want := "the reference continues at pc 00000010"; !strings.Contains(output.String(), want) {
+		t.Errorf("log =\n%s\nwant it to contain %q", output.String(), want)
+	}
+
+}
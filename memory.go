@@ -0,0 +1,154 @@
+package main
+
+import "encoding/binary"
+
+// Memory is committed in pages of this many bytes
+const (
+	PAGE_SHIFT = 12
+	PAGE_SIZE  = 1 << PAGE_SHIFT
+	PAGE_MASK  = PAGE_SIZE - 1
+)
+
+// Represents a page of committed memory
+type page [PAGE_SIZE]uint8
+
+// Represents a block of read-write memory, whose pages are only committed when first written
+type RAM struct {
+	size  uint32
+	pages map[uint32]*page // Committed pages by page number
+}
+
+// Constructor to initialize zeroed memory of the given size
+func NewRAM(size uint32) *RAM {
+	return &RAM{size: size, pages: make(map[uint32]*page)}
+}
+
+// Returns the page holding the offset, committing it if asked to
+func (ram *RAM) page(offset uint32, commit bool) *page {
+	number := offset >> PAGE_SHIFT
+	p := ram.pages[number]
+	if p == nil && commit {
+		p = new(page)
+		ram.pages[number] = p
+	}
+	return p
+}
+
+// Reads a little-endian value of the given size
+func (ram *RAM) Read(offset uint32, size uint32) (uint32, error) {
+	if size != 1 && size != BYTES_PER_HALF && size != BYTES_PER_WORD {
+		return 0, ErrAccessSize
+	}
+	// Accesses straddling two pages are assembled a byte at a time
+	if offset&PAGE_MASK+size > PAGE_SIZE {
+		var value uint32
+		for i := uint32(0); i < size; i++ {
+			b, _ := ram.Read(offset+i, 1)
+			value |= b << (8 * i)
+		}
+		return value, nil
+	}
+
+	// Uncommitted pages read as zero
+	p := ram.page(offset, false)
+	if p == nil {
+		return 0, nil
+	}
+	data := p[offset&PAGE_MASK:]
+	switch size {
+	case 1:
+		return uint32(data[0]), nil
+	case BYTES_PER_HALF:
+		return uint32(binary.LittleEndian.Uint16(data)), nil
+	default:
+		return binary.LittleEndian.Uint32(data), nil
+	}
+}
+
+// Writes a little-endian value of the given size
+func (ram *RAM) Write(offset uint32, size uint32, value uint32) error {
+	if size != 1 && size != BYTES_PER_HALF && size != BYTES_PER_WORD {
+		return ErrAccessSize
+	}
+	if offset&PAGE_MASK+size > PAGE_SIZE {
+		for i := uint32(0); i < size; i++ {
+			ram.Write(offset+i, 1, value>>(8*i))
+		}
+		return nil
+	}
+
+	data := ram.page(offset, true)[offset&PAGE_MASK:]
+	switch size {
+	case 1:
+		data[0] = uint8(value)
+	case BYTES_PER_HALF:
+		binary.LittleEndian.PutUint16(data, uint16(value))
+	default:
+		binary.LittleEndian.PutUint32(data, value)
+	}
+	return nil
+}
+
+// Copies data into the memory, leaving pages it would only fill with zeros uncommitted
+func (ram *RAM) Load(offset uint32, data []byte) error {
+	if uint64(offset)+uint64(len(data)) > uint64(ram.size) {
+		return ErrUnmapped
+	}
+	for len(data) > 0 {
+		chunk := data[:min(len(data), int(PAGE_SIZE-offset&PAGE_MASK))]
+		if p := ram.page(offset, !isZero(chunk)); p != nil {
+			copy(p[offset&PAGE_MASK:], chunk)
+		}
+		offset += uint32(len(chunk))
+		data = data[len(chunk):]
+	}
+	return nil
+}
+
+// Zeroes a range of the memory, releasing the pages it covers entirely instead of committing any
+func (ram *RAM) Clear(offset uint32, size uint32) error {
+	if uint64(offset)+uint64(size) > uint64(ram.size) {
+		return ErrUnmapped
+	}
+	for size > 0 {
+		chunk := min(size, PAGE_SIZE-offset&PAGE_MASK)
+		if chunk == PAGE_SIZE {
+			delete(ram.pages, offset>>PAGE_SHIFT)
+		} else if p := ram.page(offset, false); p != nil {
+			clear(p[offset&PAGE_MASK : offset&PAGE_MASK+chunk])
+		}
+		offset += chunk
+		size -= chunk
+	}
+	return nil
+}
+
+// Returns the number of bytes of host memory committed to the pages written so far
+func (ram *RAM) Resident() uint64 {
+	return uint64(len(ram.pages)) * PAGE_SIZE
+}
+
+// Returns whether all the bytes are zero
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Represents a block of read-only memory, whose contents can only be loaded directly
+type ROM struct {
+	RAM
+}
+
+// Constructor to initialize zeroed read-only memory of the given size
+func NewROM(size uint32) *ROM {
+	return &ROM{*NewRAM(size)}
+}
+
+// Rejects writes to the read-only memory
+func (rom *ROM) Write(offset uint32, size uint32, value uint32) error {
+	return ErrReadOnly
+}
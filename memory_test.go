@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestRAMCommitsPagesOnWrite(t *testing.T) {
+	ram := NewRAM(MEM_MAX_SIZE)
+	if value, _ := ram.Read(0x8000_0000, BYTES_PER_WORD); value != 0 || ram.Resident() != 0 {
+		t.Errorf("reading untouched memory = %#x with %d bytes resident, want 0 with none", value, ram.Resident())
+	}
+
+	ram.Write(0x8000_0000, BYTES_PER_WORD, 0xdead_beef)
+	if value, _ := ram.Read(0x8000_0000, BYTES_PER_WORD); value != 0xdead_beef {
+		t.Errorf("Read(0x80000000) = %#x, want 0xdeadbeef", value)
+	}
+	if ram.Resident() != PAGE_SIZE {
+		t.Errorf("Resident() = %d, want a single page", ram.Resident())
+	}
+}
+
+func TestRAMAccessAcrossPages(t *testing.T) {
+	ram := NewRAM(2 * PAGE_SIZE)
+	ram.Write(PAGE_SIZE-2, BYTES_PER_WORD, 0x1122_3344)
+	if value, _ := ram.Read(PAGE_SIZE-2, BYTES_PER_WORD); value != 0x1122_3344 {
+		t.Errorf("Read across pages = %#x, want 0x11223344", value)
+	}
+	if value, _ := ram.Read(PAGE_SIZE, BYTES_PER_HALF); value != 0x1122 {
+		t.Errorf("Read(PAGE_SIZE) = %#x, want the upper half of the word", value)
+	}
+	if ram.Resident() != 2*PAGE_SIZE {
+		t.Errorf("Resident() = %d, want both pages", ram.Resident())
+	}
+}
+
+func TestRAMLoadSkipsZeroPages(t *testing.T) {
+	ram := NewRAM(4 * PAGE_SIZE)
+	data := make([]byte, 3*PAGE_SIZE)
+	data[PAGE_SIZE+5] = 0x42
+	if err := ram.Load(0, data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ram.Resident() != PAGE_SIZE {
+		t.Errorf("Resident() = %d, want only the page holding data", ram.Resident())
+	}
+
+	// Zeros are still copied into pages that are already committed
+	ram.Load(PAGE_SIZE, make([]byte, PAGE_SIZE))
+	if value, _ := ram.Read(PAGE_SIZE+5, 1); value != 0 {
+		t.Errorf("Read after loading zeros = %#x, want 0", value)
+	}
+	if err := ram.Load(4*PAGE_SIZE-1, []byte{1, 2}); err == nil {
+		t.Errorf("loading past the end of the memory should fail")
+	}
+}
+
+func TestCPUWithSparseRegions(t *testing.T) {
+	cpu := newTestCPU(t, 0, MEM_MAX_SIZE)
+	if err := cpu.MapRAM(0x8000_0000, 0x1000); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cpu.ResidentSize() != 0 {
+		t.Errorf("ResidentSize() = %d, want nothing committed before any write", cpu.ResidentSize())
+	}
+
+	storeWords(t, cpu, 0x100, 1)
+	storeWords(t, cpu, 0x8000_0000, 2)
+	if cpu.ResidentSize() != 2*PAGE_SIZE {
+		t.Errorf("ResidentSize() = %d, want a page in each region", cpu.ResidentSize())
+	}
+}
@@ -1,5 +1,11 @@
 package main
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // An enum containing all the possible registers of the processor
 const (
 	REG_ZERO = iota // Hard-wired zero
@@ -37,6 +43,58 @@ const (
 	REG_COUNT // Number of registers
 )
 
+// ABI names of the registers, indexed by register number
+var registerNames = [REG_COUNT]string{
+	"zero", "ra", "sp", "gp", "tp", "t0", "t1", "t2",
+	"s0", "s1", "a0", "a1", "a2", "a3", "a4", "a5",
+	"a6", "a7", "s2", "s3", "s4", "s5", "s6", "s7",
+	"s8", "s9", "s10", "s11", "t3", "t4", "t5", "t6",
+}
+
+// Represents the integer registers of the processor, where x0 is hard-wired to zero
+type RegisterFile struct {
+	x       [REG_COUNT]uint32
+	written uint32 // Registers written since last cleared, a bit per register
+}
+
+// Returns the value of a register
+func (file *RegisterFile) Read(reg uint8) uint32 {
+	return file.x[reg]
+}
+
+// Sets the value of a register, discarding writes to x0
+func (file *RegisterFile) Write(reg uint8, value uint32) {
+	if reg != REG_ZERO {
+		file.x[reg] = value
+		file.written |= 1 << reg
+	}
+}
+
+// Returns the ABI name of a register
+func RegisterName(reg uint8) string {
+	return registerNames[reg]
+}
+
+// Returns the number of the register with the given ABI name (including fp for s0) or xN name
+func LookupRegister(name string) (uint8, error) {
+	name = strings.ToLower(name)
+	if name == "fp" {
+		return REG_S0, nil
+	}
+	for reg, abiName := range registerNames {
+		if name == abiName {
+			return uint8(reg), nil
+		}
+	}
+	if number, ok := strings.CutPrefix(name, "x"); ok {
+		reg, err := strconv.ParseUint(number, 10, 8)
+		if err == nil && reg < REG_COUNT && (number == "0" || number[0] != '0') {
+			return uint8(reg), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown register %q", name)
+}
+
 // RISC-V Constants
 const (
 	XLEN             uint32 = 32          // Width of a register in bits
@@ -48,14 +106,20 @@ const (
 	PC_START         uint32 = 0x0000_0000 // Default program counter start address
 )
 
-// Memory-mapped Registers
-// const (
-// 	MMREG_MTIMECMP = iota
+// Memory-mapped registers of the core-local interruptor
+const (
+	REG_CLINT_BASE uint32 = 0x0200_0000 // Base address of the CLINT
+	REG_CLINT_SIZE uint32 = 0x0001_0000 // Size of the CLINT address range
+	REG_MSIP       uint32 = 0x0200_0000 // Machine software interrupt pending
+	REG_MTIMECMP   uint32 = 0x0200_4000 // Machine timer compare
+	REG_MTIME      uint32 = 0x0200_BFF8 // Machine timer
+)
 
-// const REG_MTIMECMP uint32 = 0x02004000
-// const REG_MTIME uint32 = 0x0200BFF8
-// const REG_MTIME_BASE uint32 = 0x0200B000
-// const REG_MTIME_SIZE uint32 = 0x4000
+// Memory-mapped registers of the UART
+const (
+	REG_UART_BASE uint32 = 0x1000_0000 // Base address of the UART
+	REG_UART_SIZE uint32 = 0x0000_0100 // Size of the UART address range
+)
 
 // Memory-mapped I/O
 // const MMIO_BASE uint32 = 0x30000000
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestRegisterZeroIsHardwired(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100)
+	// addi zero, zero, 5; lw zero, 0(zero)
+	for _, instruction := range []uint32{0x00500013, 0x00002003} {
+		if err := cpu.Execute(instruction); err != nil {
+			t.Fatalf("Execute(%08x): unexpected error: %v", instruction, err)
+		}
+		if value := cpu.registers.Read(REG_ZERO); value != 0 {
+			t.Errorf("after %08x, zero = %d, want 0", instruction, value)
+		}
+	}
+	cpu.SetRegister("x0", 7)
+	if value, _ := cpu.Register("zero"); value != 0 {
+		t.Errorf("zero = %d after SetRegister, want 0", value)
+	}
+}
+
+func TestLookupRegister(t *testing.T) {
+	tests := []struct {
+		name string
+		reg  uint8
+	}{
+		{"zero", REG_ZERO},
+		{"a0", REG_A0},
+		{"sp", REG_SP},
+		{"s11", REG_S11},
+		{"fp", REG_S0},
+		{"S0", REG_S0},
+		{"x0", REG_ZERO},
+		{"x31", REG_T6},
+	}
+
+	for _, test := range tests {
+		reg, err := LookupRegister(test.name)
+		if err != nil || reg != test.reg {
+			t.Errorf("LookupRegister(%q) = %d, %v, want %d", test.name, reg, err, test.reg)
+		}
+	}
+	for _, name := range []string{"x32", "x01", "a8", "", "pc"} {
+		if _, err := LookupRegister(name); err == nil {
+			t.Errorf("LookupRegister(%q) should fail", name)
+		}
+	}
+	if name := RegisterName(REG_S11); name != "s11" {
+		t.Errorf("RegisterName(REG_S11) = %q, want s11", name)
+	}
+}
+
+func TestRegisterAccessors(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100)
+	if err := cpu.SetRegister("a1", 0x1234); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value, _ := cpu.Register("x11"); value != 0x1234 {
+		t.Errorf("x11 = %#x, want the value written to a1", value)
+	}
+	if err := cpu.SetRegister("q9", 0); err == nil {
+		t.Errorf("SetRegister of an unknown register should fail")
+	}
+}
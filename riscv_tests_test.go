@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Directory holding the executables of the riscv-tests
+const riscvTestDir = "testdata/riscv-tests/isa"
+
+// Address the riscv-tests are linked at, and the RAM given to them
+const (
+	riscvTestBase uint32 = 0x8000_0000
+	riscvTestRAM  uint32 = 0x1_0000
+)
+
+// Instructions a test may execute before it is considered hung
+const riscvTestSteps = 1_000_000
+
+// Runs the rv32 riscv-tests in testdata, which report their result through tohost
+func TestRISCVTests(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(riscvTestDir, "rv32*-p-*"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no riscv-tests in %s", riscvTestDir)
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			runRISCVTest(t, path)
+		})
+	}
+}
+
+// Loads a test and runs it until it writes its result to tohost
+func runRISCVTest(t *testing.T, path string) {
+	cpu := newTestCPU(t, 0, 0)
+	if err := cpu.MapRAM(riscvTestBase, riscvTestRAM); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	image, err := ParseImage(path, string(IMAGE_ELF))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cpu.LoadImages([]Image{image}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tohost, ok := cpu.Symbol("tohost")
+	if !ok {
+		t.Fatal("test does not define tohost")
+	}
+	fromhost, _ := cpu.Symbol("fromhost")
+	if err := cpu.AttachHTIF(NewHTIF(nil, nil), tohost, fromhost); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < riscvTestSteps; i++ {
+		if err := cpu.Step(); err != nil {
+			t.Fatalf("Step at %08x: unexpected error: %v", cpu.instructionAddress(), err)
+		}
+		if code, exited := cpu.Exited(); exited {
+			// A failing test exits with the number of the test case that failed
+			if code != 0 {
+				t.Errorf("failed test case %d", code)
+			}
+			return
+		}
+	}
+	t.Fatalf("did not finish within %d instructions, pc = %08x", riscvTestSteps, cpu.pc)
+}
@@ -0,0 +1,164 @@
+package main
+
+import "fmt"
+
+// Represents the reservation set registered by a load-reserved instruction
+type Reservation struct {
+	valid bool   // Whether a reservation is currently held
+	addr  uint32 // Address of the reserved word
+}
+
+// Registers a reservation on the word containing the given address
+func (reservation *Reservation) acquire(addr uint32) {
+	reservation.valid = true
+	reservation.addr = addr &^ (BYTES_PER_WORD - 1)
+}
+
+// Returns whether a reservation is held on the word containing the given address
+func (reservation *Reservation) holds(addr uint32) bool {
+	return reservation.valid && reservation.addr == addr&^(BYTES_PER_WORD-1)
+}
+
+// Drops the reservation if a store of the given size overlaps the reserved word
+func (reservation *Reservation) invalidate(addr uint32, size uint32) {
+	if reservation.valid && addr < reservation.addr+BYTES_PER_WORD && reservation.addr < addr+size {
+		reservation.valid = false
+	}
+}
+
+// Wraps the bus of a CPU so that writes from any agent, not only its own stores, drop the reservation they overlap
+type reservedBus struct {
+	Bus
+	reservation *Reservation
+}
+
+// Writes a value through to the bus, dropping the reservation if the write succeeds
+func (bus *reservedBus) Write(addr uint32, size uint32, value uint32) error {
+	if err := bus.Bus.Write(addr, size, value); err != nil {
+		return err
+	}
+	bus.reservation.invalidate(addr, size)
+	return nil
+}
+
+// Writes a doubleword through to the bus, dropping the reservation if the write succeeds
+func (bus *reservedBus) WriteDouble(addr uint32, value uint64) error {
+	if err := bus.Bus.WriteDouble(addr, value); err != nil {
+		return err
+	}
+	bus.reservation.invalidate(addr, BYTES_PER_DOUBLE)
+	return nil
+}
+
+// Loads data through to the bus, dropping the reservation if the data covers it
+func (bus *reservedBus) Load(addr uint32, data []byte) error {
+	if err := bus.Bus.Load(addr, data); err != nil {
+		return err
+	}
+	bus.reservation.invalidate(addr, uint32(len(data)))
+	return nil
+}
+
+// Zeroes memory through to the bus, dropping the reservation if the range covers it
+func (bus *reservedBus) Clear(addr uint32, size uint32) error {
+	if err := bus.Bus.Clear(addr, size); err != nil {
+		return err
+	}
+	bus.reservation.invalidate(addr, size)
+	return nil
+}
+
+// Executes the corresponding atomic memory operation based on the mnemonic
+func (cpu *CPU) ExecuteAMOType(instruction *AssemblyInstruction) error {
+	// The aq and rl ordering bits need no action on a single in-order hart
+	switch instruction.mnemonic {
+	case "lr.w":
+		return cpu.LR_W(instruction)
+	case "sc.w":
+		return cpu.SC_W(instruction)
+	case "amoswap.w":
+		return cpu.atomic(instruction, func(loaded uint32, operand uint32) uint32 { return operand })
+	case "amoadd.w":
+		return cpu.atomic(instruction, func(loaded uint32, operand uint32) uint32 { return loaded + operand })
+	case "amoxor.w":
+		return cpu.atomic(instruction, func(loaded uint32, operand uint32) uint32 { return loaded ^ operand })
+	case "amoand.w":
+		return cpu.atomic(instruction, func(loaded uint32, operand uint32) uint32 { return loaded & operand })
+	case "amoor.w":
+		return cpu.atomic(instruction, func(loaded uint32, operand uint32) uint32 { return loaded | operand })
+	case "amomin.w":
+		return cpu.atomic(instruction, func(loaded uint32, operand uint32) uint32 {
+			return uint32(min(int32(loaded), int32(operand)))
+		})
+	case "amomax.w":
+		return cpu.atomic(instruction, func(loaded uint32, operand uint32) uint32 {
+			return uint32(max(int32(loaded), int32(operand)))
+		})
+	case "amominu.w":
+		return cpu.atomic(instruction, func(loaded uint32, operand uint32) uint32 { return min(loaded, operand) })
+	case "amomaxu.w":
+		return cpu.atomic(instruction, func(loaded uint32, operand uint32) uint32 { return max(loaded, operand) })
+	default:
+		return fmt.Errorf("unknown atomic instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Checks that an atomic access is naturally aligned, raising the given misaligned-address exception if not
+func checkAtomicAlignment(addr uint32, cause uint32) error {
+	if addr%BYTES_PER_WORD != 0 {
+		return newTrap(cause, addr, "misaligned atomic access: %08x", addr)
+	}
+	return nil
+}
+
+// Loads a word from memory and registers a reservation on it
+func (cpu *CPU) LR_W(instruction *AssemblyInstruction) error {
+	addr := cpu.registers.Read(instruction.rs1)
+	if err := checkAtomicAlignment(addr, CAUSE_LOAD_ADDRESS_MISALIGNED); err != nil {
+		return err
+	}
+	value, err := cpu.FetchWord(addr)
+	if err != nil {
+		return err
+	}
+	cpu.registers.Write(instruction.rd, value)
+	cpu.reservation.acquire(addr)
+	return nil
+}
+
+// Stores a word to memory if the reservation is still held, writing 0 to rd on success and 1 on failure
+func (cpu *CPU) SC_W(instruction *AssemblyInstruction) error {
+	addr := cpu.registers.Read(instruction.rs1)
+	if err := checkAtomicAlignment(addr, CAUSE_STORE_ADDRESS_MISALIGNED); err != nil {
+		return err
+	}
+	if !cpu.reservation.holds(addr) {
+		cpu.reservation.valid = false
+		cpu.registers.Write(instruction.rd, 1)
+		return nil
+	}
+	if err := cpu.StoreWord(addr, cpu.registers.Read(instruction.rs2)); err != nil {
+		return err
+	}
+	cpu.reservation.valid = false
+	cpu.registers.Write(instruction.rd, 0)
+	return nil
+}
+
+// Atomically loads a word, combines it with rs2 and stores the result, writing the original word to rd
+func (cpu *CPU) atomic(instruction *AssemblyInstruction, operation func(loaded uint32, operand uint32) uint32) error {
+	addr := cpu.registers.Read(instruction.rs1)
+	if err := checkAtomicAlignment(addr, CAUSE_STORE_ADDRESS_MISALIGNED); err != nil {
+		return err
+	}
+	// Atomic memory operations report faults as stores even when the load fails
+	loaded, err := cpu.FetchWord(addr)
+	if err != nil {
+		return newTrap(CAUSE_STORE_ACCESS_FAULT, addr, "store access fault: invalid address %08x", addr)
+	}
+	if err := cpu.StoreWord(addr, operation(loaded, cpu.registers.Read(instruction.rs2))); err != nil {
+		return err
+	}
+	cpu.registers.Write(instruction.rd, loaded)
+	return nil
+}
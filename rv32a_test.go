@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestReservationDroppedByBusWrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		write   func(bus Bus) error
+		dropped bool
+	}{
+		{"word write", func(bus Bus) error { return bus.Write(0x100, BYTES_PER_WORD, 5) }, true},
+		{"byte write inside the word", func(bus Bus) error { return bus.Write(0x103, 1, 5) }, true},
+		{"doubleword write", func(bus Bus) error { return bus.WriteDouble(0xf8, 0) }, false},
+		{"doubleword write over the word", func(bus Bus) error { return bus.WriteDouble(0xfc, 0) }, true},
+		{"load", func(bus Bus) error { return bus.Load(0xfe, []byte{1, 2, 3}) }, true},
+		{"clear", func(bus Bus) error { return bus.Clear(0x100, 0x40) }, true},
+		{"write after the word", func(bus Bus) error { return bus.Write(0x104, BYTES_PER_WORD, 5) }, false},
+	}
+	for _, tt := range tests {
+		// lr.w a0, (a1); sc.w a2, a3, (a1), with a write from outside the CPU in between
+		cpu := newTestCPU(t, 0, 0x200, 0x1005a52f, 0x18d5a62f)
+		cpu.registers.Write(REG_A1, 0x100)
+		step(t, cpu, 1)
+		if err := tt.write(cpu.bus); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		step(t, cpu, 1)
+		// sc.w writes 1 when it fails and 0 when it succeeds
+		if failed := cpu.registers.Read(REG_A2) == 1; failed != tt.dropped {
+			t.Errorf("%s: sc.w failed = %v, want %v", tt.name, failed, tt.dropped)
+		}
+	}
+}
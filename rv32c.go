@@ -0,0 +1,221 @@
+package main
+
+import "fmt"
+
+// Masks selecting the fields of a compressed instruction
+const (
+	MASK_QUADRANT uint32 = 0x3 // The two lowest bits select the quadrant
+	QUADRANT_0    uint32 = 0x0 // Stack-pointer based and register-relative loads and stores
+	QUADRANT_1    uint32 = 0x1 // Immediate arithmetic, jumps and branches
+	QUADRANT_2    uint32 = 0x2 // Stack-pointer loads and stores, moves and register jumps
+	QUADRANT_NONE uint32 = 0x3 // Not a compressed instruction
+)
+
+// Returns whether the instruction parcel is a 16-bit compressed instruction
+func isCompressed(parcel uint32) bool {
+	return parcel&MASK_QUADRANT != QUADRANT_NONE
+}
+
+// Returns the full register number of a 3-bit compressed register field (x8-x15)
+func compressedRegister(field uint32) uint32 {
+	return 8 + field&0x7
+}
+
+// Returns the given bit of a value
+func bit(value uint32, position uint) uint32 {
+	return (value >> position) & 0x1
+}
+
+// Returns the bits [high:low] of a value
+func bits(value uint32, high uint, low uint) uint32 {
+	return (value >> low) & (1<<(high-low+1) - 1)
+}
+
+// Encodes an R-format instruction
+func encodeR(opcode InstructionType, funct3 uint32, funct7 uint32, rd uint32, rs1 uint32, rs2 uint32) uint32 {
+	return funct7Match(opcode, funct3, funct7) | rd<<REGISTER_RD_SHIFT | rs1<<REGISTER_RS1_SHIFT | rs2<<REGISTER_RS2_SHIFT
+}
+
+// Encodes an I-format instruction
+func encodeI(opcode InstructionType, funct3 uint32, rd uint32, rs1 uint32, imm int32) uint32 {
+	return funct3Match(opcode, funct3) | rd<<REGISTER_RD_SHIFT | rs1<<REGISTER_RS1_SHIFT | uint32(imm)<<20
+}
+
+// Encodes an S-format instruction
+func encodeS(opcode InstructionType, funct3 uint32, rs1 uint32, rs2 uint32, imm int32) uint32 {
+	offset := uint32(imm)
+	return funct3Match(opcode, funct3) | rs1<<REGISTER_RS1_SHIFT | rs2<<REGISTER_RS2_SHIFT |
+		bits(offset, 11, 5)<<25 | bits(offset, 4, 0)<<7
+}
+
+// Encodes a B-format instruction
+func encodeB(opcode InstructionType, funct3 uint32, rs1 uint32, rs2 uint32, imm int32) uint32 {
+	offset := uint32(imm)
+	return funct3Match(opcode, funct3) | rs1<<REGISTER_RS1_SHIFT | rs2<<REGISTER_RS2_SHIFT |
+		bit(offset, 12)<<31 | bits(offset, 10, 5)<<25 | bits(offset, 4, 1)<<8 | bit(offset, 11)<<7
+}
+
+// Encodes a U-format instruction whose immediate already holds the upper 20 bits in place
+func encodeU(opcode InstructionType, rd uint32, imm int32) uint32 {
+	return opcodeMatch(opcode) | rd<<REGISTER_RD_SHIFT | uint32(imm)&0xFFFFF000
+}
+
+// Encodes a J-format instruction
+func encodeJ(opcode InstructionType, rd uint32, imm int32) uint32 {
+	offset := uint32(imm)
+	return opcodeMatch(opcode) | rd<<REGISTER_RD_SHIFT |
+		bit(offset, 20)<<31 | bits(offset, 10, 1)<<21 | bit(offset, 11)<<20 | bits(offset, 19, 12)<<12
+}
+
+// Expands a 16-bit compressed instruction into its equivalent 32-bit instruction
+func Expand(parcel uint16) (uint32, error) {
+	c := uint32(parcel)
+	funct3 := bits(c, 15, 13)
+
+	// Register fields shared by many of the formats
+	rd := bits(c, 11, 7)
+	rs2 := bits(c, 6, 2)
+	rdPrime := compressedRegister(bits(c, 4, 2))
+	rs1Prime := compressedRegister(bits(c, 9, 7))
+
+	// The 6-bit sign-extended immediate used by the CI format
+	imm6 := signExtend(bit(c, 12)<<5|bits(c, 6, 2), 6)
+
+	switch c & MASK_QUADRANT {
+	case QUADRANT_0:
+		// Offsets of the word-sized CL/CS formats
+		wordOffset := int32(bits(c, 12, 10)<<3 | bit(c, 6)<<2 | bit(c, 5)<<6)
+		doubleOffset := int32(bits(c, 12, 10)<<3 | bits(c, 6, 5)<<6)
+		switch funct3 {
+		case 0x0: // c.addi4spn
+			nzuimm := int32(bits(c, 12, 11)<<4 | bits(c, 10, 7)<<6 | bit(c, 6)<<2 | bit(c, 5)<<3)
+			if nzuimm == 0 {
+				break
+			}
+			return encodeI(I_TYPE_ARITH, 0x0, rdPrime, REG_SP, nzuimm), nil
+		case 0x1: // c.fld
+			return encodeI(I_TYPE_LOAD_FP, 0x3, rdPrime, rs1Prime, doubleOffset), nil
+		case 0x2: // c.lw
+			return encodeI(I_TYPE_LOAD, 0x2, rdPrime, rs1Prime, wordOffset), nil
+		case 0x3: // c.flw
+			return encodeI(I_TYPE_LOAD_FP, 0x2, rdPrime, rs1Prime, wordOffset), nil
+		case 0x5: // c.fsd
+			return encodeS(S_TYPE_FP, 0x3, rs1Prime, rdPrime, doubleOffset), nil
+		case 0x6: // c.sw
+			return encodeS(S_TYPE, 0x2, rs1Prime, rdPrime, wordOffset), nil
+		case 0x7: // c.fsw
+			return encodeS(S_TYPE_FP, 0x2, rs1Prime, rdPrime, wordOffset), nil
+		}
+	case QUADRANT_1:
+		// Offset of the CJ format
+		jumpOffset := signExtend(bit(c, 12)<<11|bit(c, 11)<<4|bits(c, 10, 9)<<8|bit(c, 8)<<10|
+			bit(c, 7)<<6|bit(c, 6)<<7|bits(c, 5, 3)<<1|bit(c, 2)<<5, 12)
+		// Offset of the CB branch format
+		branchOffset := signExtend(bit(c, 12)<<8|bits(c, 11, 10)<<3|bits(c, 6, 5)<<6|bits(c, 4, 3)<<1|bit(c, 2)<<5, 9)
+		switch funct3 {
+		case 0x0: // c.addi, c.nop
+			return encodeI(I_TYPE_ARITH, 0x0, rd, rd, imm6), nil
+		case 0x1: // c.jal
+			return encodeJ(J_TYPE, REG_RA, jumpOffset), nil
+		case 0x2: // c.li
+			return encodeI(I_TYPE_ARITH, 0x0, rd, REG_ZERO, imm6), nil
+		case 0x3:
+			if rd == REG_SP { // c.addi16sp
+				nzimm := signExtend(bit(c, 12)<<9|bit(c, 6)<<4|bit(c, 5)<<6|bits(c, 4, 3)<<7|bit(c, 2)<<5, 10)
+				if nzimm == 0 {
+					break
+				}
+				return encodeI(I_TYPE_ARITH, 0x0, REG_SP, REG_SP, nzimm), nil
+			}
+			if imm6 == 0 { // c.lui
+				break
+			}
+			return encodeU(U_TYPE_LUI, rd, imm6<<12), nil
+		case 0x4:
+			shamt := int32(bits(c, 6, 2))
+			switch bits(c, 11, 10) {
+			case 0x0: // c.srli
+				if bit(c, 12) != 0 {
+					break
+				}
+				return encodeI(I_TYPE_ARITH, 0x5, rs1Prime, rs1Prime, shamt), nil
+			case 0x1: // c.srai
+				if bit(c, 12) != 0 {
+					break
+				}
+				return encodeI(I_TYPE_ARITH, 0x5, rs1Prime, rs1Prime, shamt|0x400), nil
+			case 0x2: // c.andi
+				return encodeI(I_TYPE_ARITH, 0x7, rs1Prime, rs1Prime, imm6), nil
+			case 0x3:
+				if bit(c, 12) != 0 {
+					break
+				}
+				switch bits(c, 6, 5) {
+				case 0x0: // c.sub
+					return encodeR(R_TYPE, 0x0, 0x20, rs1Prime, rs1Prime, rdPrime), nil
+				case 0x1: // c.xor
+					return encodeR(R_TYPE, 0x4, 0x00, rs1Prime, rs1Prime, rdPrime), nil
+				case 0x2: // c.or
+					return encodeR(R_TYPE, 0x6, 0x00, rs1Prime, rs1Prime, rdPrime), nil
+				case 0x3: // c.and
+					return encodeR(R_TYPE, 0x7, 0x00, rs1Prime, rs1Prime, rdPrime), nil
+				}
+			}
+		case 0x5: // c.j
+			return encodeJ(J_TYPE, REG_ZERO, jumpOffset), nil
+		case 0x6: // c.beqz
+			return encodeB(B_TYPE, 0x0, rs1Prime, REG_ZERO, branchOffset), nil
+		case 0x7: // c.bnez
+			return encodeB(B_TYPE, 0x1, rs1Prime, REG_ZERO, branchOffset), nil
+		}
+	case QUADRANT_2:
+		// Offsets of the stack-pointer relative CI and CSS formats
+		wordOffset := int32(bit(c, 12)<<5 | bits(c, 6, 4)<<2 | bits(c, 3, 2)<<6)
+		doubleOffset := int32(bit(c, 12)<<5 | bits(c, 6, 5)<<3 | bits(c, 4, 2)<<6)
+		storeWordOffset := int32(bits(c, 12, 9)<<2 | bits(c, 8, 7)<<6)
+		storeDoubleOffset := int32(bits(c, 12, 10)<<3 | bits(c, 9, 7)<<6)
+		switch funct3 {
+		case 0x0: // c.slli
+			if bit(c, 12) != 0 {
+				break
+			}
+			return encodeI(I_TYPE_ARITH, 0x1, rd, rd, int32(bits(c, 6, 2))), nil
+		case 0x1: // c.fldsp
+			return encodeI(I_TYPE_LOAD_FP, 0x3, rd, REG_SP, doubleOffset), nil
+		case 0x2: // c.lwsp
+			if rd == REG_ZERO {
+				break
+			}
+			return encodeI(I_TYPE_LOAD, 0x2, rd, REG_SP, wordOffset), nil
+		case 0x3: // c.flwsp
+			return encodeI(I_TYPE_LOAD_FP, 0x2, rd, REG_SP, wordOffset), nil
+		case 0x4:
+			if bit(c, 12) == 0 {
+				if rs2 == REG_ZERO { // c.jr
+					if rd == REG_ZERO {
+						break
+					}
+					return encodeI(I_TYPE_JALR, 0x0, REG_ZERO, rd, 0), nil
+				}
+				// c.mv
+				return encodeR(R_TYPE, 0x0, 0x00, rd, REG_ZERO, rs2), nil
+			}
+			if rs2 == REG_ZERO {
+				if rd == REG_ZERO { // c.ebreak
+					return 0x0010_0073, nil
+				}
+				// c.jalr
+				return encodeI(I_TYPE_JALR, 0x0, REG_RA, rd, 0), nil
+			}
+			// c.add
+			return encodeR(R_TYPE, 0x0, 0x00, rd, rd, rs2), nil
+		case 0x5: // c.fsdsp
+			return encodeS(S_TYPE_FP, 0x3, REG_SP, rs2, storeDoubleOffset), nil
+		case 0x6: // c.swsp
+			return encodeS(S_TYPE, 0x2, REG_SP, rs2, storeWordOffset), nil
+		case 0x7: // c.fswsp
+			return encodeS(S_TYPE_FP, 0x2, REG_SP, rs2, storeWordOffset), nil
+		}
+	}
+	return 0, fmt.Errorf("illegal compressed instruction: %04x", parcel)
+}
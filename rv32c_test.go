@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeCompressedKnownEncodings(t *testing.T) {
+	tests := []struct {
+		parcel   uint32
+		mnemonic string
+		rd       uint8
+		rs1      uint8
+		rs2      uint8
+		imm      int32
+	}{
+		{0x0808, "addi", 10, 2, 0, 16},      // c.addi4spn a0, sp, 16
+		{0x424c, "lw", 11, 12, 0, 4},        // c.lw a1, 4(a2)
+		{0xdcf4, "sw", 0, 9, 13, 124},       // c.sw a3, 124(s1)
+		{0x0001, "addi", 0, 0, 0, 0},        // c.nop
+		{0x1501, "addi", 10, 10, 0, -32},    // c.addi a0, -32
+		{0x3001, "jal", 1, 0, 0, -2048},     // c.jal -2048
+		{0x42fd, "addi", 5, 0, 0, 31},       // c.li t0, 31
+		{0x7101, "addi", 2, 2, 0, -512},     // c.addi16sp sp, -512
+		{0x7785, "lui", 15, 0, 0, -0x1f000}, // c.lui a5, 0xfffe1
+		{0x807d, "srli", 8, 8, 0, 31},       // c.srli s0, 31
+		{0x8785, "srai", 15, 15, 0, 1},      // c.srai a5, 1
+		{0x9b7d, "andi", 14, 14, 0, -1},     // c.andi a4, -1
+		{0x8c89, "sub", 9, 9, 10, 0},        // c.sub s1, a0
+		{0x8d2d, "xor", 10, 10, 11, 0},      // c.xor a0, a1
+		{0x8e55, "or", 12, 12, 13, 0},       // c.or a2, a3
+		{0x8f7d, "and", 14, 14, 15, 0},      // c.and a4, a5
+		{0xaffd, "jal", 0, 0, 0, 2046},      // c.j 2046
+		{0xd001, "beq", 0, 8, 0, -256},      // c.beqz s0, -256
+		{0xeffd, "bne", 0, 15, 0, 254},      // c.bnez a5, 254
+		{0x0f9e, "slli", 31, 31, 0, 7},      // c.slli t6, 7
+		{0x50fe, "lw", 1, 2, 0, 252},        // c.lwsp ra, 252(sp)
+		{0x8282, "jalr", 0, 5, 0, 0},        // c.jr t0
+		{0x856e, "add", 10, 0, 27, 0},       // c.mv a0, s11
+		{0x9002, "ebreak", 0, 0, 0, 1},      // c.ebreak
+		{0x9582, "jalr", 1, 11, 0, 0},       // c.jalr a1
+		{0x931e, "add", 6, 6, 7, 0},         // c.add t1, t2
+		{0xc222, "sw", 0, 2, 8, 4},          // c.swsp s0, 4(sp)
+	}
+
+	for _, test := range tests {
+		decoded, err := Decode(test.parcel)
+		if err != nil {
+			t.Errorf("Decode(%04x): unexpected error: %v", test.parcel, err)
+			continue
+		}
+		if decoded.mnemonic != test.mnemonic {
+			t.Errorf("Decode(%04x): mnemonic = %q, want %q", test.parcel, decoded.mnemonic, test.mnemonic)
+		}
+		if decoded.rd != test.rd || decoded.rs1 != test.rs1 || decoded.rs2 != test.rs2 {
+			t.Errorf("Decode(%04x): registers = (%d, %d, %d), want (%d, %d, %d)", test.parcel,
+				decoded.rd, decoded.rs1, decoded.rs2, test.rd, test.rs1, test.rs2)
+		}
+		if decoded.imm != test.imm {
+			t.Errorf("Decode(%04x): imm = %d, want %d", test.parcel, decoded.imm, test.imm)
+		}
+		if decoded.size != BYTES_PER_HALF || decoded.raw != test.parcel {
+			t.Errorf("Decode(%04x): size/raw = %d/%04x, want %d/%04x", test.parcel, decoded.size, decoded.raw, BYTES_PER_HALF, test.parcel)
+		}
+	}
+}
+
+func TestExpandReservedEncodings(t *testing.T) {
+	tests := []uint16{
+		0x0000, // all zeroes is defined to be illegal
+		0x6101, // c.addi16sp with a zero immediate
+		0x6501, // c.lui with a zero immediate
+		0x4002, // c.lwsp into x0
+		0x8002, // c.jr through x0
+		0x9c01, // c.subw is RV64 only
+		0x9001, // c.srli with shamt[5] set is RV64 only
+		0x1002, // c.slli with shamt[5] set is RV64 only
+	}
+
+	for _, parcel := range tests {
+		if expanded, err := Expand(parcel); err == nil {
+			t.Errorf("Expand(%04x) = %08x, want an error", parcel, expanded)
+		}
+	}
+}
+
+func TestCompressedExecution(t *testing.T) {
+	// c.li a0, 1; c.addi a0, 1; c.nop; addi a0, a0, 1, which starts halfway through a word
+	cpu := newTestCPU(t, 0, 0x100, 0x05054505, 0x05130001, 0x00000015)
+	for _, want := range []uint32{2, 4, 6, 10} {
+		step(t, cpu, 1)
+		if cpu.pc != want {
+			t.Errorf("pc = %08x, want %08x", cpu.pc, want)
+		}
+	}
+	if a0 := cpu.registers.Read(REG_A0); a0 != 3 {
+		t.Errorf("a0 = %d, want 3", a0)
+	}
+}
+
+func TestCompressedJumpAlignment(t *testing.T) {
+	// jal zero, 6, whose target is only aligned to a parcel
+	cpu := newTestCPU(t, 0, 0x100, 0x0060006f)
+	step(t, cpu, 1)
+	if cpu.pc != 6 {
+		t.Errorf("pc = %08x, want 00000006", cpu.pc)
+	}
+
+	// Without the C extension instructions must be aligned to words
+	cpu = newTestCPU(t, 0, 0x100, 0x0060006f)
+	cpu.extensions, _ = ParseISA("rv32i")
+	err := cpu.Step()
+	var trap *Trap
+	if !errors.As(err, &trap) || trap.cause != CAUSE_INSTRUCTION_ADDRESS_MISALIGNED || trap.value != 6 {
+		t.Errorf("Step() = %v, want a misaligned instruction address of 00000006", err)
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import "fmt"
+
+// Fields of the floating-point control and status register
+const (
+	FCSR_FFLAGS_MASK  uint32 = 0x1F // Accrued exception flags
+	FCSR_FRM_SHIFT    uint32 = 5    // Position of the dynamic rounding mode
+	FCSR_FRM_MASK     uint32 = 0x7  // Width of the dynamic rounding mode
+	FCSR_MASK         uint32 = 0xFF // Implemented bits of fcsr
+	NAN_BOX_UPPER     uint64 = 0xFFFF_FFFF_0000_0000
+	FLOAT_FORMAT_MASK uint8  = 0x3 // Selects the format in the low bits of funct7
+)
+
+// Returns the floating-point format an instruction operates on
+func floatFormatOf(instruction *AssemblyInstruction) floatFormat {
+	if instruction.funct7&FLOAT_FORMAT_MASK == 0x1 {
+		return FLOAT64
+	}
+	return FLOAT32
+}
+
+// Reads a floating-point register in the given format, treating improperly NaN-boxed singles as the canonical NaN
+func (cpu *CPU) readFloat(reg uint8, f floatFormat) uint64 {
+	value := cpu.fregisters[reg]
+	if f == FLOAT32 {
+		if value&NAN_BOX_UPPER != NAN_BOX_UPPER {
+			return f.canonicalNaN()
+		}
+		return value &^ NAN_BOX_UPPER
+	}
+	return value
+}
+
+// Writes a floating-point register in the given format, NaN-boxing singles
+func (cpu *CPU) writeFloat(reg uint8, f floatFormat, value uint64) {
+	if f == FLOAT32 {
+		value |= NAN_BOX_UPPER
+	}
+	cpu.fregisters[reg] = value
+	cpu.dirtyFloatState()
+	if cpu.commit != nil {
+		cpu.commit.fregs |= 1 << reg
+	}
+}
+
+// Accrues exception flags into fcsr
+func (cpu *CPU) raiseFloatFlags(flags uint8) {
+	cpu.fcsr |= uint32(flags)
+	if flags != 0 {
+		cpu.dirtyFloatState()
+		cpu.commitCSR(CSR_FFLAGS)
+	}
+}
+
+// Returns an error making the instruction illegal if mstatus.FS has turned the floating-point unit off
+func (cpu *CPU) checkFloatEnabled() error {
+	if cpu.csrs[CSR_MSTATUS].value&MSTATUS_FS == MSTATUS_FS_OFF {
+		return fmt.Errorf("illegal instruction: the floating-point unit is off")
+	}
+	return nil
+}
+
+// Marks the floating-point state dirty in mstatus.FS, after an instruction changed it
+func (cpu *CPU) dirtyFloatState() {
+	status := cpu.csrs[CSR_MSTATUS]
+	if status.value&MSTATUS_FS != MSTATUS_FS_DIRTY {
+		status.value |= MSTATUS_FS_DIRTY
+		cpu.commitCSR(CSR_MSTATUS)
+	}
+}
+
+// Resolves the rounding mode of an instruction, substituting the dynamic mode from frm
+func (cpu *CPU) roundingMode(instruction *AssemblyInstruction) (uint8, error) {
+	mode := instruction.funct3
+	if mode == RM_DYN {
+		mode = uint8((cpu.fcsr >> FCSR_FRM_SHIFT) & FCSR_FRM_MASK)
+	}
+	if mode > RM_RMM {
+		return 0, fmt.Errorf("illegal instruction: invalid rounding mode %d for %s", mode, instruction.mnemonic)
+	}
+	return mode, nil
+}
+
+// Executes the corresponding floating-point load instruction based on the mnemonic
+func (cpu *CPU) ExecuteFLoadType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "flw":
+		return cpu.FLW(instruction)
+	case "fld":
+		return cpu.FLD(instruction)
+	default:
+		return fmt.Errorf("unknown floating-point load instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Loads a single from memory into a floating-point register
+func (cpu *CPU) FLW(instruction *AssemblyInstruction) error {
+	value, err := cpu.FetchWord(cpu.registers.Read(instruction.rs1) + uint32(instruction.imm))
+	if err != nil {
+		return err
+	}
+	cpu.writeFloat(instruction.rd, FLOAT32, uint64(value))
+	return nil
+}
+
+// Loads a double from memory into a floating-point register
+func (cpu *CPU) FLD(instruction *AssemblyInstruction) error {
+	value, err := cpu.FetchDouble(cpu.registers.Read(instruction.rs1) + uint32(instruction.imm))
+	if err != nil {
+		return err
+	}
+	cpu.writeFloat(instruction.rd, FLOAT64, value)
+	return nil
+}
+
+// Executes the corresponding floating-point store instruction based on the mnemonic
+func (cpu *CPU) ExecuteFStoreType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "fsw":
+		return cpu.FSW(instruction)
+	case "fsd":
+		return cpu.FSD(instruction)
+	default:
+		return fmt.Errorf("unknown floating-point store instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Stores the lower 32 bits of a floating-point register into memory
+func (cpu *CPU) FSW(instruction *AssemblyInstruction) error {
+	return cpu.StoreWord(cpu.registers.Read(instruction.rs1)+uint32(instruction.imm), uint32(cpu.fregisters[instruction.rs2]))
+}
+
+// Stores a floating-point register into memory
+func (cpu *CPU) FSD(instruction *AssemblyInstruction) error {
+	return cpu.StoreDouble(cpu.registers.Read(instruction.rs1)+uint32(instruction.imm), cpu.fregisters[instruction.rs2])
+}
+
+// Executes the corresponding fused multiply-add instruction based on its opcode
+func (cpu *CPU) ExecuteFMAType(instruction *AssemblyInstruction) error {
+	switch instruction.instructionType {
+	case R4_TYPE_FMADD:
+		return cpu.fusedMultiplyAdd(instruction, false, false)
+	case R4_TYPE_FMSUB:
+		return cpu.fusedMultiplyAdd(instruction, false, true)
+	case R4_TYPE_FNMSUB:
+		return cpu.fusedMultiplyAdd(instruction, true, false)
+	case R4_TYPE_FNMADD:
+		return cpu.fusedMultiplyAdd(instruction, true, true)
+	default:
+		return fmt.Errorf("unknown fused multiply-add instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Computes rs1*rs2+rs3 with a single rounding, negating the product and addend as requested
+func (cpu *CPU) fusedMultiplyAdd(instruction *AssemblyInstruction, negateProduct bool, negateAddend bool) error {
+	f := floatFormatOf(instruction)
+	mode, err := cpu.roundingMode(instruction)
+	if err != nil {
+		return err
+	}
+	result, flags := f.fusedMultiplyAdd(cpu.readFloat(instruction.rs1, f), cpu.readFloat(instruction.rs2, f),
+		cpu.readFloat(instruction.rs3, f), negateProduct, negateAddend, mode)
+	cpu.raiseFloatFlags(flags)
+	cpu.writeFloat(instruction.rd, f, result)
+	return nil
+}
+
+// Executes the corresponding floating-point computational instruction based on the mnemonic
+func (cpu *CPU) ExecuteFPType(instruction *AssemblyInstruction) error {
+	switch instruction.mnemonic {
+	case "fadd.s", "fadd.d":
+		return cpu.FADD(instruction)
+	case "fsub.s", "fsub.d":
+		return cpu.FSUB(instruction)
+	case "fmul.s", "fmul.d":
+		return cpu.FMUL(instruction)
+	case "fdiv.s", "fdiv.d":
+		return cpu.FDIV(instruction)
+	case "fsqrt.s", "fsqrt.d":
+		return cpu.FSQRT(instruction)
+	case "fsgnj.s", "fsgnj.d", "fsgnjn.s", "fsgnjn.d", "fsgnjx.s", "fsgnjx.d":
+		return cpu.FSGNJ(instruction)
+	case "fmin.s", "fmin.d", "fmax.s", "fmax.d":
+		return cpu.FMINMAX(instruction)
+	case "fcvt.w.s", "fcvt.w.d", "fcvt.wu.s", "fcvt.wu.d":
+		return cpu.FCVT_W(instruction)
+	case "fcvt.s.w", "fcvt.d.w", "fcvt.s.wu", "fcvt.d.wu":
+		return cpu.FCVT_FROM_W(instruction)
+	case "fcvt.s.d", "fcvt.d.s":
+		return cpu.FCVT_FORMAT(instruction)
+	case "feq.s", "feq.d", "flt.s", "flt.d", "fle.s", "fle.d":
+		return cpu.FCOMPARE(instruction)
+	case "fclass.s", "fclass.d":
+		return cpu.FCLASS(instruction)
+	case "fmv.x.w":
+		return cpu.FMV_X_W(instruction)
+	case "fmv.w.x":
+		return cpu.FMV_W_X(instruction)
+	default:
+		return fmt.Errorf("unknown floating-point instruction: %s", instruction.mnemonic)
+	}
+}
+
+// Applies a rounding binary operation to rs1 and rs2 and writes the result to rd
+func (cpu *CPU) floatBinary(instruction *AssemblyInstruction, operation func(f floatFormat, a uint64, b uint64, mode uint8) (uint64, uint8)) error {
+	f := floatFormatOf(instruction)
+	mode, err := cpu.roundingMode(instruction)
+	if err != nil {
+		return err
+	}
+	result, flags := operation(f, cpu.readFloat(instruction.rs1, f), cpu.readFloat(instruction.rs2, f), mode)
+	cpu.raiseFloatFlags(flags)
+	cpu.writeFloat(instruction.rd, f, result)
+	return nil
+}
+
+// Adds two floating-point registers
+func (cpu *CPU) FADD(instruction *AssemblyInstruction) error {
+	return cpu.floatBinary(instruction, floatFormat.add)
+}
+
+// Subtracts two floating-point registers
+func (cpu *CPU) FSUB(instruction *AssemblyInstruction) error {
+	return cpu.floatBinary(instruction, floatFormat.sub)
+}
+
+// Multiplies two floating-point registers
+func (cpu *CPU) FMUL(instruction *AssemblyInstruction) error {
+	return cpu.floatBinary(instruction, floatFormat.mul)
+}
+
+// Divides two floating-point registers
+func (cpu *CPU) FDIV(instruction *AssemblyInstruction) error {
+	return cpu.floatBinary(instruction, floatFormat.div)
+}
+
+// Computes the square root of a floating-point register
+func (cpu *CPU) FSQRT(instruction *AssemblyInstruction) error {
+	f := floatFormatOf(instruction)
+	mode, err := cpu.roundingMode(instruction)
+	if err != nil {
+		return err
+	}
+	result, flags := f.sqrt(cpu.readFloat(instruction.rs1, f), mode)
+	cpu.raiseFloatFlags(flags)
+	cpu.writeFloat(instruction.rd, f, result)
+	return nil
+}
+
+// Combines the magnitude of rs1 with a sign derived from rs2: copied, negated or xored depending on funct3
+func (cpu *CPU) FSGNJ(instruction *AssemblyInstruction) error {
+	f := floatFormatOf(instruction)
+	a := cpu.readFloat(instruction.rs1, f)
+	b := cpu.readFloat(instruction.rs2, f)
+	var sign uint64
+	switch instruction.funct3 {
+	case 0x0:
+		sign = b & f.signBit()
+	case 0x1:
+		sign = ^b & f.signBit()
+	case 0x2:
+		sign = (a ^ b) & f.signBit()
+	}
+	cpu.writeFloat(instruction.rd, f, a&^f.signBit()|sign)
+	return nil
+}
+
+// Writes the smaller (funct3 0) or larger (funct3 1) of two floating-point registers
+func (cpu *CPU) FMINMAX(instruction *AssemblyInstruction) error {
+	f := floatFormatOf(instruction)
+	result, flags := f.minMax(cpu.readFloat(instruction.rs1, f), cpu.readFloat(instruction.rs2, f), instruction.funct3 == 0x1)
+	cpu.raiseFloatFlags(flags)
+	cpu.writeFloat(instruction.rd, f, result)
+	return nil
+}
+
+// Converts a floating-point register to a signed (rs2 0) or unsigned (rs2 1) integer register
+func (cpu *CPU) FCVT_W(instruction *AssemblyInstruction) error {
+	f := floatFormatOf(instruction)
+	mode, err := cpu.roundingMode(instruction)
+	if err != nil {
+		return err
+	}
+	result, flags := f.toInt(cpu.readFloat(instruction.rs1, f), instruction.rs2 == 0x0, mode)
+	cpu.raiseFloatFlags(flags)
+	cpu.registers.Write(instruction.rd, result)
+	return nil
+}
+
+// Converts a signed (rs2 0) or unsigned (rs2 1) integer register to a floating-point register
+func (cpu *CPU) FCVT_FROM_W(instruction *AssemblyInstruction) error {
+	f := floatFormatOf(instruction)
+	mode, err := cpu.roundingMode(instruction)
+	if err != nil {
+		return err
+	}
+	result, flags := f.fromInt(cpu.registers.Read(instruction.rs1), instruction.rs2 == 0x0, mode)
+	cpu.raiseFloatFlags(flags)
+	cpu.writeFloat(instruction.rd, f, result)
+	return nil
+}
+
+// Converts a floating-point register between single and double precision
+func (cpu *CPU) FCVT_FORMAT(instruction *AssemblyInstruction) error {
+	to := floatFormatOf(instruction)
+	from := FLOAT64
+	if to == FLOAT64 {
+		from = FLOAT32
+	}
+	mode, err := cpu.roundingMode(instruction)
+	if err != nil {
+		return err
+	}
+	result, flags := to.convert(cpu.readFloat(instruction.rs1, from), from, mode)
+	cpu.raiseFloatFlags(flags)
+	cpu.writeFloat(instruction.rd, to, result)
+	return nil
+}
+
+// Compares two floating-point registers for equality (funct3 2), less than (funct3 1) or less or equal (funct3 0)
+func (cpu *CPU) FCOMPARE(instruction *AssemblyInstruction) error {
+	f := floatFormatOf(instruction)
+	a := cpu.readFloat(instruction.rs1, f)
+	b := cpu.readFloat(instruction.rs2, f)
+	var result bool
+	var flags uint8
+	switch instruction.funct3 {
+	case 0x2:
+		result, flags = f.equal(a, b)
+	case 0x1:
+		result, flags = f.less(a, b, false)
+	case 0x0:
+		result, flags = f.less(a, b, true)
+	}
+	cpu.raiseFloatFlags(flags)
+	if result {
+		cpu.registers.Write(instruction.rd, 1)
+	} else {
+		cpu.registers.Write(instruction.rd, 0)
+	}
+	return nil
+}
+
+// Writes a mask describing the class of a floating-point register
+func (cpu *CPU) FCLASS(instruction *AssemblyInstruction) error {
+	f := floatFormatOf(instruction)
+	cpu.registers.Write(instruction.rd, f.classify(cpu.readFloat(instruction.rs1, f)))
+	return nil
+}
+
+// Moves the raw lower 32 bits of a floating-point register into an integer register
+func (cpu *CPU) FMV_X_W(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, uint32(cpu.fregisters[instruction.rs1]))
+	return nil
+}
+
+// Moves the raw bits of an integer register into a floating-point register
+func (cpu *CPU) FMV_W_X(instruction *AssemblyInstruction) error {
+	cpu.writeFloat(instruction.rd, FLOAT32, uint64(cpu.registers.Read(instruction.rs1)))
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import "math"
+
+// Multiplies two registers and stores the lower 32 bits of the product in a third register
+func (cpu *CPU) MUL(instruction *AssemblyInstruction) error {
+	cpu.registers.Write(instruction.rd, cpu.registers.Read(instruction.rs1)*cpu.registers.Read(instruction.rs2))
+	return nil
+}
+
+// Multiplies two signed registers and stores the upper 32 bits of the product in a third register
+func (cpu *CPU) MULH(instruction *AssemblyInstruction) error {
+	product := int64(int32(cpu.registers.Read(instruction.rs1))) * int64(int32(cpu.registers.Read(instruction.rs2)))
+	cpu.registers.Write(instruction.rd, uint32(product>>XLEN))
+	return nil
+}
+
+// Multiplies a signed register by an unsigned register and stores the upper 32 bits of the product
+func (cpu *CPU) MULHSU(instruction *AssemblyInstruction) error {
+	product := int64(int32(cpu.registers.Read(instruction.rs1))) * int64(cpu.registers.Read(instruction.rs2))
+	cpu.registers.Write(instruction.rd, uint32(product>>XLEN))
+	return nil
+}
+
+// Multiplies two unsigned registers and stores the upper 32 bits of the product in a third register
+func (cpu *CPU) MULHU(instruction *AssemblyInstruction) error {
+	product := uint64(cpu.registers.Read(instruction.rs1)) * uint64(cpu.registers.Read(instruction.rs2))
+	cpu.registers.Write(instruction.rd, uint32(product>>XLEN))
+	return nil
+}
+
+// Divides two signed registers, rounding towards zero
+func (cpu *CPU) DIV(instruction *AssemblyInstruction) error {
+	dividend := int32(cpu.registers.Read(instruction.rs1))
+	divisor := int32(cpu.registers.Read(instruction.rs2))
+	if divisor == 0 {
+		// Division by zero yields all ones rather than trapping
+		cpu.registers.Write(instruction.rd, math.MaxUint32)
+	} else if dividend == math.MinInt32 && divisor == -1 {
+		// Signed overflow yields the dividend
+		cpu.registers.Write(instruction.rd, uint32(dividend))
+	} else {
+		cpu.registers.Write(instruction.rd, uint32(dividend/divisor))
+	}
+	return nil
+}
+
+// Divides two unsigned registers
+func (cpu *CPU) DIVU(instruction *AssemblyInstruction) error {
+	dividend := cpu.registers.Read(instruction.rs1)
+	divisor := cpu.registers.Read(instruction.rs2)
+	if divisor == 0 {
+		cpu.registers.Write(instruction.rd, math.MaxUint32)
+	} else {
+		cpu.registers.Write(instruction.rd, dividend/divisor)
+	}
+	return nil
+}
+
+// Computes the remainder of dividing two signed registers, taking the sign of the dividend
+func (cpu *CPU) REM(instruction *AssemblyInstruction) error {
+	dividend := int32(cpu.registers.Read(instruction.rs1))
+	divisor := int32(cpu.registers.Read(instruction.rs2))
+	if divisor == 0 {
+		// Division by zero yields the dividend
+		cpu.registers.Write(instruction.rd, uint32(dividend))
+	} else if dividend == math.MinInt32 && divisor == -1 {
+		// Signed overflow yields zero
+		cpu.registers.Write(instruction.rd, 0)
+	} else {
+		cpu.registers.Write(instruction.rd, uint32(dividend%divisor))
+	}
+	return nil
+}
+
+// Computes the remainder of dividing two unsigned registers
+func (cpu *CPU) REMU(instruction *AssemblyInstruction) error {
+	dividend := cpu.registers.Read(instruction.rs1)
+	divisor := cpu.registers.Read(instruction.rs2)
+	if divisor == 0 {
+		cpu.registers.Write(instruction.rd, dividend)
+	} else {
+		cpu.registers.Write(instruction.rd, dividend%divisor)
+	}
+	return nil
+}
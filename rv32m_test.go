@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMultiplyDivide(t *testing.T) {
+	tests := []struct {
+		mnemonic string
+		a, b     int64 // Operands, written to rs1 and rs2
+		want     uint32
+	}{
+		{"mul", -3, 7, 0xffff_ffeb},
+		{"mulh", -1, -1, 0},
+		{"mulh", 0x7fff_ffff, -1, 0xffff_ffff},
+		// rs1 is signed and rs2 unsigned, so -1 times 0xffffffff is -0xffffffff
+		{"mulhsu", -1, 0xffff_ffff, 0xffff_ffff},
+		{"mulhsu", 2, 0xffff_ffff, 1},
+		{"mulhsu", -2, 1, 0xffff_ffff},
+		{"mulhu", 0xffff_ffff, 0xffff_ffff, 0xffff_fffe},
+		{"div", -7, 2, 0xffff_fffd},
+		{"div", 7, 0, 0xffff_ffff},
+		{"div", -0x8000_0000, -1, 0x8000_0000},
+		{"divu", 7, 0, 0xffff_ffff},
+		{"divu", -1, 2, 0x7fff_ffff},
+		{"rem", -7, 2, 0xffff_ffff},
+		{"rem", -7, 0, 0xffff_fff9},
+		{"rem", -0x8000_0000, -1, 0},
+		{"remu", 7, 0, 7},
+		{"remu", -1, 16, 15},
+	}
+	for _, tt := range tests {
+		source := fmt.Sprintf("li a1, %d\nli a2, %d\n%s a0, a1, a2\nebreak\n", int32(tt.a), int32(tt.b), tt.mnemonic)
+		cpu := runProgram(t, assemble(t, source))
+		if got := cpu.registers.Read(REG_A0); got != tt.want {
+			t.Errorf("%s(%#x, %#x) = %08x, want %08x", tt.mnemonic, uint32(tt.a), uint32(tt.b), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Number of signature bytes written per line unless another granularity is chosen
+const SIGNATURE_GRANULARITY uint32 = BYTES_PER_WORD
+
+// Writes the memory between the begin_signature and end_signature symbols, as
+// riscv-arch-test expects: one line of lowercase hex per granularity bytes,
+// most significant byte first, with the last line padded with zeros
+func (cpu *CPU) WriteSignature(writer io.Writer, granularity uint32) error {
+	if granularity == 0 || granularity&(granularity-1) != 0 {
+		return fmt.Errorf("signature granularity %d is not a power of two", granularity)
+	}
+	begin, ok := cpu.Symbol("begin_signature")
+	if !ok {
+		return fmt.Errorf("the program does not define begin_signature")
+	}
+	end, ok := cpu.Symbol("end_signature")
+	if !ok {
+		return fmt.Errorf("the program does not define end_signature")
+	}
+	if end < begin {
+		return fmt.Errorf("end_signature %08x precedes begin_signature %08x", end, begin)
+	}
+
+	output := bufio.NewWriter(writer)
+	line := make([]byte, granularity)
+	length := end - begin
+	for offset := uint32(0); offset < length; offset += granularity {
+		for i := range line {
+			line[i] = 0
+			if offset+uint32(i) >= length {
+				continue
+			}
+			addr := begin + offset + uint32(i)
+			value, err := cpu.FetchByte(addr)
+			if err != nil {
+				return fmt.Errorf("error reading the signature at %08x: %v", addr, err)
+			}
+			line[i] = value
+		}
+		for i := len(line) - 1; i >= 0; i-- {
+			fmt.Fprintf(output, "%02x", line[i])
+		}
+		output.WriteByte('\n')
+	}
+	return output.Flush()
+}
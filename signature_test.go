@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Creates a CPU whose signature spans the given bytes at 00000100
+func newSignatureCPU(t *testing.T, signature []byte) *CPU {
+	t.Helper()
+	cpu := newTestCPU(t, 0, 0x200)
+	for i, value := range signature {
+		if err := cpu.StoreByte(0x100+uint32(i), value); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	cpu.symbols["begin_signature"] = 0x100
+	cpu.symbols["end_signature"] = 0x100 + uint32(len(signature))
+	return cpu
+}
+
+func TestWriteSignature(t *testing.T) {
+	signature := []byte{0x78, 0x56, 0x34, 0x12, 0xef, 0xbe, 0xad, 0xde, 0x01, 0x02}
+	tests := []struct {
+		name        string
+		granularity uint32
+		want        string
+	}{
+		{"words", 4, "12345678\ndeadbeef\n00000201\n"},
+		{"doublewords", 8, "deadbeef12345678\n0000000000000201\n"},
+		{"bytes", 1, "78\n56\n34\n12\nef\nbe\nad\nde\n01\n02\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu := newSignatureCPU(t, signature)
+			var output bytes.Buffer
+			if err := cpu.WriteSignature(&output, tt.granularity); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output.String() != tt.want {
+				t.Errorf("signature = %q, want %q", output.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteSignatureErrors(t *testing.T) {
+	var output bytes.Buffer
+	cpu := newSignatureCPU(t, nil)
+	if err := cpu.WriteSignature(&output, 3); err == nil {
+		t.Error("expected an error for a granularity that is not a power of two")
+	}
+	cpu.symbols["end_signature"] = 0xfc
+	if err := cpu.WriteSignature(&output, 4); err == nil {
+		t.Error("expected an error for a signature that ends before it begins")
+	}
+	delete(cpu.symbols, "begin_signature")
+	if err := cpu.WriteSignature(&output, 4); err == nil {
+		t.Error("expected an error for a program without begin_signature")
+	}
+	if output.Len() != 0 {
+		t.Errorf("signature = %q, want nothing written on errors", output.String())
+	}
+}
@@ -0,0 +1,143 @@
+// Command mkelf turns an object file assembled by llvm-mc, whose references
+// have all been resolved, into an executable loaded at a fixed address. It
+// stands in for a linker, which the riscv-tests would otherwise need.
+//
+// Usage: go run ./mkelf -base 0x80000000 -o rv32ui-p-add add.o
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+func main() {
+	base := flag.Uint64("base", 0x8000_0000, "address the code is loaded at")
+	output := flag.String("o", "", "executable to write")
+	flag.Parse()
+	if flag.NArg() != 1 || *output == "" {
+		fmt.Fprintln(os.Stderr, "usage: mkelf [-base address] -o executable object")
+		os.Exit(2)
+	}
+	if err := link(flag.Arg(0), *output, uint32(*base)); err != nil {
+		fmt.Fprintf(os.Stderr, "mkelf: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Converts the .text section and global symbols of the object into an executable
+func link(input string, output string, base uint32) error {
+	object, err := elf.Open(input)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	var text *elf.Section
+	for _, section := range object.Sections {
+		switch {
+		case section.Type == elf.SHT_RELA || section.Type == elf.SHT_REL:
+			return fmt.Errorf("%s has unresolved relocations in %s", input, section.Name)
+		case section.Name == ".text":
+			text = section
+		case section.Flags&elf.SHF_ALLOC != 0 && section.Size > 0:
+			return fmt.Errorf("%s has contents outside .text in %s", input, section.Name)
+		}
+	}
+	if text == nil {
+		return fmt.Errorf("%s has no .text section", input)
+	}
+	code, err := text.Data()
+	if err != nil {
+		return err
+	}
+
+	symbols := make(map[string]uint32)
+	list, _ := object.Symbols()
+	for _, symbol := range list {
+		if elf.ST_BIND(symbol.Info) == elf.STB_GLOBAL && int(symbol.Section) < len(object.Sections) &&
+			object.Sections[symbol.Section] == text {
+			symbols[symbol.Name] = base + uint32(symbol.Value)
+		}
+	}
+	entry, ok := symbols["_start"]
+	if !ok {
+		return fmt.Errorf("%s does not define _start", input)
+	}
+	return os.WriteFile(output, executable(code, base, entry, symbols), 0o644)
+}
+
+// Lays out a 32-bit RISC-V executable with a single loadable segment and a symbol table
+func executable(code []byte, base uint32, entry uint32, symbols map[string]uint32) []byte {
+	const headerSize, progSize, sectionSize, symbolSize = 52, 32, 40, 16
+
+	// Sort the symbols so the output does not depend on map order
+	names := make([]string, 0, len(symbols))
+	for name := range symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	strtab := []byte{0}
+	symtab := []elf.Sym32{{}}
+	for _, name := range names {
+		symtab = append(symtab, elf.Sym32{
+			Name:  uint32(len(strtab)),
+			Value: symbols[name],
+			Info:  elf.ST_INFO(elf.STB_GLOBAL, elf.STT_NOTYPE),
+			Shndx: 1,
+		})
+		strtab = append(append(strtab, name...), 0)
+	}
+	shstrtab := []byte("\x00.text\x00.symtab\x00.strtab\x00.shstrtab\x00")
+
+	var contents bytes.Buffer
+	textOffset := uint32(headerSize + progSize)
+	contents.Write(code)
+	for contents.Len()%4 != 0 {
+		contents.WriteByte(0)
+	}
+	symtabOffset := textOffset + uint32(contents.Len())
+	binary.Write(&contents, binary.LittleEndian, symtab)
+	strtabOffset := textOffset + uint32(contents.Len())
+	contents.Write(strtab)
+	shstrtabOffset := textOffset + uint32(contents.Len())
+	contents.Write(shstrtab)
+	for contents.Len()%4 != 0 {
+		contents.WriteByte(0)
+	}
+
+	prog := elf.Prog32{
+		Type: uint32(elf.PT_LOAD), Off: textOffset, Vaddr: base, Paddr: base,
+		Filesz: uint32(len(code)), Memsz: uint32(len(code)),
+		Flags: uint32(elf.PF_R | elf.PF_W | elf.PF_X), Align: 0x1000,
+	}
+	sections := []elf.Section32{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_PROGBITS), Flags: uint32(elf.SHF_ALLOC | elf.SHF_EXECINSTR | elf.SHF_WRITE),
+			Addr: base, Off: textOffset, Size: uint32(len(code)), Addralign: 64},
+		{Name: 7, Type: uint32(elf.SHT_SYMTAB), Off: symtabOffset, Size: uint32(len(symtab) * symbolSize), Link: 3, Info: 1, Entsize: symbolSize},
+		{Name: 15, Type: uint32(elf.SHT_STRTAB), Off: strtabOffset, Size: uint32(len(strtab))},
+		{Name: 23, Type: uint32(elf.SHT_STRTAB), Off: shstrtabOffset, Size: uint32(len(shstrtab))},
+	}
+	header := elf.Header32{
+		Type: uint16(elf.ET_EXEC), Machine: uint16(elf.EM_RISCV), Version: uint32(elf.EV_CURRENT),
+		Entry: entry, Phoff: headerSize, Shoff: textOffset + uint32(contents.Len()),
+		Ehsize: headerSize, Phentsize: progSize, Phnum: 1,
+		Shentsize: sectionSize, Shnum: uint16(len(sections)), Shstrndx: 4,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var file bytes.Buffer
+	binary.Write(&file, binary.LittleEndian, header)
+	binary.Write(&file, binary.LittleEndian, prog)
+	file.Write(contents.Bytes())
+	binary.Write(&file, binary.LittleEndian, sections)
+	return file.Bytes()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Kinds of register the commit log records writes to, as it prefixes their numbers
+const (
+	COMMIT_X   byte = 'x' // Integer register
+	COMMIT_F   byte = 'f' // Floating-point register
+	COMMIT_CSR byte = 'c' // Control and status register
+)
+
+// The effects of the instruction being executed, recorded while it runs so they can be logged once it retires
+type Commit struct {
+	raw     uint32   // Instruction as fetched
+	retired bool     // Whether the instruction completed without raising an exception
+	fregs   uint32   // Floating-point registers written, a bit per register
+	csrs    []uint32 // Addresses of the CSRs written
+	loads   []MemoryAccess
+	stores  []MemoryAccess
+}
+
+// A load or store made by an instruction
+type MemoryAccess struct {
+	addr  uint32
+	size  uint32
+	value uint64 // Value stored, wide enough for a double
+}
+
+// A register written by an instruction
+type CommitWrite struct {
+	kind  byte   // COMMIT_X, COMMIT_F or COMMIT_CSR
+	reg   uint32 // Register number, or address of a CSR
+	value uint64
+	width uint32 // Width of the value in bits
+}
+
+// A retired instruction and its effects, as a line of Spike's commit log describes it
+type CommitRecord struct {
+	hart      uint32
+	privilege uint8
+	pc        uint32
+	raw       uint32
+	size      uint32
+	writes    []CommitWrite
+	loads     []MemoryAccess
+	stores    []MemoryAccess
+}
+
+// Records a write to a CSR by the instruction being executed
+func (cpu *CPU) commitCSR(address uint32) {
+	if cpu.commit != nil {
+		cpu.commit.csrs = append(cpu.commit.csrs, address&CSR_ADDRESS_MASK)
+	}
+}
+
+// Executes the next instruction like Step, returning a record of it if it retired and nil otherwise
+func (cpu *CPU) StepCommit() (*CommitRecord, error) {
+	pc, privilege := cpu.pc, cpu.privilege
+	commit := &Commit{}
+	cpu.commit, cpu.registers.written = commit, 0
+	err := cpu.step()
+	cpu.commit = nil
+	if !commit.retired {
+		return nil, err
+	}
+
+	record := &CommitRecord{
+		hart:      cpu.csrs[CSR_MHARTID].value,
+		privilege: privilege,
+		pc:        pc,
+		raw:       commit.raw,
+		size:      BYTES_PER_WORD,
+		loads:     commit.loads,
+		stores:    commit.stores,
+	}
+	if isCompressed(commit.raw) {
+		record.size = BYTES_PER_HALF
+	}
+	for reg := uint8(1); reg < REG_COUNT; reg++ {
+		if cpu.registers.written&(1<<reg) != 0 {
+			record.writes = append(record.writes, CommitWrite{COMMIT_X, uint32(reg), uint64(cpu.registers.Read(reg)), XLEN})
+		}
+	}
+	for reg := uint8(0); reg < REG_COUNT; reg++ {
+		if commit.fregs&(1<<reg) != 0 {
+			// Registers are as wide as the widest floating-point format implemented
+			value, width := cpu.fregisters[reg], uint32(64)
+			if cpu.extensions&EXT_D == 0 {
+				value, width = value&0xFFFF_FFFF, 32
+			}
+			record.writes = append(record.writes, CommitWrite{COMMIT_F, uint32(reg), value, width})
+		}
+	}
+	seen := make(map[uint32]bool)
+	for _, address := range commit.csrs {
+		if csr, ok := cpu.csrs[address]; ok && !seen[address] {
+			seen[address] = true
+			record.writes = append(record.writes, CommitWrite{COMMIT_CSR, address, uint64(cpu.readCSR(csr)), XLEN})
+		}
+	}
+	// Spike orders the writes by register number, integer before floating-point, with the CSRs by address
+	sort.SliceStable(record.writes, func(i, j int) bool {
+		return record.writes[i].key() < record.writes[j].key()
+	})
+	return record, err
+}
+
+// Returns the key Spike orders the writes of an instruction by
+func (write CommitWrite) key() uint64 {
+	switch write.kind {
+	case COMMIT_F:
+		return uint64(write.reg)<<4 | 1
+	case COMMIT_CSR:
+		return uint64(write.reg)<<4 | 4
+	}
+	return uint64(write.reg) << 4
+}
+
+// Renders the record as a line of Spike's --log-commits output, without the line break
+func (record *CommitRecord) String() string {
+	var line strings.Builder
+	fmt.Fprintf(&line, "core%4d: %d 0x%08x (0x%0*x)", record.hart, record.privilege, record.pc, record.size*2, record.raw)
+	for _, write := range record.writes {
+		if write.kind == COMMIT_CSR {
+			fmt.Fprintf(&line, " c%d_%s", write.reg, csrNames[write.reg])
+		} else {
+			fmt.Fprintf(&line, " %c%-2d", write.kind, write.reg)
+		}
+		fmt.Fprintf(&line, " 0x%0*x", write.width/4, write.value)
+	}
+	for _, load := range record.loads {
+		fmt.Fprintf(&line, " mem 0x%08x", load.addr)
+	}
+	for _, store := range record.stores {
+		fmt.Fprintf(&line, " mem 0x%08x 0x%0*x", store.addr, store.size*2, store.value)
+	}
+	return line.String()
+}
+
+// Writes a line per retired instruction in the format of Spike's --log-commits, followed by its disassembly
+type Tracer struct {
+	output   *bufio.Writer
+	disasm   *Disassembler
+	start    uint32 // Address that starts tracing
+	stop     uint32 // Address that stops tracing
+	hasStart bool
+	hasStop  bool
+	active   bool
+}
+
+// Constructor to initialize a tracer writing to the given output, naming addresses after the symbols
+func NewTracer(output io.Writer, symbols map[string]uint32) *Tracer {
+	return &Tracer{output: bufio.NewWriter(output), disasm: NewDisassembler(symbols), active: true}
+}
+
+// Limits tracing to the instructions from reaching the start address until reaching the stop address, which
+// starts again each time the start address is reached
+func (tracer *Tracer) SetTriggers(start uint32, hasStart bool, stop uint32, hasStop bool) {
+	tracer.start, tracer.hasStart = start, hasStart
+	tracer.stop, tracer.hasStop = stop, hasStop
+	tracer.active = !hasStart
+}
+
+// Writes out the lines still buffered
+func (tracer *Tracer) Flush() error {
+	return tracer.output.Flush()
+}
+
+// Executes the next instruction, tracing it if it retires within the window the triggers open
+func (tracer *Tracer) step(cpu *CPU) error {
+	if !tracer.tracing(cpu.pc) {
+		return cpu.step()
+	}
+	record, err := cpu.StepCommit()
+	if record != nil {
+		tracer.write(record)
+	}
+	return err
+}
+
+// Updates the window the triggers open for the instruction at pc, returning whether it is traced
+func (tracer *Tracer) tracing(pc uint32) bool {
+	if tracer.hasStart && pc == tracer.start {
+		tracer.active = true
+	}
+	if tracer.hasStop && pc == tracer.stop {
+		tracer.active = false
+	}
+	return tracer.active
+}
+
+// Writes the line of a retired instruction, followed by its disassembly
+func (tracer *Tracer) write(record *CommitRecord) {
+	text, _ := tracer.disasm.Disassemble(record.pc, record.raw)
+	fmt.Fprintf(tracer.output, "%s # %s\n", record, strings.ReplaceAll(text, "\t", " "))
+}
+
+// Traces every instruction the CPU steps through, until detached with nil
+func (cpu *CPU) AttachTracer(tracer *Tracer) {
+	cpu.tracer = tracer
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Runs a program until it reaches an ebreak with a tracer attached, returning the trace
+func traceProgram(t *testing.T, source string, configure func(cpu *CPU, tracer *Tracer)) string {
+	t.Helper()
+	program := assemble(t, source)
+	cpu := newTestCPU(t, 0, 0x2000)
+	if _, err := cpu.LoadProgram(program); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var output bytes.Buffer
+	tracer := NewTracer(&output, cpu.symbols)
+	configure(cpu, tracer)
+	cpu.AttachTracer(tracer)
+	for i := 0; ; i++ {
+		err := cpu.Step()
+		if errors.Is(err, ErrBreakpoint) {
+			break
+		} else if err != nil || i == 100 {
+			t.Fatalf("Step at %08x: unexpected error: %v", cpu.instructionAddress(), err)
+		}
+	}
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return output.String()
+}
+
+func TestTrace(t *testing.T) {
+	output := traceProgram(t, `
+	li	a0, 0x400
+	li	a1, -2
+	sh	a1, 2(a0)
+	lw	a2, 0(a0)
+	csrrw	a3, mscratch, a1
+	fmv.w.x	fa0, a1
+	amoswap.w.aq	a4, a0, (a0)
+	j	1f
+1:	ebreak
+`, func(cpu *CPU, tracer *Tracer) {})
+
+	want := []string{
+		"core   0: 3 0x00000000 (0x40000513) x10 0x00000400 # li a0,1024",
+		"core   0: 3 0x00000004 (0xffe00593) x11 0xfffffffe # li a1,-2",
+		"core   0: 3 0x00000008 (0x00b51123) mem 0x00000402 0xfffe # sh a1,2(a0)",
+		"core   0: 3 0x0000000c (0x00052603) x12 0xfffe0000 mem 0x00000400 # lw a2,0(a0)",
+		"core   0: 3 0x00000010 (0x340596f3) x13 0x00000000 c832_mscratch 0xfffffffe # csrrw a3,mscratch,a1",
+		"core   0: 3 0x00000014 (0xf0058553) f10 0xfffffffffffffffe c768_mstatus 0x80007800 # fmv.w.x fa0,a1",
+		"core   0: 3 0x00000018 (0x0ca5272f) x14 0xfffe0000 mem 0x00000400 mem 0x00000400 0x00000400 # amoswap.w.aq a4,a0,(a0)",
+		"core   0: 3 0x0000001c (0x0040006f) # j 20",
+	}
+	if lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n"); strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("trace =\n%s\nwant\n%s", output, strings.Join(want, "\n"))
+	}
+}
+
+func TestTraceTriggers(t *testing.T) {
+	// Only the instructions from each call of double up to its return are traced
+	output := traceProgram(t, `
+	li	a0, 1
+	call	double
+	call	double
+	ebreak
+double:
+	add	a0, a0, a0
+done:
+	ret
+`, func(cpu *CPU, tracer *Tracer) {
+		start, _ := cpu.Symbol("double")
+		stop, _ := cpu.Symbol("done")
+		tracer.SetTriggers(start, true, stop, true)
+	})
+
+	want := "core   0: 3 0x00000018 (0x00a50533) x10 0x00000002 # add a0,a0,a0\n" +
+		"core   0: 3 0x00000018 (0x00a50533) x10 0x00000004 # add a0,a0,a0\n"
+	if output != want {
+		t.Errorf("trace =\n%s\nwant\n%s", output, want)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exception codes written to mcause
+const (
+	CAUSE_INSTRUCTION_ADDRESS_MISALIGNED uint32 = 0  // Jump or branch to a misaligned address
+	CAUSE_INSTRUCTION_ACCESS_FAULT       uint32 = 1  // Instruction fetch from an invalid address
+	CAUSE_ILLEGAL_INSTRUCTION            uint32 = 2  // Undecodable or disallowed instruction
+	CAUSE_BREAKPOINT                     uint32 = 3  // ebreak
+	CAUSE_LOAD_ADDRESS_MISALIGNED        uint32 = 4  // Load from a misaligned address
+	CAUSE_LOAD_ACCESS_FAULT              uint32 = 5  // Load from an invalid address
+	CAUSE_STORE_ADDRESS_MISALIGNED       uint32 = 6  // Store or atomic to a misaligned address
+	CAUSE_STORE_ACCESS_FAULT             uint32 = 7  // Store or atomic to an invalid address
+	CAUSE_USER_ECALL                     uint32 = 8  // ecall from user mode
+	CAUSE_MACHINE_ECALL                  uint32 = 11 // ecall from machine mode
+	MCAUSE_INTERRUPT                     uint32 = 1 << 31
+)
+
+// Interrupt codes written to mcause along with MCAUSE_INTERRUPT
+const (
+	CAUSE_MACHINE_SOFTWARE_INTERRUPT uint32 = 3  // Raised through the CLINT msip register
+	CAUSE_MACHINE_TIMER_INTERRUPT    uint32 = 7  // Raised when mtime reaches mtimecmp
+	CAUSE_MACHINE_EXTERNAL_INTERRUPT uint32 = 11 // Raised by a platform interrupt controller
+)
+
+// Machine-mode interrupts in decreasing order of priority
+var interruptPriority = []struct {
+	bit   uint32 // Bit in mip and mie
+	cause uint32 // Interrupt code
+	name  string
+}{
+	{MIP_MEIP, CAUSE_MACHINE_EXTERNAL_INTERRUPT, "machine external interrupt"},
+	{MIP_MSIP, CAUSE_MACHINE_SOFTWARE_INTERRUPT, "machine software interrupt"},
+	{MIP_MTIP, CAUSE_MACHINE_TIMER_INTERRUPT, "machine timer interrupt"},
+}
+
+// Fields of the mtvec register
+const (
+	MTVEC_MODE_MASK uint32 = 0x3 // Selects how the trap handler address is computed
+	MTVEC_DIRECT    uint32 = 0x0 // All traps go to the base address
+	MTVEC_VECTORED  uint32 = 0x1 // Interrupts go to the base address plus four times the cause
+)
+
+// Represents a trap raised by an instruction or an interrupt
+type Trap struct {
+	cause     uint32 // Exception or interrupt code written to mcause
+	value     uint32 // Faulting address or instruction written to mtval
+	interrupt bool   // Whether the trap is an asynchronous interrupt
+	err       error  // Describes what caused the trap
+}
+
+// Returns the description of the trap
+func (trap *Trap) Error() string {
+	return trap.err.Error()
+}
+
+// Returns the error the trap wraps
+func (trap *Trap) Unwrap() error {
+	return trap.err
+}
+
+// Creates an exception with the given cause and trap value
+func newTrap(cause uint32, value uint32, format string, args ...any) *Trap {
+	return &Trap{cause: cause, value: value, err: fmt.Errorf(format, args...)}
+}
+
+// Creates an illegal-instruction exception, recording the instruction bits as the trap value
+func illegalInstruction(instruction uint32, err error) *Trap {
+	return &Trap{cause: CAUSE_ILLEGAL_INSTRUCTION, value: instruction, err: err}
+}
+
+// Returns the highest-priority interrupt that is pending and enabled, if any
+func (cpu *CPU) pendingInterrupt() *Trap {
+	// Machine-mode interrupts are masked by mstatus.MIE in machine mode and always taken from lower modes
+	if cpu.privilege == PRIV_MACHINE && cpu.csrs[CSR_MSTATUS].value&MSTATUS_MIE == 0 {
+		return nil
+	}
+	pending := cpu.readCSR(cpu.csrs[CSR_MIP]) & cpu.csrs[CSR_MIE].value
+	for _, interrupt := range interruptPriority {
+		if pending&interrupt.bit != 0 {
+			return &Trap{cause: interrupt.cause, interrupt: true, err: errors.New(interrupt.name)}
+		}
+	}
+	return nil
+}
+
+// Takes a pending interrupt or fetches and executes a single instruction, entering the trap handler on exceptions
+func (cpu *CPU) Step() error {
+	if cpu.tracer != nil {
+		return cpu.tracer.step(cpu)
+	}
+	return cpu.step()
+}
+
+// Steps the CPU without tracing
+func (cpu *CPU) step() error {
+	// Interrupts are taken between instructions
+	cpu.clint.tick()
+	if interrupt := cpu.pendingInterrupt(); interrupt != nil {
+		return cpu.TakeTrap(interrupt)
+	}
+
+	instruction, err := cpu.Fetch()
+	if err == nil {
+		err = cpu.Execute(instruction)
+	}
+	var trap *Trap
+	if errors.As(err, &trap) {
+		return cpu.TakeTrap(trap)
+	}
+	return err
+}
+
+// Enters the machine-mode trap handler, or returns the trap if no handler is installed
+func (cpu *CPU) TakeTrap(trap *Trap) error {
+	// A program that never wrote mtvec has no handler, whereas one may well be installed at address zero
+	if !cpu.hasHandler {
+		return trap
+	}
+	mtvec := cpu.csrs[CSR_MTVEC].value
+
+	// Exceptions restart the instruction that raised them, while interrupts resume the next one
+	cause, epc := trap.cause, cpu.instPC
+	if trap.interrupt {
+		cause, epc = trap.cause|MCAUSE_INTERRUPT, cpu.pc
+	}
+	cpu.csrs[CSR_MEPC].value = epc
+	cpu.csrs[CSR_MCAUSE].value = cause
+	cpu.csrs[CSR_MTVAL].value = trap.value
+
+	// Stack the interrupt enable and privilege level, disabling interrupts in the handler
+	status := cpu.csrs[CSR_MSTATUS].value &^ (MSTATUS_MPIE | MSTATUS_MPP)
+	if status&MSTATUS_MIE != 0 {
+		status |= MSTATUS_MPIE
+	}
+	status = status&^MSTATUS_MIE | uint32(cpu.privilege)<<MSTATUS_MPP_SHIFT
+	cpu.csrs[CSR_MSTATUS].value = status
+	cpu.privilege = PRIV_MACHINE
+
+	base := mtvec &^ MTVEC_MODE_MASK
+	if trap.interrupt && mtvec&MTVEC_MODE_MASK == MTVEC_VECTORED {
+		base += BYTES_PER_WORD * trap.cause
+	}
+	cpu.pc = base
+	return nil
+}
+
+// Returns from a machine-mode trap handler, restoring the interrupt enable and privilege level
+func (cpu *CPU) MRET(instruction *AssemblyInstruction) error {
+	if cpu.privilege < PRIV_MACHINE {
+		return illegalInstruction(instruction.raw, errors.New("illegal instruction: mret outside machine mode"))
+	}
+	status := cpu.csrs[CSR_MSTATUS].value
+	cpu.privilege = uint8((status & MSTATUS_MPP) >> MSTATUS_MPP_SHIFT)
+
+	// MPP drops to the least-privileged mode implemented, which is machine mode
+	status &^= MSTATUS_MIE | MSTATUS_MPP
+	if status&MSTATUS_MPIE != 0 {
+		status |= MSTATUS_MIE
+	}
+	cpu.csrs[CSR_MSTATUS].value = status | MSTATUS_MPIE | uint32(PRIV_MACHINE)<<MSTATUS_MPP_SHIFT
+	cpu.commitCSR(CSR_MSTATUS)
+	cpu.pc = cpu.csrs[CSR_MEPC].value
+	return nil
+}
+
+// Waits for an interrupt, which needs no action since interrupts are checked before every instruction
+func (cpu *CPU) WFI(instruction *AssemblyInstruction) error {
+	return nil
+}
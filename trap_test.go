@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// Address of the trap handler installed by the tests
+const testHandler uint32 = 0x100
+
+// Creates a CPU with the given instructions at address zero and a trap handler installed
+func newTrapCPU(t *testing.T, instructions ...uint32) *CPU {
+	t.Helper()
+	cpu := newTestCPU(t, 0, 0x200, instructions...)
+	if err := cpu.WriteCSR(CSR_MTVEC, testHandler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return cpu
+}
+
+// Executes the given number of instructions, failing the test on unhandled traps
+func step(t *testing.T, cpu *CPU, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		if err := cpu.Step(); err != nil {
+			t.Fatalf("Step at %08x: unexpected error: %v", cpu.instructionAddress(), err)
+		}
+	}
+}
+
+// Checks the trap CSRs and that the handler was entered
+func checkTrap(t *testing.T, cpu *CPU, cause uint32, epc uint32, tval uint32) {
+	t.Helper()
+	if cpu.pc != testHandler {
+		t.Errorf("pc = %08x, want the handler at %08x", cpu.pc, testHandler)
+	}
+	if got := cpu.csrs[CSR_MCAUSE].value; got != cause {
+		t.Errorf("mcause = %d, want %d", got, cause)
+	}
+	if got := cpu.csrs[CSR_MEPC].value; got != epc {
+		t.Errorf("mepc = %08x, want %08x", got, epc)
+	}
+	if got := cpu.csrs[CSR_MTVAL].value; got != tval {
+		t.Errorf("mtval = %08x, want %08x", got, tval)
+	}
+}
+
+func TestTrapExceptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		instruction uint32
+		a1          uint32
+		cause       uint32
+		tval        uint32
+	}{
+		{"illegal instruction", 0x0000007f, 0, CAUSE_ILLEGAL_INSTRUCTION, 0x0000007f},
+		{"illegal csr", 0x7c002573, 0, CAUSE_ILLEGAL_INSTRUCTION, 0x7c002573},              // csrr a0, 0x7c0
+		{"environment call", 0x00000073, 0, CAUSE_MACHINE_ECALL, 0},                        // ecall
+		{"breakpoint", 0x00100073, 0, CAUSE_BREAKPOINT, 0},                                 // ebreak
+		{"load access fault", 0x0005a503, 0x1000, CAUSE_LOAD_ACCESS_FAULT, 0x1000},         // lw a0, 0(a1)
+		{"store access fault", 0x00a5a023, 0x1000, CAUSE_STORE_ACCESS_FAULT, 0x1000},       // sw a0, 0(a1)
+		{"load past the end of memory", 0x0005a503, 0x1fe, CAUSE_LOAD_ACCESS_FAULT, 0x1fe}, // lw a0, 0(a1)
+		{"atomic access fault", 0x00a5a52f, 0x1000, CAUSE_STORE_ACCESS_FAULT, 0x1000},      // amoadd.w a0, a0, (a1)
+		{"misaligned load-reserved", 0x1005a52f, 0x2, CAUSE_LOAD_ADDRESS_MISALIGNED, 2},    // lr.w a0, (a1)
+	}
+
+	for _, test := range tests {
+		cpu := newTrapCPU(t, test.instruction)
+		cpu.registers.Write(REG_A1, test.a1)
+		step(t, cpu, 1)
+		checkTrap(t, cpu, test.cause, 0, test.tval)
+		if cpu.counters.instret != 0 {
+			t.Errorf("%s: the trapping instruction should not retire", test.name)
+		}
+	}
+}
+
+func TestTrapMisalignedJump(t *testing.T) {
+	cpu := newTrapCPU(t, 0x00000013, 0x00200067) // nop; jr 2(zero)
+	cpu.extensions, _ = ParseISA("rv32i_zicsr")
+	step(t, cpu, 2)
+	checkTrap(t, cpu, CAUSE_INSTRUCTION_ADDRESS_MISALIGNED, 4, 2)
+}
+
+func TestTrapMisalignedAccessPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   MisalignedPolicy
+		trap     bool
+		emulated uint64
+	}{
+		{"trap", MISALIGNED_TRAP, true, 0},
+		{"emulate", MISALIGNED_EMULATE, false, 0},
+		{"count", MISALIGNED_COUNT, false, 2},
+	}
+
+	for _, test := range tests {
+		// sw a0, 0(a1); lw a2, 0(a1)
+		cpu := newTrapCPU(t, 0x00a5a023, 0x0005a603)
+		cpu.SetMisalignedPolicy(test.policy)
+		cpu.registers.Write(REG_A0, 0x1234_5678)
+		cpu.registers.Write(REG_A1, 0x1a2)
+		if test.trap {
+			step(t, cpu, 1)
+			checkTrap(t, cpu, CAUSE_STORE_ADDRESS_MISALIGNED, 0, 0x1a2)
+			continue
+		}
+		step(t, cpu, 2)
+		if cpu.registers.Read(REG_A2) != 0x1234_5678 {
+			t.Errorf("%s: a2 = %08x, want the stored word", test.name, cpu.registers.Read(REG_A2))
+		}
+		if cpu.MisalignedAccesses() != test.emulated {
+			t.Errorf("%s: MisalignedAccesses() = %d, want %d", test.name, cpu.MisalignedAccesses(), test.emulated)
+		}
+	}
+}
+
+func TestTrapInstructionAccessFault(t *testing.T) {
+	cpu := newTrapCPU(t)
+	cpu.pc = 0x1000
+	step(t, cpu, 1)
+	checkTrap(t, cpu, CAUSE_INSTRUCTION_ACCESS_FAULT, 0x1000, 0x1000)
+}
+
+func TestTrapVectoredExceptionsUseBase(t *testing.T) {
+	cpu := newTrapCPU(t, 0x00000073) // ecall
+	cpu.WriteCSR(CSR_MTVEC, testHandler|MTVEC_VECTORED)
+	step(t, cpu, 1)
+	checkTrap(t, cpu, CAUSE_MACHINE_ECALL, 0, 0)
+}
+
+func TestTrapReturn(t *testing.T) {
+	cpu := newTrapCPU(t,
+		0x00000073, // ecall
+		0x00150513, // addi a0, a0, 1
+	)
+	storeWords(t, cpu, testHandler,
+		0x341022f3, // csrr t0, mepc
+		0x00428293, // addi t0, t0, 4
+		0x34129073, // csrw mepc, t0
+		0x30200073, // mret
+	)
+	cpu.csrs[CSR_MSTATUS].value |= MSTATUS_MIE
+
+	step(t, cpu, 1)
+	if status := cpu.csrs[CSR_MSTATUS].value; status&MSTATUS_MIE != 0 || status&MSTATUS_MPIE == 0 {
+		t.Errorf("mstatus = %08x, want interrupts disabled with MPIE holding the previous MIE", status)
+	}
+
+	step(t, cpu, 5)
+	if cpu.pc != 8 || cpu.registers.Read(REG_A0) != 1 {
+		t.Errorf("pc = %08x, a0 = %d, want execution to resume after the ecall", cpu.pc, cpu.registers.Read(REG_A0))
+	}
+	if status := cpu.csrs[CSR_MSTATUS].value; status&MSTATUS_MIE == 0 || status&MSTATUS_MPIE == 0 {
+		t.Errorf("mstatus = %08x, want MIE restored and MPIE set", status)
+	}
+}
+
+func TestTrapHandlerAtZero(t *testing.T) {
+	// csrw mtvec, zero; ebreak, which enters the handler at address zero
+	cpu := newTestCPU(t, 0x100, 0x200, 0x30501073, 0x00100073)
+	step(t, cpu, 2)
+	if cpu.pc != 0 || cpu.csrs[CSR_MEPC].value != 0x104 {
+		t.Errorf("pc, mepc = %08x, %08x, want the handler at 00000000 for the ebreak at 00000104", cpu.pc, cpu.csrs[CSR_MEPC].value)
+	}
+}
+
+func TestTrapUnhandled(t *testing.T) {
+	cpu := newTestCPU(t, 0, 0x100, 0x00100073) // ebreak
+	err := cpu.Step()
+	var trap *Trap
+	if !errors.As(err, &trap) || trap.cause != CAUSE_BREAKPOINT || !errors.Is(err, ErrBreakpoint) {
+		t.Errorf("Step() = %v, want an unhandled breakpoint", err)
+	}
+}
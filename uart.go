@@ -0,0 +1,210 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// Offsets of the registers of the UART, some of which share an offset depending on the direction or LCR_DLAB
+const (
+	UART_RBR = 0 // Receiver buffer (read)
+	UART_THR = 0 // Transmitter holding register (write)
+	UART_DLL = 0 // Divisor latch, low byte (LCR_DLAB set)
+	UART_IER = 1 // Interrupt enable
+	UART_DLM = 1 // Divisor latch, high byte (LCR_DLAB set)
+	UART_IIR = 2 // Interrupt identification (read)
+	UART_FCR = 2 // FIFO control (write)
+	UART_LCR = 3 // Line control
+	UART_MCR = 4 // Modem control
+	UART_LSR = 5 // Line status
+	UART_MSR = 6 // Modem status
+	UART_SCR = 7 // Scratch
+)
+
+// Bits of the UART registers
+const (
+	IER_RDI      uint8 = 0x01 // Interrupt when received data is available
+	IER_THRI     uint8 = 0x02 // Interrupt when the transmitter holding register is empty
+	IIR_NO_INT   uint8 = 0x01 // No interrupt is pending
+	IIR_THRI     uint8 = 0x02 // Transmitter holding register empty
+	IIR_RDI      uint8 = 0x04 // Received data available
+	IIR_FIFO     uint8 = 0xC0 // FIFOs are enabled
+	FCR_ENABLE   uint8 = 0x01 // Enable the FIFOs
+	FCR_CLEAR_RX uint8 = 0x02 // Clear the receive FIFO
+	LCR_DLAB     uint8 = 0x80 // Divisor latch access
+	LSR_DR       uint8 = 0x01 // Data ready
+	LSR_THRE     uint8 = 0x20 // Transmitter holding register empty
+	LSR_TEMT     uint8 = 0x40 // Transmitter empty
+	MSR_DCD      uint8 = 0x80 // Data carrier detect
+	MSR_DSR      uint8 = 0x20 // Data set ready
+	MSR_CTS      uint8 = 0x10 // Clear to send
+	MCR_LOOP     uint8 = 0x10 // Loopback mode
+)
+
+// Represents an NS16550A UART, which transmits instantly to a writer and receives from a reader
+type UART struct {
+	mutex  sync.Mutex // Guards the receive buffer, which is filled in the background
+	rx     []uint8    // Bytes received but not yet read
+	output io.Writer
+	ier    uint8
+	fcr    uint8
+	lcr    uint8
+	mcr    uint8
+	scr    uint8
+	dll    uint8
+	dlm    uint8
+	thre   bool // Whether the transmitter holding register empty interrupt is pending
+}
+
+// Constructor to initialize a UART writing to the output and, if there is one, reading from the input
+func NewUART(input io.Reader, output io.Writer) *UART {
+	uart := &UART{output: output}
+	if input != nil {
+		go uart.receive(input)
+	}
+	return uart
+}
+
+// Buffers the bytes read from the input until the input is closed
+func (uart *UART) receive(input io.Reader) {
+	buffer := make([]byte, 256)
+	for {
+		n, err := input.Read(buffer)
+		uart.mutex.Lock()
+		uart.rx = append(uart.rx, buffer[:n]...)
+		uart.mutex.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Returns whether the UART is raising its interrupt line
+func (uart *UART) Interrupt() bool {
+	return uart.interruptID() != IIR_NO_INT
+}
+
+// Returns the identification of the highest-priority pending interrupt
+func (uart *UART) interruptID() uint8 {
+	uart.mutex.Lock()
+	ready := len(uart.rx) > 0
+	uart.mutex.Unlock()
+	switch {
+	case uart.ier&IER_RDI != 0 && ready:
+		return IIR_RDI
+	case uart.ier&IER_THRI != 0 && uart.thre:
+		return IIR_THRI
+	}
+	return IIR_NO_INT
+}
+
+// Reads a register of the UART
+func (uart *UART) readRegister(offset uint32) uint8 {
+	dlab := uart.lcr&LCR_DLAB != 0
+	switch offset {
+	case UART_RBR:
+		if dlab {
+			return uart.dll
+		}
+		uart.mutex.Lock()
+		defer uart.mutex.Unlock()
+		if len(uart.rx) == 0 {
+			return 0
+		}
+		data := uart.rx[0]
+		uart.rx = uart.rx[1:]
+		return data
+	case UART_IER:
+		if dlab {
+			return uart.dlm
+		}
+		return uart.ier
+	case UART_IIR:
+		id := uart.interruptID()
+		// Reading the identification acknowledges the transmitter interrupt
+		if id == IIR_THRI {
+			uart.thre = false
+		}
+		if uart.fcr&FCR_ENABLE != 0 {
+			id |= IIR_FIFO
+		}
+		return id
+	case UART_LCR:
+		return uart.lcr
+	case UART_MCR:
+		return uart.mcr
+	case UART_LSR:
+		// Transmission is instant, so the transmitter is always empty
+		status := LSR_THRE | LSR_TEMT
+		uart.mutex.Lock()
+		if len(uart.rx) > 0 {
+			status |= LSR_DR
+		}
+		uart.mutex.Unlock()
+		return status
+	case UART_MSR:
+		return MSR_DCD | MSR_DSR | MSR_CTS
+	default:
+		return uart.scr
+	}
+}
+
+// Writes a register of the UART
+func (uart *UART) writeRegister(offset uint32, data uint8) {
+	dlab := uart.lcr&LCR_DLAB != 0
+	switch offset {
+	case UART_THR:
+		if dlab {
+			uart.dll = data
+			return
+		}
+		if uart.mcr&MCR_LOOP != 0 {
+			uart.mutex.Lock()
+			uart.rx = append(uart.rx, data)
+			uart.mutex.Unlock()
+		} else if uart.output != nil {
+			uart.output.Write([]byte{data})
+		}
+		uart.thre = true
+	case UART_IER:
+		if dlab {
+			uart.dlm = data
+			return
+		}
+		// Enabling the transmitter interrupt raises it right away, as the holding register is empty
+		if data&IER_THRI != 0 && uart.ier&IER_THRI == 0 {
+			uart.thre = true
+		}
+		uart.ier = data & (IER_RDI | IER_THRI)
+	case UART_FCR:
+		uart.fcr = data
+		if data&FCR_CLEAR_RX != 0 {
+			uart.mutex.Lock()
+			uart.rx = nil
+			uart.mutex.Unlock()
+		}
+	case UART_LCR:
+		uart.lcr = data
+	case UART_MCR:
+		uart.mcr = data
+	case UART_SCR:
+		uart.scr = data
+	}
+}
+
+// Reads a register of the UART, which only supports byte accesses
+func (uart *UART) Read(offset uint32, size uint32) (uint32, error) {
+	if offset > UART_SCR {
+		return 0, ErrUnmapped
+	}
+	return uint32(uart.readRegister(offset)), nil
+}
+
+// Writes a register of the UART, which only supports byte accesses
+func (uart *UART) Write(offset uint32, size uint32, value uint32) error {
+	if offset > UART_SCR {
+		return ErrUnmapped
+	}
+	uart.writeRegister(offset, uint8(value))
+	return nil
+}
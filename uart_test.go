@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Waits for the UART to receive data from its input in the background
+func waitForData(t *testing.T, uart *UART) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for uart.readRegister(UART_LSR)&LSR_DR == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for received data")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUARTTransmit(t *testing.T) {
+	var output bytes.Buffer
+	cpu := newTestCPU(t, 0, 0x100)
+	if err := cpu.AttachUART(NewUART(nil, &output)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, b := range []byte("ok\n") {
+		if err := cpu.StoreByte(REG_UART_BASE+UART_THR, b); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if output.String() != "ok\n" {
+		t.Errorf("output = %q, want %q", output.String(), "ok\n")
+	}
+	if status, _ := cpu.FetchByte(REG_UART_BASE + UART_LSR); status != LSR_THRE|LSR_TEMT {
+		t.Errorf("lsr = %#x, want the transmitter empty and no data ready", status)
+	}
+
+	// Only byte accesses are supported
+	var trap *Trap
+	if err := cpu.StoreWord(REG_UART_BASE, 0); !errors.As(err, &trap) || trap.cause != CAUSE_STORE_ACCESS_FAULT {
+		t.Errorf("StoreWord(uart) = %v, want a store access fault", err)
+	}
+}
+
+func TestUARTReceive(t *testing.T) {
+	uart := NewUART(strings.NewReader("hi"), nil)
+	waitForData(t, uart)
+	for _, want := range []byte("hi") {
+		waitForData(t, uart)
+		if data := uart.readRegister(UART_RBR); data != want {
+			t.Errorf("rbr = %q, want %q", data, want)
+		}
+	}
+	if status := uart.readRegister(UART_LSR); status&LSR_DR != 0 {
+		t.Errorf("lsr = %#x, want no data ready once the input is drained", status)
+	}
+}
+
+func TestUARTInterrupts(t *testing.T) {
+	uart := NewUART(nil, nil)
+	if uart.Interrupt() || uart.readRegister(UART_IIR) != IIR_NO_INT {
+		t.Errorf("no interrupt should be pending before any is enabled")
+	}
+
+	// The transmitter interrupt is raised when enabled and acknowledged by reading the identification
+	uart.writeRegister(UART_IER, IER_THRI)
+	if !uart.Interrupt() || uart.readRegister(UART_IIR) != IIR_THRI {
+		t.Errorf("enabling the transmitter interrupt should raise it")
+	}
+	if uart.Interrupt() {
+		t.Errorf("reading iir should acknowledge the transmitter interrupt")
+	}
+
+	// Received data takes priority, here looped back from the transmitter
+	uart.writeRegister(UART_MCR, MCR_LOOP)
+	uart.writeRegister(UART_IER, IER_RDI|IER_THRI)
+	uart.writeRegister(UART_FCR, FCR_ENABLE)
+	uart.writeRegister(UART_THR, 'x')
+	if id := uart.readRegister(UART_IIR); id != IIR_FIFO|IIR_RDI {
+		t.Errorf("iir = %#x, want received data available with the fifos enabled", id)
+	}
+	if data := uart.readRegister(UART_RBR); data != 'x' {
+		t.Errorf("rbr = %q, want the looped back byte", data)
+	}
+}
+
+func TestUARTDivisorLatch(t *testing.T) {
+	uart := NewUART(nil, nil)
+	uart.writeRegister(UART_IER, IER_RDI)
+	uart.writeRegister(UART_LCR, LCR_DLAB|0x03)
+	uart.writeRegister(UART_DLL, 0x0c)
+	uart.writeRegister(UART_DLM, 0x01)
+	if dll, dlm := uart.readRegister(UART_DLL), uart.readRegister(UART_DLM); dll != 0x0c || dlm != 0x01 {
+		t.Errorf("divisor = %02x%02x, want 010c", dlm, dll)
+	}
+	uart.writeRegister(UART_LCR, 0x03)
+	if ier := uart.readRegister(UART_IER); ier != IER_RDI {
+		t.Errorf("ier = %#x, want it untouched by the divisor latch", ier)
+	}
+}